@@ -2,15 +2,38 @@ package main
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"flag"
 	"fmt"
+	"html/template"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	maxFailureSummary = 240
+
+	// reportSchemaVersion is bumped whenever a field in the JSON report is
+	// renamed or removed. New sections and columns do not change it.
+	reportSchemaVersion = 1
+
+	statusPassed = "passed"
+	statusFailed = "failed"
+	statusInfo   = "info"
+	statusEmpty  = "empty"
+)
+
+var statusRank = map[string]int{statusEmpty: 0, statusInfo: 1, statusPassed: 2, statusFailed: 3}
+
+var reportExtensions = map[string]string{"markdown": ".md", "html": ".html", "json": ".json"}
+
+var alphaVersionRE = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)-alpha(\d+)$`)
+
 type signoffPlan struct {
 	TargetVersion      string        `json:"target_version"`
 	ReleaseLine        string        `json:"release_line"`
@@ -31,6 +54,7 @@ type signoffLane struct {
 	UpgradeToRancher     string `json:"upgrade_to_rancher"`
 	ProvisionDownstream  bool   `json:"provision_downstream"`
 	WebhookOverrideImage string `json:"webhook_override_image"`
+	BackupRestore        bool   `json:"backup_restore"`
 	TerraformStateKey    string `json:"terraform_state_key"`
 	AWSPrefix            string `json:"aws_prefix"`
 	Description          string `json:"description"`
@@ -43,23 +67,126 @@ type skippedLane struct {
 
 type metadata map[string]interface{}
 
+// report is the model every output format renders. Its JSON form is the
+// stable schema dashboards read.
+type report struct {
+	SchemaVersion int             `json:"schema_version"`
+	Title         string          `json:"title"`
+	GeneratedAt   string          `json:"generated_at"`
+	ActiveLane    string          `json:"active_lane,omitempty"`
+	Plan          reportPlan      `json:"plan"`
+	Lanes         []reportLane    `json:"lanes"`
+	SkippedLanes  []skippedLane   `json:"skipped_lanes"`
+	Sections      []reportSection `json:"sections"`
+}
+
+type reportPlan struct {
+	TargetVersion      string `json:"target_version"`
+	ReleaseLine        string `json:"release_line"`
+	PreviousVersion    string `json:"previous_version"`
+	TargetWebhookTag   string `json:"target_webhook_tag"`
+	PreviousWebhookTag string `json:"previous_webhook_tag"`
+	WebhookChanged     bool   `json:"webhook_changed"`
+	WebhookImage       string `json:"webhook_image"`
+	SigningPolicy      string `json:"signing_policy"`
+	SigningRegistry    string `json:"signing_registry"`
+}
+
+type reportLane struct {
+	Name                 string `json:"name"`
+	InstallRancher       string `json:"install_rancher"`
+	UpgradeToRancher     string `json:"upgrade_to_rancher"`
+	ProvisionDownstream  bool   `json:"provision_downstream"`
+	WebhookOverrideImage string `json:"webhook_override_image"`
+	BackupRestore        bool   `json:"backup_restore"`
+	Description          string `json:"description"`
+}
+
+// reportSection is one metadata table. Status is the worst row status:
+// failed, passed, info or empty when there are no rows.
+type reportSection struct {
+	ID      string      `json:"id"`
+	Title   string      `json:"title"`
+	Status  string      `json:"status"`
+	Columns []string    `json:"columns"`
+	Rows    []reportRow `json:"rows"`
+}
+
+type reportRow struct {
+	Status string   `json:"status"`
+	Values metadata `json:"values"`
+}
+
+// reportSources are the files the report reads besides the plan. An empty
+// JUnitDir or LedgerPath leaves that part out.
+type reportSources struct {
+	OutputDir  string
+	JUnitDir   string
+	LedgerPath string
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:""`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Cases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name    string        `xml:"name,attr"`
+	Time    string        `xml:"time,attr"`
+	Failure *junitMessage `xml:"failure"`
+	Error   *junitMessage `xml:"error"`
+	Skipped *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type signoffLedger struct {
+	Entries map[string]map[string]ledgerEntry `json:"entries"`
+}
+
+type ledgerEntry struct {
+	ReleaseLine string   `json:"release_line"`
+	FailedTests []string `json:"failed_tests"`
+}
+
 func main() {
 	var planPath string
 	var outputPath string
+	var format string
 	var outputDir string
 	var laneName string
+	var junitDir string
+	var ledgerPath string
 
 	flag.StringVar(&planPath, "plan", "signoff-plan.json", "sign-off plan JSON path")
-	flag.StringVar(&outputPath, "output", filepath.Join("automation-output", "signoff-report.md"), "Markdown report output path")
+	flag.StringVar(&outputPath, "output", "", "report output path (default automation-output/signoff-report with the format's extension)")
+	flag.StringVar(&format, "format", "markdown", "report format: markdown, html or json")
 	flag.StringVar(&outputDir, "output-dir", "automation-output", "directory containing lane metadata JSON files")
 	flag.StringVar(&laneName, "lane", "", "optional active lane name")
+	flag.StringVar(&junitDir, "junit-dir", "test-results", "directory containing rancher/tests JUnit XML files")
+	flag.StringVar(&ledgerPath, "ledger", "signoff-ledger.json", "sign-off ledger used to flag tests that failed on earlier alphas")
 	flag.Parse()
 
+	extension, ok := reportExtensions[format]
+	if !ok {
+		fatalf("unsupported format %q: expected markdown, html or json", format)
+	}
+	if outputPath == "" {
+		outputPath = filepath.Join("automation-output", "signoff-report"+extension)
+	}
+
 	plan, err := readPlan(planPath)
 	if err != nil {
 		fatalf("read plan: %v", err)
 	}
-	report, err := renderReport(plan, outputDir, laneName, time.Now().UTC())
+	report, err := renderReport(plan, reportSources{OutputDir: outputDir, JUnitDir: junitDir, LedgerPath: ledgerPath}, laneName, format, time.Now().UTC())
 	if err != nil {
 		fatalf("render report: %v", err)
 	}
@@ -84,34 +211,128 @@ func readPlan(path string) (signoffPlan, error) {
 	return plan, nil
 }
 
-func renderReport(plan signoffPlan, outputDir, activeLane string, generatedAt time.Time) (string, error) {
-	downstream, err := readMetadataFiles(filepath.Join(outputDir, "downstream-ha-*.json"))
+// renderReport builds the report model once and renders it in the requested
+// format, so the Markdown, HTML and JSON reports always carry the same data.
+func renderReport(plan signoffPlan, sources reportSources, activeLane, format string, generatedAt time.Time) (string, error) {
+	model, err := buildReport(plan, sources, activeLane, generatedAt)
 	if err != nil {
 		return "", err
 	}
+	switch format {
+	case "markdown":
+		return renderMarkdown(model), nil
+	case "html":
+		return renderHTML(model)
+	case "json":
+		return renderJSON(model)
+	default:
+		return "", fmt.Errorf("unsupported format %q: expected markdown, html or json", format)
+	}
+}
+
+func buildReport(plan signoffPlan, sources reportSources, activeLane string, generatedAt time.Time) (report, error) {
+	outputDir := sources.OutputDir
+	downstream, err := readMetadataFiles(filepath.Join(outputDir, "downstream-ha-*.json"))
+	if err != nil {
+		return report{}, err
+	}
 	localSuites, err := readMetadataFiles(filepath.Join(outputDir, "local-suite-ha-*.json"))
 	if err != nil {
-		return "", err
+		return report{}, err
 	}
 	overrides, err := readMetadataFiles(filepath.Join(outputDir, "webhook-override-*.json"))
 	if err != nil {
-		return "", err
+		return report{}, err
 	}
 	rancherTestRuns, err := readMetadataFiles(filepath.Join(outputDir, "rancher-test-results.json"))
 	if err != nil {
-		return "", err
+		return report{}, err
 	}
 	signingRuns, err := readMetadataFiles(filepath.Join(outputDir, "webhook-signing.json"))
 	if err != nil {
-		return "", err
+		return report{}, err
 	}
+	readiness, err := readMetadataFiles(filepath.Join(outputDir, "rancher-readiness-ha-*.json"))
+	if err != nil {
+		return report{}, err
+	}
+	rancherSettings, err := readMetadataFiles(filepath.Join(outputDir, "rancher-settings-ha-*.json"))
+	if err != nil {
+		return report{}, err
+	}
+	backupRestore, err := readMetadataFiles(filepath.Join(outputDir, "backup-restore-ha-*.json"))
+	if err != nil {
+		return report{}, err
+	}
+	junitSummaries, failingTests, err := readJUnitResults(sources.JUnitDir)
+	if err != nil {
+		return report{}, err
+	}
+	earlierFailures, err := readEarlierFailures(sources.LedgerPath, plan)
+	if err != nil {
+		return report{}, err
+	}
+	markSuspectedFlakes(failingTests, earlierFailures)
 	rancherTests := expandRancherTestRows(rancherTestRuns)
+	readinessChecks := expandHARows(readiness, "checks")
+	rancherSettingRows := expandRancherSettingRows(rancherSettings)
+
+	model := report{
+		SchemaVersion: reportSchemaVersion,
+		Title:         valueOr(plan.TargetVersion, "Rancher Alpha") + " Sign-Off Report",
+		GeneratedAt:   generatedAt.Format(time.RFC3339),
+		ActiveLane:    activeLane,
+		Plan: reportPlan{
+			TargetVersion:      plan.TargetVersion,
+			ReleaseLine:        plan.ReleaseLine,
+			PreviousVersion:    plan.PreviousVersion,
+			TargetWebhookTag:   plan.TargetWebhookTag,
+			PreviousWebhookTag: plan.PreviousWebhookTag,
+			WebhookChanged:     plan.WebhookChanged,
+			WebhookImage:       plan.WebhookImage,
+			SigningPolicy:      plan.SigningPolicy,
+			SigningRegistry:    plan.SigningRegistry,
+		},
+		Lanes:        []reportLane{},
+		SkippedLanes: []skippedLane{},
+	}
+	for _, lane := range plan.Lanes {
+		model.Lanes = append(model.Lanes, reportLane{
+			Name:                 lane.Name,
+			InstallRancher:       lane.InstallRancher,
+			UpgradeToRancher:     lane.UpgradeToRancher,
+			ProvisionDownstream:  lane.ProvisionDownstream,
+			WebhookOverrideImage: lane.WebhookOverrideImage,
+			BackupRestore:        lane.BackupRestore,
+			Description:          lane.Description,
+		})
+	}
+	model.SkippedLanes = append(model.SkippedLanes, plan.SkippedLanes...)
 
+	model.Sections = []reportSection{
+		newSection("readiness", "Rancher Readiness", readiness, []string{"ha_index", "ready", "attempts", "failed_checks", "checked_at"}, boolStatus("ready")),
+		newSection("readiness_checks", "Rancher Readiness Checks", readinessChecks, []string{"ha_index", "name", "passed", "detail"}, boolStatus("passed")),
+		newSection("settings", "Rancher Settings", rancherSettingRows, []string{"ha_index", "kind", "name", "desired", "actual", "changed", "verified", "restart_required"}, boolStatus("verified")),
+		newSection("backup_restore", "Backup and Restore", backupRestore, []string{"ha_index", "server_version", "chart_family", "chart_version", "resource_set", "backup_seconds", "restore_seconds", "marker_restored", "passed", "error"}, boolStatus("passed")),
+		newSection("downstream", "Downstream Linode", downstream, []string{"ha_index", "k3s_version"}, nil),
+		newSection("webhook_signing", "Webhook Signing", signingRuns, []string{"target_version", "webhook_image", "signing_policy", "enforced", "signature_verified", "provenance_verified", "sbom_verified", "verification_error"}, signingStatus),
+		newSection("local_suites", "Local Suite Targets", localSuites, []string{"ha_index"}, nil),
+		newSection("webhook_overrides", "Webhook Overrides", overrides, []string{"scope", "ha_index", "rollout_complete"}, boolStatus("rollout_complete")),
+		newSection("rancher_test_runs", "Rancher Test Runs", rancherTestRuns, []string{"ref", "lane", "rancher_version"}, nil),
+		newSection("rancher_test_results", "Rancher Test Results", rancherTests, []string{"ref", "lane", "suite", "package", "test_run", "junit", "conclusion"}, conclusionStatus),
+		newSection("rancher_test_cases", "Rancher Test Cases", junitSummaries, []string{"tests", "failures", "skipped", "duration"}, junitSummaryStatus),
+		newSection("failing_tests", "Failing Tests", failingTests, []string{"test", "duration", "message", "suspected_flake"}, func(metadata) string { return statusFailed }),
+	}
+	return model, nil
+}
+
+func renderMarkdown(model report) string {
+	plan := model.Plan
 	var b strings.Builder
-	fmt.Fprintf(&b, "# %s Sign-Off Report\n\n", valueOr(plan.TargetVersion, "Rancher Alpha"))
-	fmt.Fprintf(&b, "Generated: `%s`\n\n", generatedAt.Format(time.RFC3339))
-	if activeLane != "" {
-		fmt.Fprintf(&b, "Active lane: `%s`\n\n", activeLane)
+	fmt.Fprintf(&b, "# %s\n\n", model.Title)
+	fmt.Fprintf(&b, "Generated: `%s`\n\n", model.GeneratedAt)
+	if model.ActiveLane != "" {
+		fmt.Fprintf(&b, "Active lane: `%s`\n\n", model.ActiveLane)
 	}
 
 	fmt.Fprintf(&b, "## Plan\n\n")
@@ -122,33 +343,337 @@ func renderReport(plan signoffPlan, outputDir, activeLane string, generatedAt ti
 	fmt.Fprintf(&b, "- Signing policy: `%s` for `%s`\n\n", plan.SigningPolicy, plan.SigningRegistry)
 
 	fmt.Fprintf(&b, "## Lanes\n\n")
-	fmt.Fprintf(&b, "| Lane | Install | Upgrade | Downstream | Webhook override |\n")
-	fmt.Fprintf(&b, "| --- | --- | --- | --- | --- |\n")
-	for _, lane := range plan.Lanes {
-		fmt.Fprintf(&b, "| `%s` | `%s` | %s | `%t` | %s |\n",
+	fmt.Fprintf(&b, "| Lane | Install | Upgrade | Downstream | Webhook override | Backup/restore |\n")
+	fmt.Fprintf(&b, "| --- | --- | --- | --- | --- | --- |\n")
+	for _, lane := range model.Lanes {
+		fmt.Fprintf(&b, "| `%s` | `%s` | %s | `%t` | %s | `%t` |\n",
 			lane.Name,
 			lane.InstallRancher,
 			codeOrDash(lane.UpgradeToRancher),
 			lane.ProvisionDownstream,
-			codeOrDash(lane.WebhookOverrideImage))
+			codeOrDash(lane.WebhookOverrideImage),
+			lane.BackupRestore)
 	}
-	if len(plan.SkippedLanes) > 0 {
+	if len(model.SkippedLanes) > 0 {
 		fmt.Fprintf(&b, "\n## Skipped\n\n")
-		for _, skipped := range plan.SkippedLanes {
+		for _, skipped := range model.SkippedLanes {
 			fmt.Fprintf(&b, "- `%s`: %s\n", skipped.Name, skipped.Reason)
 		}
 	}
 
-	writeMetadataTable(&b, "Downstream Linode", downstream, []string{"ha_index", "k3s_version"})
-	writeMetadataTable(&b, "Webhook Signing", signingRuns, []string{"target_version", "webhook_image", "signing_policy", "enforced", "signature_verified", "provenance_verified", "sbom_verified", "verification_error"})
-	writeMetadataTable(&b, "Local Suite Targets", localSuites, []string{"ha_index"})
-	writeMetadataTable(&b, "Webhook Overrides", overrides, []string{"scope", "ha_index", "rollout_complete"})
-	writeMetadataTable(&b, "Rancher Test Runs", rancherTestRuns, []string{"ref", "lane", "rancher_version"})
-	writeMetadataTable(&b, "Rancher Test Results", rancherTests, []string{"ref", "lane", "suite", "package", "test_run", "junit", "conclusion"})
+	for _, section := range model.Sections {
+		writeMetadataTable(&b, section)
+	}
+	return b.String()
+}
 
+func renderJSON(model report) (string, error) {
+	data, err := json.MarshalIndent(model, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+func renderHTML(model report) (string, error) {
+	var b strings.Builder
+	if err := htmlReportTemplate.Execute(&b, model); err != nil {
+		return "", err
+	}
 	return b.String(), nil
 }
 
+// newSection keeps only the file and the listed columns of each row, so a
+// field the Markdown report leaves out, such as a Rancher URL, cannot reach
+// the HTML or JSON reports either.
+func newSection(id, title string, rows []metadata, columns []string, status func(metadata) string) reportSection {
+	section := reportSection{
+		ID:      id,
+		Title:   title,
+		Columns: columns,
+		Rows:    []reportRow{},
+		Status:  statusEmpty,
+	}
+	for _, row := range rows {
+		values := metadata{"file": row["file"]}
+		for _, column := range columns {
+			values[column] = row[column]
+		}
+		rowStatus := statusInfo
+		if status != nil {
+			rowStatus = status(row)
+		}
+		section.Rows = append(section.Rows, reportRow{Status: rowStatus, Values: values})
+		section.Status = worseStatus(section.Status, rowStatus)
+	}
+	return section
+}
+
+func boolStatus(column string) func(metadata) string {
+	return func(row metadata) string {
+		switch row[column] {
+		case true:
+			return statusPassed
+		case false:
+			return statusFailed
+		default:
+			return statusInfo
+		}
+	}
+}
+
+func signingStatus(row metadata) string {
+	if row["signature_verified"] == true {
+		return statusPassed
+	}
+	if row["enforced"] == true {
+		return statusFailed
+	}
+	return statusInfo
+}
+
+func conclusionStatus(row metadata) string {
+	switch row["conclusion"] {
+	case "success":
+		return statusPassed
+	case "failure":
+		return statusFailed
+	default:
+		return statusInfo
+	}
+}
+
+func junitSummaryStatus(row metadata) string {
+	if fmt.Sprint(row["failures"]) != "0" {
+		return statusFailed
+	}
+	return statusPassed
+}
+
+// worseStatus orders statuses as failed, passed, info and then empty, so a
+// section shows its worst row.
+func worseStatus(a, b string) string {
+	if statusRank[b] > statusRank[a] {
+		return b
+	}
+	return a
+}
+
+// readJUnitResults summarizes each JUnit file in dir and lists its failing
+// tests. A parent test that failed only because a subtest failed is left out
+// of the failing list.
+func readJUnitResults(dir string) ([]metadata, []metadata, error) {
+	if strings.TrimSpace(dir) == "" {
+		return nil, nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+	var summaries []metadata
+	var failing []metadata
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		cases, err := parseJUnitCases(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		file := filepath.Base(path)
+		failures, skipped := 0, 0
+		seconds := 0.0
+		var failed []junitTestCase
+		for _, testCase := range cases {
+			seconds += junitSeconds(testCase.Time)
+			switch {
+			case testCase.Failure != nil || testCase.Error != nil:
+				failures++
+				failed = append(failed, testCase)
+			case testCase.Skipped != nil:
+				skipped++
+			}
+		}
+		summaries = append(summaries, metadata{
+			"file":     file,
+			"tests":    len(cases),
+			"failures": failures,
+			"skipped":  skipped,
+			"duration": formatSeconds(seconds),
+		})
+		for _, testCase := range failed {
+			if hasFailedSubtest(testCase.Name, failed) {
+				continue
+			}
+			message := testCase.Failure
+			if message == nil {
+				message = testCase.Error
+			}
+			failing = append(failing, metadata{
+				"file":     file,
+				"test":     testCase.Name,
+				"duration": formatSeconds(junitSeconds(testCase.Time)),
+				"message":  failureSummary(message),
+			})
+		}
+	}
+	return summaries, failing, nil
+}
+
+func parseJUnitCases(data []byte) ([]junitTestCase, error) {
+	var root junitTestSuites
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	suites := root.Suites
+	if root.XMLName.Local == "testsuite" {
+		var single junitTestSuite
+		if err := xml.Unmarshal(data, &single); err != nil {
+			return nil, err
+		}
+		suites = []junitTestSuite{single}
+	}
+	var cases []junitTestCase
+	for _, suite := range suites {
+		cases = append(cases, suite.Cases...)
+	}
+	return cases, nil
+}
+
+func hasFailedSubtest(name string, failed []junitTestCase) bool {
+	for _, other := range failed {
+		if strings.HasPrefix(other.Name, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// failureSummary keeps the test's own output from a gotestsum failure body,
+// without the === RUN and --- FAIL framing, on one line.
+func failureSummary(message *junitMessage) string {
+	text := message.Body
+	if strings.TrimSpace(text) == "" {
+		text = message.Message
+	}
+	var parts []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "=== ") || strings.HasPrefix(line, "--- FAIL") || strings.HasPrefix(line, "--- PASS") {
+			continue
+		}
+		parts = append(parts, line)
+	}
+	summary := strings.ReplaceAll(strings.Join(strings.Fields(strings.Join(parts, " ")), " "), "`", "'")
+	if len(summary) > maxFailureSummary {
+		summary = summary[:maxFailureSummary] + "..."
+	}
+	return summary
+}
+
+func junitSeconds(value string) float64 {
+	seconds, _ := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return seconds
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 1, 64) + "s"
+}
+
+// readEarlierFailures maps each test recorded as failed in the ledger to the
+// earlier alphas of the plan's release line it failed on.
+func readEarlierFailures(path string, plan signoffPlan) (map[string][]string, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ledger signoffLedger
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	target, ok := parseAlpha(plan.TargetVersion)
+	if !ok {
+		return nil, nil
+	}
+	releaseLine := plan.ReleaseLine
+	if releaseLine == "" {
+		releaseLine = fmt.Sprintf("v%d.%d", target[0], target[1])
+	}
+
+	failures := map[string][]string{}
+	for version, lanes := range ledger.Entries {
+		earlier, ok := parseAlpha(version)
+		if !ok || !alphaLess(earlier, target) {
+			continue
+		}
+		for _, entry := range lanes {
+			if entry.ReleaseLine != releaseLine {
+				continue
+			}
+			for _, test := range entry.FailedTests {
+				if !containsString(failures[test], version) {
+					failures[test] = append(failures[test], version)
+				}
+			}
+		}
+	}
+	for test := range failures {
+		sort.Slice(failures[test], func(i, j int) bool {
+			a, _ := parseAlpha(failures[test][i])
+			b, _ := parseAlpha(failures[test][j])
+			return alphaLess(a, b)
+		})
+	}
+	return failures, nil
+}
+
+func markSuspectedFlakes(failing []metadata, earlierFailures map[string][]string) {
+	for _, row := range failing {
+		test, _ := row["test"].(string)
+		if versions := earlierFailures[test]; len(versions) > 0 {
+			row["suspected_flake"] = "failed on " + strings.Join(versions, ", ")
+		}
+	}
+}
+
+func parseAlpha(version string) ([4]int, bool) {
+	match := alphaVersionRE.FindStringSubmatch(strings.TrimSpace(version))
+	if len(match) != 5 {
+		return [4]int{}, false
+	}
+	var parts [4]int
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(match[i+1])
+	}
+	return parts, true
+}
+
+func alphaLess(a, b [4]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func containsString(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
 func expandRancherTestRows(testRuns []metadata) []metadata {
 	var rows []metadata
 	for _, testRun := range testRuns {
@@ -177,6 +702,46 @@ func expandRancherTestRows(testRuns []metadata) []metadata {
 	return rows
 }
 
+// expandHARows flattens the list under field in each per-HA artifact into one
+// row per entry, keeping the artifact's file and ha_index.
+func expandHARows(items []metadata, field string) []metadata {
+	var rows []metadata
+	for _, item := range items {
+		entries, ok := item[field].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, entry := range entries {
+			fields, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			row := metadata{
+				"file":     item["file"],
+				"ha_index": item["ha_index"],
+			}
+			for key, value := range fields {
+				row[key] = value
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// expandRancherSettingRows lists each applied setting and feature. The
+// server-url values are left out like every other Rancher URL.
+func expandRancherSettingRows(settings []metadata) []metadata {
+	rows := expandHARows(settings, "results")
+	for _, row := range rows {
+		if row["name"] == "server-url" {
+			delete(row, "desired")
+			delete(row, "actual")
+		}
+	}
+	return rows
+}
+
 func readMetadataFiles(pattern string) ([]metadata, error) {
 	paths, err := filepath.Glob(pattern)
 	if err != nil {
@@ -199,25 +764,25 @@ func readMetadataFiles(pattern string) ([]metadata, error) {
 	return items, nil
 }
 
-func writeMetadataTable(b *strings.Builder, title string, rows []metadata, columns []string) {
-	fmt.Fprintf(b, "\n## %s\n\n", title)
-	if len(rows) == 0 {
+func writeMetadataTable(b *strings.Builder, section reportSection) {
+	fmt.Fprintf(b, "\n## %s\n\n", section.Title)
+	if len(section.Rows) == 0 {
 		fmt.Fprintf(b, "_No records yet._\n")
 		return
 	}
 	fmt.Fprintf(b, "| File |")
-	for _, column := range columns {
+	for _, column := range section.Columns {
 		fmt.Fprintf(b, " %s |", header(column))
 	}
 	fmt.Fprintf(b, "\n| --- |")
-	for range columns {
+	for range section.Columns {
 		fmt.Fprintf(b, " --- |")
 	}
 	fmt.Fprintf(b, "\n")
-	for _, row := range rows {
-		fmt.Fprintf(b, "| %s |", md(row["file"]))
-		for _, column := range columns {
-			fmt.Fprintf(b, " %s |", md(row[column]))
+	for _, row := range section.Rows {
+		fmt.Fprintf(b, "| %s |", md(row.Values["file"]))
+		for _, column := range section.Columns {
+			fmt.Fprintf(b, " %s |", md(row.Values[column]))
 		}
 		fmt.Fprintf(b, "\n")
 	}
@@ -228,22 +793,27 @@ func header(value string) string {
 }
 
 func md(value interface{}) string {
-	if value == nil {
+	text := cellText(value)
+	if text == "" {
 		return "-"
 	}
+	return "`" + escapePipes(text) + "`"
+}
+
+// cellText is the plain text of a metadata value, with lists joined by
+// commas. It is empty for missing values.
+func cellText(value interface{}) string {
 	switch v := value.(type) {
+	case nil:
+		return ""
 	case []interface{}:
 		parts := make([]string, 0, len(v))
 		for _, item := range v {
 			parts = append(parts, fmt.Sprint(item))
 		}
-		return "`" + escapePipes(strings.Join(parts, ", ")) + "`"
+		return strings.Join(parts, ", ")
 	default:
-		text := strings.TrimSpace(fmt.Sprint(v))
-		if text == "" {
-			return "-"
-		}
-		return "`" + escapePipes(text) + "`"
+		return strings.TrimSpace(fmt.Sprint(v))
 	}
 }
 
@@ -269,3 +839,71 @@ func fatalf(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format+"\n", args...)
 	os.Exit(1)
 }
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"header": header,
+	"cell": func(value interface{}) string {
+		return valueOr(cellText(value), "-")
+	},
+	"dash": func(value string) string {
+		return valueOr(value, "-")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2rem; color: #1f2328; }
+table { border-collapse: collapse; margin: 0.5rem 0 1rem; }
+th, td { border: 1px solid #d0d7de; padding: 0.3rem 0.6rem; text-align: left; vertical-align: top; }
+th { background: #f6f8fa; }
+td { font-family: ui-monospace, Menlo, Consolas, monospace; font-size: 0.85rem; }
+details { margin: 0.5rem 0; }
+summary { cursor: pointer; font-size: 1.1rem; font-weight: 600; }
+.badge { border-radius: 1rem; font-size: 0.75rem; margin-left: 0.5rem; padding: 0.1rem 0.5rem; }
+.badge.passed { background: #1a7f37; color: #fff; }
+.badge.failed { background: #cf222e; color: #fff; }
+.badge.info { background: #0969da; color: #fff; }
+.badge.empty { background: #d0d7de; color: #1f2328; }
+tr.passed td:first-child { border-left: 4px solid #1a7f37; }
+tr.failed td { background: #ffebe9; }
+tr.failed td:first-child { border-left: 4px solid #cf222e; }
+.empty-note { color: #656d76; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated: <code>{{.GeneratedAt}}</code>{{if .ActiveLane}}<br>Active lane: <code>{{.ActiveLane}}</code>{{end}}</p>
+<details open>
+<summary>Plan</summary>
+<ul>
+<li>Target Rancher: <code>{{.Plan.TargetVersion}}</code></li>
+<li>Previous Rancher: <code>{{.Plan.PreviousVersion}}</code></li>
+<li>Webhook image: <code>{{.Plan.WebhookImage}}</code></li>
+<li>Webhook changed: <code>{{.Plan.WebhookChanged}}</code> (<code>{{.Plan.PreviousWebhookTag}}</code> -&gt; <code>{{.Plan.TargetWebhookTag}}</code>)</li>
+<li>Signing policy: <code>{{.Plan.SigningPolicy}}</code> for <code>{{.Plan.SigningRegistry}}</code></li>
+</ul>
+</details>
+<details open>
+<summary>Lanes</summary>
+<table>
+<tr><th>Lane</th><th>Install</th><th>Upgrade</th><th>Downstream</th><th>Webhook override</th><th>Backup/restore</th></tr>
+{{range .Lanes}}<tr><td>{{.Name}}</td><td>{{.InstallRancher}}</td><td>{{dash .UpgradeToRancher}}</td><td>{{.ProvisionDownstream}}</td><td>{{dash .WebhookOverrideImage}}</td><td>{{.BackupRestore}}</td></tr>
+{{end}}</table>
+{{if .SkippedLanes}}<h3>Skipped</h3>
+<ul>
+{{range .SkippedLanes}}<li><code>{{.Name}}</code>: {{.Reason}}</li>
+{{end}}</ul>
+{{end}}</details>
+{{range .Sections}}<details id="{{.ID}}"{{if eq .Status "failed"}} open{{end}}>
+<summary>{{.Title}}<span class="badge {{.Status}}">{{.Status}}</span></summary>
+{{if .Rows}}<table>
+<tr><th>File</th>{{range .Columns}}<th>{{header .}}</th>{{end}}</tr>
+{{$columns := .Columns}}{{range .Rows}}<tr class="{{.Status}}"><td>{{cell (index .Values "file")}}</td>{{$values := .Values}}{{range $columns}}<td>{{cell (index $values .)}}</td>{{end}}</tr>
+{{end}}</table>
+{{else}}<p class="empty-note">No records yet.</p>
+{{end}}</details>
+{{end}}</body>
+</html>
+`))
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
@@ -69,7 +70,7 @@ func TestRenderReportIncludesNonSecretMetadata(t *testing.T) {
 			InstallRancher:      "v2.14.1-alpha6",
 			ProvisionDownstream: true,
 		}},
-	}, dir, "fresh-alpha", time.Date(2026, 4, 24, 0, 0, 0, 0, time.UTC))
+	}, reportSources{OutputDir: dir}, "fresh-alpha", "markdown", time.Date(2026, 4, 24, 0, 0, 0, 0, time.UTC))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -85,6 +86,282 @@ func TestRenderReportIncludesNonSecretMetadata(t *testing.T) {
 	}
 }
 
+func TestRenderReportIncludesReadinessChecks(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "rancher-readiness-ha-1.json"), `{
+  "ha_index": 1,
+  "rancher_url": "https://ha1.example.com",
+  "ready": false,
+  "attempts": 75,
+  "checked_at": "2026-10-16T10:00:00Z",
+  "failed_checks": ["catalog-repos"],
+  "checks": [
+    {"name": "login", "passed": true, "detail": "admin login accepted"},
+    {"name": "catalog-repos", "passed": false, "detail": "not downloaded: rancher-charts (git clone failed)"}
+  ]
+}`)
+
+	report, err := renderReport(signoffPlan{TargetVersion: "v2.14.1-alpha6"}, reportSources{OutputDir: dir}, "", "markdown", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"## Rancher Readiness\n",
+		"| `rancher-readiness-ha-1.json` | `1` | `false` | `75` | `catalog-repos` |",
+		"## Rancher Readiness Checks",
+		"| `rancher-readiness-ha-1.json` | `1` | `catalog-repos` | `false` | `not downloaded: rancher-charts (git clone failed)` |",
+	} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("expected report to contain %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "ha1.example.com") {
+		t.Fatalf("expected report to omit the Rancher URL:\n%s", report)
+	}
+}
+
+func TestRenderReportIncludesRancherSettings(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "rancher-settings-ha-2.json"), `{
+  "ha_index": 2,
+  "applied_at": "2026-10-16T10:00:00Z",
+  "restarted": true,
+  "verified": true,
+  "results": [
+    {"kind": "setting", "name": "server-url", "desired": "https://ha2.example.com", "actual": "https://ha2.example.com", "changed": true, "verified": true},
+    {"kind": "setting", "name": "password-min-length", "desired": "14", "previous": "12", "actual": "14", "changed": true, "verified": true},
+    {"kind": "feature", "name": "ui-sql-cache", "desired": "true", "previous": "false", "actual": "true", "changed": true, "verified": true, "restart_required": true}
+  ]
+}`)
+
+	report, err := renderReport(signoffPlan{TargetVersion: "v2.14.1-alpha6"}, reportSources{OutputDir: dir}, "", "markdown", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"## Rancher Settings",
+		"| `rancher-settings-ha-2.json` | `2` | `setting` | `password-min-length` | `14` | `14` | `true` | `true` | - |",
+		"| `rancher-settings-ha-2.json` | `2` | `feature` | `ui-sql-cache` | `true` | `true` | `true` | `true` | `true` |",
+		"| `rancher-settings-ha-2.json` | `2` | `setting` | `server-url` | - | - | `true` | `true` | - |",
+	} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("expected report to contain %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "ha2.example.com") {
+		t.Fatalf("expected report to omit the server-url value:\n%s", report)
+	}
+}
+
+func TestRenderReportIncludesBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "backup-restore-ha-1.json"), `{
+  "ha_index": 1,
+  "server_version": "v2.14.1-alpha6",
+  "chart_family": "staging",
+  "chart_repo": "https://charts.example.com",
+  "chart_version": "109.0.0-rc.1+up9.0.0-rc.1",
+  "resource_set": "rancher-resource-set-basic",
+  "backup_filename": "signoff-backup-abc.tar.gz",
+  "backup_seconds": 42,
+  "restore_seconds": 310,
+  "marker_restored": true,
+  "passed": true,
+  "finished_at": "2026-10-16T10:00:00Z"
+}`)
+
+	report, err := renderReport(signoffPlan{
+		TargetVersion: "v2.14.1-alpha6",
+		Lanes: []signoffLane{{
+			Name:           "backup-restore",
+			InstallRancher: "v2.14.1-alpha6",
+			BackupRestore:  true,
+		}},
+	}, reportSources{OutputDir: dir}, "backup-restore", "markdown", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"| `backup-restore` | `v2.14.1-alpha6` | - | `false` | - | `true` |",
+		"## Backup and Restore",
+		"| `backup-restore-ha-1.json` | `1` | `v2.14.1-alpha6` | `staging` | `109.0.0-rc.1+up9.0.0-rc.1` | `rancher-resource-set-basic` | `42` | `310` | `true` | `true` | - |",
+	} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("expected report to contain %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "charts.example.com") {
+		t.Fatalf("expected report to omit the chart repo URL:\n%s", report)
+	}
+}
+
+func TestRenderReportListsFailingTestsAndSuspectedFlakes(t *testing.T) {
+	dir := t.TempDir()
+	junitDir := filepath.Join(dir, "test-results")
+	if err := os.MkdirAll(junitDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, filepath.Join(junitDir, "TestWebhookTestSuite.xml"), `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="validation/charts" tests="4">
+    <testcase classname="validation/charts" name="TestWebhookTestSuite" time="61.20">
+      <failure message="Failed">=== RUN   TestWebhookTestSuite
+--- FAIL: TestWebhookTestSuite (61.20s)</failure>
+    </testcase>
+    <testcase classname="validation/charts" name="TestWebhookTestSuite/TestWebhookChart" time="40.50">
+      <failure message="Failed">=== RUN   TestWebhookTestSuite/TestWebhookChart
+    webhook_test.go:88: expected 1 `+"`rancher-webhook`"+` pod, got 0 | retrying
+--- FAIL: TestWebhookTestSuite/TestWebhookChart (40.50s)</failure>
+    </testcase>
+    <testcase classname="validation/charts" name="TestWebhookTestSuite/TestWebhookLogs" time="3.00"></testcase>
+    <testcase classname="validation/charts" name="TestWebhookTestSuite/TestWebhookMetrics" time="0.00">
+      <skipped message="Skipped"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>`)
+	ledgerPath := filepath.Join(dir, "signoff-ledger.json")
+	mustWrite(t, ledgerPath, `{
+  "schema_version": 2,
+  "entries": {
+    "v2.14.1-alpha3": {
+      "fresh-alpha": {"release_line": "v2.14", "failed_tests": ["TestWebhookTestSuite/TestWebhookChart"]}
+    },
+    "v2.14.1-alpha5": {
+      "upgrade-alpha": {"release_line": "v2.14", "failed_tests": ["TestWebhookTestSuite/TestWebhookChart"]}
+    },
+    "v2.14.1-alpha7": {
+      "fresh-alpha": {"release_line": "v2.14", "failed_tests": ["TestWebhookTestSuite/TestWebhookChart"]}
+    },
+    "v2.13.4-alpha1": {
+      "fresh-alpha": {"release_line": "v2.13", "failed_tests": ["TestWebhookTestSuite/TestWebhookChart"]}
+    }
+  }
+}`)
+
+	report, err := renderReport(signoffPlan{
+		TargetVersion: "v2.14.1-alpha6",
+		ReleaseLine:   "v2.14",
+		Lanes: []signoffLane{{
+			Name:           "fresh-alpha",
+			InstallRancher: "v2.14.1-alpha6",
+		}},
+	}, reportSources{OutputDir: dir, JUnitDir: junitDir, LedgerPath: ledgerPath}, "fresh-alpha", "markdown", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"## Rancher Test Cases",
+		"| `TestWebhookTestSuite.xml` | `4` | `2` | `1` | `104.7s` |",
+		"## Failing Tests",
+		"| `TestWebhookTestSuite.xml` | `TestWebhookTestSuite/TestWebhookChart` | `40.5s` | `webhook_test.go:88: expected 1 'rancher-webhook' pod, got 0 \\| retrying` | `failed on v2.14.1-alpha3, v2.14.1-alpha5` |",
+	} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("expected report to contain %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "`TestWebhookTestSuite` |") {
+		t.Fatalf("expected report to leave out the parent of a failing subtest:\n%s", report)
+	}
+}
+
+func TestFailureSummaryTruncatesLongMessages(t *testing.T) {
+	summary := failureSummary(&junitMessage{Body: "=== RUN   TestLong\n" + strings.Repeat("x", 500) + "\n--- FAIL: TestLong (1.00s)"})
+	if len(summary) != maxFailureSummary+len("...") || !strings.HasSuffix(summary, "...") {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+	if got := failureSummary(&junitMessage{Message: "timed out"}); got != "timed out" {
+		t.Fatalf("expected the message attribute when the body is empty, got %q", got)
+	}
+}
+
+func TestRenderReportFormatsShareTheModel(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "webhook-signing.json"), `{
+  "target_version": "v2.14.1-alpha6",
+  "webhook_image": "stgregistry.suse.com/rancher/rancher-webhook:v0.10.1-rc.5",
+  "signing_policy": "required",
+  "enforced": true,
+  "signature_verified": false,
+  "verification_error": "no signatures found <none>"
+}`)
+	mustWrite(t, filepath.Join(dir, "downstream-ha-1.json"), `{
+  "ha_index": 1,
+  "rancher_host": "ha1.example.com",
+  "k3s_version": "v1.33.4+k3s1"
+}`)
+	plan := signoffPlan{
+		TargetVersion: "v2.14.1-alpha6",
+		ReleaseLine:   "v2.14",
+		Lanes: []signoffLane{{
+			Name:                "fresh-alpha",
+			InstallRancher:      "v2.14.1-alpha6",
+			ProvisionDownstream: true,
+			AWSPrefix:           "gha-1-fa",
+		}},
+		SkippedLanes: []skippedLane{{Name: "previous-with-candidate-webhook", Reason: "same webhook"}},
+	}
+	generatedAt := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	rendered, err := renderReport(plan, reportSources{OutputDir: dir}, "fresh-alpha", "json", generatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got report
+	if err := json.Unmarshal([]byte(rendered), &got); err != nil {
+		t.Fatalf("parse JSON report: %v\n%s", err, rendered)
+	}
+	if got.SchemaVersion != reportSchemaVersion || got.Plan.ReleaseLine != "v2.14" || got.ActiveLane != "fresh-alpha" {
+		t.Fatalf("unexpected report header: %+v", got)
+	}
+	if len(got.Lanes) != 1 || !got.Lanes[0].ProvisionDownstream || len(got.SkippedLanes) != 1 {
+		t.Fatalf("unexpected lanes: %+v %+v", got.Lanes, got.SkippedLanes)
+	}
+	sections := map[string]reportSection{}
+	for _, section := range got.Sections {
+		sections[section.ID] = section
+	}
+	if signing := sections["webhook_signing"]; signing.Status != statusFailed || len(signing.Rows) != 1 || signing.Rows[0].Values["verification_error"] != "no signatures found <none>" {
+		t.Fatalf("unexpected signing section: %+v", signing)
+	}
+	if downstream := sections["downstream"]; downstream.Status != statusInfo || downstream.Rows[0].Values["k3s_version"] != "v1.33.4+k3s1" {
+		t.Fatalf("unexpected downstream section: %+v", downstream)
+	}
+	if sections["failing_tests"].Status != statusEmpty || sections["failing_tests"].Rows == nil {
+		t.Fatalf("expected an empty failing tests section with a rows array: %+v", sections["failing_tests"])
+	}
+	for _, forbidden := range []string{"ha1.example.com", "rancher_host", "gha-1-fa"} {
+		if strings.Contains(rendered, forbidden) {
+			t.Fatalf("expected JSON report to omit %q:\n%s", forbidden, rendered)
+		}
+	}
+
+	html, err := renderReport(plan, reportSources{OutputDir: dir}, "fresh-alpha", "html", generatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>v2.14.1-alpha6 Sign-Off Report</title>",
+		`<details id="webhook_signing" open>`,
+		`<span class="badge failed">failed</span>`,
+		`<tr class="failed"><td>webhook-signing.json</td>`,
+		"no signatures found &lt;none&gt;",
+		`<details id="downstream">`,
+		"<td>v1.33.4&#43;k3s1</td>",
+		"<code>previous-with-candidate-webhook</code>: same webhook",
+	} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("expected HTML report to contain %q:\n%s", want, html)
+		}
+	}
+	if strings.Contains(html, "ha1.example.com") {
+		t.Fatalf("expected HTML report to omit the Rancher host:\n%s", html)
+	}
+
+	if _, err := renderReport(plan, reportSources{OutputDir: dir}, "", "pdf", generatedAt); err == nil {
+		t.Fatal("expected an unsupported format to fail")
+	}
+}
+
 func mustWrite(t *testing.T, path, content string) {
 	t.Helper()
 	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
@@ -16,12 +16,16 @@ type signoffPlan struct {
 }
 
 type signoffLane struct {
-	Name                 string `json:"name"`
-	InstallRancher       string `json:"install_rancher"`
-	UpgradeToRancher     string `json:"upgrade_to_rancher"`
-	WebhookOverrideImage string `json:"webhook_override_image"`
-	TerraformStateKey    string `json:"terraform_state_key"`
-	AWSPrefix            string `json:"aws_prefix"`
+	Name                 string            `json:"name"`
+	InstallRancher       string            `json:"install_rancher"`
+	UpgradeToRancher     string            `json:"upgrade_to_rancher"`
+	ProvisionDownstream  bool              `json:"provision_downstream"`
+	WebhookOverrideImage string            `json:"webhook_override_image"`
+	BackupRestore        bool              `json:"backup_restore"`
+	SuiteEnv             string            `json:"suite_env"`
+	Suites               []json.RawMessage `json:"suites"`
+	TerraformStateKey    string            `json:"terraform_state_key"`
+	AWSPrefix            string            `json:"aws_prefix"`
 }
 
 type renderConfig struct {
@@ -225,6 +229,22 @@ func renderEnvOutput(plan signoffPlan, lane signoffLane) (string, error) {
 	if err := writeGitHubEnvLine(&b, "RANCHER_WEBHOOK_IMAGE", webhookImage); err != nil {
 		return "", err
 	}
+	// The lane workflow picks its steps from these instead of the lane name.
+	if err := writeGitHubEnvLine(&b, "SIGNOFF_PROVISION_DOWNSTREAM", envFlag(lane.ProvisionDownstream)); err != nil {
+		return "", err
+	}
+	if err := writeGitHubEnvLine(&b, "SIGNOFF_WEBHOOK_OVERRIDE", envFlag(lane.WebhookOverrideImage != "")); err != nil {
+		return "", err
+	}
+	if err := writeGitHubEnvLine(&b, "SIGNOFF_BACKUP_RESTORE", envFlag(lane.BackupRestore)); err != nil {
+		return "", err
+	}
+	if err := writeGitHubEnvLine(&b, "SIGNOFF_SUITE_ENV", lane.SuiteEnv); err != nil {
+		return "", err
+	}
+	if err := writeGitHubEnvLine(&b, "SIGNOFF_RANCHER_TESTS", envFlag(len(lane.Suites) > 0)); err != nil {
+		return "", err
+	}
 	return b.String(), nil
 }
 
@@ -240,6 +260,13 @@ func writeGitHubEnvLine(b *strings.Builder, key, value string) error {
 	return nil
 }
 
+func envFlag(enabled bool) string {
+	if enabled {
+		return "true"
+	}
+	return ""
+}
+
 func yamlQuote(value string) string {
 	return strconv.Quote(value)
 }
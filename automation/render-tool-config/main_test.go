@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
@@ -149,6 +150,39 @@ func TestRenderEnvOutputPrefersLaneWebhookOverride(t *testing.T) {
 		t.Fatal(err)
 	}
 	assertContains(t, env, "RANCHER_WEBHOOK_IMAGE=registry.rancher.com/rancher/rancher-webhook:v0.10.1-rc.5")
+	assertContains(t, env, "SIGNOFF_WEBHOOK_OVERRIDE=true")
+}
+
+func TestRenderEnvOutputExportsLaneSteps(t *testing.T) {
+	var plan signoffPlan
+	if err := json.Unmarshal([]byte(`{
+		"webhook_image": "stgregistry.suse.com/rancher/rancher-webhook:v0.10.1-rc.5",
+		"lanes": [
+			{"name": "fresh-alpha-local-suites", "install_rancher": "v2.14.1-alpha7", "suite_env": "local", "suites": [{"name": "TestConfigMapTestSuite"}]},
+			{"name": "backup-restore", "install_rancher": "v2.14.1-alpha7", "backup_restore": true}
+		]
+	}`), &plan); err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := renderEnvOutput(plan, plan.Lanes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, local, "SIGNOFF_SUITE_ENV=local")
+	assertContains(t, local, "SIGNOFF_RANCHER_TESTS=true")
+	if strings.Contains(local, "SIGNOFF_PROVISION_DOWNSTREAM") || strings.Contains(local, "SIGNOFF_BACKUP_RESTORE") {
+		t.Fatalf("unexpected lane steps for local suites: %s", local)
+	}
+
+	backup, err := renderEnvOutput(plan, plan.Lanes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, backup, "SIGNOFF_BACKUP_RESTORE=true")
+	if strings.Contains(backup, "SIGNOFF_RANCHER_TESTS") || strings.Contains(backup, "SIGNOFF_SUITE_ENV") {
+		t.Fatalf("unexpected lane steps for backup-restore: %s", backup)
+	}
 }
 
 func assertContains(t *testing.T, haystack, needle string) {
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+)
+
+type resolveConfig struct {
+	Versions   []string
+	Distro     string
+	RecordDir  string
+	ReplayDir  string
+	OutputPath string
+}
+
+func main() {
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := run(cfg, os.Stdout); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func parseFlags(args []string) (resolveConfig, error) {
+	cfg := resolveConfig{}
+	var versions string
+
+	flags := flag.NewFlagSet("resolve-rancher-plan", flag.ContinueOnError)
+	flags.StringVar(&versions, "versions", "", "comma-separated Rancher versions to resolve, for example 2.13.4,v2.14.1-alpha3")
+	flags.StringVar(&cfg.Distro, "distro", "auto", "Rancher distro: auto, community, or prime")
+	flags.StringVar(&cfg.RecordDir, "record", "", "resolve live and snapshot every external lookup into this fixture directory")
+	flags.StringVar(&cfg.ReplayDir, "replay", "", "resolve fully offline from this fixture directory")
+	flags.StringVar(&cfg.OutputPath, "output", "", "optional JSON output path")
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	for _, version := range strings.Split(versions, ",") {
+		if version = strings.TrimSpace(version); version != "" {
+			cfg.Versions = append(cfg.Versions, version)
+		}
+	}
+	cfg.Versions = append(cfg.Versions, flags.Args()...)
+	if len(cfg.Versions) == 0 {
+		return cfg, fmt.Errorf("set -versions or pass Rancher versions as arguments")
+	}
+	if cfg.RecordDir != "" && cfg.ReplayDir != "" {
+		return cfg, fmt.Errorf("set only one of -record or -replay")
+	}
+	return cfg, nil
+}
+
+func run(cfg resolveConfig, stdout io.Writer) error {
+	options := resolver.RancherResolveOptions{
+		Versions: cfg.Versions,
+		Distro:   cfg.Distro,
+	}
+	switch {
+	case cfg.RecordDir != "":
+		options.FixtureMode, options.FixtureDir = "record", cfg.RecordDir
+	case cfg.ReplayDir != "":
+		options.FixtureMode, options.FixtureDir = "replay", cfg.ReplayDir
+	}
+
+	plans, err := resolver.ResolveRancherPlans(options)
+	if err != nil {
+		return fmt.Errorf("resolve Rancher plans: %w", err)
+	}
+
+	data, err := json.MarshalIndent(plans, "", "  ")
+	if err != nil {
+		return fmt.Errorf("render plan JSON: %w", err)
+	}
+	data = append(data, '\n')
+
+	if cfg.OutputPath != "" {
+		if err := os.WriteFile(cfg.OutputPath, data, 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", cfg.OutputPath, err)
+		}
+	}
+	_, err = stdout.Write(data)
+	return err
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseFlagsCollectsVersions(t *testing.T) {
+	cfg, err := parseFlags([]string{"-versions", "2.13.4, v2.14.1-alpha3", "-replay", "fixtures", "head"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(cfg.Versions, ",") != "2.13.4,v2.14.1-alpha3,head" || cfg.ReplayDir != "fixtures" || cfg.Distro != "auto" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	if _, err := parseFlags([]string{"-record", "a", "-replay", "b", "2.13.4"}); err == nil {
+		t.Fatal("expected -record and -replay to be mutually exclusive")
+	}
+	if _, err := parseFlags(nil); err == nil {
+		t.Fatal("expected an error without versions")
+	}
+}
+
+func TestRunReplaysFixturesAsJSON(t *testing.T) {
+	var stdout bytes.Buffer
+	err := run(resolveConfig{
+		Versions:  []string{"2.13.4"},
+		Distro:    "auto",
+		ReplayDir: "../../terratest/resolver/testdata/resolver-fixtures",
+	}, &stdout)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var plans []map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &plans); err != nil {
+		t.Fatalf("expected JSON output, got %v:\n%s", err, stdout.String())
+	}
+	if len(plans) != 1 || plans[0]["ChartRepoAlias"] != "rancher-prime" || plans[0]["RecommendedRKE2Version"] != "v1.34.6+rke2r1" {
+		t.Fatalf("unexpected plans: %s", stdout.String())
+	}
+}
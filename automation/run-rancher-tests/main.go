@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultTestsRepo  = "https://github.com/rancher/tests.git"
+	maxFailureMessage = 2048
+)
+
+type signoffPlan struct {
+	TargetVersion string        `json:"target_version"`
+	Lanes         []signoffLane `json:"lanes"`
+}
+
+type signoffLane struct {
+	Name     string      `json:"name"`
+	SuiteEnv string      `json:"suite_env"`
+	Suites   []laneSuite `json:"suites"`
+}
+
+type laneSuite struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	Run     string `json:"run"`
+	Tags    string `json:"tags"`
+	Timeout string `json:"timeout"`
+	JUnit   string `json:"junit"`
+}
+
+// suiteEnv is the env file TestHAWriteLocalSuiteEnv or the downstream
+// provisioning test writes for rancher/tests.
+type suiteEnv struct {
+	Host        string
+	AdminToken  string
+	ClusterName string
+}
+
+type runConfig struct {
+	Lane           signoffLane
+	RancherVersion string
+	Env            suiteEnv
+	TestsDir       string
+	TestsRepo      string
+	TestsRef       string
+	ConfigPath     string
+	JUnitDir       string
+	Gotestsum      string
+	Settle         time.Duration
+	Stdout         io.Writer
+	Stderr         io.Writer
+	sleep          func(time.Duration)
+}
+
+type testRunResults struct {
+	Repo           string        `json:"repo"`
+	Ref            string        `json:"ref"`
+	Lane           string        `json:"lane"`
+	RancherVersion string        `json:"rancher_version"`
+	Results        []suiteResult `json:"results"`
+}
+
+type suiteResult struct {
+	Suite      string           `json:"suite"`
+	Package    string           `json:"package"`
+	TestRun    string           `json:"test_run"`
+	JUnit      string           `json:"junit"`
+	Conclusion string           `json:"conclusion"`
+	Tests      int              `json:"tests"`
+	Failures   int              `json:"failures"`
+	Skipped    int              `json:"skipped"`
+	Seconds    float64          `json:"seconds"`
+	Cases      []testCaseResult `json:"cases,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type testCaseResult struct {
+	Name      string  `json:"name"`
+	Classname string  `json:"classname"`
+	Status    string  `json:"status"`
+	Seconds   float64 `json:"seconds"`
+	Message   string  `json:"message,omitempty"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:""`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name  string          `xml:"name,attr"`
+	Cases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type cattleConfig struct {
+	Rancher cattleRancher `yaml:"rancher"`
+}
+
+type cattleRancher struct {
+	Host        string `yaml:"host"`
+	AdminToken  string `yaml:"adminToken"`
+	Cleanup     bool   `yaml:"cleanup"`
+	Insecure    bool   `yaml:"insecure"`
+	ClusterName string `yaml:"clusterName"`
+}
+
+func main() {
+	var planPath string
+	var laneName string
+	var envPath string
+	var testsDir string
+	var testsRepo string
+	var testsRef string
+	var configPath string
+	var junitDir string
+	var outputPath string
+	var gotestsum string
+	var settle time.Duration
+
+	flag.StringVar(&planPath, "plan", "signoff-plan.json", "sign-off plan JSON path")
+	flag.StringVar(&laneName, "lane", "", "lane whose suites to run")
+	flag.StringVar(&envPath, "env-file", "", "suite env file; defaults to the file for the lane's suite_env")
+	flag.StringVar(&testsDir, "tests-dir", "", "rancher/tests checkout path")
+	flag.StringVar(&testsRepo, "tests-repo", defaultTestsRepo, "rancher/tests repository recorded in the results")
+	flag.StringVar(&testsRef, "tests-ref", envOrDefault("RANCHER_TESTS_REF", "main"), "rancher/tests ref recorded in the results")
+	flag.StringVar(&configPath, "config", filepath.Join(os.TempDir(), "cattle-config.yaml"), "cattle config path written for rancher/tests")
+	flag.StringVar(&junitDir, "junit-dir", "test-results", "directory for JUnit XML files")
+	flag.StringVar(&outputPath, "output", filepath.Join("automation-output", "rancher-test-results.json"), "results JSON path")
+	flag.StringVar(&gotestsum, "gotestsum", "gotestsum", "gotestsum executable path")
+	flag.DurationVar(&settle, "settle", settleFromEnv(), "pause between suites")
+	flag.Parse()
+
+	if strings.TrimSpace(laneName) == "" {
+		fatalf("set -lane")
+	}
+	if strings.TrimSpace(testsDir) == "" {
+		fatalf("set -tests-dir to a rancher/tests checkout")
+	}
+	plan, err := readPlan(planPath)
+	if err != nil {
+		fatalf("read plan: %v", err)
+	}
+	lane, err := findLane(plan, laneName)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if envPath == "" {
+		envPath, err = defaultEnvPath(lane.SuiteEnv)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+	env, err := readSuiteEnv(envPath)
+	if err != nil {
+		fatalf("read suite env: %v", err)
+	}
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		fmt.Printf("::add-mask::%s\n::add-mask::%s\n::add-mask::https://%s\n", env.AdminToken, env.Host, env.Host)
+	}
+
+	results, runErr := runLaneSuites(runConfig{
+		Lane:           lane,
+		RancherVersion: plan.TargetVersion,
+		Env:            env,
+		TestsDir:       testsDir,
+		TestsRepo:      testsRepo,
+		TestsRef:       testsRef,
+		ConfigPath:     configPath,
+		JUnitDir:       junitDir,
+		Gotestsum:      gotestsum,
+		Settle:         settle,
+	})
+	if len(results.Results) > 0 {
+		if err := writeResults(outputPath, results); err != nil {
+			fatalf("write results: %v", err)
+		}
+	}
+	if runErr != nil {
+		fatalf("%v", runErr)
+	}
+}
+
+func readPlan(path string) (signoffPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signoffPlan{}, err
+	}
+	var plan signoffPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return signoffPlan{}, err
+	}
+	return plan, nil
+}
+
+func findLane(plan signoffPlan, laneName string) (signoffLane, error) {
+	for _, lane := range plan.Lanes {
+		if lane.Name == laneName {
+			if len(lane.Suites) == 0 {
+				return signoffLane{}, fmt.Errorf("lane %q has no rancher/tests suites", laneName)
+			}
+			return lane, nil
+		}
+	}
+	return signoffLane{}, fmt.Errorf("lane %q not found", laneName)
+}
+
+func defaultEnvPath(suiteEnv string) (string, error) {
+	switch suiteEnv {
+	case "local":
+		return filepath.Join("automation-output", "local-suite-ha-1.env"), nil
+	case "downstream":
+		return filepath.Join("automation-output", "downstream-ha-1.env"), nil
+	default:
+		return "", fmt.Errorf("lane has no suite_env; set -env-file")
+	}
+}
+
+func readSuiteEnv(path string) (suiteEnv, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return suiteEnv{}, err
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	if err := scanner.Err(); err != nil {
+		return suiteEnv{}, err
+	}
+
+	env := suiteEnv{
+		Host:        normalizeHost(values["RANCHER_HOST"]),
+		AdminToken:  values["RANCHER_ADMIN_TOKEN"],
+		ClusterName: values["CLUSTER_NAME"],
+	}
+	var missing []string
+	if env.Host == "" {
+		missing = append(missing, "RANCHER_HOST")
+	}
+	if env.AdminToken == "" {
+		missing = append(missing, "RANCHER_ADMIN_TOKEN")
+	}
+	if env.ClusterName == "" {
+		missing = append(missing, "CLUSTER_NAME")
+	}
+	if len(missing) > 0 {
+		return suiteEnv{}, fmt.Errorf("%s is missing %s", path, strings.Join(missing, ", "))
+	}
+	return env, nil
+}
+
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	return strings.TrimRight(host, "/")
+}
+
+// runLaneSuites checks API access, writes the cattle config and runs each
+// suite through gotestsum. A failing suite does not stop the ones after it;
+// the returned error reports any failure once every suite has run.
+func runLaneSuites(cfg runConfig) (testRunResults, error) {
+	results := testRunResults{
+		Repo:           cfg.TestsRepo,
+		Ref:            cfg.TestsRef,
+		Lane:           cfg.Lane.Name,
+		RancherVersion: cfg.RancherVersion,
+		Results:        []suiteResult{},
+	}
+	if cfg.Stdout == nil {
+		cfg.Stdout = os.Stdout
+	}
+	if cfg.Stderr == nil {
+		cfg.Stderr = os.Stderr
+	}
+	if cfg.sleep == nil {
+		cfg.sleep = time.Sleep
+	}
+
+	gotestsum, err := exec.LookPath(cfg.Gotestsum)
+	if err != nil {
+		return results, fmt.Errorf("find gotestsum: %w", err)
+	}
+	if err := verifyRancherAPI(cfg.Env); err != nil {
+		return results, err
+	}
+	fmt.Fprintln(cfg.Stdout, "Verified Rancher API access for rancher/tests")
+
+	configPath, err := filepath.Abs(cfg.ConfigPath)
+	if err != nil {
+		return results, err
+	}
+	if err := writeCattleConfig(configPath, cfg.Env); err != nil {
+		return results, fmt.Errorf("write cattle config: %w", err)
+	}
+	if err := os.MkdirAll(cfg.JUnitDir, 0o755); err != nil {
+		return results, err
+	}
+
+	var failed []string
+	for i, suite := range cfg.Lane.Suites {
+		if i > 0 && cfg.Settle > 0 {
+			fmt.Fprintf(cfg.Stdout, "Settling for %s before running %s\n", cfg.Settle, suite.Name)
+			cfg.sleep(cfg.Settle)
+		}
+		result := runSuite(cfg, gotestsum, configPath, suite)
+		if result.Conclusion != "success" {
+			failed = append(failed, result.TestRun)
+		}
+		results.Results = append(results.Results, result)
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("rancher/tests failed: %s", strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+func runSuite(cfg runConfig, gotestsum, configPath string, suite laneSuite) suiteResult {
+	junitPath := filepath.Join(cfg.JUnitDir, suite.JUnit+".xml")
+	result := suiteResult{
+		Suite:      suite.Run,
+		Package:    suite.Package,
+		TestRun:    suite.Run,
+		JUnit:      filepath.ToSlash(junitPath),
+		Conclusion: "success",
+	}
+	absJUnit, err := filepath.Abs(junitPath)
+	if err != nil {
+		result.Conclusion = "failure"
+		result.Error = err.Error()
+		return result
+	}
+	// A JUnit file from an earlier run must not stand in for this one.
+	_ = os.Remove(absJUnit)
+
+	args := []string{"--format", "standard-verbose", "--junitfile", absJUnit, "--", "-v"}
+	if suite.Timeout != "" {
+		args = append(args, "-timeout", suite.Timeout)
+	}
+	args = append(args, "-tags="+suite.Tags, suite.Package, "-run", suite.Run)
+
+	fmt.Fprintf(cfg.Stdout, "Running rancher/tests %s -run %s against cluster %s\n", suite.Package, suite.Run, cfg.Env.ClusterName)
+	cmd := exec.Command(gotestsum, args...)
+	cmd.Dir = cfg.TestsDir
+	cmd.Env = append(os.Environ(), "CATTLE_TEST_CONFIG="+configPath)
+	cmd.Stdout = cfg.Stdout
+	cmd.Stderr = cfg.Stderr
+	if err := cmd.Run(); err != nil {
+		result.Conclusion = "failure"
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			result.Error = err.Error()
+		}
+	}
+
+	cases, err := parseJUnitFile(absJUnit)
+	if err != nil {
+		fmt.Fprintf(cfg.Stderr, "Could not read JUnit results for %s: %v\n", suite.Run, err)
+		return result
+	}
+	result.Cases = cases
+	for _, testCase := range cases {
+		result.Tests++
+		result.Seconds += testCase.Seconds
+		switch testCase.Status {
+		case "failed":
+			result.Failures++
+		case "skipped":
+			result.Skipped++
+		}
+	}
+	return result
+}
+
+// verifyRancherAPI makes one cheap authenticated call so a bad host or token
+// fails before any suite is cloned or run.
+func verifyRancherAPI(env suiteEnv) error {
+	client, err := rancherclient.New(env.Host, rancherclient.Options{Insecure: true, Token: env.AdminToken})
+	if err != nil {
+		return err
+	}
+	if _, err := client.GetSetting(context.Background(), "server-version"); err != nil {
+		return fmt.Errorf("rancher API check failed: %w", err)
+	}
+	return nil
+}
+
+func writeCattleConfig(path string, env suiteEnv) error {
+	data, err := yaml.Marshal(cattleConfig{Rancher: cattleRancher{
+		Host:        env.Host,
+		AdminToken:  env.AdminToken,
+		Cleanup:     true,
+		Insecure:    true,
+		ClusterName: env.ClusterName,
+	}})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0o600)
+}
+
+func parseJUnitFile(path string) ([]testCaseResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseJUnit(data)
+}
+
+// parseJUnit reads gotestsum JUnit XML, which has a testsuites root, and
+// also accepts a single testsuite root.
+func parseJUnit(data []byte) ([]testCaseResult, error) {
+	var root junitTestSuites
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	suites := root.Suites
+	if root.XMLName.Local == "testsuite" {
+		var single junitTestSuite
+		if err := xml.Unmarshal(data, &single); err != nil {
+			return nil, err
+		}
+		suites = []junitTestSuite{single}
+	}
+
+	cases := []testCaseResult{}
+	for _, suite := range suites {
+		for _, testCase := range suite.Cases {
+			seconds, _ := strconv.ParseFloat(strings.TrimSpace(testCase.Time), 64)
+			result := testCaseResult{
+				Name:      testCase.Name,
+				Classname: testCase.Classname,
+				Status:    "passed",
+				Seconds:   seconds,
+			}
+			switch {
+			case testCase.Failure != nil:
+				result.Status = "failed"
+				result.Message = junitMessageText(testCase.Failure)
+			case testCase.Error != nil:
+				result.Status = "failed"
+				result.Message = junitMessageText(testCase.Error)
+			case testCase.Skipped != nil:
+				result.Status = "skipped"
+				result.Message = junitMessageText(testCase.Skipped)
+			}
+			cases = append(cases, result)
+		}
+	}
+	return cases, nil
+}
+
+// junitMessageText prefers the element body because gotestsum puts the test
+// output there and only "Failed" in the message attribute.
+func junitMessageText(message *junitMessage) string {
+	text := strings.TrimSpace(message.Body)
+	if text == "" {
+		text = strings.TrimSpace(message.Message)
+	}
+	if len(text) > maxFailureMessage {
+		text = text[:maxFailureMessage] + "\n[output truncated]"
+	}
+	return text
+}
+
+func writeResults(path string, results testRunResults) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func settleFromEnv() time.Duration {
+	seconds, err := strconv.Atoi(envOrDefault("RANCHER_TEST_SUITE_SETTLE_SECONDS", "30"))
+	if err != nil || seconds < 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const passingJUnit = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="2" failures="0" errors="0" time="3.5">
+  <testsuite tests="2" failures="0" time="3.5" name="github.com/rancher/tests/validation/charts">
+    <testcase classname="github.com/rancher/tests/validation/charts" name="TestWebhookTestSuite" time="3.000000"></testcase>
+    <testcase classname="github.com/rancher/tests/validation/charts" name="TestWebhookTestSuite/TestSkipped" time="0.500000">
+      <skipped message="=== RUN   TestWebhookTestSuite/TestSkipped&#xA;    no downstream&#xA;"></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>`
+
+const failingJUnit = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="2" failures="1" errors="0" time="12.0">
+  <testsuite tests="2" failures="1" time="12.0" name="github.com/rancher/tests/validation/configmaps">
+    <testcase classname="github.com/rancher/tests/validation/configmaps" name="TestConfigMapTestSuite" time="10.000000">
+      <failure message="Failed" type="">=== RUN   TestConfigMapTestSuite&#xA;    configmap_test.go:42: timed out waiting for configmap&#xA;</failure>
+    </testcase>
+    <testcase classname="github.com/rancher/tests/validation/configmaps" name="TestConfigMapTestSuite/TestCreate" time="2.000000"></testcase>
+  </testsuite>
+</testsuites>`
+
+func TestRunLaneSuitesWritesResultsFromJUnit(t *testing.T) {
+	dir := t.TempDir()
+	host := fakeRancherAPI(t, "token-abc")
+	logPath := filepath.Join(dir, "calls.log")
+	junitDir := filepath.Join(dir, "test-results")
+	gotestsum := writeFakeGotestsum(t, dir, logPath, `
+case "$*" in
+  *"-run TestWebhookTestSuite"*)
+    cat > "$junit" <<'XML'
+`+passingJUnit+`
+XML
+    ;;
+  *)
+    cat > "$junit" <<'XML'
+`+failingJUnit+`
+XML
+    exit 1
+    ;;
+esac
+`)
+
+	var slept []time.Duration
+	var stdout bytes.Buffer
+	results, err := runLaneSuites(runConfig{
+		Lane: signoffLane{Name: "fresh-alpha", SuiteEnv: "downstream", Suites: []laneSuite{
+			{Name: "TestWebhookTestSuite", Package: "./validation/charts", Run: "TestWebhookTestSuite", Tags: "validation,infra.any,cluster.any", JUnit: "TestWebhookTestSuite"},
+			{Name: "TestConfigMapTestSuite", Package: "./validation/configmaps", Run: "TestConfigMapTestSuite", Tags: "validation,infra.any,cluster.any", Timeout: "30m", JUnit: "TestConfigMapTestSuite"},
+		}},
+		RancherVersion: "v2.14.1-alpha6",
+		Env:            suiteEnv{Host: host, AdminToken: "token-abc", ClusterName: "signoff-ds"},
+		TestsDir:       dir,
+		TestsRepo:      defaultTestsRepo,
+		TestsRef:       "main",
+		ConfigPath:     filepath.Join(dir, "cattle-config.yaml"),
+		JUnitDir:       junitDir,
+		Gotestsum:      gotestsum,
+		Settle:         5 * time.Second,
+		Stdout:         &stdout,
+		Stderr:         &stdout,
+		sleep:          func(d time.Duration) { slept = append(slept, d) },
+	})
+	if err == nil || !strings.Contains(err.Error(), "TestConfigMapTestSuite") {
+		t.Fatalf("expected the failing suite to be reported, got %v", err)
+	}
+	if len(slept) != 1 || slept[0] != 5*time.Second {
+		t.Fatalf("expected one settle between suites, got %v", slept)
+	}
+
+	if len(results.Results) != 2 {
+		t.Fatalf("expected both suites to run, got %+v", results.Results)
+	}
+	webhook, configMap := results.Results[0], results.Results[1]
+	if webhook.Conclusion != "success" || webhook.Tests != 2 || webhook.Skipped != 1 || webhook.Failures != 0 || webhook.Seconds != 3.5 {
+		t.Fatalf("unexpected webhook result: %+v", webhook)
+	}
+	if webhook.JUnit != filepath.ToSlash(filepath.Join(junitDir, "TestWebhookTestSuite.xml")) {
+		t.Fatalf("unexpected JUnit path: %s", webhook.JUnit)
+	}
+	if configMap.Conclusion != "failure" || configMap.Failures != 1 || configMap.Error != "" {
+		t.Fatalf("unexpected configmap result: %+v", configMap)
+	}
+	if !strings.Contains(configMap.Cases[0].Message, "timed out waiting for configmap") {
+		t.Fatalf("expected the failure output as the message, got %q", configMap.Cases[0].Message)
+	}
+
+	calls, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(calls)
+	for _, want := range []string{
+		"-v -tags=validation,infra.any,cluster.any ./validation/charts -run TestWebhookTestSuite",
+		"-v -timeout 30m -tags=validation,infra.any,cluster.any ./validation/configmaps -run TestConfigMapTestSuite",
+		"CATTLE_TEST_CONFIG=" + filepath.Join(dir, "cattle-config.yaml"),
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in gotestsum calls:\n%s", want, got)
+		}
+	}
+
+	config, err := os.ReadFile(filepath.Join(dir, "cattle-config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"host: " + host, "adminToken: token-abc", "clusterName: signoff-ds", "insecure: true"} {
+		if !strings.Contains(string(config), want) {
+			t.Fatalf("expected %q in cattle config:\n%s", want, config)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "cattle-config.yaml")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a 0600 cattle config, got %v, %v", info, err)
+	}
+}
+
+func TestRunLaneSuitesStopsWhenRancherAPIRejectsToken(t *testing.T) {
+	dir := t.TempDir()
+	host := fakeRancherAPI(t, "token-abc")
+	logPath := filepath.Join(dir, "calls.log")
+	gotestsum := writeFakeGotestsum(t, dir, logPath, "")
+
+	results, err := runLaneSuites(runConfig{
+		Lane:       signoffLane{Name: "fresh-alpha", Suites: []laneSuite{{Name: "TestWebhookTestSuite", Run: "TestWebhookTestSuite", JUnit: "TestWebhookTestSuite"}}},
+		Env:        suiteEnv{Host: host, AdminToken: "wrong", ClusterName: "local"},
+		TestsDir:   dir,
+		ConfigPath: filepath.Join(dir, "cattle-config.yaml"),
+		JUnitDir:   filepath.Join(dir, "test-results"),
+		Gotestsum:  gotestsum,
+		Stdout:     &bytes.Buffer{},
+	})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected an API check failure, got %v", err)
+	}
+	if len(results.Results) != 0 {
+		t.Fatalf("expected no suites to run, got %+v", results.Results)
+	}
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Fatal("expected gotestsum not to run")
+	}
+}
+
+func TestReadSuiteEnvNormalizesHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "downstream-ha-1.env")
+	if err := os.WriteFile(path, []byte("RANCHER_HOST=https://rancher.example.com/\nRANCHER_ADMIN_TOKEN=token-abc\nCLUSTER_NAME=signoff-ds\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, err := readSuiteEnv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Host != "rancher.example.com" || env.AdminToken != "token-abc" || env.ClusterName != "signoff-ds" {
+		t.Fatalf("unexpected env: %+v", env)
+	}
+
+	if err := os.WriteFile(path, []byte("RANCHER_HOST=rancher.example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSuiteEnv(path); err == nil || !strings.Contains(err.Error(), "RANCHER_ADMIN_TOKEN, CLUSTER_NAME") {
+		t.Fatalf("expected missing keys to be reported, got %v", err)
+	}
+}
+
+func TestFindLaneUsesPlanSuites(t *testing.T) {
+	var plan signoffPlan
+	if err := json.Unmarshal([]byte(`{"target_version":"v2.14.1-alpha6","lanes":[
+		{"name":"fresh-alpha-local-suites","suite_env":"local","suites":[{"name":"TestVaiTestSuite/TestVaiEnabled","package":"./validation/steve/vai","run":"TestVaiTestSuite/TestVaiEnabled","tags":"validation,extended","timeout":"30m","junit":"TestVaiTestSuite-TestVaiEnabled"}]},
+		{"name":"backup-restore"}
+	]}`), &plan); err != nil {
+		t.Fatal(err)
+	}
+	lane, err := findLane(plan, "fresh-alpha-local-suites")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path, err := defaultEnvPath(lane.SuiteEnv); err != nil || path != filepath.Join("automation-output", "local-suite-ha-1.env") {
+		t.Fatalf("unexpected env path %q, %v", path, err)
+	}
+	if lane.Suites[0].Timeout != "30m" || lane.Suites[0].JUnit != "TestVaiTestSuite-TestVaiEnabled" {
+		t.Fatalf("unexpected suite: %+v", lane.Suites[0])
+	}
+	if _, err := findLane(plan, "backup-restore"); err == nil {
+		t.Fatal("expected a lane without suites to be rejected")
+	}
+	if _, err := findLane(plan, "missing"); err == nil {
+		t.Fatal("expected a missing lane to be rejected")
+	}
+}
+
+func TestParseJUnitAcceptsSingleTestsuiteRoot(t *testing.T) {
+	cases, err := parseJUnit([]byte(`<testsuite name="pkg"><testcase name="TestA" classname="pkg" time="1.5"><error message="panic">stack</error></testcase></testsuite>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 1 || cases[0].Status != "failed" || cases[0].Message != "stack" || cases[0].Seconds != 1.5 {
+		t.Fatalf("unexpected cases: %+v", cases)
+	}
+
+	long := strings.Repeat("x", maxFailureMessage+10)
+	cases, err = parseJUnit([]byte(`<testsuites><testsuite><testcase name="TestB"><failure message="Failed">` + long + `</failure></testcase></testsuite></testsuites>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(cases[0].Message, "[output truncated]") {
+		t.Fatalf("expected a truncated message, got %d bytes", len(cases[0].Message))
+	}
+}
+
+func fakeRancherAPI(t *testing.T, token string) string {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/settings/server-version" || r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"server-version","value":"v2.13.0-alpha1"}`))
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "https://")
+}
+
+// writeFakeGotestsum writes a gotestsum stand-in that logs its arguments and
+// CATTLE_TEST_CONFIG, sets $junit to the --junitfile path and then runs body.
+func writeFakeGotestsum(t *testing.T, dir, logPath, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "gotestsum")
+	script := "#!/bin/sh\n" +
+		"set -eu\n" +
+		"echo \"$@\" >> " + shellQuote(logPath) + "\n" +
+		"echo \"CATTLE_TEST_CONFIG=$CATTLE_TEST_CONFIG\" >> " + shellQuote(logPath) + "\n" +
+		"junit=\"\"\n" +
+		"prev=\"\"\n" +
+		"for arg in \"$@\"; do\n" +
+		"  if [ \"$prev\" = \"--junitfile\" ]; then junit=\"$arg\"; fi\n" +
+		"  prev=\"$arg\"\n" +
+		"done\n" +
+		body + "\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake gotestsum: %v", err)
+	}
+	return path
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "'\\''") + "'"
+}
@@ -93,10 +93,14 @@ func prepareArtifacts(sourceDir, outputDir string) error {
 		}
 	}
 
-	if copiedPath, err := sanitizeTextFile(filepath.Join(sourceDir, "automation-output", "signoff-report.md"), filepath.Join(outputDir, "automation-output", "signoff-report.md")); err != nil {
-		return err
-	} else if copiedPath != "" {
-		copied = append(copied, copiedPath)
+	for _, name := range []string{"signoff-report.md", "signoff-report.html"} {
+		copiedPath, err := sanitizeTextFile(filepath.Join(sourceDir, "automation-output", name), filepath.Join(outputDir, "automation-output", name))
+		if err != nil {
+			return err
+		}
+		if copiedPath != "" {
+			copied = append(copied, copiedPath)
+		}
 	}
 
 	xmlFiles, err := filepath.Glob(filepath.Join(sourceDir, "test-results", "*.xml"))
@@ -67,6 +67,7 @@ func TestPrepareArtifactsSanitizesPublicBundle(t *testing.T) {
   }
 ]`)
 	writeTestFile(t, filepath.Join(sourceDir, "automation-output", "signoff-report.md"), "Host https://gha-32790833-ua-1-hippo-4e22.qa.rancher.space cluster gha-32790833-ha1-61abcbed\n")
+	writeTestFile(t, filepath.Join(sourceDir, "automation-output", "signoff-report.html"), "<td>https://gha-32790833-ua-1-hippo-4e22.qa.rancher.space</td>\n")
 	writeTestFile(t, filepath.Join(sourceDir, "test-results", "suite.xml"), `<testcase name="Verify_on_gha-32790833-ha1-61abcbed"></testcase>`)
 
 	if err := prepareArtifacts(sourceDir, outputDir); err != nil {
@@ -81,6 +82,7 @@ func TestPrepareArtifactsSanitizesPublicBundle(t *testing.T) {
 	assertFileOmits(t, filepath.Join(outputDir, "automation-output", "webhook-override-downstream-ha-1.json"), "cluster_name", "namespace", "deployment", "container", "previous_image", "candidate_image", "gha-32790833", "registry.example.invalid")
 	assertFileOmits(t, filepath.Join(outputDir, "automation-output", "signoff-dispatch-results.json"), "databaseId", "url", "https://github.com", "25132790833")
 	assertFileOmits(t, filepath.Join(outputDir, "automation-output", "signoff-report.md"), "https://", "gha-32790833", "qa.rancher.space")
+	assertFileOmits(t, filepath.Join(outputDir, "automation-output", "signoff-report.html"), "https://", "gha-32790833", "qa.rancher.space")
 	assertFileOmits(t, filepath.Join(outputDir, "test-results", "suite.xml"), "gha-32790833")
 	assertFileOmits(t, filepath.Join(outputDir, "artifact-summary.md"), outputDir)
 
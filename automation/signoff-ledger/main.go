@@ -4,17 +4,34 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 )
 
 const (
 	ledgerSchemaVersion   = 2
 	currentCoveragePolicy = "alpha-webhook-signoff-v2"
+
+	statusSuccess     = "success"
+	statusFailure     = "failure"
+	statusInvalidated = "invalidated"
+
+	commandUsage = "expected record, list, show, coverage, prune, migrate or invalidate"
 )
 
+var ledgerVersionRE = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([A-Za-z]+)\.?(\d+))?$`)
+
+// ledgerMigrations upgrade a ledger from the schema_version they are keyed by
+// to the next one. Add an entry here when ledgerSchemaVersion is bumped.
+var ledgerMigrations = map[int]func(*ledger){}
+
 type signoffPlan struct {
 	TargetVersion        string        `json:"target_version"`
 	ReleaseLine          string        `json:"release_line"`
@@ -99,10 +116,79 @@ type entry struct {
 	InstallResolution    *rancherResolution `json:"rancher_install_resolution,omitempty"`
 	UpgradeResolution    *rancherResolution `json:"rancher_upgrade_resolution,omitempty"`
 	CommitSHA            string             `json:"commit_sha,omitempty"`
+	FailedTests          []string           `json:"failed_tests,omitempty"`
 	CompletedAt          string             `json:"completed_at"`
+	InvalidatedAt        string             `json:"invalidated_at,omitempty"`
+}
+
+// ledgerFlags are the flags every query and maintenance command shares.
+type ledgerFlags struct {
+	LedgerPath string
+	Format     string
+}
+
+type coverageReport struct {
+	Lanes    []string          `json:"lanes"`
+	Versions []coverageVersion `json:"versions"`
+}
+
+type coverageVersion struct {
+	Version     string            `json:"version"`
+	ReleaseLine string            `json:"release_line"`
+	Cells       map[string]string `json:"cells"`
+}
+
+type migrationResult struct {
+	LedgerPath string `json:"ledger"`
+	From       int    `json:"from"`
+	To         int    `json:"to"`
+	Entries    int    `json:"entries"`
+	Written    bool   `json:"written"`
+}
+
+type rancherTestResults struct {
+	Results []struct {
+		Cases []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		} `json:"cases"`
+	} `json:"results"`
 }
 
 func main() {
+	if err := run(os.Args[1:], os.Stdout, time.Now().UTC()); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// run dispatches to a subcommand. Without one it records a lane, which is how
+// the lane runner has always called it.
+func run(args []string, stdout io.Writer, now time.Time) error {
+	command := "record"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "record":
+		return runRecord(args, stdout, now)
+	case "list":
+		return runList(args, stdout)
+	case "show":
+		return runShow(args, stdout)
+	case "coverage":
+		return runCoverage(args, stdout)
+	case "prune":
+		return runPrune(args, stdout, now)
+	case "migrate":
+		return runMigrate(args, stdout)
+	case "invalidate":
+		return runInvalidate(args, stdout, now)
+	default:
+		return fmt.Errorf("unknown command %q: %s", command, commandUsage)
+	}
+}
+
+func runRecord(args []string, stdout io.Writer, now time.Time) error {
 	var planPath string
 	var ledgerPath string
 	var laneName string
@@ -115,62 +201,76 @@ func main() {
 	var signingResultPath string
 	var installResolutionPath string
 	var upgradeResolutionPath string
+	var rancherTestResultsPath string
 
-	flag.StringVar(&planPath, "plan", "signoff-plan.json", "sign-off plan JSON path")
-	flag.StringVar(&ledgerPath, "ledger", "signoff-ledger.json", "sign-off ledger JSON path")
-	flag.StringVar(&laneName, "lane", "", "lane name to record")
-	flag.StringVar(&status, "status", "success", "lane status")
-	flag.StringVar(&runID, "run-id", os.Getenv("GITHUB_RUN_ID"), "GitHub Actions run id")
-	flag.StringVar(&runURL, "run-url", "", "GitHub Actions run URL")
-	flag.StringVar(&workflow, "workflow", os.Getenv("GITHUB_WORKFLOW"), "GitHub Actions workflow name")
-	flag.StringVar(&commitSHA, "commit-sha", os.Getenv("GITHUB_SHA"), "commit SHA tested by the run")
-	flag.StringVar(&completedAt, "completed-at", "", "completion time in RFC3339; defaults to now")
-	flag.StringVar(&signingResultPath, "signing-result", "", "optional webhook signing verification result JSON path")
-	flag.StringVar(&installResolutionPath, "install-resolution", "", "optional Rancher install resolution JSON path")
-	flag.StringVar(&upgradeResolutionPath, "upgrade-resolution", "", "optional Rancher upgrade resolution JSON path")
-	flag.Parse()
+	flags := flag.NewFlagSet("signoff-ledger record", flag.ContinueOnError)
+	flags.StringVar(&planPath, "plan", "signoff-plan.json", "sign-off plan JSON path")
+	flags.StringVar(&ledgerPath, "ledger", "signoff-ledger.json", "sign-off ledger JSON path")
+	flags.StringVar(&laneName, "lane", "", "lane name to record")
+	flags.StringVar(&status, "status", "success", "lane status")
+	flags.StringVar(&runID, "run-id", os.Getenv("GITHUB_RUN_ID"), "GitHub Actions run id")
+	flags.StringVar(&runURL, "run-url", "", "GitHub Actions run URL")
+	flags.StringVar(&workflow, "workflow", os.Getenv("GITHUB_WORKFLOW"), "GitHub Actions workflow name")
+	flags.StringVar(&commitSHA, "commit-sha", os.Getenv("GITHUB_SHA"), "commit SHA tested by the run")
+	flags.StringVar(&completedAt, "completed-at", "", "completion time in RFC3339; defaults to now")
+	flags.StringVar(&signingResultPath, "signing-result", "", "optional webhook signing verification result JSON path")
+	flags.StringVar(&installResolutionPath, "install-resolution", "", "optional Rancher install resolution JSON path")
+	flags.StringVar(&upgradeResolutionPath, "upgrade-resolution", "", "optional Rancher upgrade resolution JSON path")
+	flags.StringVar(&rancherTestResultsPath, "rancher-test-results", "", "optional rancher-test-results.json path whose failed tests are recorded")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
 
 	if strings.TrimSpace(laneName) == "" {
-		fatalf("-lane is required")
+		return fmt.Errorf("-lane is required")
 	}
 	if strings.TrimSpace(completedAt) == "" {
-		completedAt = time.Now().UTC().Format(time.RFC3339)
+		completedAt = now.Format(time.RFC3339)
 	}
 	if _, err := time.Parse(time.RFC3339, completedAt); err != nil {
-		fatalf("invalid -completed-at: %v", err)
+		return fmt.Errorf("invalid -completed-at: %w", err)
 	}
 
 	plan, err := readPlan(planPath)
 	if err != nil {
-		fatalf("read plan: %v", err)
+		return fmt.Errorf("read plan: %w", err)
 	}
 	lane, err := findLane(plan, laneName)
 	if err != nil {
-		fatalf("find lane: %v", err)
+		return fmt.Errorf("find lane: %w", err)
 	}
 	l, err := readLedger(ledgerPath)
 	if err != nil {
-		fatalf("read ledger: %v", err)
+		return fmt.Errorf("read ledger: %w", err)
 	}
 	signingResult, err := readSigningResult(signingResultPath)
 	if err != nil {
-		fatalf("read signing result: %v", err)
+		return fmt.Errorf("read signing result: %w", err)
 	}
 	installResolution, err := readRancherResolution(installResolutionPath)
 	if err != nil {
-		fatalf("read install resolution: %v", err)
+		return fmt.Errorf("read install resolution: %w", err)
 	}
 	upgradeResolution, err := readRancherResolution(upgradeResolutionPath)
 	if err != nil {
-		fatalf("read upgrade resolution: %v", err)
+		return fmt.Errorf("read upgrade resolution: %w", err)
+	}
+	failedTests, err := readFailedTests(rancherTestResultsPath)
+	if err != nil {
+		return fmt.Errorf("read rancher test results: %w", err)
+	}
+	if _, err := migrateLedger(&l); err != nil {
+		return fmt.Errorf("migrate ledger: %w", err)
 	}
-	l.SchemaVersion = ledgerSchemaVersion
 	if l.Entries == nil {
 		l.Entries = map[string]map[string]entry{}
 	}
 	if l.Entries[plan.TargetVersion] == nil {
 		l.Entries[plan.TargetVersion] = map[string]entry{}
 	}
+	// Failed tests accumulate across runs of the same version and lane, so a
+	// failure followed by a passing re-run still counts as flake history.
+	previous := l.Entries[plan.TargetVersion][lane.Name]
 	l.Entries[plan.TargetVersion][lane.Name] = entry{
 		Status:               strings.TrimSpace(status),
 		CoveragePolicy:       currentCoveragePolicy,
@@ -196,12 +296,258 @@ func main() {
 		InstallResolution:    installResolution,
 		UpgradeResolution:    upgradeResolution,
 		CommitSHA:            strings.TrimSpace(commitSHA),
+		FailedTests:          mergeFailedTests(previous.FailedTests, failedTests),
 		CompletedAt:          completedAt,
 	}
 	if err := writeLedger(ledgerPath, l); err != nil {
-		fatalf("write ledger: %v", err)
+		return fmt.Errorf("write ledger: %w", err)
+	}
+	_, err = fmt.Fprintf(stdout, "Recorded %s %s as %s in %s\n", plan.TargetVersion, lane.Name, status, ledgerPath)
+	return err
+}
+
+func runList(args []string, stdout io.Writer) error {
+	var common ledgerFlags
+	var releaseLine, laneName, status, since, until string
+	flags := newLedgerFlagSet("list", &common)
+	flags.StringVar(&releaseLine, "release-line", "", "only entries for this release line, for example v2.14")
+	flags.StringVar(&laneName, "lane", "", "only entries for this lane")
+	flags.StringVar(&status, "status", "", "only entries with this status: success, failure or invalidated")
+	flags.StringVar(&since, "since", "", "only entries completed at or after this date (YYYY-MM-DD or RFC3339)")
+	flags.StringVar(&until, "until", "", "only entries completed before the end of this date (YYYY-MM-DD or RFC3339)")
+	if err := parseLedgerFlags(flags, args, &common); err != nil {
+		return err
+	}
+	sinceTime, err := parseDate(since, false)
+	if err != nil {
+		return fmt.Errorf("invalid -since: %w", err)
+	}
+	untilTime, err := parseDate(until, true)
+	if err != nil {
+		return fmt.Errorf("invalid -until: %w", err)
+	}
+	l, err := readLedger(common.LedgerPath)
+	if err != nil {
+		return fmt.Errorf("read ledger: %w", err)
+	}
+
+	var matched []entry
+	for _, e := range ledgerEntries(l) {
+		if releaseLine != "" && e.ReleaseLine != releaseLine {
+			continue
+		}
+		if laneName != "" && e.Lane != laneName {
+			continue
+		}
+		if status != "" && e.Status != status {
+			continue
+		}
+		if !sinceTime.IsZero() || !untilTime.IsZero() {
+			completed, err := time.Parse(time.RFC3339, e.CompletedAt)
+			if err != nil || (!sinceTime.IsZero() && completed.Before(sinceTime)) || (!untilTime.IsZero() && !completed.Before(untilTime)) {
+				continue
+			}
+		}
+		matched = append(matched, e)
+	}
+	return writeEntries(stdout, common.Format, matched)
+}
+
+func runShow(args []string, stdout io.Writer) error {
+	var common ledgerFlags
+	flags := newLedgerFlagSet("show", &common)
+	if err := parseLedgerFlags(flags, args, &common); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: signoff-ledger show [-ledger PATH] [-format table|json] VERSION")
+	}
+	version := flags.Arg(0)
+	l, err := readLedger(common.LedgerPath)
+	if err != nil {
+		return fmt.Errorf("read ledger: %w", err)
+	}
+	lanes, ok := l.Entries[version]
+	if !ok {
+		return fmt.Errorf("version %s is not in %s", version, common.LedgerPath)
 	}
-	fmt.Printf("Recorded %s %s as %s in %s\n", plan.TargetVersion, lane.Name, status, ledgerPath)
+	if common.Format == "json" {
+		return writeJSON(stdout, lanes)
+	}
+
+	names := sortedKeys(lanes)
+	rows := make([][]string, 0, len(names))
+	for _, name := range names {
+		e := lanes[name]
+		rows = append(rows, []string{
+			name,
+			e.Status,
+			e.CoveragePolicy,
+			e.InstallRancher,
+			e.UpgradeToRancher,
+			e.RunID,
+			e.CompletedAt,
+			strings.Join(e.FailedTests, ", "),
+		})
+	}
+	return writeTable(stdout, []string{"LANE", "STATUS", "COVERAGE POLICY", "INSTALL", "UPGRADE", "RUN", "COMPLETED", "FAILED TESTS"}, rows)
+}
+
+func runCoverage(args []string, stdout io.Writer) error {
+	var common ledgerFlags
+	var releaseLine, laneNames string
+	flags := newLedgerFlagSet("coverage", &common)
+	flags.StringVar(&releaseLine, "release-line", "", "only versions in this release line, for example v2.14")
+	flags.StringVar(&laneNames, "lanes", "", "comma-separated lane columns; defaults to every lane in the ledger")
+	if err := parseLedgerFlags(flags, args, &common); err != nil {
+		return err
+	}
+	l, err := readLedger(common.LedgerPath)
+	if err != nil {
+		return fmt.Errorf("read ledger: %w", err)
+	}
+	var lanes []string
+	for _, name := range strings.Split(laneNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			lanes = append(lanes, name)
+		}
+	}
+	report := buildCoverage(l, releaseLine, lanes)
+	if common.Format == "json" {
+		return writeJSON(stdout, report)
+	}
+
+	rows := make([][]string, 0, len(report.Versions))
+	for _, version := range report.Versions {
+		row := []string{version.Version}
+		for _, lane := range report.Lanes {
+			row = append(row, version.Cells[lane])
+		}
+		rows = append(rows, row)
+	}
+	return writeTable(stdout, append([]string{"VERSION"}, report.Lanes...), rows)
+}
+
+func runPrune(args []string, stdout io.Writer, now time.Time) error {
+	var common ledgerFlags
+	var olderThan string
+	var dryRun bool
+	flags := newLedgerFlagSet("prune", &common)
+	flags.StringVar(&olderThan, "older-than", "", "remove entries completed longer ago than this, for example 180d or 720h")
+	flags.BoolVar(&dryRun, "dry-run", false, "list the entries that would be removed without writing the ledger")
+	if err := parseLedgerFlags(flags, args, &common); err != nil {
+		return err
+	}
+	age, err := parseAge(olderThan)
+	if err != nil {
+		return fmt.Errorf("invalid -older-than: %w", err)
+	}
+	l, err := readLedger(common.LedgerPath)
+	if err != nil {
+		return fmt.Errorf("read ledger: %w", err)
+	}
+
+	removed := pruneLedger(&l, now.Add(-age))
+	if !dryRun && len(removed) > 0 {
+		if err := writeLedger(common.LedgerPath, l); err != nil {
+			return fmt.Errorf("write ledger: %w", err)
+		}
+	}
+	if err := writeEntries(stdout, common.Format, removed); err != nil {
+		return err
+	}
+	return writeSummaryLine(stdout, common.Format, dryRun, "Removed %d entries from %s", len(removed), common.LedgerPath)
+}
+
+func runMigrate(args []string, stdout io.Writer) error {
+	var common ledgerFlags
+	var dryRun bool
+	flags := newLedgerFlagSet("migrate", &common)
+	flags.BoolVar(&dryRun, "dry-run", false, "report the upgrade without writing the ledger")
+	if err := parseLedgerFlags(flags, args, &common); err != nil {
+		return err
+	}
+	l, err := readLedger(common.LedgerPath)
+	if err != nil {
+		return fmt.Errorf("read ledger: %w", err)
+	}
+	from, err := migrateLedger(&l)
+	if err != nil {
+		return fmt.Errorf("migrate ledger: %w", err)
+	}
+
+	result := migrationResult{
+		LedgerPath: common.LedgerPath,
+		From:       from,
+		To:         l.SchemaVersion,
+		Entries:    len(ledgerEntries(l)),
+	}
+	if !dryRun && from != l.SchemaVersion {
+		if err := writeLedger(common.LedgerPath, l); err != nil {
+			return fmt.Errorf("write ledger: %w", err)
+		}
+		result.Written = true
+	}
+	if common.Format == "json" {
+		return writeJSON(stdout, result)
+	}
+	return writeTable(stdout, []string{"LEDGER", "FROM", "TO", "ENTRIES", "WRITTEN"}, [][]string{{
+		result.LedgerPath,
+		strconv.Itoa(result.From),
+		strconv.Itoa(result.To),
+		strconv.Itoa(result.Entries),
+		strconv.FormatBool(result.Written),
+	}})
+}
+
+// runInvalidate marks successful entries recorded under a coverage policy as
+// invalidated, so the planner schedules those lanes again.
+func runInvalidate(args []string, stdout io.Writer, now time.Time) error {
+	var common ledgerFlags
+	var policy, releaseLine, laneName, version string
+	var dryRun bool
+	flags := newLedgerFlagSet("invalidate", &common)
+	flags.StringVar(&policy, "coverage-policy", "", "invalidate successful entries recorded under this coverage policy")
+	flags.StringVar(&releaseLine, "release-line", "", "only entries for this release line")
+	flags.StringVar(&laneName, "lane", "", "only entries for this lane")
+	flags.StringVar(&version, "version", "", "only entries for this Rancher version")
+	flags.BoolVar(&dryRun, "dry-run", false, "list the entries that would be invalidated without writing the ledger")
+	if err := parseLedgerFlags(flags, args, &common); err != nil {
+		return err
+	}
+	if strings.TrimSpace(policy) == "" {
+		return fmt.Errorf("-coverage-policy is required")
+	}
+	l, err := readLedger(common.LedgerPath)
+	if err != nil {
+		return fmt.Errorf("read ledger: %w", err)
+	}
+
+	var invalidated []entry
+	for v, lanes := range l.Entries {
+		for name, e := range lanes {
+			if e.Status != statusSuccess || e.CoveragePolicy != policy {
+				continue
+			}
+			if (version != "" && v != version) || (laneName != "" && name != laneName) || (releaseLine != "" && entryReleaseLine(v, e) != releaseLine) {
+				continue
+			}
+			e.Status = statusInvalidated
+			e.InvalidatedAt = now.Format(time.RFC3339)
+			lanes[name] = e
+			invalidated = append(invalidated, withKeys(v, name, e))
+		}
+	}
+	sortEntries(invalidated)
+	if !dryRun && len(invalidated) > 0 {
+		if err := writeLedger(common.LedgerPath, l); err != nil {
+			return fmt.Errorf("write ledger: %w", err)
+		}
+	}
+	if err := writeEntries(stdout, common.Format, invalidated); err != nil {
+		return err
+	}
+	return writeSummaryLine(stdout, common.Format, dryRun, "Invalidated %d entries in %s", len(invalidated), common.LedgerPath)
 }
 
 func readRancherResolution(path string) (*rancherResolution, error) {
@@ -226,6 +572,64 @@ func readRancherResolution(path string) (*rancherResolution, error) {
 	return &result, nil
 }
 
+// readFailedTests returns the failed test cases run-rancher-tests recorded.
+// A parent test that failed only because a subtest failed is left out.
+func readFailedTests(path string) ([]string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, nil
+	}
+	var results rancherTestResults
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, err
+	}
+	var failed []string
+	for _, result := range results.Results {
+		for _, testCase := range result.Cases {
+			if testCase.Status == "failed" && testCase.Name != "" {
+				failed = append(failed, testCase.Name)
+			}
+		}
+	}
+	var leaves []string
+	for _, name := range failed {
+		parent := false
+		for _, other := range failed {
+			if strings.HasPrefix(other, name+"/") {
+				parent = true
+				break
+			}
+		}
+		if !parent {
+			leaves = append(leaves, name)
+		}
+	}
+	return mergeFailedTests(nil, leaves), nil
+}
+
+func mergeFailedTests(previous, current []string) []string {
+	seen := map[string]bool{}
+	var merged []string
+	for _, name := range append(append([]string{}, previous...), current...) {
+		if !seen[name] {
+			seen[name] = true
+			merged = append(merged, name)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
 func readSigningResult(path string) (*signingResult, error) {
 	path = strings.TrimSpace(path)
 	if path == "" {
@@ -269,6 +673,323 @@ func findLane(plan signoffPlan, laneName string) (signoffLane, error) {
 	return signoffLane{}, fmt.Errorf("lane %q not found", laneName)
 }
 
+func newLedgerFlagSet(command string, common *ledgerFlags) *flag.FlagSet {
+	flags := flag.NewFlagSet("signoff-ledger "+command, flag.ContinueOnError)
+	flags.StringVar(&common.LedgerPath, "ledger", "signoff-ledger.json", "sign-off ledger JSON path")
+	flags.StringVar(&common.Format, "format", "table", "output format: table or json")
+	return flags
+}
+
+func parseLedgerFlags(flags *flag.FlagSet, args []string, common *ledgerFlags) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if common.Format != "table" && common.Format != "json" {
+		return fmt.Errorf("unsupported -format %q: expected table or json", common.Format)
+	}
+	return nil
+}
+
+// ledgerEntries flattens the ledger into entries sorted by version and lane.
+// Lane, target version and release line are filled from the keys when an
+// entry does not carry them.
+func ledgerEntries(l ledger) []entry {
+	var entries []entry
+	for version, lanes := range l.Entries {
+		for name, e := range lanes {
+			entries = append(entries, withKeys(version, name, e))
+		}
+	}
+	sortEntries(entries)
+	return entries
+}
+
+func withKeys(version, lane string, e entry) entry {
+	e.Lane = lane
+	e.TargetVersion = version
+	e.ReleaseLine = entryReleaseLine(version, e)
+	return e
+}
+
+func entryReleaseLine(version string, e entry) string {
+	if e.ReleaseLine != "" {
+		return e.ReleaseLine
+	}
+	match := ledgerVersionRE.FindStringSubmatch(version)
+	if match == nil {
+		return ""
+	}
+	return "v" + match[1] + "." + match[2]
+}
+
+func sortEntries(entries []entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].TargetVersion != entries[j].TargetVersion {
+			return compareVersions(entries[i].TargetVersion, entries[j].TargetVersion) < 0
+		}
+		return entries[i].Lane < entries[j].Lane
+	})
+}
+
+// compareVersions orders Rancher versions numerically, with alphas and other
+// pre-releases before the release they lead up to. Versions it cannot parse
+// sort after the rest by name.
+func compareVersions(a, b string) int {
+	partsA, okA := versionParts(a)
+	partsB, okB := versionParts(b)
+	switch {
+	case okA && okB:
+		for i := range partsA {
+			if partsA[i] != partsB[i] {
+				if partsA[i] < partsB[i] {
+					return -1
+				}
+				return 1
+			}
+		}
+		return strings.Compare(a, b)
+	case okA:
+		return -1
+	case okB:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func versionParts(version string) ([5]int, bool) {
+	match := ledgerVersionRE.FindStringSubmatch(strings.TrimSpace(version))
+	if match == nil {
+		return [5]int{}, false
+	}
+	var parts [5]int
+	for i := 0; i < 3; i++ {
+		parts[i], _ = strconv.Atoi(match[i+1])
+	}
+	if match[4] == "" {
+		parts[3] = 1
+	} else {
+		parts[4], _ = strconv.Atoi(match[5])
+	}
+	return parts, true
+}
+
+func sortedKeys(lanes map[string]entry) []string {
+	names := make([]string, 0, len(lanes))
+	for name := range lanes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// buildCoverage lays out versions against lanes. A cell is ok for a success
+// under the current coverage policy, stale for a success under an older one,
+// failed, invalidated or missing when the lane was never recorded.
+func buildCoverage(l ledger, releaseLine string, lanes []string) coverageReport {
+	if len(lanes) == 0 {
+		seen := map[string]bool{}
+		for _, byLane := range l.Entries {
+			for name := range byLane {
+				if !seen[name] {
+					seen[name] = true
+					lanes = append(lanes, name)
+				}
+			}
+		}
+		sort.Strings(lanes)
+	}
+
+	var versions []string
+	for version := range l.Entries {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+
+	report := coverageReport{Lanes: lanes, Versions: []coverageVersion{}}
+	for _, version := range versions {
+		byLane := l.Entries[version]
+		line := ""
+		for _, e := range byLane {
+			line = entryReleaseLine(version, e)
+			break
+		}
+		if releaseLine != "" && line != releaseLine {
+			continue
+		}
+		row := coverageVersion{Version: version, ReleaseLine: line, Cells: map[string]string{}}
+		for _, lane := range lanes {
+			e, ok := byLane[lane]
+			row.Cells[lane] = coverageCell(e, ok)
+		}
+		report.Versions = append(report.Versions, row)
+	}
+	return report
+}
+
+func coverageCell(e entry, ok bool) string {
+	switch {
+	case !ok:
+		return "missing"
+	case e.Status == statusSuccess && e.CoveragePolicy == currentCoveragePolicy:
+		return "ok"
+	case e.Status == statusSuccess:
+		return "stale"
+	case e.Status == statusFailure:
+		return "failed"
+	default:
+		return e.Status
+	}
+}
+
+// pruneLedger removes entries completed before cutoff and any version left
+// without entries. Entries without a parseable completed_at are kept.
+func pruneLedger(l *ledger, cutoff time.Time) []entry {
+	var removed []entry
+	for version, lanes := range l.Entries {
+		for name, e := range lanes {
+			completed, err := time.Parse(time.RFC3339, e.CompletedAt)
+			if err != nil || !completed.Before(cutoff) {
+				continue
+			}
+			removed = append(removed, withKeys(version, name, e))
+			delete(lanes, name)
+		}
+		if len(lanes) == 0 {
+			delete(l.Entries, version)
+		}
+	}
+	sortEntries(removed)
+	return removed
+}
+
+// parseAge accepts a Go duration or a whole number of days such as 180d.
+func parseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("a duration such as 180d is required")
+	}
+	var age time.Duration
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("parse %q: %w", value, err)
+		}
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if age, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	}
+	if age <= 0 {
+		return 0, fmt.Errorf("%q must be positive", value)
+	}
+	return age, nil
+}
+
+// parseDate parses a YYYY-MM-DD date or an RFC3339 time. With endOfDay, a
+// date means the start of the following day so -until includes it.
+func parseDate(value string, endOfDay bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		if endOfDay {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// migrateLedger upgrades l to ledgerSchemaVersion and returns the version it
+// started from. A ledger without a schema_version is only stamped with the
+// current one, as record has always done.
+func migrateLedger(l *ledger) (int, error) {
+	from := l.SchemaVersion
+	if from > ledgerSchemaVersion {
+		return from, fmt.Errorf("schema_version %d is newer than the supported %d", from, ledgerSchemaVersion)
+	}
+	start := from
+	if start == 0 {
+		start = ledgerSchemaVersion
+	}
+	for version := start; version < ledgerSchemaVersion; version++ {
+		migrate, ok := ledgerMigrations[version]
+		if !ok {
+			return from, fmt.Errorf("no migration from schema_version %d", version)
+		}
+		migrate(l)
+	}
+	l.SchemaVersion = ledgerSchemaVersion
+	return from, nil
+}
+
+func writeEntries(stdout io.Writer, format string, entries []entry) error {
+	if format == "json" {
+		if entries == nil {
+			entries = []entry{}
+		}
+		return writeJSON(stdout, entries)
+	}
+	rows := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		rows = append(rows, []string{
+			e.TargetVersion,
+			e.Lane,
+			e.Status,
+			e.CoveragePolicy,
+			e.RunID,
+			e.CompletedAt,
+			strconv.Itoa(len(e.FailedTests)),
+		})
+	}
+	return writeTable(stdout, []string{"VERSION", "LANE", "STATUS", "COVERAGE POLICY", "RUN", "COMPLETED", "FAILED TESTS"}, rows)
+}
+
+// writeSummaryLine follows a table with what a maintenance command changed.
+// JSON output stays a single document.
+func writeSummaryLine(stdout io.Writer, format string, dryRun bool, message string, args ...interface{}) error {
+	if format == "json" {
+		return nil
+	}
+	line := fmt.Sprintf(message, args...)
+	if dryRun {
+		line = "Dry run: " + line
+	}
+	_, err := fmt.Fprintln(stdout, line)
+	return err
+}
+
+func writeTable(stdout io.Writer, headers []string, rows [][]string) error {
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			if strings.TrimSpace(cell) == "" {
+				cell = "-"
+			}
+			cells[i] = cell
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+func writeJSON(stdout io.Writer, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(append(data, '\n'))
+	return err
+}
+
 func readLedger(path string) (ledger, error) {
 	data, err := os.ReadFile(path)
 	if os.IsNotExist(err) {
@@ -284,6 +1005,9 @@ func readLedger(path string) (ledger, error) {
 	if err := json.Unmarshal(data, &l); err != nil {
 		return ledger{}, err
 	}
+	if l.SchemaVersion > ledgerSchemaVersion {
+		return ledger{}, fmt.Errorf("schema_version %d is newer than the supported %d", l.SchemaVersion, ledgerSchemaVersion)
+	}
 	return l, nil
 }
 
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLedgerRecordsSuccessfulLane(t *testing.T) {
@@ -177,3 +180,249 @@ func TestReadRancherResolutionMissingPathIsOptional(t *testing.T) {
 		t.Fatalf("expected nil result, got %+v", result)
 	}
 }
+
+func TestReadFailedTestsKeepsFailingSubtests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rancher-test-results.json")
+	resultsJSON := `{
+  "lane": "fresh-alpha",
+  "results": [
+    {
+      "suite": "TestWebhookTestSuite",
+      "conclusion": "failure",
+      "cases": [
+        {"name": "TestWebhookTestSuite", "status": "failed"},
+        {"name": "TestWebhookTestSuite/TestWebhookChart", "status": "failed"},
+        {"name": "TestWebhookTestSuite/TestWebhookChart/Verify_webhook_pod_logs", "status": "failed"},
+        {"name": "TestWebhookTestSuite/TestWebhookChart/Verify_the_count_of_webhook_is_greater_than_zero", "status": "passed"}
+      ]
+    },
+    {
+      "suite": "TestConfigMapTestSuite",
+      "conclusion": "failure",
+      "cases": [
+        {"name": "TestConfigMapTestSuite", "status": "failed"},
+        {"name": "TestConfigMapTestSuite/TestSkipped", "status": "skipped"}
+      ]
+    }
+  ]
+}`
+	if err := os.WriteFile(path, []byte(resultsJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := readFailedTests(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "TestConfigMapTestSuite,TestWebhookTestSuite/TestWebhookChart/Verify_webhook_pod_logs"
+	if got := strings.Join(failed, ","); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	merged := mergeFailedTests([]string{"TestVaiTestSuite/TestVaiEnabled", "TestConfigMapTestSuite"}, failed)
+	want = "TestConfigMapTestSuite,TestVaiTestSuite/TestVaiEnabled,TestWebhookTestSuite/TestWebhookChart/Verify_webhook_pod_logs"
+	if got := strings.Join(merged, ","); got != want {
+		t.Fatalf("expected merged %s, got %s", want, got)
+	}
+
+	if failed, err := readFailedTests(filepath.Join(t.TempDir(), "missing.json")); err != nil || failed != nil {
+		t.Fatalf("expected missing results to be optional, got %v, %v", failed, err)
+	}
+}
+
+const queryLedgerJSON = `{
+  "schema_version": 2,
+  "entries": {
+    "v2.14.1-alpha10": {
+      "fresh-alpha": {"status": "success", "coverage_policy": "alpha-webhook-signoff-v2", "run_id": "10", "lane": "fresh-alpha", "release_line": "v2.14", "target_version": "v2.14.1-alpha10", "completed_at": "2026-10-10T00:00:00Z"},
+      "upgrade-alpha": {"status": "failure", "coverage_policy": "alpha-webhook-signoff-v2", "run_id": "11", "lane": "upgrade-alpha", "release_line": "v2.14", "target_version": "v2.14.1-alpha10", "failed_tests": ["TestWebhookTestSuite/TestWebhookChart"], "completed_at": "2026-10-11T00:00:00Z"}
+    },
+    "v2.14.1-alpha9": {
+      "fresh-alpha": {"status": "success", "coverage_policy": "alpha-webhook-signoff-v1", "run_id": "9", "lane": "fresh-alpha", "release_line": "v2.14", "target_version": "v2.14.1-alpha9", "completed_at": "2026-04-01T00:00:00Z"},
+      "upgrade-alpha": {"status": "success", "coverage_policy": "alpha-webhook-signoff-v2", "run_id": "8", "lane": "upgrade-alpha", "release_line": "v2.14", "target_version": "v2.14.1-alpha9", "completed_at": "2026-04-02T00:00:00Z"}
+    },
+    "v2.13.5-alpha7": {
+      "fresh-alpha": {"status": "success", "coverage_policy": "alpha-webhook-signoff-v2", "run_id": "7", "lane": "fresh-alpha", "release_line": "v2.13", "target_version": "v2.13.5-alpha7", "completed_at": "2026-10-01T00:00:00Z"}
+    }
+  }
+}`
+
+var queryNow = time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+func writeQueryLedger(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "signoff-ledger.json")
+	if err := os.WriteFile(path, []byte(queryLedgerJSON), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runCommand(t *testing.T, args ...string) string {
+	t.Helper()
+	var stdout bytes.Buffer
+	if err := run(args, &stdout, queryNow); err != nil {
+		t.Fatalf("run %v: %v", args, err)
+	}
+	return stdout.String()
+}
+
+func TestListFiltersAndSortsEntries(t *testing.T) {
+	ledgerPath := writeQueryLedger(t)
+
+	var entries []entry
+	output := runCommand(t, "list", "-ledger", ledgerPath, "-release-line", "v2.14", "-format", "json")
+	if err := json.Unmarshal([]byte(output), &entries); err != nil {
+		t.Fatalf("parse list output: %v\n%s", err, output)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.TargetVersion+"/"+e.Lane)
+	}
+	want := "v2.14.1-alpha9/fresh-alpha v2.14.1-alpha9/upgrade-alpha v2.14.1-alpha10/fresh-alpha v2.14.1-alpha10/upgrade-alpha"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("expected %s, got %v", want, got)
+	}
+
+	output = runCommand(t, "list", "-ledger", ledgerPath, "-status", "success", "-since", "2026-10-01", "-until", "2026-10-10")
+	for _, want := range []string{"v2.13.5-alpha7 ", "v2.14.1-alpha10 "} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("expected list output to contain %q:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "upgrade-alpha") || strings.Contains(output, "alpha9") {
+		t.Fatalf("expected list output to be filtered:\n%s", output)
+	}
+}
+
+func TestShowPrintsLanesForVersion(t *testing.T) {
+	ledgerPath := writeQueryLedger(t)
+
+	output := runCommand(t, "show", "-ledger", ledgerPath, "v2.14.1-alpha10")
+	if !strings.Contains(output, "upgrade-alpha") || !strings.Contains(output, "TestWebhookTestSuite/TestWebhookChart") {
+		t.Fatalf("unexpected show output:\n%s", output)
+	}
+	if err := run([]string{"show", "-ledger", ledgerPath, "v2.99.0-alpha1"}, &bytes.Buffer{}, queryNow); err == nil {
+		t.Fatal("expected an unknown version to fail")
+	}
+}
+
+func TestCoverageMarksMissingFailedAndStaleCells(t *testing.T) {
+	ledgerPath := writeQueryLedger(t)
+
+	var report coverageReport
+	output := runCommand(t, "coverage", "-ledger", ledgerPath, "-lanes", "fresh-alpha,upgrade-alpha,backup-restore", "-format", "json")
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		t.Fatalf("parse coverage output: %v\n%s", err, output)
+	}
+	want := map[string]map[string]string{
+		"v2.13.5-alpha7":  {"fresh-alpha": "ok", "upgrade-alpha": "missing", "backup-restore": "missing"},
+		"v2.14.1-alpha9":  {"fresh-alpha": "stale", "upgrade-alpha": "ok", "backup-restore": "missing"},
+		"v2.14.1-alpha10": {"fresh-alpha": "ok", "upgrade-alpha": "failed", "backup-restore": "missing"},
+	}
+	if len(report.Versions) != len(want) {
+		t.Fatalf("unexpected coverage versions: %+v", report.Versions)
+	}
+	for _, version := range report.Versions {
+		for lane, cell := range want[version.Version] {
+			if version.Cells[lane] != cell {
+				t.Fatalf("expected %s/%s to be %s, got %+v", version.Version, lane, cell, version.Cells)
+			}
+		}
+	}
+
+	output = runCommand(t, "coverage", "-ledger", ledgerPath, "-release-line", "v2.13")
+	if !strings.HasPrefix(output, "VERSION") || !strings.Contains(output, "v2.13.5-alpha7") || strings.Contains(output, "v2.14") {
+		t.Fatalf("unexpected coverage table:\n%s", output)
+	}
+}
+
+func TestPruneRemovesOldEntries(t *testing.T) {
+	ledgerPath := writeQueryLedger(t)
+
+	output := runCommand(t, "prune", "-ledger", ledgerPath, "-older-than", "90d", "-dry-run")
+	if !strings.Contains(output, "Dry run: Removed 2 entries") {
+		t.Fatalf("unexpected dry run output:\n%s", output)
+	}
+	if l, err := readLedger(ledgerPath); err != nil || len(l.Entries) != 3 {
+		t.Fatalf("expected dry run to leave the ledger alone, got %+v %v", l, err)
+	}
+
+	runCommand(t, "prune", "-ledger", ledgerPath, "-older-than", "2160h")
+	l, err := readLedger(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.Entries["v2.14.1-alpha9"]; ok || len(l.Entries) != 2 {
+		t.Fatalf("expected v2.14.1-alpha9 to be pruned, got %+v", l.Entries)
+	}
+	if err := run([]string{"prune", "-ledger", ledgerPath, "-older-than", "-1d"}, &bytes.Buffer{}, queryNow); err == nil {
+		t.Fatal("expected a negative age to fail")
+	}
+}
+
+func TestMigrateStampsUnversionedLedger(t *testing.T) {
+	ledgerPath := filepath.Join(t.TempDir(), "signoff-ledger.json")
+	if err := os.WriteFile(ledgerPath, []byte(`{"entries": {"v2.12.9-alpha7": {"fresh-alpha": {"lane": "fresh-alpha", "target_version": "v2.12.9-alpha7", "release_line": "v2.12", "status": "success", "run_id": "1", "completed_at": "2026-04-01T00:00:00Z"}}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var result migrationResult
+	output := runCommand(t, "migrate", "-ledger", ledgerPath, "-format", "json")
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("parse migrate output: %v\n%s", err, output)
+	}
+	if result.From != 0 || result.To != ledgerSchemaVersion || result.Entries != 1 || !result.Written {
+		t.Fatalf("unexpected migration result: %+v", result)
+	}
+	l, err := readLedger(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := l.Entries["v2.12.9-alpha7"]["fresh-alpha"]; l.SchemaVersion != ledgerSchemaVersion || e.Status != statusSuccess || e.RunID != "1" {
+		t.Fatalf("unexpected migrated ledger: %+v", l)
+	}
+
+	output = runCommand(t, "migrate", "-ledger", ledgerPath, "-format", "json")
+	if err := json.Unmarshal([]byte(output), &result); err != nil || result.Written {
+		t.Fatalf("expected a current ledger to be left alone, got %+v %v", result, err)
+	}
+
+	if err := os.WriteFile(ledgerPath, []byte(`{"schema_version": 99, "entries": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"migrate", "-ledger", ledgerPath}, &bytes.Buffer{}, queryNow); err == nil {
+		t.Fatal("expected a newer schema_version to fail")
+	}
+}
+
+func TestInvalidateMarksEntriesForRerun(t *testing.T) {
+	ledgerPath := writeQueryLedger(t)
+
+	output := runCommand(t, "invalidate", "-ledger", ledgerPath, "-coverage-policy", "alpha-webhook-signoff-v2", "-release-line", "v2.14")
+	if !strings.Contains(output, "Invalidated 2 entries") {
+		t.Fatalf("unexpected invalidate output:\n%s", output)
+	}
+	l, err := readLedger(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version, lanes := range map[string]map[string]string{
+		"v2.14.1-alpha10": {"fresh-alpha": statusInvalidated, "upgrade-alpha": statusFailure},
+		"v2.14.1-alpha9":  {"fresh-alpha": statusSuccess, "upgrade-alpha": statusInvalidated},
+		"v2.13.5-alpha7":  {"fresh-alpha": statusSuccess},
+	} {
+		for lane, status := range lanes {
+			e := l.Entries[version][lane]
+			if e.Status != status {
+				t.Fatalf("expected %s/%s to be %s, got %s", version, lane, status, e.Status)
+			}
+			if status == statusInvalidated && e.InvalidatedAt != "2026-10-16T00:00:00Z" {
+				t.Fatalf("expected %s/%s to record invalidated_at, got %q", version, lane, e.InvalidatedAt)
+			}
+		}
+	}
+	if err := run([]string{"invalidate", "-ledger", ledgerPath}, &bytes.Buffer{}, queryNow); err == nil {
+		t.Fatal("expected -coverage-policy to be required")
+	}
+}
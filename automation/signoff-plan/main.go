@@ -14,16 +14,16 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	goversion "github.com/hashicorp/go-version"
+	"gopkg.in/yaml.v3"
 )
 
 const (
 	rancherRepo           = "rancher/rancher"
 	defaultWebhook        = "rancher/rancher-webhook"
 	currentCoveragePolicy = "alpha-webhook-signoff-v2"
-	laneFreshAlpha        = "fresh-alpha"
-	laneUpgradeAlpha      = "upgrade-alpha"
-	laneOldWebhook        = "previous-with-candidate-webhook"
-	laneLocalSuites       = "fresh-alpha-local-suites"
+	laneDefinitionsV1     = 1
 )
 
 var (
@@ -64,14 +64,73 @@ type planSet struct {
 }
 
 type lane struct {
-	Name                 string `json:"name"`
-	InstallRancher       string `json:"install_rancher"`
-	UpgradeToRancher     string `json:"upgrade_to_rancher,omitempty"`
-	ProvisionDownstream  bool   `json:"provision_downstream"`
-	WebhookOverrideImage string `json:"webhook_override_image,omitempty"`
-	TerraformStateKey    string `json:"terraform_state_key,omitempty"`
-	AWSPrefix            string `json:"aws_prefix,omitempty"`
-	Description          string `json:"description"`
+	Name                 string      `json:"name"`
+	InstallRancher       string      `json:"install_rancher"`
+	UpgradeToRancher     string      `json:"upgrade_to_rancher,omitempty"`
+	ProvisionDownstream  bool        `json:"provision_downstream"`
+	WebhookOverrideImage string      `json:"webhook_override_image,omitempty"`
+	BackupRestore        bool        `json:"backup_restore,omitempty"`
+	SuiteEnv             string      `json:"suite_env,omitempty"`
+	Suites               []laneSuite `json:"suites,omitempty"`
+	TerraformStateKey    string      `json:"terraform_state_key,omitempty"`
+	AWSPrefix            string      `json:"aws_prefix,omitempty"`
+	Description          string      `json:"description"`
+}
+
+// laneSuite is one rancher/tests target a lane runs after its setup.
+type laneSuite struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	Run     string `json:"run"`
+	Tags    string `json:"tags"`
+	Timeout string `json:"timeout,omitempty"`
+	JUnit   string `json:"junit"`
+}
+
+// laneDefinitions is the signoff-lanes.yaml file the planner evaluates for
+// every target.
+type laneDefinitions struct {
+	Version int                        `yaml:"version"`
+	Suites  map[string]suiteDefinition `yaml:"suites"`
+	Lanes   []laneDefinition           `yaml:"lanes"`
+}
+
+type suiteDefinition struct {
+	Package string   `yaml:"package"`
+	Tags    []string `yaml:"tags"`
+	Timeout string   `yaml:"timeout"`
+}
+
+type laneDefinition struct {
+	Name                string                `yaml:"name"`
+	Code                string                `yaml:"code"`
+	Install             string                `yaml:"install"`
+	Upgrade             string                `yaml:"upgrade"`
+	When                string                `yaml:"when"`
+	SkipReason          string                `yaml:"skip_reason"`
+	ProvisionDownstream bool                  `yaml:"provision_downstream"`
+	WebhookOverride     string                `yaml:"webhook_override"`
+	BackupRestore       bool                  `yaml:"backup_restore"`
+	SuiteEnv            string                `yaml:"suite_env"`
+	Description         string                `yaml:"description"`
+	Suites              []laneSuiteDefinition `yaml:"suites"`
+}
+
+type laneSuiteDefinition struct {
+	Suite   string `yaml:"suite"`
+	Run     string `yaml:"run"`
+	JUnit   string `yaml:"junit"`
+	Rancher string `yaml:"rancher"`
+}
+
+// laneInputs are the resolved versions and images lane definitions refer to.
+type laneInputs struct {
+	Target           semver
+	TargetVersion    string
+	PreviousVersion  string
+	WebhookImage     string
+	TargetWebhookTag string
+	WebhookChanged   bool
 }
 
 type skippedLane struct {
@@ -127,6 +186,7 @@ func main() {
 	var stateKeyRoot string
 	var ledgerPath string
 	var ignoreListPath string
+	var lanesPath string
 	var latestAlpha bool
 	var latestAlphaPerLine bool
 	var ignoreLedger bool
@@ -141,6 +201,7 @@ func main() {
 	flag.StringVar(&stateKeyRoot, "state-key-root", "ha-rancher-rke2/signoff", "root prefix for generated Terraform state keys")
 	flag.StringVar(&ledgerPath, "ledger", "signoff-ledger.json", "sign-off ledger path used to skip already successful lanes")
 	flag.StringVar(&ignoreListPath, "ignore-list", "signoff-ignore.json", "target ignore list path used to skip known-bad versions or release lines")
+	flag.StringVar(&lanesPath, "lanes", "signoff-lanes.yaml", "lane definitions evaluated for every target")
 	flag.BoolVar(&latestAlpha, "latest-alpha", false, "resolve the latest Rancher alpha from GitHub releases")
 	flag.BoolVar(&latestAlphaPerLine, "latest-alpha-per-line", false, "resolve the latest Rancher alpha per vX.Y release line from GitHub releases")
 	flag.BoolVar(&ignoreLedger, "ignore-ledger", false, "ignore sign-off ledger entries when rendering lanes")
@@ -164,6 +225,10 @@ func main() {
 	if err != nil {
 		fatalf("read ignore list: %v", err)
 	}
+	definitions, err := readLaneDefinitions(lanesPath)
+	if err != nil {
+		fatalf("read lane definitions: %v", err)
+	}
 
 	if latestAlpha && latestAlphaPerLine {
 		fatalf("set only one of -latest-alpha or -latest-alpha-per-line")
@@ -189,14 +254,14 @@ func main() {
 		plans := make([]plan, 0, len(targets))
 		for _, version := range targets {
 			if reason, ignored := ignoreList.reasonFor(version); ignored {
-				p, err := ignoredPlan(version, reason, runID, stateKeyRoot)
+				p, err := ignoredPlan(definitions, version, reason, runID, stateKeyRoot)
 				if err != nil {
 					fatalf("build ignored sign-off plan for %s: %v", version, err)
 				}
 				plans = append(plans, p)
 				continue
 			}
-			p, err := buildPlan(ctx, client, version, previousVersion, webhookImage, signingPolicy, runID, stateKeyRoot)
+			p, err := buildPlan(ctx, client, definitions, version, previousVersion, webhookImage, signingPolicy, runID, stateKeyRoot)
 			if err != nil {
 				fatalf("build sign-off plan for %s: %v", version, err)
 			}
@@ -227,7 +292,7 @@ func main() {
 	}
 
 	if reason, ignored := ignoreList.reasonFor(targetVersion); ignored {
-		p, err := ignoredPlan(targetVersion, reason, runID, stateKeyRoot)
+		p, err := ignoredPlan(definitions, targetVersion, reason, runID, stateKeyRoot)
 		if err != nil {
 			fatalf("build ignored sign-off plan: %v", err)
 		}
@@ -235,7 +300,7 @@ func main() {
 		return
 	}
 
-	p, err := buildPlan(ctx, client, targetVersion, previousVersion, webhookImage, signingPolicy, runID, stateKeyRoot)
+	p, err := buildPlan(ctx, client, definitions, targetVersion, previousVersion, webhookImage, signingPolicy, runID, stateKeyRoot)
 	if err != nil {
 		fatalf("build sign-off plan: %v", err)
 	}
@@ -264,7 +329,7 @@ func writeJSON(value interface{}, outputPath string) {
 	}
 }
 
-func buildPlan(ctx context.Context, client githubClient, targetVersion, previousVersion, webhookImage, signingPolicyInput, runID, stateKeyRoot string) (plan, error) {
+func buildPlan(ctx context.Context, client githubClient, definitions laneDefinitions, targetVersion, previousVersion, webhookImage, signingPolicyInput, runID, stateKeyRoot string) (plan, error) {
 	target, err := parseAlphaVersion(targetVersion)
 	if err != nil {
 		return plan{}, err
@@ -321,44 +386,18 @@ func buildPlan(ctx context.Context, client githubClient, targetVersion, previous
 	}
 
 	webhookChanged := targetWebhookTag != previousWebhookTag
-	lanes := []lane{
-		{
-			Name:                laneFreshAlpha,
-			InstallRancher:      targetVersion,
-			ProvisionDownstream: true,
-			Description:         fmt.Sprintf("Fresh install %s, provision downstream Linode, run webhook suite.", targetVersion),
-		},
-		{
-			Name:                laneUpgradeAlpha,
-			InstallRancher:      previousVersion,
-			UpgradeToRancher:    targetVersion,
-			ProvisionDownstream: true,
-			Description:         fmt.Sprintf("Install %s, provision downstream Linode, upgrade to %s, run webhook suite.", previousVersion, targetVersion),
-		},
-		{
-			Name:                laneLocalSuites,
-			InstallRancher:      targetVersion,
-			ProvisionDownstream: false,
-			Description:         fmt.Sprintf("Fresh install %s, run local-cluster suites such as frameworks regression and VAI enabled.", targetVersion),
-		},
+	lanes, skipped, err := definitions.evaluate(laneInputs{
+		Target:           target,
+		TargetVersion:    targetVersion,
+		PreviousVersion:  previousVersion,
+		WebhookImage:     webhookImage,
+		TargetWebhookTag: targetWebhookTag,
+		WebhookChanged:   webhookChanged,
+	})
+	if err != nil {
+		return plan{}, err
 	}
-
-	var skipped []skippedLane
-	if webhookChanged {
-		lanes = append(lanes, lane{
-			Name:                 laneOldWebhook,
-			InstallRancher:       previousVersion,
-			ProvisionDownstream:  true,
-			WebhookOverrideImage: webhookImage,
-			Description:          fmt.Sprintf("Install %s, provision downstream Linode, override local and downstream webhook to %s, run webhook suite.", previousVersion, webhookImage),
-		})
-	} else {
-		skipped = append(skipped, skippedLane{
-			Name:   laneOldWebhook,
-			Reason: fmt.Sprintf("Target alpha reuses previous Rancher webhook tag %s; overriding the old Rancher to the same webhook adds no coverage.", targetWebhookTag),
-		})
-	}
-	applyLaneRuntimeFields(lanes, targetVersion, fmt.Sprintf("v%d.%d", target.Major, target.Minor), runID, stateKeyRoot)
+	applyLaneRuntimeFields(lanes, definitions, targetVersion, fmt.Sprintf("v%d.%d", target.Major, target.Minor), runID, stateKeyRoot)
 
 	return plan{
 		TargetVersion:        targetVersion,
@@ -381,6 +420,189 @@ func buildPlan(ctx context.Context, client githubClient, targetVersion, previous
 	}, nil
 }
 
+func readLaneDefinitions(path string) (laneDefinitions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return laneDefinitions{}, err
+	}
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	var definitions laneDefinitions
+	if err := decoder.Decode(&definitions); err != nil {
+		return laneDefinitions{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := definitions.validate(); err != nil {
+		return laneDefinitions{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return definitions, nil
+}
+
+func (d laneDefinitions) validate() error {
+	if d.Version != laneDefinitionsV1 {
+		return fmt.Errorf("unsupported lane definitions version %d", d.Version)
+	}
+	if len(d.Lanes) == 0 {
+		return errors.New("no lanes defined")
+	}
+	for name, suite := range d.Suites {
+		if strings.TrimSpace(suite.Package) == "" {
+			return fmt.Errorf("suite %s: package is required", name)
+		}
+		if len(suite.Tags) == 0 {
+			return fmt.Errorf("suite %s: tags are required", name)
+		}
+		if suite.Timeout != "" {
+			if _, err := time.ParseDuration(suite.Timeout); err != nil {
+				return fmt.Errorf("suite %s: timeout: %w", name, err)
+			}
+		}
+	}
+	names := map[string]bool{}
+	codes := map[string]bool{}
+	for _, definition := range d.Lanes {
+		if definition.Name == "" {
+			return errors.New("lane name is required")
+		}
+		if names[definition.Name] {
+			return fmt.Errorf("lane %s is defined more than once", definition.Name)
+		}
+		names[definition.Name] = true
+		if definition.Code == "" || sanitizeAWSNamePart(definition.Code) != definition.Code {
+			return fmt.Errorf("lane %s: code must be lowercase letters, digits or dashes", definition.Name)
+		}
+		if codes[definition.Code] {
+			return fmt.Errorf("lane %s: code %s is already used", definition.Name, definition.Code)
+		}
+		codes[definition.Code] = true
+		if !isLaneVersionRef(definition.Install) {
+			return fmt.Errorf("lane %s: install must be target or previous, got %q", definition.Name, definition.Install)
+		}
+		if definition.Upgrade != "" && !isLaneVersionRef(definition.Upgrade) {
+			return fmt.Errorf("lane %s: upgrade must be target or previous, got %q", definition.Name, definition.Upgrade)
+		}
+		switch definition.When {
+		case "", "always", "webhook_changed":
+		default:
+			return fmt.Errorf("lane %s: unsupported when %q", definition.Name, definition.When)
+		}
+		switch definition.WebhookOverride {
+		case "", "candidate":
+		default:
+			return fmt.Errorf("lane %s: unsupported webhook_override %q", definition.Name, definition.WebhookOverride)
+		}
+		switch definition.SuiteEnv {
+		case "", "local", "downstream":
+		default:
+			return fmt.Errorf("lane %s: unsupported suite_env %q", definition.Name, definition.SuiteEnv)
+		}
+		if definition.SuiteEnv == "downstream" && !definition.ProvisionDownstream {
+			return fmt.Errorf("lane %s: suite_env downstream needs provision_downstream", definition.Name)
+		}
+		if len(definition.Suites) > 0 && definition.SuiteEnv == "" {
+			return fmt.Errorf("lane %s: suite_env is required when the lane runs suites", definition.Name)
+		}
+		for _, suite := range definition.Suites {
+			if _, ok := d.Suites[suite.Suite]; !ok {
+				return fmt.Errorf("lane %s: suite %q is not in the suite catalog", definition.Name, suite.Suite)
+			}
+			if suite.Rancher != "" {
+				if _, err := goversion.NewConstraint(suite.Rancher); err != nil {
+					return fmt.Errorf("lane %s: suite %s: rancher constraint %q: %w", definition.Name, suite.Suite, suite.Rancher, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func isLaneVersionRef(value string) bool {
+	return value == "target" || value == "previous"
+}
+
+// evaluate turns the definitions into the lanes planned for one target and
+// the lanes skipped because their when condition does not hold.
+func (d laneDefinitions) evaluate(inputs laneInputs) ([]lane, []skippedLane, error) {
+	releaseLine, err := goversion.NewVersion(fmt.Sprintf("%d.%d", inputs.Target.Major, inputs.Target.Minor))
+	if err != nil {
+		return nil, nil, err
+	}
+	replacer := strings.NewReplacer(
+		"{target}", inputs.TargetVersion,
+		"{previous}", inputs.PreviousVersion,
+		"{webhook_image}", inputs.WebhookImage,
+		"{target_webhook_tag}", inputs.TargetWebhookTag,
+	)
+	versionFor := func(ref string) string {
+		switch ref {
+		case "target":
+			return inputs.TargetVersion
+		case "previous":
+			return inputs.PreviousVersion
+		}
+		return ""
+	}
+
+	lanes := []lane{}
+	var skipped []skippedLane
+	for _, definition := range d.Lanes {
+		if definition.When == "webhook_changed" && !inputs.WebhookChanged {
+			skipped = append(skipped, skippedLane{Name: definition.Name, Reason: replacer.Replace(definition.SkipReason)})
+			continue
+		}
+		planned := lane{
+			Name:                definition.Name,
+			InstallRancher:      versionFor(definition.Install),
+			UpgradeToRancher:    versionFor(definition.Upgrade),
+			ProvisionDownstream: definition.ProvisionDownstream,
+			BackupRestore:       definition.BackupRestore,
+			SuiteEnv:            definition.SuiteEnv,
+			Description:         replacer.Replace(definition.Description),
+		}
+		if definition.WebhookOverride == "candidate" {
+			planned.WebhookOverrideImage = inputs.WebhookImage
+		}
+		for _, suite := range definition.Suites {
+			if suite.Rancher != "" {
+				constraint, err := goversion.NewConstraint(suite.Rancher)
+				if err != nil {
+					return nil, nil, fmt.Errorf("lane %s: suite %s: %w", definition.Name, suite.Suite, err)
+				}
+				if !constraint.Check(releaseLine) {
+					continue
+				}
+			}
+			catalog := d.Suites[suite.Suite]
+			run := suite.Run
+			if run == "" {
+				run = suite.Suite
+			}
+			junit := suite.JUnit
+			if junit == "" {
+				junit = strings.ReplaceAll(suite.Suite, "/", "-")
+			}
+			planned.Suites = append(planned.Suites, laneSuite{
+				Name:    suite.Suite,
+				Package: catalog.Package,
+				Run:     run,
+				Tags:    strings.Join(catalog.Tags, ","),
+				Timeout: catalog.Timeout,
+				JUnit:   junit,
+			})
+		}
+		lanes = append(lanes, planned)
+	}
+	return lanes, skipped, nil
+}
+
+func (d laneDefinitions) code(laneName string) string {
+	for _, definition := range d.Lanes {
+		if definition.Name == laneName {
+			return definition.Code
+		}
+	}
+	return ""
+}
+
 func readLedger(path string) (signoffLedger, error) {
 	data, err := os.ReadFile(path)
 	if os.IsNotExist(err) {
@@ -469,7 +691,7 @@ func (ignoreList targetIgnoreList) reasonFor(targetVersion string) (string, bool
 	return "", false
 }
 
-func ignoredPlan(targetVersion, reason, runID, stateKeyRoot string) (plan, error) {
+func ignoredPlan(definitions laneDefinitions, targetVersion, reason, runID, stateKeyRoot string) (plan, error) {
 	target, err := parseAlphaVersion(targetVersion)
 	if err != nil {
 		return plan{}, err
@@ -480,6 +702,10 @@ func ignoredPlan(targetVersion, reason, runID, stateKeyRoot string) (plan, error
 	if reason == "" {
 		reason = "Target is listed in the repo-owned sign-off ignore list."
 	}
+	skipped := make([]skippedLane, 0, len(definitions.Lanes))
+	for _, definition := range definitions.Lanes {
+		skipped = append(skipped, skippedLane{Name: definition.Name, Reason: reason})
+	}
 	return plan{
 		TargetVersion: targetVersion,
 		ReleaseLine:   releaseLine,
@@ -488,13 +714,8 @@ func ignoredPlan(targetVersion, reason, runID, stateKeyRoot string) (plan, error
 		Ignored:       true,
 		IgnoreReason:  reason,
 		Lanes:         []lane{},
-		SkippedLanes: []skippedLane{
-			{Name: laneFreshAlpha, Reason: reason},
-			{Name: laneUpgradeAlpha, Reason: reason},
-			{Name: laneLocalSuites, Reason: reason},
-			{Name: laneOldWebhook, Reason: reason},
-		},
-		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		SkippedLanes:  skipped,
+		GeneratedAt:   time.Now().UTC().Format(time.RFC3339),
 	}, nil
 }
 
@@ -530,11 +751,11 @@ func applyLedgerSkips(p plan, ledger signoffLedger) plan {
 	return p
 }
 
-func applyLaneRuntimeFields(lanes []lane, targetVersion, releaseLine, runID, stateKeyRoot string) {
+func applyLaneRuntimeFields(lanes []lane, definitions laneDefinitions, targetVersion, releaseLine, runID, stateKeyRoot string) {
 	runID = strings.TrimSpace(runID)
 	stateKeyRoot = strings.Trim(strings.TrimSpace(stateKeyRoot), "/")
 	for i := range lanes {
-		lanes[i].AWSPrefix = buildLaneAWSPrefix(runID, lanes[i].Name)
+		lanes[i].AWSPrefix = buildLaneAWSPrefix(runID, definitions.code(lanes[i].Name))
 		if runID != "" && stateKeyRoot != "" {
 			lanes[i].TerraformStateKey = buildTerraformStateKey(stateKeyRoot, releaseLine, targetVersion, runID, lanes[i].Name)
 		}
@@ -553,13 +774,7 @@ func buildTerraformStateKey(root, releaseLine, targetVersion, runID, laneName st
 	return strings.Join(parts, "/")
 }
 
-func buildLaneAWSPrefix(runID, laneName string) string {
-	laneCode := map[string]string{
-		laneFreshAlpha:   "fa",
-		laneUpgradeAlpha: "ua",
-		laneOldWebhook:   "ow",
-		laneLocalSuites:  "ls",
-	}[laneName]
+func buildLaneAWSPrefix(runID, laneCode string) string {
 	if laneCode == "" {
 		laneCode = "ln"
 	}
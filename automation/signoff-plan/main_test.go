@@ -5,11 +5,21 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 )
 
+const (
+	laneFreshAlpha    = "fresh-alpha"
+	laneUpgradeAlpha  = "upgrade-alpha"
+	laneOldWebhook    = "previous-with-candidate-webhook"
+	laneLocalSuites   = "fresh-alpha-local-suites"
+	laneBackupRestore = "backup-restore"
+)
+
 func TestWebhookTagFromBuild(t *testing.T) {
 	tag, err := webhookTagFromBuild("109.0.1+up0.10.1-rc.5")
 	if err != nil {
@@ -84,7 +94,7 @@ func TestBuildPlanAddsOldWebhookLaneWhenWebhookChanged(t *testing.T) {
 		"/stg/v2/rancher/rancher-webhook/manifests/v0.10.1-rc.5": "ok",
 	})
 
-	plan, err := buildPlan(context.Background(), client, "v2.14.1-alpha6", "v2.14.0", "stgregistry.suse.com/rancher/rancher-webhook:v0.10.1-rc.5", "auto", "123456789", "ha-rancher-rke2/signoff")
+	plan, err := buildPlan(context.Background(), client, defaultLaneDefinitions(t), "v2.14.1-alpha6", "v2.14.0", "stgregistry.suse.com/rancher/rancher-webhook:v0.10.1-rc.5", "auto", "123456789", "ha-rancher-rke2/signoff")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -95,8 +105,8 @@ func TestBuildPlanAddsOldWebhookLaneWhenWebhookChanged(t *testing.T) {
 	if plan.SigningPolicy != "report-only" {
 		t.Fatalf("expected report-only signing policy, got %s", plan.SigningPolicy)
 	}
-	if len(plan.Lanes) != 4 {
-		t.Fatalf("expected 4 lanes, got %d", len(plan.Lanes))
+	if len(plan.Lanes) != 5 {
+		t.Fatalf("expected 5 lanes, got %d", len(plan.Lanes))
 	}
 	if plan.Lanes[2].Name != laneLocalSuites {
 		t.Fatalf("expected local suites lane, got %s", plan.Lanes[2].Name)
@@ -116,6 +126,15 @@ func TestBuildPlanAddsOldWebhookLaneWhenWebhookChanged(t *testing.T) {
 	if plan.Lanes[3].AWSPrefix != "gha-23456789-ow" {
 		t.Fatalf("unexpected AWS prefix: %s", plan.Lanes[3].AWSPrefix)
 	}
+	if plan.Lanes[4].Name != laneBackupRestore || !plan.Lanes[4].BackupRestore {
+		t.Fatalf("expected backup-restore lane, got %#v", plan.Lanes[4])
+	}
+	if plan.Lanes[4].InstallRancher != "v2.14.1-alpha6" || plan.Lanes[4].ProvisionDownstream {
+		t.Fatalf("expected backup-restore lane to install the target without a downstream, got %#v", plan.Lanes[4])
+	}
+	if plan.Lanes[4].AWSPrefix != "gha-23456789-br" {
+		t.Fatalf("unexpected AWS prefix: %s", plan.Lanes[4].AWSPrefix)
+	}
 }
 
 func TestBuildPlanDiscoversStagingPrereleaseWebhookImageWhenNoOverride(t *testing.T) {
@@ -133,7 +152,7 @@ func TestBuildPlanDiscoversStagingPrereleaseWebhookImageWhenNoOverride(t *testin
 		"docker.io":            client.rawBaseURL + "/docker",
 	}
 
-	plan, err := buildPlan(context.Background(), client, "v2.14.1-alpha6", "v2.14.0", "", "auto", "", "ha-rancher-rke2/signoff")
+	plan, err := buildPlan(context.Background(), client, defaultLaneDefinitions(t), "v2.14.1-alpha6", "v2.14.0", "", "auto", "", "ha-rancher-rke2/signoff")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -165,7 +184,7 @@ func TestBuildPlanDiscoversReleasedWebhookImageForStableTagWhenNoOverride(t *tes
 		"docker.io":            client.rawBaseURL + "/docker",
 	}
 
-	plan, err := buildPlan(context.Background(), client, "v2.13.5-alpha6", "v2.13.4", "", "auto", "", "ha-rancher-rke2/signoff")
+	plan, err := buildPlan(context.Background(), client, defaultLaneDefinitions(t), "v2.13.5-alpha6", "v2.13.4", "", "auto", "", "ha-rancher-rke2/signoff")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -192,7 +211,7 @@ func TestBuildPlanFallsBackToDockerHubWhenSUSERegistriesAreMissing(t *testing.T)
 		"docker.io":            client.rawBaseURL + "/docker",
 	}
 
-	plan, err := buildPlan(context.Background(), client, "v2.14.1-alpha6", "v2.14.0", "", "auto", "", "ha-rancher-rke2/signoff")
+	plan, err := buildPlan(context.Background(), client, defaultLaneDefinitions(t), "v2.14.1-alpha6", "v2.14.0", "", "auto", "", "ha-rancher-rke2/signoff")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -215,7 +234,7 @@ func TestBuildPlanFallsBackToPrimeBeforePublicSUSEAndDockerHub(t *testing.T) {
 		"/docker/v2/rancher/rancher-webhook/manifests/v0.10.1-rc.5": "ok",
 	})
 
-	plan, err := buildPlan(context.Background(), client, "v2.14.1-alpha6", "v2.14.0", "", "auto", "", "ha-rancher-rke2/signoff")
+	plan, err := buildPlan(context.Background(), client, defaultLaneDefinitions(t), "v2.14.1-alpha6", "v2.14.0", "", "auto", "", "ha-rancher-rke2/signoff")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -237,7 +256,7 @@ func TestBuildPlanFailsWhenExplicitWebhookImageTagMismatchesBuildYAML(t *testing
 		"/stg/v2/rancher/rancher-webhook/manifests/v0.10.1-rc.5": "ok",
 	})
 
-	_, err := buildPlan(context.Background(), client, "v2.14.1-alpha6", "v2.14.0", "stgregistry.suse.com/rancher/rancher-webhook:v0.10.0", "auto", "", "ha-rancher-rke2/signoff")
+	_, err := buildPlan(context.Background(), client, defaultLaneDefinitions(t), "v2.14.1-alpha6", "v2.14.0", "stgregistry.suse.com/rancher/rancher-webhook:v0.10.0", "auto", "", "ha-rancher-rke2/signoff")
 	if err == nil {
 		t.Fatal("expected explicit mismatched webhook image tag to fail")
 	}
@@ -252,7 +271,7 @@ func TestBuildPlanFailsWhenExplicitWebhookImageIsMissing(t *testing.T) {
 		"/rancher/rancher/v2.14.0/build.yaml":        `webhookVersion: 109.0.0+up0.10.0`,
 	})
 
-	_, err := buildPlan(context.Background(), client, "v2.14.1-alpha6", "v2.14.0", "stgregistry.suse.com/rancher/rancher-webhook:v0.10.1-rc.5", "auto", "", "ha-rancher-rke2/signoff")
+	_, err := buildPlan(context.Background(), client, defaultLaneDefinitions(t), "v2.14.1-alpha6", "v2.14.0", "stgregistry.suse.com/rancher/rancher-webhook:v0.10.1-rc.5", "auto", "", "ha-rancher-rke2/signoff")
 	if err == nil {
 		t.Fatal("expected explicit missing webhook image to fail")
 	}
@@ -303,7 +322,7 @@ func TestBuildPlanSkipsOldWebhookLaneWhenWebhookUnchanged(t *testing.T) {
 		"/docker/v2/rancher/rancher-webhook/manifests/v0.10.1-rc.5": "ok",
 	})
 
-	plan, err := buildPlan(context.Background(), client, "v2.14.1-alpha6", "v2.14.0", "", "auto", "", "ha-rancher-rke2/signoff")
+	plan, err := buildPlan(context.Background(), client, defaultLaneDefinitions(t), "v2.14.1-alpha6", "v2.14.0", "", "auto", "", "ha-rancher-rke2/signoff")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -314,8 +333,8 @@ func TestBuildPlanSkipsOldWebhookLaneWhenWebhookUnchanged(t *testing.T) {
 	if plan.SigningPolicy != "report-only" {
 		t.Fatalf("expected Docker Hub default to be report-only, got %s", plan.SigningPolicy)
 	}
-	if len(plan.Lanes) != 3 {
-		t.Fatalf("expected 3 lanes, got %d", len(plan.Lanes))
+	if len(plan.Lanes) != 4 {
+		t.Fatalf("expected 4 lanes, got %d", len(plan.Lanes))
 	}
 	if plan.Lanes[2].Name != laneLocalSuites {
 		t.Fatalf("expected local suites lane, got %s", plan.Lanes[2].Name)
@@ -331,6 +350,104 @@ func TestBuildPlanSkipsOldWebhookLaneWhenWebhookUnchanged(t *testing.T) {
 	}
 }
 
+func TestDefaultLaneDefinitionsGateSuitesOnReleaseLine(t *testing.T) {
+	definitions := defaultLaneDefinitions(t)
+	suiteNames := func(lanes []lane, name string) []string {
+		for _, lane := range lanes {
+			if lane.Name == name {
+				var names []string
+				for _, suite := range lane.Suites {
+					names = append(names, suite.Name)
+				}
+				return names
+			}
+		}
+		t.Fatalf("lane %s was not planned", name)
+		return nil
+	}
+
+	target, _ := parseAlphaVersion("v2.14.1-alpha6")
+	lanes, skipped, err := definitions.evaluate(laneInputs{Target: target, TargetVersion: "v2.14.1-alpha6", PreviousVersion: "v2.14.0", WebhookImage: "registry.example.com/rancher/rancher-webhook:v0.10.1", WebhookChanged: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(skipped) != 0 {
+		t.Fatalf("expected no skipped lanes, got %#v", skipped)
+	}
+	if got := strings.Join(suiteNames(lanes, laneFreshAlpha), " "); got != "TestWebhookTestSuite TestWebhookSecuritySettingsTestSuite" {
+		t.Fatalf("unexpected fresh-alpha suites on v2.14: %s", got)
+	}
+	if got := strings.Join(suiteNames(lanes, laneLocalSuites), " "); got != "TestConfigMapTestSuite TestSchemaChangesTestSuite TestNodeAnnotationsTestSuite TestVaiTestSuite/TestVaiEnabled" {
+		t.Fatalf("unexpected local suites on v2.14: %s", got)
+	}
+	if got := suiteNames(lanes, laneBackupRestore); len(got) != 0 {
+		t.Fatalf("expected backup-restore to run no rancher/tests suites, got %v", got)
+	}
+
+	for _, lane := range lanes {
+		switch lane.Name {
+		case laneOldWebhook:
+			if lane.SuiteEnv != "downstream" || len(lane.Suites) != 1 {
+				t.Fatalf("unexpected override lane: %#v", lane)
+			}
+			suite := lane.Suites[0]
+			if suite.Run != "TestWebhookTestSuite/TestWebhookChart/Verify_(webhook_pod_logs|the_count_of_webhook_is_greater_than_zero)" || suite.JUnit != "TestWebhookTestSuite-WebhookOverride" {
+				t.Fatalf("unexpected override suite: %#v", suite)
+			}
+			if lane.Description != "Install v2.14.0, provision downstream Linode, override local and downstream webhook to registry.example.com/rancher/rancher-webhook:v0.10.1, run webhook suite." {
+				t.Fatalf("unexpected override description: %s", lane.Description)
+			}
+		case laneLocalSuites:
+			vai := lane.Suites[len(lane.Suites)-1]
+			if vai.Package != "./validation/steve/vai" || vai.Tags != "validation,infra.any,cluster.any,extended" || vai.Timeout != "30m" || vai.JUnit != "TestVaiTestSuite-TestVaiEnabled" {
+				t.Fatalf("unexpected VAI suite: %#v", vai)
+			}
+		case laneUpgradeAlpha:
+			if lane.InstallRancher != "v2.14.0" || lane.UpgradeToRancher != "v2.14.1-alpha6" {
+				t.Fatalf("unexpected upgrade versions: %#v", lane)
+			}
+		}
+	}
+
+	target, _ = parseAlphaVersion("v2.11.4-alpha1")
+	lanes, skipped, err = definitions.evaluate(laneInputs{Target: target, TargetVersion: "v2.11.4-alpha1", PreviousVersion: "v2.11.3", TargetWebhookTag: "v0.7.3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(suiteNames(lanes, laneFreshAlpha), " "); got != "TestWebhookTestSuite" {
+		t.Fatalf("unexpected fresh-alpha suites on v2.11: %s", got)
+	}
+	if got := suiteNames(lanes, laneLocalSuites); got[len(got)-1] != "TestVaiTestSuite/TestVaiDisabled" {
+		t.Fatalf("expected VAI disabled on v2.11, got %v", got)
+	}
+	if len(skipped) != 1 || skipped[0].Reason != "Target alpha reuses previous Rancher webhook tag v0.7.3; overriding the old Rancher to the same webhook adds no coverage." {
+		t.Fatalf("unexpected skipped lanes: %#v", skipped)
+	}
+}
+
+func TestReadLaneDefinitionsRejectsInvalidFiles(t *testing.T) {
+	tests := map[string]string{
+		"version":        "version: 2\nlanes:\n  - {name: a, code: a, install: target}\n",
+		"unknown field":  "version: 1\nlanes:\n  - {name: a, code: a, install: target, downstream: true}\n",
+		"install":        "version: 1\nlanes:\n  - {name: a, code: a, install: latest}\n",
+		"duplicate code": "version: 1\nlanes:\n  - {name: a, code: a, install: target}\n  - {name: b, code: a, install: target}\n",
+		"unknown suite":  "version: 1\nlanes:\n  - {name: a, code: a, install: target, suite_env: local, suites: [{suite: Missing}]}\n",
+		"suite env":      "version: 1\nsuites:\n  S: {package: ./s, tags: [validation]}\nlanes:\n  - {name: a, code: a, install: target, suites: [{suite: S}]}\n",
+		"constraint":     "version: 1\nsuites:\n  S: {package: ./s, tags: [validation]}\nlanes:\n  - {name: a, code: a, install: target, suite_env: local, suites: [{suite: S, rancher: soon}]}\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "lanes.yaml")
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readLaneDefinitions(path); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
 func TestBuildTerraformStateKey(t *testing.T) {
 	got := buildTerraformStateKey("root/", "v2.14", "v2.14.1-alpha6", "123", laneFreshAlpha)
 	want := "root/v2.14/v2.14.1-alpha6/123/fresh-alpha/terraform.tfstate"
@@ -404,7 +521,7 @@ func TestTargetIgnoreListExactVersionOverridesReleaseLine(t *testing.T) {
 }
 
 func TestIgnoredPlanHasNoRunnableLanes(t *testing.T) {
-	plan, err := ignoredPlan("v2.15.0-alpha3", "known-bad line", "123456789", "root")
+	plan, err := ignoredPlan(defaultLaneDefinitions(t), "v2.15.0-alpha3", "known-bad line", "123456789", "root")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -417,7 +534,7 @@ func TestIgnoredPlanHasNoRunnableLanes(t *testing.T) {
 	if len(plan.Lanes) != 0 {
 		t.Fatalf("expected no runnable lanes, got %#v", plan.Lanes)
 	}
-	if len(plan.SkippedLanes) != 4 {
+	if len(plan.SkippedLanes) != 5 {
 		t.Fatalf("expected skipped lane records for all lane types, got %#v", plan.SkippedLanes)
 	}
 }
@@ -477,6 +594,16 @@ func TestApplyLedgerDoesNotSkipStaleCoveragePolicy(t *testing.T) {
 	}
 }
 
+func defaultLaneDefinitions(t *testing.T) laneDefinitions {
+	t.Helper()
+
+	definitions, err := readLaneDefinitions(filepath.Join("..", "..", "signoff-lanes.yaml"))
+	if err != nil {
+		t.Fatalf("read default lane definitions: %v", err)
+	}
+	return definitions
+}
+
 func fakeGitHubClient(t *testing.T, responses map[string]string) githubClient {
 	t.Helper()
 
@@ -81,6 +81,24 @@ variable "custom_hostname_prefix" {
   default     = ""
 }
 
+variable "server_count" {
+  type        = number
+  description = "Number of RKE2 server nodes per HA (1, 3, or 5)"
+  default     = 3
+}
+
+variable "agent_count" {
+  type        = number
+  description = "Number of worker-only RKE2 agent nodes per HA"
+  default     = 0
+}
+
+variable "tls_mode" {
+  type        = string
+  description = "Rancher TLS mode: external (ALB + ACM) or rancher, letsencrypt, secret (NLB TCP passthrough)"
+  default     = "external"
+}
+
 # Module configuration
 locals {
   ha_instances = { for i in range(1, var.total_has + 1) : i => "${var.aws_prefix}-${i}" }
@@ -101,18 +119,19 @@ module "ha" {
   aws_pem_key_name       = var.aws_pem_key_name
   aws_route53_fqdn       = var.aws_route53_fqdn
   custom_hostname_prefix = trimspace(var.custom_hostname_prefix)
+  server_count           = var.server_count
+  agent_count            = var.agent_count
+  tls_mode               = var.tls_mode
 }
 
 # Outputs
 output "ha_details" {
   value = {
     for idx, instance in module.ha : "ha_${idx}" => {
-      server1_ip         = instance.server1_ip
-      server2_ip         = instance.server2_ip
-      server3_ip         = instance.server3_ip
-      server1_private_ip = instance.server1_private_ip
-      server2_private_ip = instance.server2_private_ip
-      server3_private_ip = instance.server3_private_ip
+      server_ips         = instance.server_ips
+      server_private_ips = instance.server_private_ips
+      agent_ips          = instance.agent_ips
+      agent_private_ips  = instance.agent_private_ips
       aws_lb             = instance.aws_lb
       rancher_url        = instance.rancher_url
     }
@@ -120,18 +139,19 @@ output "ha_details" {
   sensitive = true
 }
 
+# Node keys are numbered from 1, e.g. ha_1_server1_ip or ha_2_agent3_private_ip.
 output "flat_outputs" {
-  value = merge([
-    for idx, instance in module.ha : {
-      "ha_${idx}_server1_ip"         = instance.server1_ip
-      "ha_${idx}_server2_ip"         = instance.server2_ip
-      "ha_${idx}_server3_ip"         = instance.server3_ip
-      "ha_${idx}_server1_private_ip" = instance.server1_private_ip
-      "ha_${idx}_server2_private_ip" = instance.server2_private_ip
-      "ha_${idx}_server3_private_ip" = instance.server3_private_ip
-      "ha_${idx}_aws_lb"             = instance.aws_lb
-      "ha_${idx}_rancher_url"        = instance.rancher_url
-    }
-  ]...)
+  value = merge(flatten([
+    for idx, instance in module.ha : concat(
+      [{
+        "ha_${idx}_aws_lb"      = instance.aws_lb
+        "ha_${idx}_rancher_url" = instance.rancher_url
+      }],
+      [for n, ip in instance.server_ips : { "ha_${idx}_server${n + 1}_ip" = ip }],
+      [for n, ip in instance.server_private_ips : { "ha_${idx}_server${n + 1}_private_ip" = ip }],
+      [for n, ip in instance.agent_ips : { "ha_${idx}_agent${n + 1}_ip" = ip }],
+      [for n, ip in instance.agent_private_ips : { "ha_${idx}_agent${n + 1}_private_ip" = ip }],
+    )
+  ])...)
   sensitive = true
 }
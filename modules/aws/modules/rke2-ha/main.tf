@@ -57,6 +57,39 @@ variable "custom_hostname_prefix" {
   default     = ""
 }
 
+variable "server_count" {
+  type        = number
+  description = "Number of RKE2 server nodes"
+  default     = 3
+
+  validation {
+    condition     = contains([1, 3, 5], var.server_count)
+    error_message = "server_count must be 1, 3, or 5."
+  }
+}
+
+variable "agent_count" {
+  type        = number
+  description = "Number of worker-only RKE2 agent nodes"
+  default     = 0
+
+  validation {
+    condition     = var.agent_count >= 0
+    error_message = "agent_count must be 0 or greater."
+  }
+}
+
+variable "tls_mode" {
+  type        = string
+  description = "Rancher TLS mode. external terminates TLS at an ALB with ACM; the others pass TCP through an NLB to the Rancher ingress."
+  default     = "external"
+
+  validation {
+    condition     = contains(["external", "rancher", "letsencrypt", "secret"], var.tls_mode)
+    error_message = "tls_mode must be external, rancher, letsencrypt, or secret."
+  }
+}
+
 # Resources
 resource "random_pet" "name" {
   keepers = {
@@ -78,10 +111,12 @@ locals {
   dns_label            = trimspace(var.custom_hostname_prefix) != "" ? trimspace(var.custom_hostname_prefix) : local.resource_name_prefix
   target_group_prefix  = substr(local.resource_name_prefix, 0, 28)
   domain_name          = "${local.dns_label}.${var.aws_route53_fqdn}"
+  external_tls         = var.tls_mode == "external"
+  lb_protocol          = local.external_tls ? "HTTP" : "TCP"
 }
 
 resource "aws_instance" "aws_instance" {
-  count                  = 3
+  count                  = var.server_count
   ami                    = var.aws_ami
   instance_type          = "t3a.large"
   subnet_id              = var.aws_subnet_id
@@ -103,48 +138,103 @@ resource "aws_instance" "aws_instance" {
   }
 }
 
-# Application Load Balancer for Rancher UI. Public TLS terminates at the ALB,
-# then forwards to Rancher's HTTP ingress because Helm uses tls=external.
+resource "aws_instance" "agent" {
+  count                  = var.agent_count
+  ami                    = var.aws_ami
+  instance_type          = "t3a.large"
+  subnet_id              = var.aws_subnet_id
+  vpc_security_group_ids = [var.aws_security_group_id]
+  key_name               = var.aws_pem_key_name
+  iam_instance_profile   = aws_iam_instance_profile.ssm_profile.name
+
+  root_block_device {
+    volume_size = 200
+    tags = {
+      Name  = "${local.resource_name_prefix}-agent-${count.index + 1}"
+      Owner = "${var.aws_prefix}-terraform"
+    }
+  }
+
+  tags = {
+    Name  = "${local.resource_name_prefix}-agent-${count.index + 1}"
+    Owner = "${var.aws_prefix}-terraform"
+  }
+}
+
+# Load balancer for Rancher UI. With tls_mode=external, public TLS terminates
+# at an ALB with an ACM certificate and forwards to Rancher's HTTP ingress
+# because Helm uses tls=external. Every other mode uses an NLB that passes
+# TCP 80/443 straight through so Rancher's ingress serves its own certificate.
 resource "aws_lb_target_group" "aws_lb_target_group_80" {
   name        = "${local.target_group_prefix}-80"
   port        = 80
-  protocol    = "HTTP"
+  protocol    = local.lb_protocol
   target_type = "instance"
   vpc_id      = var.aws_vpc
   health_check {
-    protocol          = "HTTP"
+    protocol          = local.lb_protocol
     port              = "traffic-port"
     healthy_threshold = 3
     interval          = 10
   }
 }
 
+resource "aws_lb_target_group" "aws_lb_target_group_443" {
+  count       = local.external_tls ? 0 : 1
+  name        = "${local.target_group_prefix}-443"
+  port        = 443
+  protocol    = "TCP"
+  target_type = "instance"
+  vpc_id      = var.aws_vpc
+  health_check {
+    protocol          = "TCP"
+    port              = "traffic-port"
+    healthy_threshold = 3
+    interval          = 10
+  }
+}
+
+# rke2-ingress-nginx runs on every node, so agents serve Rancher traffic too.
 resource "aws_lb_target_group_attachment" "attach_tg_80" {
-  count            = length(aws_instance.aws_instance)
+  count            = var.server_count + var.agent_count
   target_group_arn = aws_lb_target_group.aws_lb_target_group_80.arn
-  target_id        = aws_instance.aws_instance[count.index].id
+  target_id        = concat(aws_instance.aws_instance[*].id, aws_instance.agent[*].id)[count.index]
   port             = 80
 }
 
+resource "aws_lb_target_group_attachment" "attach_tg_443" {
+  count            = local.external_tls ? 0 : var.server_count + var.agent_count
+  target_group_arn = aws_lb_target_group.aws_lb_target_group_443[0].arn
+  target_id        = concat(aws_instance.aws_instance[*].id, aws_instance.agent[*].id)[count.index]
+  port             = 443
+}
+
 resource "aws_lb" "aws_lb" {
-  load_balancer_type = "application"
+  load_balancer_type = local.external_tls ? "application" : "network"
   name               = local.resource_name_prefix
   internal           = false
   ip_address_type    = "ipv4"
   subnets            = [var.aws_subnet_a, var.aws_subnet_b, var.aws_subnet_c]
 }
 
+# The ALB redirects HTTP to HTTPS itself. The NLB forwards port 80 so
+# cert-manager's HTTP-01 challenges and the ingress redirect still work.
 resource "aws_lb_listener" "aws_lb_listener_80" {
   load_balancer_arn = aws_lb.aws_lb.arn
   port              = "80"
-  protocol          = "HTTP"
+  protocol          = local.lb_protocol
 
   default_action {
-    type = "redirect"
-    redirect {
-      port        = "443"
-      protocol    = "HTTPS"
-      status_code = "HTTP_301"
+    type             = local.external_tls ? "redirect" : "forward"
+    target_group_arn = local.external_tls ? null : aws_lb_target_group.aws_lb_target_group_80.arn
+
+    dynamic "redirect" {
+      for_each = local.external_tls ? [1] : []
+      content {
+        port        = "443"
+        protocol    = "HTTPS"
+        status_code = "HTTP_301"
+      }
     }
   }
 }
@@ -163,6 +253,7 @@ resource "aws_route53_record" "aws_route53_record" {
 }
 
 resource "aws_acm_certificate" "cert" {
+  count             = local.external_tls ? 1 : 0
   domain_name       = local.domain_name
   validation_method = "DNS"
 
@@ -172,56 +263,49 @@ resource "aws_acm_certificate" "cert" {
 }
 
 resource "aws_route53_record" "cert_validation" {
-  count = 1
+  count = local.external_tls ? 1 : 0
 
-  name    = tolist(aws_acm_certificate.cert.domain_validation_options)[count.index].resource_record_name
-  type    = tolist(aws_acm_certificate.cert.domain_validation_options)[count.index].resource_record_type
+  name    = tolist(aws_acm_certificate.cert[0].domain_validation_options)[count.index].resource_record_name
+  type    = tolist(aws_acm_certificate.cert[0].domain_validation_options)[count.index].resource_record_type
   zone_id = data.aws_route53_zone.zone.zone_id
-  records = [tolist(aws_acm_certificate.cert.domain_validation_options)[count.index].resource_record_value]
+  records = [tolist(aws_acm_certificate.cert[0].domain_validation_options)[count.index].resource_record_value]
   ttl     = 60
 }
 
 resource "aws_acm_certificate_validation" "cert" {
-  certificate_arn         = aws_acm_certificate.cert.arn
+  count                   = local.external_tls ? 1 : 0
+  certificate_arn         = aws_acm_certificate.cert[0].arn
   validation_record_fqdns = aws_route53_record.cert_validation[*].fqdn
 }
 
 resource "aws_lb_listener" "aws_lb_listener_443" {
   load_balancer_arn = aws_lb.aws_lb.arn
   port              = "443"
-  protocol          = "HTTPS"
-  ssl_policy        = "ELBSecurityPolicy-2016-08"
-  certificate_arn   = aws_acm_certificate_validation.cert.certificate_arn
+  protocol          = local.external_tls ? "HTTPS" : "TCP"
+  ssl_policy        = local.external_tls ? "ELBSecurityPolicy-2016-08" : null
+  certificate_arn   = local.external_tls ? aws_acm_certificate_validation.cert[0].certificate_arn : null
 
   default_action {
     type             = "forward"
-    target_group_arn = aws_lb_target_group.aws_lb_target_group_80.arn
+    target_group_arn = local.external_tls ? aws_lb_target_group.aws_lb_target_group_80.arn : aws_lb_target_group.aws_lb_target_group_443[0].arn
   }
 }
 
 # Outputs
-output "server1_ip" {
-  value = aws_instance.aws_instance[0].public_ip
-}
-
-output "server2_ip" {
-  value = aws_instance.aws_instance[1].public_ip
-}
-
-output "server3_ip" {
-  value = aws_instance.aws_instance[2].public_ip
+output "server_ips" {
+  value = aws_instance.aws_instance[*].public_ip
 }
 
-output "server1_private_ip" {
-  value = aws_instance.aws_instance[0].private_ip
+output "server_private_ips" {
+  value = aws_instance.aws_instance[*].private_ip
 }
 
-output "server2_private_ip" {
-  value = aws_instance.aws_instance[1].private_ip
+output "agent_ips" {
+  value = aws_instance.agent[*].public_ip
 }
 
-output "server3_private_ip" {
-  value = aws_instance.aws_instance[2].private_ip
+output "agent_private_ips" {
+  value = aws_instance.agent[*].private_ip
 }
 
 output "aws_lb" {
@@ -0,0 +1,661 @@
+package test
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
+	"github.com/spf13/viper"
+)
+
+const (
+	airgapArtifactsDir        = "airgap-artifacts"
+	airgapNodeArtifactsDir    = "/var/lib/rancher/rke2-artifacts"
+	rke2AgentImagesDir        = "/var/lib/rancher/rke2/agent/images"
+	rke2PodManifestsDir       = "/var/lib/rancher/rke2/agent/pod-manifests"
+	rke2ChecksumFileName      = "sha256sum-amd64.txt"
+	rke2InstallScriptFileName = "install.sh"
+
+	airgapRegistryPort       = 5000
+	airgapRegistryImage      = "docker.io/library/registry:2"
+	airgapRegistryArchive    = "airgap-registry.tar"
+	airgapRegistryDataDir    = "/var/lib/rancher/airgap-registry"
+	airgapRegistrySeedMarker = airgapRegistryDataDir + "/seeded-images.sha256"
+	airgapRegistryReadyWait  = 5 * time.Minute
+	airgapSeedWorkers        = 4
+)
+
+var rke2AirgapTarballs = []string{"rke2.linux-amd64.tar.gz", "rke2-images.linux-amd64.tar.zst"}
+
+var (
+	rke2ReleaseDownloadBaseURL    = "https://github.com/rancher/rke2/releases/download"
+	rke2InstallScriptBaseURL      = "https://raw.githubusercontent.com/rancher/rke2"
+	rancherReleaseDownloadBaseURL = "https://github.com/rancher/rancher/releases/download"
+)
+
+type rke2AirgapArtifacts struct {
+	Version       string
+	Dir           string
+	InstallScript string
+	ChecksumFile  string
+	Tarballs      []string
+}
+
+// airgapImage is one image to seed into the first server's registry. Sources
+// without a registry host come from Docker Hub; Destination drops the host so
+// both systemDefaultRegistry and the "*" mirror resolve to the same path. Two
+// sources that would share a Destination are rejected by buildAirgapImages.
+type airgapImage struct {
+	Source      string
+	Destination string
+}
+
+func airgapEnabled() bool {
+	return viper.GetBool("airgap")
+}
+
+func validateAirgapPreflight() error {
+	if !airgapEnabled() {
+		return nil
+	}
+	transport, err := configuredNodeTransport()
+	if err != nil {
+		return err
+	}
+	if transport != nodeTransportSSH {
+		return fmt.Errorf("airgap mode copies RKE2 artifacts and seeds the registry over SSH; set node_transport: ssh")
+	}
+	if _, err := exec.LookPath("skopeo"); err != nil {
+		return fmt.Errorf("skopeo is required locally in airgap mode but was not found in PATH")
+	}
+	if settings.ConfiguredTLSModeOrDefault() == settings.TLSModeLetsEncrypt {
+		return fmt.Errorf("tls.mode letsencrypt needs Let's Encrypt to reach the cluster and cannot be used in airgap mode")
+	}
+	if imagesFile := strings.TrimSpace(viper.GetString("airgap_images_file")); imagesFile != "" {
+		if _, err := readAirgapImagesFile(imagesFile); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[preflight] Airgap mode enabled; nodes will install from staged artifacts and a registry on each first server")
+	return nil
+}
+
+// stageAirgapArtifacts downloads and verifies everything the nodes need before
+// any infrastructure exists. Files are cached under airgap-artifacts/ and
+// reused when their checksums still match.
+func stageAirgapArtifacts(totalHAs int, plans []*resolver.RancherResolvedPlan) error {
+	if !airgapEnabled() {
+		return nil
+	}
+
+	staged := map[string]bool{}
+	for i := 1; i <= totalHAs; i++ {
+		plan := planForHA(plans, i)
+		version, installerSHA256 := rke2VersionForHA(plan)
+		if !staged[version] {
+			if _, err := stageRKE2AirgapArtifacts(version, installerSHA256); err != nil {
+				return fmt.Errorf("HA %d: %w", i, err)
+			}
+			staged[version] = true
+		}
+		if _, err := airgapImagesForHA(i, plan, true); err != nil {
+			return fmt.Errorf("HA %d: %w", i, err)
+		}
+	}
+
+	if err := stageAirgapRegistryImage(); err != nil {
+		return err
+	}
+	log.Printf("[preflight] Airgap artifacts staged in %s", airgapArtifactsDir)
+	return nil
+}
+
+func planForHA(plans []*resolver.RancherResolvedPlan, instanceNum int) *resolver.RancherResolvedPlan {
+	if len(plans) >= instanceNum {
+		return plans[instanceNum-1]
+	}
+	return nil
+}
+
+func rke2VersionForHA(plan *resolver.RancherResolvedPlan) (string, string) {
+	if plan != nil {
+		return plan.RecommendedRKE2Version, plan.InstallerSHA256
+	}
+	return viper.GetString("k8s.version"), viper.GetString("rke2.install_script_sha256")
+}
+
+func rke2AirgapArtifactsDir(version string) string {
+	return filepath.Join(airgapArtifactsDir, "rke2-"+version)
+}
+
+func stageRKE2AirgapArtifacts(version, installerSHA256 string) (rke2AirgapArtifacts, error) {
+	if version == "" || installerSHA256 == "" {
+		return rke2AirgapArtifacts{}, fmt.Errorf("airgap mode needs a pinned RKE2 version and installer checksum")
+	}
+	artifacts := newRKE2AirgapArtifacts(version)
+	if err := os.MkdirAll(artifacts.Dir, 0o755); err != nil {
+		return rke2AirgapArtifacts{}, fmt.Errorf("failed to create %s: %w", artifacts.Dir, err)
+	}
+
+	log.Printf("[airgap] Staging RKE2 %s artifacts in %s", version, artifacts.Dir)
+	checksumURL := fmt.Sprintf("%s/%s/%s", rke2ReleaseDownloadBaseURL, version, rke2ChecksumFileName)
+	if err := downloadFile(checksumURL, artifacts.ChecksumFile); err != nil {
+		return rke2AirgapArtifacts{}, err
+	}
+	checksums, err := readRKE2ChecksumFile(artifacts.ChecksumFile)
+	if err != nil {
+		return rke2AirgapArtifacts{}, err
+	}
+
+	for _, tarball := range artifacts.Tarballs {
+		name := filepath.Base(tarball)
+		expected, ok := checksums[name]
+		if !ok {
+			return rke2AirgapArtifacts{}, fmt.Errorf("%s does not list %s", rke2ChecksumFileName, name)
+		}
+		tarballURL := fmt.Sprintf("%s/%s/%s", rke2ReleaseDownloadBaseURL, version, name)
+		if err := downloadVerifiedFile(tarballURL, tarball, expected); err != nil {
+			return rke2AirgapArtifacts{}, err
+		}
+	}
+
+	installScriptURL := fmt.Sprintf("%s/%s/install.sh", rke2InstallScriptBaseURL, version)
+	if err := downloadVerifiedFile(installScriptURL, artifacts.InstallScript, installerSHA256); err != nil {
+		return rke2AirgapArtifacts{}, err
+	}
+	return artifacts, nil
+}
+
+func newRKE2AirgapArtifacts(version string) rke2AirgapArtifacts {
+	dir := rke2AirgapArtifactsDir(version)
+	artifacts := rke2AirgapArtifacts{
+		Version:       version,
+		Dir:           dir,
+		InstallScript: filepath.Join(dir, rke2InstallScriptFileName),
+		ChecksumFile:  filepath.Join(dir, rke2ChecksumFileName),
+	}
+	for _, name := range rke2AirgapTarballs {
+		artifacts.Tarballs = append(artifacts.Tarballs, filepath.Join(dir, name))
+	}
+	return artifacts
+}
+
+// stagedRKE2AirgapArtifacts returns artifacts staged by preflight without
+// touching the network, so node setup fails fast if staging was skipped.
+func stagedRKE2AirgapArtifacts(version string) (rke2AirgapArtifacts, error) {
+	artifacts := newRKE2AirgapArtifacts(version)
+	for _, path := range append([]string{artifacts.InstallScript, artifacts.ChecksumFile}, artifacts.Tarballs...) {
+		if _, err := os.Stat(path); err != nil {
+			return rke2AirgapArtifacts{}, fmt.Errorf("airgap artifact %s is missing; it should have been staged during preflight: %w", path, err)
+		}
+	}
+	return artifacts, nil
+}
+
+func readRKE2ChecksumFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		checksums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return checksums, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func downloadFile(url, dest string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %d downloading %s", resp.StatusCode, url)
+	}
+
+	tmp := dest + ".partial"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, dest)
+}
+
+func downloadVerifiedFile(url, dest, expectedSHA256 string) error {
+	if actual, err := fileSHA256(dest); err == nil && strings.EqualFold(actual, expectedSHA256) {
+		log.Printf("[airgap] %s already staged, checksum verified", filepath.Base(dest))
+		return nil
+	}
+
+	log.Printf("[airgap] Downloading %s", url)
+	if err := downloadFile(url, dest); err != nil {
+		return err
+	}
+	actual, err := fileSHA256(dest)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", dest, err)
+	}
+	if !strings.EqualFold(actual, expectedSHA256) {
+		os.Remove(dest)
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", url, expectedSHA256, actual)
+	}
+	return nil
+}
+
+func stageAirgapRegistryImage() error {
+	archive := filepath.Join(airgapArtifactsDir, airgapRegistryArchive)
+	if _, err := os.Stat(archive); err == nil {
+		return nil
+	}
+
+	log.Printf("[airgap] Saving %s for the first-server registry", airgapRegistryImage)
+	tmp := archive + ".partial"
+	os.Remove(tmp)
+	output, err := exec.Command("skopeo", "copy", "--override-os", "linux", "--override-arch", "amd64",
+		"docker://"+airgapRegistryImage, "docker-archive:"+tmp+":"+airgapRegistryImage).CombinedOutput()
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save %s: %w: %s", airgapRegistryImage, err, strings.TrimSpace(string(output)))
+	}
+	return os.Rename(tmp, archive)
+}
+
+// rancherVersionForAirgap finds the Rancher release whose rancher-images.txt
+// lists the images to seed.
+func rancherVersionForAirgap(plan *resolver.RancherResolvedPlan, helmCommand string) string {
+	if plan != nil && strings.HasPrefix(plan.RancherImageTag, "v") {
+		return plan.RancherImageTag
+	}
+	if plan != nil && plan.ChartVersion != "" {
+		return "v" + strings.TrimPrefix(plan.ChartVersion, "v")
+	}
+	fields := strings.Fields(helmCommand)
+	for i, field := range fields {
+		if field == "--version" && i+1 < len(fields) {
+			return "v" + strings.TrimPrefix(cleanHelmCommandField(fields[i+1]), "v")
+		}
+		if value, ok := strings.CutPrefix(field, "--version="); ok {
+			return "v" + strings.TrimPrefix(cleanHelmCommandField(value), "v")
+		}
+	}
+	return ""
+}
+
+// airgapImagesForHA returns the images to seed for one HA. With download set,
+// a missing rancher-images.txt is fetched into the artifact cache.
+func airgapImagesForHA(instanceNum int, plan *resolver.RancherResolvedPlan, download bool) ([]airgapImage, error) {
+	var images []string
+	if imagesFile := strings.TrimSpace(viper.GetString("airgap_images_file")); imagesFile != "" {
+		fileImages, err := readAirgapImagesFile(imagesFile)
+		if err != nil {
+			return nil, err
+		}
+		images = fileImages
+	} else {
+		helmCommand, err := helmCommandForHA(instanceNum, plan)
+		if err != nil {
+			return nil, err
+		}
+		version := rancherVersionForAirgap(plan, helmCommand)
+		if version == "" {
+			return nil, fmt.Errorf("could not determine the Rancher release for rancher-images.txt; set airgap_images_file")
+		}
+
+		listPath := filepath.Join(airgapArtifactsDir, "rancher-"+version, "rancher-images.txt")
+		if _, err := os.Stat(listPath); err != nil && download {
+			if err := os.MkdirAll(filepath.Dir(listPath), 0o755); err != nil {
+				return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(listPath), err)
+			}
+			listURL := fmt.Sprintf("%s/%s/rancher-images.txt", rancherReleaseDownloadBaseURL, version)
+			if err := downloadFile(listURL, listPath); err != nil {
+				return nil, fmt.Errorf("%w; set airgap_images_file to use your own image list", err)
+			}
+		}
+		fileImages, err := readAirgapImagesFile(listPath)
+		if err != nil {
+			return nil, err
+		}
+		images = fileImages
+	}
+
+	images = append(images, certManagerAirgapImages(settings.ConfiguredTLSModeOrDefault())...)
+	// TestHABackupRestore deploys MinIO from the airgap registry.
+	images = append(images, rancherBackupMinIOImageRef(""))
+
+	var resolved []string
+	if plan != nil && plan.RancherImage != "" && plan.RancherImageTag != "" {
+		resolved = append(resolved, plan.RancherImage+":"+plan.RancherImageTag)
+	}
+	if plan != nil && plan.AgentImage != "" {
+		resolved = append(resolved, plan.AgentImage)
+	}
+	return buildAirgapImages(images, resolved...)
+}
+
+func readAirgapImagesFile(path string) ([]string, error) {
+	path, err := settings.ExpandHomePath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read airgap image list: %w", err)
+	}
+	images := parseAirgapImageList(string(data))
+	if len(images) == 0 {
+		return nil, fmt.Errorf("airgap image list %s is empty", path)
+	}
+	return images, nil
+}
+
+func parseAirgapImageList(content string) []string {
+	var images []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		images = append(images, line)
+	}
+	return images
+}
+
+// buildAirgapImages maps images to their seeded destinations. The resolved
+// Rancher and agent images replace list entries with the same destination, so
+// a staging rancher/rancher wins over the Docker Hub one in rancher-images.txt.
+// Any other two sources sharing a destination, such as docker.io/foo/bar and
+// quay.io/foo/bar, are an error because one would overwrite the other.
+func buildAirgapImages(images []string, resolved ...string) ([]airgapImage, error) {
+	var result []airgapImage
+	sources := map[string]string{}
+	resolvedDestinations := map[string]bool{}
+	for i, image := range append(slices.Clone(resolved), images...) {
+		source, destination := image, image
+		if registry, repository, ok := resolver.SplitRegistryRepository(image); ok && isRegistryHost(registry) {
+			destination = repository
+		} else {
+			source = "docker.io/" + image
+		}
+		if existing, ok := sources[destination]; ok {
+			if existing == source || (i >= len(resolved) && resolvedDestinations[destination]) {
+				continue
+			}
+			return nil, fmt.Errorf("airgap images %s and %s would both be seeded as %s; remove one from the image list", existing, source, destination)
+		}
+		sources[destination] = source
+		resolvedDestinations[destination] = i < len(resolved)
+		result = append(result, airgapImage{Source: source, Destination: destination})
+	}
+	slices.SortFunc(result, func(a, b airgapImage) int { return strings.Compare(a.Destination, b.Destination) })
+	return result, nil
+}
+
+func isRegistryHost(value string) bool {
+	return strings.ContainsAny(value, ".:") || value == "localhost"
+}
+
+func airgapImageListDigest(images []airgapImage) string {
+	hasher := sha256.New()
+	for _, image := range images {
+		fmt.Fprintf(hasher, "%s=%s\n", image.Destination, image.Source)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func airgapRegistryHost(haOutputs TerraformOutputs) string {
+	if len(haOutputs.ServerPrivateIPs) == 0 {
+		return ""
+	}
+	return net.JoinHostPort(haOutputs.ServerPrivateIPs[0], strconv.Itoa(airgapRegistryPort))
+}
+
+func airgapRegistryManifest() string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: Pod
+metadata:
+  name: airgap-registry
+  namespace: kube-system
+spec:
+  hostNetwork: true
+  containers:
+    - name: registry
+      image: %s
+      imagePullPolicy: IfNotPresent
+      env:
+        - name: REGISTRY_HTTP_ADDR
+          value: "0.0.0.0:%d"
+      volumeMounts:
+        - name: data
+          mountPath: /var/lib/registry
+  volumes:
+    - name: data
+      hostPath:
+        path: %s
+        type: DirectoryOrCreate
+`, airgapRegistryImage, airgapRegistryPort, airgapRegistryDataDir)
+}
+
+func pushRKE2AirgapArtifacts(ip string, artifacts rke2AirgapArtifacts) error {
+	executor, err := configuredSSHNodeExecutor()
+	if err != nil {
+		return err
+	}
+	for _, tarball := range artifacts.Tarballs {
+		remoteDir := airgapNodeArtifactsDir
+		if strings.HasPrefix(filepath.Base(tarball), "rke2-images") {
+			remoteDir = rke2AgentImagesDir
+		}
+		if err := executor.CopyFile(tarball, remoteDir+"/"+filepath.Base(tarball), "0644", ip); err != nil {
+			return err
+		}
+	}
+	if err := executor.CopyFile(artifacts.ChecksumFile, airgapNodeArtifactsDir+"/"+rke2ChecksumFileName, "0644", ip); err != nil {
+		return err
+	}
+	return executor.CopyFile(artifacts.InstallScript, airgapNodeArtifactsDir+"/"+rke2InstallScriptFileName, "0755", ip)
+}
+
+// installAirgapRegistry drops the registry image where RKE2 imports it at
+// startup and runs it as a static pod, so it comes up with the first server.
+func installAirgapRegistry(ip string) error {
+	executor, err := configuredSSHNodeExecutor()
+	if err != nil {
+		return err
+	}
+	archive := filepath.Join(airgapArtifactsDir, airgapRegistryArchive)
+	if err := executor.CopyFile(archive, rke2AgentImagesDir+"/"+airgapRegistryArchive, "0644", ip); err != nil {
+		return err
+	}
+	if _, err := RunCommand(writeRemoteFileCommand(rke2PodManifestsDir+"/airgap-registry.yaml", airgapRegistryManifest(), "0644"), ip); err != nil {
+		return fmt.Errorf("failed to write airgap registry manifest: %w", err)
+	}
+	return nil
+}
+
+func buildRKE2AirgapInstallCommand(nodeType, rke2Version, expectedInstallerSHA256 string) (string, error) {
+	if nodeType != "server" && nodeType != "agent" {
+		return "", fmt.Errorf("unsupported RKE2 node type %q", nodeType)
+	}
+	if rke2Version == "" || expectedInstallerSHA256 == "" {
+		return "", fmt.Errorf("airgap install needs a pinned RKE2 version and installer checksum")
+	}
+	installScript := airgapNodeArtifactsDir + "/" + rke2InstallScriptFileName
+
+	return fmt.Sprintf(`# Refuse to execute the staged script unless it still matches the pinned checksum.
+if ! echo %s"  "%s | sha256sum -c -; then
+  echo "############################################################" >&2
+  echo "# SECURITY ERROR: RKE2 installer checksum validation failed #" >&2
+  echo "# Refusing to run the staged installer.                    #" >&2
+  echo "############################################################" >&2
+  exit 1
+fi
+
+sudo INSTALL_RKE2_ARTIFACT_PATH=%s INSTALL_RKE2_VERSION=%s INSTALL_RKE2_TYPE=%s sh %s`,
+		shellSingleQuote(expectedInstallerSHA256),
+		shellSingleQuote(installScript),
+		shellSingleQuote(airgapNodeArtifactsDir),
+		shellSingleQuote(rke2Version),
+		shellSingleQuote(nodeType),
+		shellSingleQuote(installScript),
+	), nil
+}
+
+func airgapRegistrySeeded(ip string, images []airgapImage) (bool, error) {
+	marker, err := RunCommand(fmt.Sprintf("sudo cat %s 2>/dev/null || true", airgapRegistrySeedMarker), ip)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(marker) == airgapImageListDigest(images), nil
+}
+
+func seedAirgapRegistry(ip string, images []airgapImage) error {
+	executor, err := configuredSSHNodeExecutor()
+	if err != nil {
+		return err
+	}
+	localAddr, stop, err := executor.Forward(ip, net.JoinHostPort("127.0.0.1", strconv.Itoa(airgapRegistryPort)))
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	if err := waitForAirgapRegistry(localAddr); err != nil {
+		return err
+	}
+
+	log.Printf("[airgap] Seeding %d images into the registry on %s", len(images), ip)
+	jobs := make(chan airgapImage)
+	var mu sync.Mutex
+	var failures []string
+	var wg sync.WaitGroup
+	for range airgapSeedWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for image := range jobs {
+				output, err := exec.Command("skopeo", airgapSkopeoCopyArgs(image, localAddr)...).CombinedOutput()
+				if err != nil {
+					mu.Lock()
+					failures = append(failures, fmt.Sprintf("%s: %v: %s", image.Source, err, strings.TrimSpace(string(output))))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, image := range images {
+		jobs <- image
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(failures) > 0 {
+		slices.Sort(failures)
+		return fmt.Errorf("failed to seed %d of %d images into the airgap registry; first failure: %s", len(failures), len(images), failures[0])
+	}
+
+	if _, err := RunCommand(writeRemoteFileCommand(airgapRegistrySeedMarker, airgapImageListDigest(images)+"\n", "0644"), ip); err != nil {
+		return fmt.Errorf("failed to record airgap registry seed: %w", err)
+	}
+	log.Printf("[airgap] Registry on %s seeded", ip)
+	return nil
+}
+
+func airgapSkopeoCopyArgs(image airgapImage, registryAddr string) []string {
+	return []string{
+		"copy",
+		"--retry-times", "3",
+		"--override-os", "linux",
+		"--override-arch", "amd64",
+		"--dest-tls-verify=false",
+		"docker://" + image.Source,
+		"docker://" + registryAddr + "/" + image.Destination,
+	}
+}
+
+func waitForAirgapRegistry(localAddr string) error {
+	deadline := time.Now().Add(airgapRegistryReadyWait)
+	for {
+		resp, err := http.Get("http://" + localAddr + "/v2/")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("airgap registry did not become ready within %s", airgapRegistryReadyWait)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// airgapHelmCommand points Rancher at the first server's registry. The values
+// are appended so they win over any earlier --set of the same key.
+func airgapHelmCommand(helmCommand, registryHost string) string {
+	return strings.TrimSpace(helmCommand) + fmt.Sprintf(" \\\n  --set systemDefaultRegistry=%s \\\n  --set useBundledSystemChart=true", registryHost)
+}
+
+// addAirgapRegistryMirrors sends pulls for the registry host and every other
+// registry to the first server first; rewritten image paths match the seeded
+// destinations.
+func addAirgapRegistryMirrors(settings *registriesSettings, registryHost string) {
+	if settings.Mirrors == nil {
+		settings.Mirrors = map[string]registryMirrorSettings{}
+	}
+	endpoint := "http://" + registryHost
+	for _, host := range []string{registryHost, "*"} {
+		mirror := settings.Mirrors[host]
+		mirror.Endpoints = append([]string{endpoint}, mirror.Endpoints...)
+		settings.Mirrors[host] = mirror
+	}
+}
@@ -0,0 +1,208 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+	"github.com/spf13/viper"
+)
+
+func sha256Hex(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func startTestRKE2ReleaseServer(t *testing.T, files map[string]string) *atomic.Int32 {
+	t.Helper()
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		downloads.Add(1)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	previousRelease, previousInstall := rke2ReleaseDownloadBaseURL, rke2InstallScriptBaseURL
+	rke2ReleaseDownloadBaseURL = server.URL + "/releases"
+	rke2InstallScriptBaseURL = server.URL + "/raw"
+	t.Cleanup(func() {
+		rke2ReleaseDownloadBaseURL, rke2InstallScriptBaseURL = previousRelease, previousInstall
+	})
+	return &downloads
+}
+
+func testRKE2ReleaseFiles(version string) map[string]string {
+	binary, images, installer := "rke2-binary", "rke2-images", "#!/bin/sh\necho install\n"
+	return map[string]string{
+		"/releases/" + version + "/sha256sum-amd64.txt": fmt.Sprintf("%s  rke2.linux-amd64.tar.gz\n%s  rke2-images.linux-amd64.tar.zst\n%s  rke2.linux-arm64.tar.gz\n",
+			sha256Hex(binary), sha256Hex(images), sha256Hex("other")),
+		"/releases/" + version + "/rke2.linux-amd64.tar.gz":         binary,
+		"/releases/" + version + "/rke2-images.linux-amd64.tar.zst": images,
+		"/raw/" + version + "/install.sh":                           installer,
+	}
+}
+
+func TestStageRKE2AirgapArtifactsVerifiesAndCaches(t *testing.T) {
+	t.Chdir(t.TempDir())
+	version := "v1.34.6+rke2r1"
+	files := testRKE2ReleaseFiles(version)
+	downloads := startTestRKE2ReleaseServer(t, files)
+	installerSHA := sha256Hex(files["/raw/"+version+"/install.sh"])
+
+	artifacts, err := stageRKE2AirgapArtifacts(version, installerSHA)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(artifacts.Tarballs[1])
+	if err != nil || string(data) != "rke2-images" {
+		t.Fatalf("expected staged images tarball, got %q (%v)", data, err)
+	}
+	if downloads.Load() != 4 {
+		t.Fatalf("expected 4 downloads, got %d", downloads.Load())
+	}
+
+	if _, err := stageRKE2AirgapArtifacts(version, installerSHA); err != nil {
+		t.Fatalf("unexpected error restaging: %v", err)
+	}
+	if downloads.Load() != 5 {
+		t.Fatalf("expected only the checksum file to be fetched again, got %d downloads", downloads.Load())
+	}
+	if _, err := stagedRKE2AirgapArtifacts(version); err != nil {
+		t.Fatalf("expected staged artifacts to be found: %v", err)
+	}
+}
+
+func TestStageRKE2AirgapArtifactsRejectsChecksumMismatch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	version := "v1.34.6+rke2r1"
+	files := testRKE2ReleaseFiles(version)
+	files["/releases/"+version+"/rke2-images.linux-amd64.tar.zst"] = "tampered"
+	startTestRKE2ReleaseServer(t, files)
+
+	_, err := stageRKE2AirgapArtifacts(version, sha256Hex(files["/raw/"+version+"/install.sh"]))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	if _, statErr := os.Stat(newRKE2AirgapArtifacts(version).Tarballs[1]); !os.IsNotExist(statErr) {
+		t.Fatalf("expected tampered tarball to be removed, got %v", statErr)
+	}
+}
+
+func TestBuildAirgapImagesStripsRegistryHosts(t *testing.T) {
+	images, err := buildAirgapImages(parseAirgapImageList(`
+# comment
+rancher/fleet:v0.13.0
+rancher/rancher:v2.14.1-alpha3
+rancher/fleet:v0.13.0
+localhost:5000/rancher/shell:v0.5.0
+`), "stgregistry.suse.com/rancher/rancher:v2.14.1-alpha3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []airgapImage{
+		{Source: "docker.io/rancher/fleet:v0.13.0", Destination: "rancher/fleet:v0.13.0"},
+		{Source: "stgregistry.suse.com/rancher/rancher:v2.14.1-alpha3", Destination: "rancher/rancher:v2.14.1-alpha3"},
+		{Source: "localhost:5000/rancher/shell:v0.5.0", Destination: "rancher/shell:v0.5.0"},
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, images)
+	}
+	args := airgapSkopeoCopyArgs(images[0], "127.0.0.1:40000")
+	if args[len(args)-1] != "docker://127.0.0.1:40000/rancher/fleet:v0.13.0" {
+		t.Fatalf("unexpected skopeo destination %v", args)
+	}
+}
+
+func TestBuildAirgapImagesRejectsDestinationCollisions(t *testing.T) {
+	_, err := buildAirgapImages([]string{"foo/bar:v1", "quay.io/foo/bar:v1"})
+	if err == nil || !strings.Contains(err.Error(), "docker.io/foo/bar:v1") || !strings.Contains(err.Error(), "quay.io/foo/bar:v1") {
+		t.Fatalf("expected a collision between docker.io and quay.io, got %v", err)
+	}
+	if _, err := buildAirgapImages(nil, "stgregistry.suse.com/rancher/rancher:v2.14.1", "rancher/rancher:v2.14.1"); err == nil {
+		t.Fatal("expected two resolved images with one destination to collide")
+	}
+}
+
+func TestRancherVersionForAirgap(t *testing.T) {
+	cases := []struct {
+		plan        *resolver.RancherResolvedPlan
+		helmCommand string
+		expected    string
+	}{
+		{plan: &resolver.RancherResolvedPlan{RancherImageTag: "v2.14.1-alpha3", ChartVersion: "2.14.0"}, expected: "v2.14.1-alpha3"},
+		{plan: &resolver.RancherResolvedPlan{RancherImageTag: "head", ChartVersion: "2.13.4"}, expected: "v2.13.4"},
+		{helmCommand: "helm install rancher rancher-latest/rancher \\\n  --version 2.12.3 \\\n  --set tls=external", expected: "v2.12.3"},
+		{helmCommand: "helm install rancher rancher-latest/rancher", expected: ""},
+	}
+	for _, tc := range cases {
+		if got := rancherVersionForAirgap(tc.plan, tc.helmCommand); got != tc.expected {
+			t.Fatalf("expected %q for %+v / %q, got %q", tc.expected, tc.plan, tc.helmCommand, got)
+		}
+	}
+}
+
+func TestBuildRKE2AirgapInstallCommandUsesStagedArtifacts(t *testing.T) {
+	cmd, err := buildRKE2AirgapInstallCommand("agent", "v1.34.6+rke2r1", "abc123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, snippet := range []string{
+		"echo 'abc123'\"  \"'/var/lib/rancher/rke2-artifacts/install.sh' | sha256sum -c -",
+		"SECURITY ERROR: RKE2 installer checksum validation failed",
+		"INSTALL_RKE2_ARTIFACT_PATH='/var/lib/rancher/rke2-artifacts'",
+		"INSTALL_RKE2_TYPE='agent'",
+	} {
+		if !strings.Contains(cmd, snippet) {
+			t.Fatalf("expected %q in command:\n%s", snippet, cmd)
+		}
+	}
+	if strings.Contains(cmd, "curl") {
+		t.Fatalf("expected airgap install without downloads:\n%s", cmd)
+	}
+}
+
+func TestAirgapRegistryMirrorsAndHelmCommand(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	clearDockerHubEnvironment(t)
+	viper.Set("airgap", true)
+
+	files, err := renderConfiguredRKE2RegistryFiles(testRKE2HAOutputs())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, snippet := range []string{`'*':`, "10.0.0.1:5000:", "- http://10.0.0.1:5000"} {
+		if !strings.Contains(files.RegistriesYAML, snippet) {
+			t.Fatalf("expected %q in registries.yaml:\n%s", snippet, files.RegistriesYAML)
+		}
+	}
+
+	command := airgapHelmCommand("helm install rancher rancher-latest/rancher \\\n  --set systemDefaultRegistry= \\\n  --set tls=external", "10.0.0.1:5000")
+	if !strings.HasSuffix(command, "--set systemDefaultRegistry=10.0.0.1:5000 \\\n  --set useBundledSystemChart=true") {
+		t.Fatalf("expected airgap settings last, got:\n%s", command)
+	}
+}
+
+func TestValidateAirgapPreflightRequiresSSH(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("airgap", true)
+
+	if err := validateAirgapPreflight(); err == nil || !strings.Contains(err.Error(), "node_transport: ssh") {
+		t.Fatalf("expected ssh transport error, got %v", err)
+	}
+}
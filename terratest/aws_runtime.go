@@ -207,9 +207,13 @@ func runCommandSSM(cmd string, instanceID string) (string, error) {
 	return "", fmt.Errorf("command timed out after %d attempts", maxAttempts)
 }
 
-func RunCommand(cmd string, pubIP string) (string, error) {
-	log.Printf("[RunCommand] Starting command execution for IP %s", pubIP)
+type ssmNodeExecutor struct{}
+
+func (ssmNodeExecutor) Name() string {
+	return nodeTransportSSM
+}
 
+func (ssmNodeExecutor) Run(cmd string, pubIP string) (string, error) {
 	instanceID, err := getInstanceIDFromIP(pubIP)
 	if err != nil {
 		return "", fmt.Errorf("failed to get instance ID from IP %s: %w", pubIP, err)
@@ -219,7 +223,18 @@ func RunCommand(cmd string, pubIP string) (string, error) {
 		return "", fmt.Errorf("SSM agent not ready for instance %s: %w", instanceID, err)
 	}
 
-	result, err := runCommandSSM(cmd, instanceID)
+	return runCommandSSM(cmd, instanceID)
+}
+
+func RunCommand(cmd string, pubIP string) (string, error) {
+	executor, err := configuredNodeExecutor()
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("[RunCommand] Starting command execution for IP %s via %s", pubIP, executor.Name())
+
+	result, err := executor.Run(cmd, pubIP)
 	if err != nil {
 		log.Printf("[RunCommand] Command failed: %v", err)
 		return "", err
@@ -239,7 +254,7 @@ func estimateCurrentRunCost(totalHAs int, outputs map[string]string) (*cleanupCo
 
 	for i := 1; i <= totalHAs; i++ {
 		haOutputs := getHAOutputs(i, outputs)
-		for _, ip := range []string{haOutputs.Server1IP, haOutputs.Server2IP, haOutputs.Server3IP} {
+		for _, ip := range append(append([]string{}, haOutputs.ServerIPs...), haOutputs.AgentIPs...) {
 			if ip == "" || seenIPs[ip] {
 				continue
 			}
@@ -1,7 +1,6 @@
 package test
 
 import (
-	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
@@ -12,23 +11,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
 	"github.com/spf13/viper"
 )
 
-func setupHAInstance(t *testing.T, instanceNum int, outputs map[string]string, resolvedPlan *RancherResolvedPlan) error {
-	haDir := fmt.Sprintf("high-availability-%d", instanceNum)
+func setupHAInstance(t *testing.T, instanceNum int, outputs map[string]string, resolvedPlan *resolver.RancherResolvedPlan) error {
+	haDir := haInstanceDir(instanceNum)
 	haOutputs := getHAOutputs(instanceNum, outputs)
 
-	ips := []string{
-		haOutputs.Server1IP, haOutputs.Server2IP, haOutputs.Server3IP,
-		haOutputs.Server1PrivateIP, haOutputs.Server2PrivateIP, haOutputs.Server3PrivateIP,
-	}
-	for _, ip := range ips {
-		if CheckIPAddress(ip) != "valid" {
-			return fmt.Errorf("invalid IP address: %s", ip)
-		}
-	}
-
 	currentDir, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
@@ -42,39 +33,258 @@ func setupHAInstance(t *testing.T, instanceNum int, outputs map[string]string, r
 		log.Printf("Created directory %s", absHADir)
 	}
 
-	helmCommands := viper.GetStringSlice("rancher.helm_commands")
-	helmCommand := helmCommands[instanceNum-1]
-	helmCommand = rancherHelmCommandForHA(helmCommand, haOutputs.RancherURL)
-
-	CreateInstallScript(helmCommand, haDir)
+	checkpoint, err := readHASetupCheckpoint(instanceNum)
+	if err != nil {
+		return err
+	}
+	if checkpoint.Server1IP != "" && checkpoint.Server1IP != haOutputs.FirstServerIP() {
+		log.Printf("[checkpoint] HA %d infrastructure changed (%s -> %s); discarding previous checkpoint", instanceNum, checkpoint.Server1IP, haOutputs.FirstServerIP())
+		checkpoint = newHASetupCheckpoint(instanceNum)
+	}
+	checkpoint.Server1IP = haOutputs.FirstServerIP()
+	if resolvedPlan == nil {
+		resolvedPlan = checkpoint.ResolvedPlan
+	}
+	checkpoint.ResolvedPlan = resolvedPlan
 
-	log.Printf("Setting up first server node with IP %s", haOutputs.Server1IP)
-	err = setupFirstServerNode(haOutputs.Server1IP, haOutputs, resolvedPlan)
+	rke2Config, err := configuredRKE2ConfigOverrides(instanceNum)
 	if err != nil {
-		return fmt.Errorf("failed to setup first server node: %w", err)
+		return err
 	}
 
-	token, err := getNodeToken(haOutputs.Server1IP)
+	absKubeConfigPath := filepath.Join(absHADir, "kube_config.yaml")
+	var token string
+
+	phases := []haSetupPhase{
+		{
+			name: haPhaseTerraformApply,
+			verify: func() (bool, error) {
+				return validateHANodeIPs(haOutputs) == nil, nil
+			},
+			run: func() error {
+				if err := validateHANodeIPs(haOutputs); err != nil {
+					return fmt.Errorf("terraform outputs for HA %d are incomplete; run TestHaSetup to apply infrastructure: %w", instanceNum, err)
+				}
+				return nil
+			},
+		},
+		{
+			name: haPhaseFirstServer,
+			verify: func() (bool, error) {
+				return rke2FirstServerReady(haOutputs.FirstServerIP())
+			},
+			run: func() error {
+				log.Printf("Setting up first server node with IP %s", haOutputs.FirstServerIP())
+				if err := setupFirstServerNode(haOutputs.FirstServerIP(), haOutputs, resolvedPlan, rke2Config); err != nil {
+					return fmt.Errorf("failed to setup first server node: %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			name: haPhaseAirgapRegistry,
+			verify: func() (bool, error) {
+				if !airgapEnabled() {
+					return true, nil
+				}
+				images, err := airgapImagesForHA(instanceNum, resolvedPlan, false)
+				if err != nil {
+					return false, err
+				}
+				return airgapRegistrySeeded(haOutputs.FirstServerIP(), images)
+			},
+			run: func() error {
+				if !airgapEnabled() {
+					return nil
+				}
+				images, err := airgapImagesForHA(instanceNum, resolvedPlan, false)
+				if err != nil {
+					return err
+				}
+				return seedAirgapRegistry(haOutputs.FirstServerIP(), images)
+			},
+		},
+		{
+			name: haPhaseNodeToken,
+			verify: func() (bool, error) {
+				nodeToken, err := getNodeToken(haOutputs.FirstServerIP())
+				if err != nil {
+					return false, err
+				}
+				token = nodeToken
+				return strings.TrimSpace(token) != "", nil
+			},
+			run: func() error {
+				nodeToken, err := getNodeToken(haOutputs.FirstServerIP())
+				if err != nil {
+					return fmt.Errorf("failed to get node token: %w", err)
+				}
+				if strings.TrimSpace(nodeToken) == "" {
+					return fmt.Errorf("node token on %s is empty", haOutputs.FirstServerIP())
+				}
+				token = nodeToken
+				return nil
+			},
+		},
+		{
+			name: haPhaseAdditionalServers,
+			verify: func() (bool, error) {
+				return rke2ServicesActive(haOutputs.ServerIPs[1:], "server")
+			},
+			run: func() error {
+				return setupJoiningNodes("server", haOutputs.ServerIPs[1:], token, haOutputs, resolvedPlan, rke2Config)
+			},
+		},
+		{
+			name: haPhaseAgents,
+			verify: func() (bool, error) {
+				return rke2ServicesActive(haOutputs.AgentIPs, "agent")
+			},
+			run: func() error {
+				return setupJoiningNodes("agent", haOutputs.AgentIPs, token, haOutputs, resolvedPlan, rke2Config)
+			},
+		},
+		{
+			name: haPhaseKubeconfig,
+			verify: func() (bool, error) {
+				return savedKubeconfigTargetsServer(absKubeConfigPath, haOutputs.FirstServerIP())
+			},
+			run: func() error {
+				log.Printf("Waiting for cluster to fully initialize...")
+				time.Sleep(30 * time.Second)
+
+				// As before checkpoints, a missing kubeconfig is not fatal here; the
+				// phase is re-run on resume because verify finds no kubeconfig.
+				if err := getAndSaveKubeconfig(haOutputs.FirstServerIP(), haDir); err != nil {
+					log.Printf("Warning: Failed to save kubeconfig: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			name: haPhaseInstallScript,
+			verify: func() (bool, error) {
+				return rancherHelmReleaseDeployed(absKubeConfigPath)
+			},
+			run: func() error {
+				helmCommand, err := helmCommandForHA(instanceNum, resolvedPlan)
+				if err != nil {
+					return err
+				}
+				helmCommand = rancherHelmCommandForHA(helmCommand, haOutputs.RancherURL)
+				if airgapEnabled() {
+					helmCommand = airgapHelmCommand(helmCommand, airgapRegistryHost(haOutputs))
+				}
+				tlsSetup, err := rancherTLSSetupCommands(settings.ConfiguredTLSModeOrDefault(), haDir, haOutputs.RancherURL)
+				if err != nil {
+					return fmt.Errorf("failed to prepare Rancher TLS setup: %w", err)
+				}
+				CreateInstallScript(helmCommand, haDir, tlsSetup)
+				if err := removeIncompleteRancherRelease(absKubeConfigPath); err != nil {
+					return err
+				}
+
+				absInstallScriptPath := filepath.Join(absHADir, "install.sh")
+				log.Printf("Executing install script at %s", absInstallScriptPath)
+
+				cmd := exec.Command(absInstallScriptPath)
+				cmd.Dir = absHADir
+				cmd.Env = append(os.Environ(), fmt.Sprintf("KUBECONFIG=%s", absKubeConfigPath))
+				cmd.Stdout = os.Stdout
+				cmd.Stderr = os.Stderr
+
+				if execErr := cmd.Run(); execErr != nil {
+					return fmt.Errorf("failed to execute install script: %w", execErr)
+				}
+
+				log.Printf("Install script executed successfully")
+				return nil
+			},
+		},
+	}
+
+	if phase := checkpoint.firstIncompletePhase(); phase != "" && len(checkpoint.CompletedPhases) > 0 {
+		log.Printf("[checkpoint] HA %d resuming at phase %s", instanceNum, phase)
+	}
+	if err := runHASetupPhases(checkpoint, phases); err != nil {
+		return err
+	}
+
+	log.Printf("HA %d setup complete", instanceNum)
+	log.Printf("HA %d LB: %s", instanceNum, haOutputs.LoadBalancerDNS)
+	log.Printf("HA %d Rancher URL: %s", instanceNum, clickableURL(haOutputs.RancherURL))
+
+	return nil
+}
+
+func validateHANodeIPs(haOutputs TerraformOutputs) error {
+	if len(haOutputs.ServerIPs) == 0 {
+		return fmt.Errorf("no server nodes found in terraform outputs")
+	}
+	if len(haOutputs.ServerIPs) != len(haOutputs.ServerPrivateIPs) || len(haOutputs.AgentIPs) != len(haOutputs.AgentPrivateIPs) {
+		return fmt.Errorf("terraform outputs have mismatched public and private node IPs")
+	}
+
+	serverCount, err := settings.ConfiguredServerCount()
 	if err != nil {
-		return fmt.Errorf("failed to get node token: %w", err)
+		return err
 	}
+	agentCount, err := settings.ConfiguredAgentCount()
+	if err != nil {
+		return err
+	}
+	if len(haOutputs.ServerIPs) != serverCount || len(haOutputs.AgentIPs) != agentCount {
+		return fmt.Errorf("terraform outputs have %d servers and %d agents but config asks for server_count=%d agent_count=%d",
+			len(haOutputs.ServerIPs), len(haOutputs.AgentIPs), serverCount, agentCount)
+	}
+
+	ips := append([]string{}, haOutputs.ServerIPs...)
+	ips = append(ips, haOutputs.ServerPrivateIPs...)
+	ips = append(ips, haOutputs.AgentIPs...)
+	ips = append(ips, haOutputs.AgentPrivateIPs...)
+	for _, ip := range ips {
+		if CheckIPAddress(ip) != "valid" {
+			return fmt.Errorf("invalid IP address: %s", ip)
+		}
+	}
+	return nil
+}
+
+func helmCommandForHA(instanceNum int, resolvedPlan *resolver.RancherResolvedPlan) (string, error) {
+	if resolvedPlan != nil && len(resolvedPlan.HelmCommands) > 0 {
+		return resolvedPlan.HelmCommands[0], nil
+	}
+	helmCommands := viper.GetStringSlice("rancher.helm_commands")
+	if len(helmCommands) < instanceNum {
+		return "", fmt.Errorf("no Helm command configured for HA %d", instanceNum)
+	}
+	return helmCommands[instanceNum-1], nil
+}
 
+func setupJoiningNodes(nodeType string, ips []string, token string, haOutputs TerraformOutputs, resolvedPlan *resolver.RancherResolvedPlan, rke2Config map[string]interface{}) error {
 	var wg sync.WaitGroup
 	var setupErr error
 	var setupErrMutex sync.Mutex
 
-	for i, ip := range []string{haOutputs.Server2IP, haOutputs.Server3IP} {
-		wg.Add(1)
-		nodeNum := i + 2
+	for i, ip := range ips {
+		nodeNum := i + 1
+		if nodeType == "server" {
+			nodeNum = i + 2
+		}
+		if active, err := rke2ServiceActive(ip, nodeType); err == nil && active {
+			log.Printf("%s node %d (%s) already has %s active, skipping", nodeType, nodeNum, ip, rke2ServiceName(nodeType))
+			continue
+		}
 
+		wg.Add(1)
 		go func(ip string, nodeNum int) {
 			defer wg.Done()
 
-			log.Printf("Setting up server node %d with IP %s", nodeNum, ip)
-			err := setupAdditionalServerNode(ip, token, haOutputs, resolvedPlan)
+			log.Printf("Setting up %s node %d with IP %s", nodeType, nodeNum, ip)
+			err := setupJoiningNode(ip, nodeType, token, haOutputs, resolvedPlan, rke2Config)
 			if err != nil {
 				setupErrMutex.Lock()
-				setupErr = fmt.Errorf("failed to setup server node %d: %w", nodeNum, err)
+				setupErr = fmt.Errorf("failed to setup %s node %d: %w", nodeType, nodeNum, err)
 				setupErrMutex.Unlock()
 			}
 		}(ip, nodeNum)
@@ -85,49 +295,6 @@ func setupHAInstance(t *testing.T, instanceNum int, outputs map[string]string, r
 	if setupErr != nil {
 		return fmt.Errorf("node setup error: %w", setupErr)
 	}
-
-	log.Printf("Waiting for cluster to fully initialize...")
-	time.Sleep(30 * time.Second)
-
-	err = getAndSaveKubeconfig(haOutputs.Server1IP, haDir)
-	if err != nil {
-		t.Logf("Warning: Failed to save kubeconfig: %v", err)
-	}
-
-	installScriptPath := fmt.Sprintf("%s/install.sh", haDir)
-	log.Printf("Executing install script at %s", installScriptPath)
-
-	currentDir, dirErr := os.Getwd()
-	if dirErr != nil {
-		return fmt.Errorf("failed to get current directory: %w", dirErr)
-	}
-
-	absHADirForScript := filepath.Join(currentDir, haDir)
-	if _, err := os.Stat(absHADirForScript); os.IsNotExist(err) {
-		if mkdirErr := os.MkdirAll(absHADirForScript, os.ModePerm); mkdirErr != nil {
-			return fmt.Errorf("failed to create directory %s: %w", absHADirForScript, mkdirErr)
-		}
-		log.Printf("Created directory %s", absHADirForScript)
-	}
-
-	absInstallScriptPath := filepath.Join(absHADirForScript, "install.sh")
-	absKubeConfigPath := filepath.Join(absHADirForScript, "kube_config.yaml")
-
-	cmd := exec.Command(absInstallScriptPath)
-	cmd.Dir = absHADirForScript
-	cmd.Env = append(os.Environ(), fmt.Sprintf("KUBECONFIG=%s", absKubeConfigPath))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if execErr := cmd.Run(); execErr != nil {
-		return fmt.Errorf("failed to execute install script: %w", execErr)
-	}
-
-	log.Printf("Install script executed successfully")
-	log.Printf("HA %d setup complete", instanceNum)
-	log.Printf("HA %d LB: %s", instanceNum, haOutputs.LoadBalancerDNS)
-	log.Printf("HA %d Rancher URL: %s", instanceNum, clickableURL(haOutputs.RancherURL))
-
 	return nil
 }
 
@@ -143,7 +310,7 @@ func rancherHelmCommandForHA(helmCommand, rancherURL string) string {
 	return strings.TrimSpace(helmCommand) + fmt.Sprintf(" \\\n  --set hostname=%s", rancherURL)
 }
 
-func setupFirstServerNode(ip string, haOutputs TerraformOutputs, resolvedPlan *RancherResolvedPlan) error {
+func setupFirstServerNode(ip string, haOutputs TerraformOutputs, resolvedPlan *resolver.RancherResolvedPlan, rke2Config map[string]interface{}) error {
 	log.Printf("[setupFirstServerNode] Starting setup for IP %s", ip)
 	rke2K8sVersion := viper.GetString("k8s.version")
 	expectedInstallerSHA256 := viper.GetString("rke2.install_script_sha256")
@@ -161,24 +328,13 @@ func setupFirstServerNode(ip string, haOutputs TerraformOutputs, resolvedPlan *R
 	}
 	log.Printf("[setupFirstServerNode] Config directory created. Output: %s", output)
 
-	configContent := fmt.Sprintf(`tls-san:
-  - %s
-  - %s
-  - %s
-  - %s
-  - %s
-  - %s
-  - %s`,
-		haOutputs.RancherURL,
-		haOutputs.Server1IP,
-		haOutputs.Server1PrivateIP,
-		haOutputs.Server2IP,
-		haOutputs.Server2PrivateIP,
-		haOutputs.Server3IP,
-		haOutputs.Server3PrivateIP)
+	configContent, err := renderRKE2NodeConfig("server", haOutputs, "", rke2Config)
+	if err != nil {
+		return err
+	}
 
 	log.Printf("[setupFirstServerNode] Creating config file with content:\n%s", configContent)
-	cmd = fmt.Sprintf("sudo bash -c 'cat > /etc/rancher/rke2/config.yaml << EOL\n%s\nEOL'", configContent)
+	cmd = writeRemoteFileCommand("/etc/rancher/rke2/config.yaml", configContent, "0600")
 	output, err = RunCommand(cmd, ip)
 	if err != nil {
 		log.Printf("[setupFirstServerNode] FAILED to create config file: %v", err)
@@ -197,7 +353,20 @@ func setupFirstServerNode(ip string, haOutputs TerraformOutputs, resolvedPlan *R
 
 	preloadImages := viper.GetBool("rke2.preload_images")
 
-	if preloadImages {
+	if airgapEnabled() {
+		log.Printf("[setupFirstServerNode] Pushing staged airgap artifacts and registry to %s...", ip)
+		artifacts, err := stagedRKE2AirgapArtifacts(rke2K8sVersion)
+		if err != nil {
+			return err
+		}
+		if err := pushRKE2AirgapArtifacts(ip, artifacts); err != nil {
+			return fmt.Errorf("failed to push airgap artifacts: %w", err)
+		}
+		if err := installAirgapRegistry(ip); err != nil {
+			return fmt.Errorf("failed to install airgap registry: %w", err)
+		}
+		log.Printf("[setupFirstServerNode] Airgap artifacts and registry pushed")
+	} else if preloadImages {
 		log.Printf("[setupFirstServerNode] Pre-downloading RKE2 images to avoid Docker Hub rate limiting...")
 
 		cmd = "sudo mkdir -p /var/lib/rancher/rke2/agent/images"
@@ -228,40 +397,29 @@ func setupFirstServerNode(ip string, haOutputs TerraformOutputs, resolvedPlan *R
 		log.Printf("[setupFirstServerNode] Image pre-loading disabled, will pull from registry")
 	}
 
-	dockerUsername := strings.TrimSpace(os.Getenv("DOCKERHUB_USERNAME"))
-	dockerPassword := strings.TrimSpace(os.Getenv("DOCKERHUB_PASSWORD"))
-
-	if dockerUsername != "" && dockerPassword != "" {
-		log.Printf("[setupFirstServerNode] Configuring Docker Hub authentication...")
-
-		authString := fmt.Sprintf("%s:%s", dockerUsername, dockerPassword)
-		encodedAuth := base64.StdEncoding.EncodeToString([]byte(authString))
-
-		registriesConfig := fmt.Sprintf(`configs:
-  "registry-1.docker.io":
-    auth:
-      auth: %s
-  "docker.io":
-    auth:
-      auth: %s`, encodedAuth, encodedAuth)
-
-		cmd = fmt.Sprintf("sudo bash -c 'cat > /etc/rancher/rke2/registries.yaml << EOL\n%s\nEOL'", registriesConfig)
-		output, err = RunCommand(cmd, ip)
-		if err != nil {
-			log.Printf("[setupFirstServerNode] FAILED to create registries.yaml: %v", err)
-			return fmt.Errorf("failed to create registries.yaml: %w", err)
+	registryFiles, err := renderConfiguredRKE2RegistryFiles(haOutputs)
+	if err != nil {
+		return err
+	}
+	if registryFiles.RegistriesYAML != "" {
+		log.Printf("[setupFirstServerNode] Writing registries.yaml (%d CA bundle(s))...", len(registryFiles.CABundles))
+		if err := configureRKE2Registries(ip, registryFiles); err != nil {
+			log.Printf("[setupFirstServerNode] FAILED to configure registries: %v", err)
+			return err
 		}
-		log.Printf("[setupFirstServerNode] Docker Hub authentication configured")
+		log.Printf("[setupFirstServerNode] Registry configuration written")
 	} else {
-		log.Printf("[setupFirstServerNode] No Docker Hub credentials provided, skipping registries.yaml creation")
+		log.Printf("[setupFirstServerNode] No registries or Docker Hub credentials configured, skipping registries.yaml creation")
 	}
 
-	if err := configureRKE2IngressForExternalTLS(ip); err != nil {
-		return fmt.Errorf("failed to configure RKE2 ingress for external TLS: %w", err)
+	if !settings.TLSPassthrough(settings.ConfiguredTLSModeOrDefault()) {
+		if err := configureRKE2IngressForExternalTLS(ip); err != nil {
+			return fmt.Errorf("failed to configure RKE2 ingress for external TLS: %w", err)
+		}
 	}
 
 	log.Printf("[setupFirstServerNode] Installing RKE2 version %s...", rke2K8sVersion)
-	cmd, err = buildRKE2InstallCommand("server", rke2K8sVersion, expectedInstallerSHA256)
+	cmd, err = buildNodeRKE2InstallCommand("server", rke2K8sVersion, expectedInstallerSHA256)
 	if err != nil {
 		return fmt.Errorf("failed to build RKE2 install command: %w", err)
 	}
@@ -419,7 +577,7 @@ spec:
         use-forwarded-headers: "true"`
 }
 
-func setupAdditionalServerNode(ip, token string, haOutputs TerraformOutputs, resolvedPlan *RancherResolvedPlan) error {
+func setupJoiningNode(ip, nodeType, token string, haOutputs TerraformOutputs, resolvedPlan *resolver.RancherResolvedPlan, rke2Config map[string]interface{}) error {
 	rke2K8sVersion := viper.GetString("k8s.version")
 	expectedInstallerSHA256 := viper.GetString("rke2.install_script_sha256")
 	if resolvedPlan != nil {
@@ -433,27 +591,12 @@ func setupAdditionalServerNode(ip, token string, haOutputs TerraformOutputs, res
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
-	configContent := fmt.Sprintf(`server: https://%s:9345
-token: %s
-tls-san:
-  - %s
-  - %s
-  - %s
-  - %s
-  - %s
-  - %s
-  - %s`,
-		haOutputs.Server1IP,
-		token,
-		haOutputs.RancherURL,
-		haOutputs.Server1IP,
-		haOutputs.Server1PrivateIP,
-		haOutputs.Server2IP,
-		haOutputs.Server2PrivateIP,
-		haOutputs.Server3IP,
-		haOutputs.Server3PrivateIP)
-
-	cmd = fmt.Sprintf("sudo bash -c 'cat > /etc/rancher/rke2/config.yaml << EOL\n%s\nEOL'", configContent)
+	configContent, err := renderRKE2NodeConfig(nodeType, haOutputs, token, rke2Config)
+	if err != nil {
+		return err
+	}
+
+	cmd = writeRemoteFileCommand("/etc/rancher/rke2/config.yaml", configContent, "0600")
 	_, err = RunCommand(cmd, ip)
 	if err != nil {
 		return fmt.Errorf("failed to create config file: %w", err)
@@ -461,67 +604,65 @@ tls-san:
 
 	preloadImages := viper.GetBool("rke2.preload_images")
 
-	if preloadImages {
-		log.Printf("[setupAdditionalServerNode] Pre-downloading RKE2 images for %s...", ip)
+	if airgapEnabled() {
+		log.Printf("[setupJoiningNode] Pushing staged airgap artifacts to %s...", ip)
+		artifacts, err := stagedRKE2AirgapArtifacts(rke2K8sVersion)
+		if err != nil {
+			return err
+		}
+		if err := pushRKE2AirgapArtifacts(ip, artifacts); err != nil {
+			return fmt.Errorf("failed to push airgap artifacts: %w", err)
+		}
+	} else if preloadImages {
+		log.Printf("[setupJoiningNode] Pre-downloading RKE2 images for %s...", ip)
 
 		cmd = "sudo mkdir -p /var/lib/rancher/rke2/agent/images"
 		_, err = RunCommand(cmd, ip)
 		if err != nil {
-			log.Printf("[setupAdditionalServerNode] FAILED to create images directory: %v", err)
+			log.Printf("[setupJoiningNode] FAILED to create images directory: %v", err)
 			return fmt.Errorf("failed to create images directory: %w", err)
 		}
 
-		log.Printf("[setupAdditionalServerNode] Downloading and validating RKE2 images for %s...", ip)
+		log.Printf("[setupJoiningNode] Downloading and validating RKE2 images for %s...", ip)
 		cmd = buildRKE2ImagesDownloadCommand(rke2K8sVersion)
 		_, err = RunCommand(cmd, ip)
 		if err != nil {
-			log.Printf("[setupAdditionalServerNode] FAILED to download/validate images: %v", err)
+			log.Printf("[setupJoiningNode] FAILED to download/validate images: %v", err)
 			return fmt.Errorf("failed to download/validate RKE2 images: %w", err)
 		}
 
 		cmd = "sudo mv /tmp/rke2-images.linux-amd64.tar.zst /var/lib/rancher/rke2/agent/images/"
 		_, err = RunCommand(cmd, ip)
 		if err != nil {
-			log.Printf("[setupAdditionalServerNode] FAILED to move images: %v", err)
+			log.Printf("[setupJoiningNode] FAILED to move images: %v", err)
 			return fmt.Errorf("failed to move images: %w", err)
 		}
-		log.Printf("[setupAdditionalServerNode] Images pre-loaded and validated successfully for %s", ip)
+		log.Printf("[setupJoiningNode] Images pre-loaded and validated successfully for %s", ip)
 	}
 
-	dockerUsername := strings.TrimSpace(os.Getenv("DOCKERHUB_USERNAME"))
-	dockerPassword := strings.TrimSpace(os.Getenv("DOCKERHUB_PASSWORD"))
-
-	if dockerUsername != "" && dockerPassword != "" {
-		log.Printf("[setupAdditionalServerNode] Configuring Docker Hub authentication for %s...", ip)
-
-		authString := fmt.Sprintf("%s:%s", dockerUsername, dockerPassword)
-		encodedAuth := base64.StdEncoding.EncodeToString([]byte(authString))
-
-		registriesConfig := fmt.Sprintf(`configs:
-  "registry-1.docker.io":
-    auth:
-      auth: %s
-  "docker.io":
-    auth:
-      auth: %s`, encodedAuth, encodedAuth)
-
-		cmd = fmt.Sprintf("sudo bash -c 'cat > /etc/rancher/rke2/registries.yaml << EOL\n%s\nEOL'", registriesConfig)
-		_, err = RunCommand(cmd, ip)
-		if err != nil {
-			log.Printf("[setupAdditionalServerNode] FAILED to create registries.yaml: %v", err)
-			return fmt.Errorf("failed to create registries.yaml: %w", err)
+	registryFiles, err := renderConfiguredRKE2RegistryFiles(haOutputs)
+	if err != nil {
+		return err
+	}
+	if registryFiles.RegistriesYAML != "" {
+		log.Printf("[setupJoiningNode] Writing registries.yaml for %s...", ip)
+		if err := configureRKE2Registries(ip, registryFiles); err != nil {
+			log.Printf("[setupJoiningNode] FAILED to configure registries: %v", err)
+			return err
 		}
-		log.Printf("[setupAdditionalServerNode] Docker Hub authentication configured for %s", ip)
+		log.Printf("[setupJoiningNode] Registry configuration written for %s", ip)
 	} else {
-		log.Printf("[setupAdditionalServerNode] No Docker Hub credentials provided, skipping registries.yaml creation for %s", ip)
+		log.Printf("[setupJoiningNode] No registries or Docker Hub credentials configured, skipping registries.yaml creation for %s", ip)
 	}
 
-	if err := configureRKE2IngressForExternalTLS(ip); err != nil {
-		return fmt.Errorf("failed to configure RKE2 ingress for external TLS: %w", err)
+	if nodeType == "server" && !settings.TLSPassthrough(settings.ConfiguredTLSModeOrDefault()) {
+		if err := configureRKE2IngressForExternalTLS(ip); err != nil {
+			return fmt.Errorf("failed to configure RKE2 ingress for external TLS: %w", err)
+		}
 	}
 
-	log.Printf("[setupAdditionalServerNode] Installing RKE2 version %s on %s...", rke2K8sVersion, ip)
-	cmd, err = buildRKE2InstallCommand("server", rke2K8sVersion, expectedInstallerSHA256)
+	log.Printf("[setupJoiningNode] Installing RKE2 %s version %s on %s...", nodeType, rke2K8sVersion, ip)
+	cmd, err = buildNodeRKE2InstallCommand(nodeType, rke2K8sVersion, expectedInstallerSHA256)
 	if err != nil {
 		return fmt.Errorf("failed to build RKE2 install command: %w", err)
 	}
@@ -530,24 +671,24 @@ tls-san:
 		return fmt.Errorf("failed to install RKE2: %w", err)
 	}
 
-	cmd = "sudo systemctl enable rke2-server.service"
+	service := rke2ServiceName(nodeType)
+	cmd = fmt.Sprintf("sudo systemctl enable %s.service", service)
 	_, err = RunCommand(cmd, ip)
 	if err != nil {
-		return fmt.Errorf("failed to enable RKE2 server: %w", err)
+		return fmt.Errorf("failed to enable RKE2 %s: %w", nodeType, err)
 	}
 
-	cmd = "sudo systemctl start rke2-server.service"
+	cmd = fmt.Sprintf("sudo systemctl start %s.service", service)
 	_, err = RunCommand(cmd, ip)
 	if err != nil {
-		return fmt.Errorf("failed to start RKE2 server: %w", err)
+		return fmt.Errorf("failed to start RKE2 %s: %w", nodeType, err)
 	}
 
 	log.Printf("Waiting for RKE2 to initialize on %s (this may take several minutes)...", ip)
 	maxRetries := 30
 	for i := 0; i < maxRetries; i++ {
-		cmd = "sudo systemctl is-active --quiet rke2-server && echo 'active' || echo 'inactive'"
-		status, err := RunCommand(cmd, ip)
-		if err == nil && strings.TrimSpace(status) == "active" {
+		active, err := rke2ServiceActive(ip, nodeType)
+		if err == nil && active {
 			log.Printf("RKE2 initialized successfully on %s", ip)
 			return nil
 		}
@@ -558,6 +699,17 @@ tls-san:
 	return fmt.Errorf("timeout waiting for RKE2 to initialize on %s", ip)
 }
 
+func buildNodeRKE2InstallCommand(nodeType, rke2Version, expectedInstallerSHA256 string) (string, error) {
+	if airgapEnabled() {
+		return buildRKE2AirgapInstallCommand(nodeType, rke2Version, expectedInstallerSHA256)
+	}
+	return buildRKE2InstallCommand(nodeType, rke2Version, expectedInstallerSHA256)
+}
+
+func rke2ServiceName(nodeType string) string {
+	return "rke2-" + nodeType
+}
+
 func getAndSaveKubeconfig(serverIP string, haDir string) error {
 	rawKubeconfig, err := RunCommand("sudo cat /etc/rancher/rke2/rke2.yaml", serverIP)
 	if err != nil {
@@ -20,3 +20,17 @@ func TestRKE2IngressNginxConfigManifestEnablesForwardedHeaders(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildRKE2InstallCommandForAgent(t *testing.T) {
+	cmd, err := buildRKE2InstallCommand("agent", "v1.34.6+rke2r3", "abc123")
+	if err != nil {
+		t.Fatalf("expected agent install command, got %v", err)
+	}
+	if !strings.Contains(cmd, "INSTALL_RKE2_TYPE='agent'") {
+		t.Fatalf("expected agent install type, got:\n%s", cmd)
+	}
+
+	if _, err := buildRKE2InstallCommand("worker", "v1.34.6+rke2r3", "abc123"); err == nil {
+		t.Fatal("expected unsupported node type error")
+	}
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/brudnak/ha-rancher-rke2/terratest/hcl"
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
 	"github.com/gruntwork-io/terratest/modules/logger"
 	"github.com/gruntwork-io/terratest/modules/terraform"
@@ -16,14 +18,77 @@ import (
 )
 
 func setupConfig(t *testing.T) {
+	name, err := resolveWorkspaceName()
+	if err != nil {
+		t.Fatalf("Invalid workspace: %v", err)
+	}
+	if err := loadWorkspaceConfig(name); err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if name != "" {
+		log.Printf("[workspace] Using workspace %s with %s", name, viper.ConfigFileUsed())
+	}
+}
+
+// workspaceConfig is a workspace's tool-config.yml read into its own viper
+// instance, together with the bytes it was parsed from.
+type workspaceConfig struct {
+	name   string
+	path   string
+	data   []byte
+	values *viper.Viper
+}
+
+// loadWorkspaceConfig reads the workspace's tool-config.yml into viper and
+// makes it the active workspace.
+func loadWorkspaceConfig(name string) error {
+	config, err := readWorkspaceConfig(name)
+	if err != nil {
+		return err
+	}
+	return applyWorkspaceConfig(config)
+}
+
+// readWorkspaceConfig reads the workspace's tool-config.yml without touching
+// the global viper config, so a file that does not parse leaves the current
+// workspace loaded.
+func readWorkspaceConfig(name string) (workspaceConfig, error) {
+	values := viper.New()
+	values.AddConfigPath(workspaceRoot(name))
+	values.SetConfigName("tool-config")
+	values.SetConfigType("yml")
+
+	if err := values.ReadInConfig(); err != nil {
+		if name != "" {
+			return workspaceConfig{}, fmt.Errorf("workspace %s needs %s: %w", name, workspaceToolConfigPath(name), err)
+		}
+		return workspaceConfig{}, err
+	}
+	path := values.ConfigFileUsed()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return workspaceConfig{}, err
+	}
+	// Parse the bytes kept for applyWorkspaceConfig, in case the file changed
+	// after ReadInConfig.
+	if err := values.ReadConfig(bytes.NewReader(data)); err != nil {
+		return workspaceConfig{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return workspaceConfig{name: name, path: path, data: data, values: values}, nil
+}
+
+// applyWorkspaceConfig replaces the global viper config, including any values
+// set at runtime, with a config readWorkspaceConfig already parsed and makes
+// its workspace active.
+func applyWorkspaceConfig(config workspaceConfig) error {
 	viper.Reset()
-	viper.AddConfigPath("../")
-	viper.SetConfigName("tool-config")
+	viper.SetConfigFile(config.path)
 	viper.SetConfigType("yml")
-
-	if err := viper.ReadInConfig(); err != nil {
-		t.Fatalf("Failed to read config: %v", err)
+	if err := viper.ReadConfig(bytes.NewReader(config.data)); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", config.path, err)
 	}
+	useWorkspace(config.name)
+	return nil
 }
 
 func getTerraformOptions(t *testing.T, totalHAs int) *terraform.Options {
@@ -38,6 +103,18 @@ func getTerraformOptions(t *testing.T, totalHAs int) *terraform.Options {
 	if err != nil {
 		t.Fatalf("Invalid custom Rancher hostname: %v", err)
 	}
+	serverCount, err := settings.ConfiguredServerCount()
+	if err != nil {
+		t.Fatalf("Invalid node topology: %v", err)
+	}
+	agentCount, err := settings.ConfiguredAgentCount()
+	if err != nil {
+		t.Fatalf("Invalid node topology: %v", err)
+	}
+	tlsMode, err := settings.ConfiguredTLSMode()
+	if err != nil {
+		t.Fatalf("Invalid TLS mode: %v", err)
+	}
 
 	backendConfig, err := terraformBackendConfigFromEnv()
 	if err != nil {
@@ -47,14 +124,26 @@ func getTerraformOptions(t *testing.T, totalHAs int) *terraform.Options {
 		t.Fatalf("Failed to sync Terraform backend file: %v", err)
 	}
 
+	dataDir, err := terraformDataDir(activeWorkspace())
+	if err != nil {
+		t.Fatalf("Failed to resolve the Terraform data directory: %v", err)
+	}
+	envVars := map[string]string{}
+	if dataDir != "" {
+		envVars["TF_DATA_DIR"] = dataDir
+	}
+
 	options := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
 		TerraformDir:  "../modules/aws",
 		NoColor:       true,
 		Lock:          true,
 		LockTimeout:   "5m",
 		BackendConfig: backendConfig,
+		EnvVars:       envVars,
 		Vars: map[string]interface{}{
 			"total_has":              totalHAs,
+			"server_count":           serverCount,
+			"agent_count":            agentCount,
 			"aws_prefix":             viper.GetString("tf_vars.aws_prefix"),
 			"aws_vpc":                viper.GetString("tf_vars.aws_vpc"),
 			"aws_subnet_a":           viper.GetString("tf_vars.aws_subnet_a"),
@@ -66,6 +155,7 @@ func getTerraformOptions(t *testing.T, totalHAs int) *terraform.Options {
 			"aws_pem_key_name":       viper.GetString("tf_vars.aws_pem_key_name"),
 			"aws_route53_fqdn":       viper.GetString("tf_vars.aws_route53_fqdn"),
 			"custom_hostname_prefix": customHostnamePrefix,
+			"tls_mode":               tlsMode,
 		},
 	})
 
@@ -78,6 +168,17 @@ func getTerraformOptions(t *testing.T, totalHAs int) *terraform.Options {
 	return options
 }
 
+// initTerraformWorkspace runs terraform init and, for a named workspace,
+// selects or creates the Terraform workspace of the same name. The local
+// backend then keeps its state in terraform.tfstate.d/<name> and the S3
+// backend under env:/<name>/ next to TF_STATE_KEY.
+func initTerraformWorkspace(t *testing.T, terraformOptions *terraform.Options) {
+	terraform.Init(t, terraformOptions)
+	if name := activeWorkspace(); name != "" {
+		terraform.WorkspaceSelectOrNew(t, terraformOptions, name)
+	}
+}
+
 func terraformBackendConfigFromEnv() (map[string]interface{}, error) {
 	values := map[string]string{
 		"TF_STATE_BUCKET":     strings.TrimSpace(os.Getenv("TF_STATE_BUCKET")),
@@ -177,18 +278,27 @@ func maskTerraformOutputs(outputs map[string]string) {
 func getHAOutputs(instanceNum int, outputs map[string]string) TerraformOutputs {
 	prefix := fmt.Sprintf("ha_%d", instanceNum)
 	return TerraformOutputs{
-		Server1IP:        outputs[fmt.Sprintf("%s_server1_ip", prefix)],
-		Server2IP:        outputs[fmt.Sprintf("%s_server2_ip", prefix)],
-		Server3IP:        outputs[fmt.Sprintf("%s_server3_ip", prefix)],
-		Server1PrivateIP: outputs[fmt.Sprintf("%s_server1_private_ip", prefix)],
-		Server2PrivateIP: outputs[fmt.Sprintf("%s_server2_private_ip", prefix)],
-		Server3PrivateIP: outputs[fmt.Sprintf("%s_server3_private_ip", prefix)],
+		ServerIPs:        nodeOutputList(outputs, prefix, "server", "ip"),
+		ServerPrivateIPs: nodeOutputList(outputs, prefix, "server", "private_ip"),
+		AgentIPs:         nodeOutputList(outputs, prefix, "agent", "ip"),
+		AgentPrivateIPs:  nodeOutputList(outputs, prefix, "agent", "private_ip"),
 		LoadBalancerDNS:  outputs[fmt.Sprintf("%s_aws_lb", prefix)],
 		RancherURL:       outputs[fmt.Sprintf("%s_rancher_url", prefix)],
 	}
 }
 
-func logHASummary(totalHAs int, outputs map[string]string, resolvedPlans []*RancherResolvedPlan) {
+func nodeOutputList(outputs map[string]string, prefix, role, suffix string) []string {
+	var values []string
+	for n := 1; ; n++ {
+		value, ok := outputs[fmt.Sprintf("%s_%s%d_%s", prefix, role, n, suffix)]
+		if !ok {
+			return values
+		}
+		values = append(values, value)
+	}
+}
+
+func logHASummary(totalHAs int, outputs map[string]string, resolvedPlans []*resolver.RancherResolvedPlan) {
 	log.Printf("HA setup complete. Rancher URLs:")
 	for i := 1; i <= totalHAs; i++ {
 		haOutputs := getHAOutputs(i, outputs)
@@ -1,6 +1,9 @@
 package test
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestTerraformBackendConfigFromEnvEmptyUsesLocalState(t *testing.T) {
 	t.Setenv("TF_STATE_BUCKET", "")
@@ -53,3 +56,35 @@ func TestTerraformBackendConfigFromEnvBuildsS3Backend(t *testing.T) {
 		}
 	}
 }
+
+func TestGetHAOutputsBuildsNodeListsFromFlatOutputs(t *testing.T) {
+	outputs := map[string]string{
+		"ha_1_server1_ip":         "3.0.0.1",
+		"ha_1_server1_private_ip": "10.0.0.1",
+		"ha_1_agent1_ip":          "3.0.0.11",
+		"ha_1_agent1_private_ip":  "10.0.0.11",
+		"ha_1_agent2_ip":          "3.0.0.12",
+		"ha_1_agent2_private_ip":  "10.0.0.12",
+		"ha_1_aws_lb":             "lb.example.com",
+		"ha_1_rancher_url":        "rancher.example.com",
+		"ha_2_server1_ip":         "3.0.1.1",
+		"ha_2_server2_ip":         "3.0.1.2",
+		"ha_2_server3_ip":         "3.0.1.3",
+	}
+
+	first := getHAOutputs(1, outputs)
+	if !reflect.DeepEqual(first.ServerIPs, []string{"3.0.0.1"}) || !reflect.DeepEqual(first.ServerPrivateIPs, []string{"10.0.0.1"}) {
+		t.Fatalf("unexpected servers %#v", first)
+	}
+	if !reflect.DeepEqual(first.AgentIPs, []string{"3.0.0.11", "3.0.0.12"}) || !reflect.DeepEqual(first.AgentPrivateIPs, []string{"10.0.0.11", "10.0.0.12"}) {
+		t.Fatalf("unexpected agents %#v", first)
+	}
+	if first.FirstServerIP() != "3.0.0.1" || first.LoadBalancerDNS != "lb.example.com" || first.RancherURL != "rancher.example.com" {
+		t.Fatalf("unexpected HA outputs %#v", first)
+	}
+
+	second := getHAOutputs(2, outputs)
+	if !reflect.DeepEqual(second.ServerIPs, []string{"3.0.1.1", "3.0.1.2", "3.0.1.3"}) || len(second.AgentIPs) != 0 {
+		t.Fatalf("unexpected second HA outputs %#v", second)
+	}
+}
@@ -1,31 +1,36 @@
 package test
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 	"github.com/brudnak/ha-rancher-rke2/terratest/ui"
 	"github.com/spf13/viper"
 )
 
+const (
+	panelStateRefreshInterval = 15 * time.Second
+	panelStateDebounce        = 500 * time.Millisecond
+	panelTerraformOutputsTTL  = 30 * time.Second
+)
+
 type localControlPanel struct {
 	token    string
-	totalHAs int
+	config   panelConfig
 	repoRoot string
 	testDir  string
 	listener net.Listener
@@ -33,20 +38,25 @@ type localControlPanel struct {
 	baseURL  string
 	doneCh   chan error
 
-	mu                sync.Mutex
-	cleanupRunning    bool
-	cleanupOutput     []string
-	cleanupStartedAt  *time.Time
-	cleanupFinishedAt *time.Time
-	cleanupError      string
+	jobs                *panelJobRunner
+	kube                *kubeWatchers
+	nodes               *panelNodeConsole
+	upgradeVersionsOnce sync.Once
+	upgradeVersions     []string
 
-	rancherTokens             map[int]string
+	mu                        sync.Mutex
+	rancherTokens             map[int]panelRancherToken
+	kubeconfigTokens          map[int][]string
 	downstreamKubeconfigCache map[string]string
+	terraformOutputs          map[string]string
+	terraformOutputsAt        time.Time
 }
 
 type panelState struct {
-	Clusters panelClusterState `json:"clusters"`
-	Cleanup  cleanupState      `json:"cleanup"`
+	Workspace string            `json:"workspace"`
+	Clusters  panelClusterState `json:"clusters"`
+	Cleanup   cleanupState      `json:"cleanup"`
+	Jobs      []panelJobView    `json:"jobs"`
 }
 
 type panelClusterState struct {
@@ -54,23 +64,24 @@ type panelClusterState struct {
 }
 
 type clusterView struct {
-	ID                  string    `json:"id"`
-	Type                string    `json:"type"`
-	HAIndex             int       `json:"haIndex"`
-	Name                string    `json:"name"`
-	Version             string    `json:"version,omitempty"`
-	RancherURL          string    `json:"rancherUrl,omitempty"`
-	LoadBalancer        string    `json:"loadBalancer,omitempty"`
-	Namespace           string    `json:"namespace,omitempty"`
-	ManagementClusterID string    `json:"managementClusterId,omitempty"`
-	KubeconfigPath      string    `json:"kubeconfigPath,omitempty"`
-	DownloadName        string    `json:"downloadName,omitempty"`
-	Provisioning        bool      `json:"provisioning,omitempty"`
-	ProvisioningMessage string    `json:"provisioningMessage,omitempty"`
-	Available           bool      `json:"available"`
-	Reachable           bool      `json:"reachable"`
-	Error               string    `json:"error,omitempty"`
-	Pods                []podView `json:"pods"`
+	ID                  string         `json:"id"`
+	Type                string         `json:"type"`
+	HAIndex             int            `json:"haIndex"`
+	Name                string         `json:"name"`
+	Version             string         `json:"version,omitempty"`
+	RancherURL          string         `json:"rancherUrl,omitempty"`
+	LoadBalancer        string         `json:"loadBalancer,omitempty"`
+	Namespace           string         `json:"namespace,omitempty"`
+	ManagementClusterID string         `json:"managementClusterId,omitempty"`
+	KubeconfigPath      string         `json:"kubeconfigPath,omitempty"`
+	DownloadName        string         `json:"downloadName,omitempty"`
+	Provisioning        bool           `json:"provisioning,omitempty"`
+	ProvisioningMessage string         `json:"provisioningMessage,omitempty"`
+	Available           bool           `json:"available"`
+	Reachable           bool           `json:"reachable"`
+	Error               string         `json:"error,omitempty"`
+	Pods                []podView      `json:"pods"`
+	Health              *clusterHealth `json:"health,omitempty"`
 }
 
 type podView struct {
@@ -94,91 +105,32 @@ type cleanupState struct {
 	Output     []string   `json:"output"`
 }
 
-type kubectlPodList struct {
-	Items []kubectlPod `json:"items"`
-}
-
-type kubectlPod struct {
-	Metadata struct {
-		Namespace         string    `json:"namespace"`
-		Name              string    `json:"name"`
-		CreationTimestamp time.Time `json:"creationTimestamp"`
-	} `json:"metadata"`
-	Spec struct {
-		NodeName   string `json:"nodeName"`
-		Containers []struct {
-			Name string `json:"name"`
-		} `json:"containers"`
-		InitContainers []struct {
-			Name string `json:"name"`
-		} `json:"initContainers"`
-	} `json:"spec"`
-	Status struct {
-		Phase             string `json:"phase"`
-		Reason            string `json:"reason"`
-		ContainerStatuses []struct {
-			Name         string `json:"name"`
-			Ready        bool   `json:"ready"`
-			RestartCount int    `json:"restartCount"`
-			State        struct {
-				Waiting struct {
-					Reason string `json:"reason"`
-				} `json:"waiting"`
-				Terminated struct {
-					Reason string `json:"reason"`
-				} `json:"terminated"`
-			} `json:"state"`
-		} `json:"containerStatuses"`
-		InitContainerStatuses []struct {
-			Name         string `json:"name"`
-			Ready        bool   `json:"ready"`
-			RestartCount int    `json:"restartCount"`
-			State        struct {
-				Waiting struct {
-					Reason string `json:"reason"`
-				} `json:"waiting"`
-				Terminated struct {
-					Reason string `json:"reason"`
-				} `json:"terminated"`
-			} `json:"state"`
-		} `json:"initContainerStatuses"`
-	} `json:"status"`
-}
-
-type provisioningClusterList struct {
-	Items []provisioningClusterItem `json:"items"`
-}
-
-type provisioningClusterItem struct {
-	Metadata struct {
-		Name      string `json:"name"`
-		Namespace string `json:"namespace"`
-	} `json:"metadata"`
-	Status struct {
-		ClusterName string `json:"clusterName"`
-	} `json:"status"`
-}
-
-type managementClusterList struct {
-	Items []managementClusterItem `json:"items"`
-}
-
-type managementClusterItem struct {
-	Metadata struct {
-		Name string `json:"name"`
-	} `json:"metadata"`
-	Spec struct {
-		DisplayName string `json:"displayName"`
-	} `json:"spec"`
-}
-
 type discoveredDownstreamCluster struct {
 	Name                string
 	Namespace           string
 	ManagementClusterID string
 }
 
-func newLocalControlPanel(totalHAs int) (*localControlPanel, error) {
+// panelConfig is the part of tool-config.yml that HTTP handlers read. The
+// panel keeps its own copy, guarded by mu, because viper is not safe for
+// concurrent use and a workspace switch replaces its config.
+type panelConfig struct {
+	TotalHAs          int
+	RancherVersions   []string
+	RancherVersion    string
+	BootstrapPassword string
+}
+
+func panelConfigFrom(values *viper.Viper) panelConfig {
+	return panelConfig{
+		TotalHAs:          values.GetInt("total_has"),
+		RancherVersions:   values.GetStringSlice("rancher.versions"),
+		RancherVersion:    values.GetString("rancher.version"),
+		BootstrapPassword: values.GetString("rancher.bootstrap_password"),
+	}
+}
+
+func newLocalControlPanel(config panelConfig) (*localControlPanel, error) {
 	token, err := randomConfirmationToken()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create control panel token: %w", err)
@@ -200,13 +152,17 @@ func newLocalControlPanel(totalHAs int) (*localControlPanel, error) {
 
 	panel := &localControlPanel{
 		token:                     token,
-		totalHAs:                  totalHAs,
+		config:                    config,
 		repoRoot:                  repoRoot,
 		testDir:                   testDir,
 		listener:                  listener,
 		baseURL:                   fmt.Sprintf("http://%s/?token=%s", listener.Addr().String(), token),
 		doneCh:                    make(chan error, 1),
-		rancherTokens:             map[int]string{},
+		jobs:                      newPanelJobRunner(repoRoot),
+		kube:                      newKubeWatchers(),
+		nodes:                     newPanelNodeConsole(),
+		rancherTokens:             map[int]panelRancherToken{},
+		kubeconfigTokens:          map[int][]string{},
 		downstreamKubeconfigCache: map[string]string{},
 	}
 
@@ -215,13 +171,22 @@ func newLocalControlPanel(totalHAs int) (*localControlPanel, error) {
 	mux.HandleFunc("/static/control_panel.js", panel.handleControlPanelJS)
 	mux.HandleFunc("/static/control_panel_theme.js", panel.handleControlPanelThemeJS)
 	mux.HandleFunc("/api/state", panel.handleState)
+	mux.HandleFunc("/api/state/stream", panel.handleStateStream)
+	mux.HandleFunc("/api/workspaces", panel.handleWorkspaces)
+	mux.HandleFunc("/api/health", panel.handleHealth)
 	mux.HandleFunc("/api/logs", panel.handleLogs)
 	mux.HandleFunc("/api/logs/stream", panel.handleLogStream)
 	mux.HandleFunc("/api/kubeconfig", panel.handleKubeconfigDownload)
-	mux.HandleFunc("/api/cleanup", panel.handleCleanup)
+	mux.HandleFunc("/api/jobs", panel.handleJobs)
+	mux.HandleFunc("/api/jobs/cancel", panel.handleJobCancel)
+	mux.HandleFunc("/api/jobs/stream", panel.handleJobStream)
+	mux.HandleFunc("/api/nodes", panel.handleNodes)
+	mux.HandleFunc("/api/nodes/run", panel.handleNodeRun)
+	mux.HandleFunc("/api/nodes/journal/stream", panel.handleNodeJournalStream)
 	mux.HandleFunc("/api/shutdown", panel.handleShutdown)
 
 	panel.server = &http.Server{Handler: mux}
+	panel.server.RegisterOnShutdown(panel.kube.stopAll)
 	return panel, nil
 }
 
@@ -237,7 +202,10 @@ func (p *localControlPanel) start() {
 }
 
 func (p *localControlPanel) wait() error {
-	return <-p.doneCh
+	err := <-p.doneCh
+	p.kube.stopAll()
+	p.revokeRancherTokens()
+	return err
 }
 
 func (p *localControlPanel) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -304,6 +272,68 @@ func (p *localControlPanel) handleState(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, state)
 }
 
+// handleStateStream pushes a state snapshot whenever a watched Kubernetes
+// object or job changes, and on a slow tick so ages and Terraform outputs stay
+// current. Unchanged snapshots are not resent.
+func (p *localControlPanel) handleStateStream(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizedReadOnly(r) {
+		http.Error(w, "invalid control panel token", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(panelStateRefreshInterval)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		kubeChanged := p.kube.changes()
+		jobsChanged := p.jobs.changes()
+
+		data, err := json.Marshal(p.buildState())
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+			flusher.Flush()
+			return
+		}
+		if !bytes.Equal(data, last) {
+			fmt.Fprintf(w, "event: state\ndata: %s\n\n", data)
+			flusher.Flush()
+			last = data
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-p.kube.closed:
+			return
+		case <-kubeChanged:
+		case <-jobsChanged:
+		case <-ticker.C:
+		}
+
+		// Coalesce bursts, such as a rollout touching many pods, into one push.
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(panelStateDebounce):
+		}
+	}
+}
+
 func (p *localControlPanel) handleLogs(w http.ResponseWriter, r *http.Request) {
 	if !p.authorizedReadOnly(r) {
 		http.Error(w, "invalid control panel token", http.StatusForbidden)
@@ -320,14 +350,12 @@ func (p *localControlPanel) handleLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	args := []string{"logs", pod, "-n", namespace, "--tail=200"}
-	if container != "" {
-		args = append(args, "-c", container)
-	} else {
-		args = append(args, "--all-containers=true")
+	watcher, err := p.kube.get(cluster.KubeconfigPath, clusterWatchScope(cluster))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
 	}
-
-	output, err := runKubectl(cluster.KubeconfigPath, args...)
+	output, err := readPodLogs(r.Context(), watcher.clientset, namespace, pod, container)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
@@ -358,29 +386,11 @@ func (p *localControlPanel) handleLogStream(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	args := []string{"logs", "-f", pod, "-n", namespace, "--tail=20"}
-	if container != "" {
-		args = append(args, "-c", container)
-	} else {
-		args = append(args, "--all-containers=true")
-	}
-
-	cmd := exec.CommandContext(r.Context(), "kubectl", append([]string{"--kubeconfig", cluster.KubeconfigPath}, args...)...)
-	stdout, err := cmd.StdoutPipe()
+	watcher, err := p.kube.get(cluster.KubeconfigPath, clusterWatchScope(cluster))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to open log stream: %v", err), http.StatusInternalServerError)
-		return
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to open log stream: %v", err), http.StatusInternalServerError)
-		return
-	}
-	if err := cmd.Start(); err != nil {
-		http.Error(w, fmt.Sprintf("failed to start log stream: %v", err), http.StatusBadGateway)
+		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
-	defer cmd.Wait()
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -392,19 +402,8 @@ func (p *localControlPanel) handleLogStream(w http.ResponseWriter, r *http.Reque
 		flusher.Flush()
 	}
 
-	stdoutDone := make(chan struct{})
-	go func() {
-		defer close(stdoutDone)
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			sendLine("line", scanner.Text())
-		}
-	}()
-
-	stderrBytes, _ := io.ReadAll(stderr)
-	<-stdoutDone
-	if len(stderrBytes) > 0 {
-		sendLine("error", string(stderrBytes))
+	if err := streamPodLogs(r.Context(), watcher.clientset, namespace, pod, container, sendLine); err != nil {
+		sendLine("error", err.Error())
 	}
 	sendLine("end", "stream closed")
 }
@@ -442,36 +441,6 @@ func (p *localControlPanel) handleKubeconfigDownload(w http.ResponseWriter, r *h
 	_, _ = w.Write(content)
 }
 
-func (p *localControlPanel) handleCleanup(w http.ResponseWriter, r *http.Request) {
-	if !p.authorizedLocalAction(r) {
-		http.Error(w, "invalid control panel token", http.StatusForbidden)
-		return
-	}
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req struct {
-		Confirm string `json:"confirm"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
-		return
-	}
-	if strings.TrimSpace(strings.ToLower(req.Confirm)) != "cleanup" {
-		http.Error(w, "typed confirmation must equal cleanup", http.StatusBadRequest)
-		return
-	}
-
-	if err := p.startCleanup(); err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
-		return
-	}
-
-	writeJSON(w, map[string]string{"status": "cleanup started"})
-}
-
 func (p *localControlPanel) handleShutdown(w http.ResponseWriter, r *http.Request) {
 	if !p.authorizedLocalAction(r) {
 		http.Error(w, "invalid control panel token", http.StatusForbidden)
@@ -494,38 +463,47 @@ func (p *localControlPanel) handleShutdown(w http.ResponseWriter, r *http.Reques
 
 func (p *localControlPanel) buildState() panelState {
 	return panelState{
+		Workspace: workspaceLabel(activeWorkspace()),
 		Clusters: panelClusterState{
 			Items: p.discoverClusters(),
 		},
 		Cleanup: p.snapshotCleanupState(),
+		Jobs:    p.jobs.snapshot(false),
 	}
 }
 
+// snapshotCleanupState reports the newest cleanup job in the shape the cleanup
+// card and cluster view already render.
 func (p *localControlPanel) snapshotCleanupState() cleanupState {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	outputCopy := append([]string(nil), p.cleanupOutput...)
-	if outputCopy == nil {
-		outputCopy = []string{}
+	job, ok := p.jobs.latest("cleanup")
+	if !ok {
+		return cleanupState{Output: []string{}}
+	}
+	startedAt := job.StartedAt
+	output := job.Output
+	if output == nil {
+		output = []string{}
 	}
 	return cleanupState{
-		Running:    p.cleanupRunning,
-		StartedAt:  p.cleanupStartedAt,
-		FinishedAt: p.cleanupFinishedAt,
-		Error:      p.cleanupError,
-		Output:     outputCopy,
+		Running:    job.Status == panelJobRunning,
+		StartedAt:  &startedAt,
+		FinishedAt: job.FinishedAt,
+		Error:      job.Error,
+		Output:     output,
 	}
 }
 
 func (p *localControlPanel) discoverClusters() []clusterView {
-	outputs, _ := readTerraformFlatOutputs(p.repoRoot)
-	versions := readRequestedRancherVersionsForPanel(p.totalHAs)
+	outputs := p.cachedTerraformOutputs()
+	config := p.currentConfig()
+	totalHAs := config.TotalHAs
+	versions := config.requestedRancherVersions()
 	downstreamRecords, _ := readDownstreamOutputRecords()
 	recordsByHA := downstreamRecordsByHA(downstreamRecords)
 
-	clusters := make([]clusterView, 0, p.totalHAs)
-	for i := 1; i <= p.totalHAs; i++ {
+	clusters := make([]clusterView, 0, totalHAs)
+	watchedKubeconfigs := map[string]bool{}
+	for i := 1; i <= totalHAs; i++ {
 		cluster := clusterView{
 			ID:           localClusterID(i),
 			Type:         "local",
@@ -536,7 +514,7 @@ func (p *localControlPanel) discoverClusters() []clusterView {
 		if len(versions) >= i {
 			cluster.Version = versions[i-1]
 		}
-		cluster.KubeconfigPath = filepath.Join(p.testDir, fmt.Sprintf("high-availability-%d", i), "kube_config.yaml")
+		cluster.KubeconfigPath = filepath.Join(p.testDir, haInstanceDir(i), "kube_config.yaml")
 		if outputs != nil {
 			cluster.RancherURL = clickableURL(outputs[fmt.Sprintf("ha_%d_rancher_url", i)])
 			cluster.LoadBalancer = outputs[fmt.Sprintf("ha_%d_aws_lb", i)]
@@ -549,7 +527,8 @@ func (p *localControlPanel) discoverClusters() []clusterView {
 		}
 
 		cluster.Available = true
-		pods, err := fetchLocalRancherPods(cluster.KubeconfigPath)
+		watchedKubeconfigs[cluster.KubeconfigPath] = true
+		pods, err := p.clusterPods(cluster)
 		if err != nil {
 			cluster.Error = err.Error()
 			clusters = append(clusters, cluster)
@@ -559,19 +538,76 @@ func (p *localControlPanel) discoverClusters() []clusterView {
 
 		cluster.Reachable = true
 		cluster.Pods = pods
+		cluster.Health = p.clusterHealthFor(cluster, cluster)
 		clusters = append(clusters, cluster)
 		clusters = append(clusters, p.discoverDownstreamClusters(cluster, recordsByHA[i])...)
 	}
 
+	for i, cluster := range clusters {
+		if cluster.Type == "downstream" && cluster.KubeconfigPath != "" {
+			watchedKubeconfigs[cluster.KubeconfigPath] = true
+		}
+		if cluster.Health == nil {
+			clusters[i].Health = unreachableClusterHealth(cluster)
+		}
+	}
+	p.kube.retain(watchedKubeconfigs)
+
 	return clusters
 }
 
+// clusterWatchScope is what the panel shows for a cluster: Rancher's pods and
+// downstream clusters on a local cluster, every pod on a downstream one.
+func clusterWatchScope(cluster clusterView) kubeWatchScope {
+	if cluster.Type == "downstream" {
+		return kubeWatchScope{}
+	}
+	return kubeWatchScope{PodNamespace: "cattle-system", RancherClusters: true}
+}
+
+func (p *localControlPanel) clusterPods(cluster clusterView) ([]podView, error) {
+	watcher, err := p.kube.get(cluster.KubeconfigPath, clusterWatchScope(cluster))
+	if err != nil {
+		return nil, err
+	}
+	if cluster.Type == "downstream" {
+		return watcher.podViews(nil)
+	}
+	return watcher.podViews(isRancherPodName)
+}
+
+// cachedTerraformOutputs keeps Terraform outputs for a short while, since the
+// state stream can rebuild state many times a minute.
+func (p *localControlPanel) cachedTerraformOutputs() map[string]string {
+	p.mu.Lock()
+	if p.terraformOutputs != nil && time.Since(p.terraformOutputsAt) < panelTerraformOutputsTTL {
+		outputs := p.terraformOutputs
+		p.mu.Unlock()
+		return outputs
+	}
+	p.mu.Unlock()
+
+	outputs, err := readTerraformFlatOutputs(p.repoRoot, activeWorkspace())
+	if err != nil {
+		return nil
+	}
+	p.mu.Lock()
+	p.terraformOutputs = outputs
+	p.terraformOutputsAt = time.Now()
+	p.mu.Unlock()
+	return outputs
+}
+
 func (p *localControlPanel) discoverDownstreamClusters(local clusterView, records []downstreamOutputRecord) []clusterView {
 	if !local.Available {
 		return nil
 	}
 
-	provisioningClusters, err := discoverProvisioningDownstreamClusters(local.KubeconfigPath)
+	localWatcher, err := p.kube.get(local.KubeconfigPath, clusterWatchScope(local))
+	if err != nil {
+		return downstreamClustersFromRecords(local, records, err)
+	}
+	provisioningClusters, err := localWatcher.downstreamClusters()
 	if err != nil {
 		return downstreamClustersFromRecords(local, records, err)
 	}
@@ -615,7 +651,7 @@ func (p *localControlPanel) discoverDownstreamClusters(local clusterView, record
 		}
 		cluster.KubeconfigPath = kubeconfigPath
 
-		pods, err := fetchAllPods(kubeconfigPath)
+		pods, err := p.clusterPods(cluster)
 		if err != nil {
 			cluster.Provisioning = true
 			cluster.ProvisioningMessage = "Waiting for downstream Kubernetes API"
@@ -624,6 +660,7 @@ func (p *localControlPanel) discoverDownstreamClusters(local clusterView, record
 		}
 		cluster.Reachable = true
 		cluster.Pods = pods
+		cluster.Health = p.clusterHealthFor(cluster, local)
 		clusters = append(clusters, cluster)
 	}
 	p.pruneStaleDownstreamKubeconfigs(local.HAIndex, activeIDs)
@@ -654,87 +691,6 @@ func downstreamClustersFromRecords(local clusterView, records []downstreamOutput
 	return clusters
 }
 
-func discoverProvisioningDownstreamClusters(kubeconfigPath string) ([]discoveredDownstreamCluster, error) {
-	output, err := runKubectl(kubeconfigPath, "get", "clusters.provisioning.cattle.io", "-A", "-o", "json")
-	if err != nil {
-		return nil, err
-	}
-
-	var list provisioningClusterList
-	if err := json.Unmarshal([]byte(output), &list); err != nil {
-		return nil, fmt.Errorf("failed to parse provisioning clusters: %w", err)
-	}
-
-	clusters := make([]discoveredDownstreamCluster, 0, len(list.Items))
-	for _, item := range list.Items {
-		name := strings.TrimSpace(item.Metadata.Name)
-		namespace := strings.TrimSpace(item.Metadata.Namespace)
-		if name == "" || namespace == "" {
-			continue
-		}
-		if name == "local" || namespace == "local" {
-			continue
-		}
-		clusters = append(clusters, discoveredDownstreamCluster{
-			Name:                name,
-			Namespace:           namespace,
-			ManagementClusterID: strings.TrimSpace(item.Status.ClusterName),
-		})
-	}
-
-	managementClusters, err := discoverManagementDownstreamClusters(kubeconfigPath)
-	if err == nil {
-		seenManagementIDs := map[string]bool{}
-		for _, cluster := range clusters {
-			if cluster.ManagementClusterID != "" {
-				seenManagementIDs[cluster.ManagementClusterID] = true
-			}
-		}
-		for _, cluster := range managementClusters {
-			if seenManagementIDs[cluster.ManagementClusterID] {
-				continue
-			}
-			clusters = append(clusters, cluster)
-		}
-	}
-
-	sort.Slice(clusters, func(i, j int) bool {
-		left := provisioningClusterRecordKey(clusters[i].Namespace, clusters[i].Name)
-		right := provisioningClusterRecordKey(clusters[j].Namespace, clusters[j].Name)
-		return left < right
-	})
-	return clusters, nil
-}
-
-func discoverManagementDownstreamClusters(kubeconfigPath string) ([]discoveredDownstreamCluster, error) {
-	output, err := runKubectl(kubeconfigPath, "get", "clusters.management.cattle.io", "-o", "json")
-	if err != nil {
-		return nil, err
-	}
-
-	var list managementClusterList
-	if err := json.Unmarshal([]byte(output), &list); err != nil {
-		return nil, fmt.Errorf("failed to parse management clusters: %w", err)
-	}
-
-	clusters := make([]discoveredDownstreamCluster, 0, len(list.Items))
-	for _, item := range list.Items {
-		clusterID := strings.TrimSpace(item.Metadata.Name)
-		if clusterID == "" || clusterID == "local" {
-			continue
-		}
-		name := strings.TrimSpace(item.Spec.DisplayName)
-		if name == "" {
-			name = clusterID
-		}
-		clusters = append(clusters, discoveredDownstreamCluster{
-			Name:                name,
-			ManagementClusterID: clusterID,
-		})
-	}
-	return clusters, nil
-}
-
 func downstreamRecordsByHA(records []downstreamOutputRecord) map[int][]downstreamOutputRecord {
 	byHA := map[int][]downstreamOutputRecord{}
 	for _, record := range records {
@@ -829,128 +785,12 @@ func (p *localControlPanel) pruneStaleDownstreamKubeconfigs(haIndex int, activeI
 	}
 }
 
-func fetchLocalRancherPods(kubeconfigPath string) ([]podView, error) {
-	pods, err := fetchPods(kubeconfigPath, "cattle-system")
-	if err != nil {
-		return nil, err
-	}
-
-	filtered := make([]podView, 0, len(pods))
-	for _, pod := range pods {
-		nameLower := strings.ToLower(pod.Name)
-		if !strings.Contains(nameLower, "rancher") && !strings.Contains(nameLower, "webhook") {
-			continue
-		}
-		filtered = append(filtered, pod)
-	}
-	return filtered, nil
-}
-
-func fetchAllPods(kubeconfigPath string) ([]podView, error) {
-	return fetchPods(kubeconfigPath, "")
-}
-
 func fetchRelevantPods(kubeconfigPath string) ([]podView, error) {
-	return fetchLocalRancherPods(kubeconfigPath)
-}
-
-func fetchPods(kubeconfigPath, namespace string) ([]podView, error) {
-	args := []string{"get", "pods"}
-	if namespace == "" {
-		args = append(args, "-A")
-	} else {
-		args = append(args, "-n", namespace)
-	}
-	args = append(args, "-o", "json")
-
-	output, err := runKubectl(kubeconfigPath, args...)
+	clientset, _, err := newKubeClients(kubeconfigPath)
 	if err != nil {
 		return nil, err
 	}
-
-	var list kubectlPodList
-	if err := json.Unmarshal([]byte(output), &list); err != nil {
-		return nil, fmt.Errorf("failed to parse pod list: %w", err)
-	}
-
-	leaderLabels := discoverLeaderLabels(kubeconfigPath)
-
-	pods := make([]podView, 0)
-	for _, item := range list.Items {
-		totalContainers := len(item.Spec.Containers)
-		readyContainers := 0
-		restarts := 0
-		status := item.Status.Phase
-		for _, containerStatus := range item.Status.ContainerStatuses {
-			if containerStatus.Ready {
-				readyContainers++
-			}
-			restarts += containerStatus.RestartCount
-			if containerStatus.State.Waiting.Reason != "" {
-				status = containerStatus.State.Waiting.Reason
-			}
-			if containerStatus.State.Terminated.Reason != "" {
-				status = containerStatus.State.Terminated.Reason
-			}
-		}
-		if item.Status.Reason != "" {
-			status = item.Status.Reason
-		}
-
-		containerNames := make([]string, 0, len(item.Spec.Containers))
-		for _, container := range item.Spec.Containers {
-			containerNames = append(containerNames, container.Name)
-		}
-
-		leaderLabel := leaderLabels[item.Metadata.Name]
-		pods = append(pods, podView{
-			Namespace:   item.Metadata.Namespace,
-			Name:        item.Metadata.Name,
-			Ready:       fmt.Sprintf("%d/%d", readyContainers, totalContainers),
-			Status:      status,
-			Restarts:    restarts,
-			Age:         humanDurationSince(item.Metadata.CreationTimestamp),
-			Node:        item.Spec.NodeName,
-			Containers:  strings.Join(containerNames, ", "),
-			Leader:      leaderLabel != "",
-			LeaderLabel: leaderLabel,
-		})
-	}
-
-	sort.Slice(pods, func(i, j int) bool {
-		return pods[i].Name < pods[j].Name
-	})
-
-	return pods, nil
-}
-
-func discoverLeaderLabels(kubeconfigPath string) map[string]string {
-	leaders := map[string]string{}
-	if holder, err := leaseHolderIdentity(kubeconfigPath, "kube-system", "cattle-controllers"); err == nil && holder != "" {
-		leaders[holder] = "Leader"
-	}
-	if holder, err := leaseHolderIdentity(kubeconfigPath, "cattle-system", "rancher-webhook-leader"); err == nil && holder != "" {
-		leaders[holder] = "Webhook Leader"
-	}
-	return leaders
-}
-
-func leaseHolderIdentity(kubeconfigPath, namespace, name string) (string, error) {
-	output, err := runKubectl(kubeconfigPath, "get", "lease", name, "-n", namespace, "-o", "json")
-	if err != nil {
-		return "", err
-	}
-
-	var lease struct {
-		Spec struct {
-			HolderIdentity string `json:"holderIdentity"`
-		} `json:"spec"`
-	}
-	if err := json.Unmarshal([]byte(output), &lease); err != nil {
-		return "", fmt.Errorf("failed to parse %s/%s lease: %w", namespace, name, err)
-	}
-
-	return strings.TrimSpace(lease.Spec.HolderIdentity), nil
+	return listRancherPods(context.Background(), clientset)
 }
 
 func humanDurationSince(ts time.Time) string {
@@ -970,18 +810,12 @@ func humanDurationSince(ts time.Time) string {
 	}
 }
 
-func runKubectl(kubeconfigPath string, args ...string) (string, error) {
-	cmd := exec.Command("kubectl", append([]string{"--kubeconfig", kubeconfigPath, "--request-timeout=5s"}, args...)...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("kubectl %s failed: %w (%s)", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
-	}
-	return string(output), nil
-}
-
-func readTerraformFlatOutputs(repoRoot string) (map[string]string, error) {
+func readTerraformFlatOutputs(repoRoot, workspace string) (map[string]string, error) {
 	cmd := exec.Command("terraform", "output", "-no-color", "-json", "flat_outputs")
 	cmd.Dir = filepath.Join(repoRoot, "modules", "aws")
+	if workspace != "" {
+		cmd.Env = append(os.Environ(), "TF_DATA_DIR="+filepath.Join(repoRoot, workspacesDirName, workspace, ".terraform"))
+	}
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("terraform output failed: %w (%s)", err, strings.TrimSpace(string(output)))
@@ -994,17 +828,18 @@ func readTerraformFlatOutputs(repoRoot string) (map[string]string, error) {
 	return outputs, nil
 }
 
-func readRequestedRancherVersionsForPanel(totalHAs int) []string {
-	versions := viper.GetStringSlice("rancher.versions")
+func (c panelConfig) requestedRancherVersions() []string {
+	totalHAs := c.TotalHAs
+	versions := c.RancherVersions
 	if len(versions) == totalHAs {
 		out := make([]string, 0, len(versions))
 		for _, version := range versions {
-			out = append(out, normalizeVersionInput(version))
+			out = append(out, resolver.NormalizeVersionInput(version))
 		}
 		return out
 	}
 
-	version := normalizeVersionInput(viper.GetString("rancher.version"))
+	version := resolver.NormalizeVersionInput(c.RancherVersion)
 	if version == "" {
 		return nil
 	}
@@ -1019,89 +854,6 @@ func readRequestedRancherVersionsForPanel(totalHAs int) []string {
 	return out
 }
 
-func (p *localControlPanel) startCleanup() error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if p.cleanupRunning {
-		return fmt.Errorf("cleanup is already running")
-	}
-
-	now := time.Now()
-	p.cleanupRunning = true
-	p.cleanupStartedAt = &now
-	p.cleanupFinishedAt = nil
-	p.cleanupError = ""
-	p.cleanupOutput = []string{"[control-panel] Starting canonical cleanup via go test -run TestHACleanup"}
-
-	go p.runCleanupCommand()
-	return nil
-}
-
-func (p *localControlPanel) runCleanupCommand() {
-	cmd := exec.Command("go", "test", "-v", "-run", "TestHACleanup", "-timeout", "20m", "./terratest")
-	cmd.Dir = p.repoRoot
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		p.finishCleanup(fmt.Errorf("failed to capture cleanup output: %w", err))
-		return
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		p.finishCleanup(fmt.Errorf("failed to capture cleanup output: %w", err))
-		return
-	}
-
-	if err := cmd.Start(); err != nil {
-		p.finishCleanup(fmt.Errorf("failed to start cleanup command: %w", err))
-		return
-	}
-
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go p.captureCleanupStream(&wg, stdout)
-	go p.captureCleanupStream(&wg, stderr)
-	wg.Wait()
-
-	p.finishCleanup(cmd.Wait())
-}
-
-func (p *localControlPanel) captureCleanupStream(wg *sync.WaitGroup, reader io.Reader) {
-	defer wg.Done()
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		p.appendCleanupOutput(scanner.Text())
-	}
-}
-
-func (p *localControlPanel) appendCleanupOutput(line string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	p.cleanupOutput = append(p.cleanupOutput, line)
-	if len(p.cleanupOutput) > 500 {
-		p.cleanupOutput = append([]string(nil), p.cleanupOutput[len(p.cleanupOutput)-500:]...)
-	}
-}
-
-func (p *localControlPanel) finishCleanup(err error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	p.cleanupRunning = false
-	finishedAt := time.Now()
-	p.cleanupFinishedAt = &finishedAt
-	if err != nil {
-		p.cleanupError = err.Error()
-		p.cleanupOutput = append(p.cleanupOutput, "[control-panel] Cleanup finished with error: "+err.Error())
-		return
-	}
-
-	p.cleanupError = ""
-	p.cleanupOutput = append(p.cleanupOutput, "[control-panel] Cleanup completed successfully")
-}
-
 func (p *localControlPanel) logRequest(r *http.Request) (clusterView, string, string, string, error) {
 	clusterID := strings.TrimSpace(r.URL.Query().Get("cluster"))
 	pod := strings.TrimSpace(r.URL.Query().Get("pod"))
@@ -1158,11 +910,9 @@ func (p *localControlPanel) kubeconfigContentForDownload(cluster clusterView) ([
 		if cluster.ManagementClusterID == "" {
 			return nil, "", fmt.Errorf("downstream cluster has no management cluster id yet")
 		}
-		token, err := p.rancherToken(cluster.HAIndex, cluster.RancherURL)
-		if err != nil {
-			return nil, "", err
-		}
-		kubeconfig, err := generateRancherKubeconfig(cluster.RancherURL, token, cluster.ManagementClusterID)
+		// The token in a downloaded kubeconfig is revoked with the panel's
+		// other tokens when the panel shuts down or switches workspace.
+		kubeconfig, err := p.generateKubeconfig(cluster.HAIndex, cluster.RancherURL, cluster.ManagementClusterID)
 		if err != nil {
 			return nil, "", err
 		}
@@ -1188,11 +938,7 @@ func (p *localControlPanel) ensureDownstreamKubeconfig(haIndex int, rancherURL,
 	}
 	p.mu.Unlock()
 
-	token, err := p.rancherToken(haIndex, rancherURL)
-	if err != nil {
-		return "", err
-	}
-	kubeconfig, err := generateRancherKubeconfig(rancherURL, token, managementClusterID)
+	kubeconfig, err := p.generateKubeconfig(haIndex, rancherURL, managementClusterID)
 	if err != nil {
 		return "", err
 	}
@@ -1212,25 +958,6 @@ func (p *localControlPanel) ensureDownstreamKubeconfig(haIndex int, rancherURL,
 	return path, nil
 }
 
-func (p *localControlPanel) rancherToken(haIndex int, rancherURL string) (string, error) {
-	p.mu.Lock()
-	if token := p.rancherTokens[haIndex]; token != "" {
-		p.mu.Unlock()
-		return token, nil
-	}
-	p.mu.Unlock()
-
-	token, err := createRancherAdminToken(rancherURL, viper.GetString("rancher.bootstrap_password"))
-	if err != nil {
-		return "", err
-	}
-
-	p.mu.Lock()
-	p.rancherTokens[haIndex] = token
-	p.mu.Unlock()
-	return token, nil
-}
-
 func (p *localControlPanel) authorized(r *http.Request) bool {
 	token := strings.TrimSpace(r.URL.Query().Get("token"))
 	if token == "" {
@@ -0,0 +1,520 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	healthGreen  = "green"
+	healthYellow = "yellow"
+	healthRed    = "red"
+
+	controlPlanePodSelector = "tier=control-plane"
+	helmReleaseSelector     = "owner=helm,name in (rancher,rancher-webhook,fleet,fleet-agent-local)"
+	nodeRoleLabelPrefix     = "node-role.kubernetes.io/"
+)
+
+// panelHelmReleases are the Helm releases the health view reports. Required
+// releases turn a local cluster red when missing; the others only warn.
+var panelHelmReleases = []struct {
+	Name     string
+	Required bool
+}{
+	{Name: "rancher", Required: true},
+	{Name: "rancher-webhook", Required: true},
+	{Name: "fleet"},
+	{Name: "fleet-agent-local"},
+}
+
+// importantClusterConditions are the management.cattle.io cluster conditions
+// that affect the score. Other conditions are shown but not scored.
+var importantClusterConditions = []string{"Ready", "Provisioned", "Updated", "Connected", "AgentDeployed"}
+
+type clusterHealth struct {
+	Score      string              `json:"score"`
+	Reasons    []string            `json:"reasons,omitempty"`
+	Nodes      []nodeHealth        `json:"nodes"`
+	Etcd       []etcdMemberHealth  `json:"etcd"`
+	Releases   []helmReleaseHealth `json:"releases"`
+	Conditions []clusterCondition  `json:"conditions"`
+}
+
+type nodeHealth struct {
+	Name          string   `json:"name"`
+	Roles         string   `json:"roles"`
+	Version       string   `json:"version,omitempty"`
+	Ready         bool     `json:"ready"`
+	Unschedulable bool     `json:"unschedulable,omitempty"`
+	Problems      []string `json:"problems,omitempty"`
+	// Server is the RKE2 server check for control-plane nodes: the node's
+	// kube-apiserver static pod is "ready", "not ready" or "missing".
+	Server string `json:"server,omitempty"`
+}
+
+type etcdMemberHealth struct {
+	Name     string `json:"name"`
+	Node     string `json:"node"`
+	Ready    bool   `json:"ready"`
+	Restarts int    `json:"restarts"`
+}
+
+type helmReleaseHealth struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Status    string `json:"status"`
+	Revision  int    `json:"revision,omitempty"`
+	Required  bool   `json:"required,omitempty"`
+}
+
+type clusterCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type panelHealthReport struct {
+	Score    string              `json:"score"`
+	Clusters []clusterHealthView `json:"clusters"`
+}
+
+type clusterHealthView struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	HAIndex int           `json:"haIndex"`
+	Health  clusterHealth `json:"health"`
+}
+
+// healthScorer keeps the worst score seen and why.
+type healthScorer struct {
+	score   string
+	reasons []string
+}
+
+func (s *healthScorer) warn(format string, args ...interface{}) {
+	if s.score != healthRed {
+		s.score = healthYellow
+	}
+	s.reasons = append(s.reasons, fmt.Sprintf(format, args...))
+}
+
+func (s *healthScorer) fail(format string, args ...interface{}) {
+	s.score = healthRed
+	s.reasons = append(s.reasons, fmt.Sprintf(format, args...))
+}
+
+func worseHealth(a, b string) string {
+	rank := map[string]int{healthGreen: 0, healthYellow: 1, healthRed: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func (p *localControlPanel) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizedReadOnly(r) {
+		http.Error(w, "invalid control panel token", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, healthReport(p.discoverClusters()))
+}
+
+func healthReport(clusters []clusterView) panelHealthReport {
+	report := panelHealthReport{Score: healthGreen, Clusters: make([]clusterHealthView, 0, len(clusters))}
+	for _, cluster := range clusters {
+		if cluster.Health == nil {
+			continue
+		}
+		report.Score = worseHealth(report.Score, cluster.Health.Score)
+		report.Clusters = append(report.Clusters, clusterHealthView{
+			ID:      cluster.ID,
+			Name:    cluster.Name,
+			Type:    cluster.Type,
+			HAIndex: cluster.HAIndex,
+			Health:  *cluster.Health,
+		})
+	}
+	return report
+}
+
+// clusterHealthFor scores a reachable cluster. local is the Rancher cluster that
+// owns it, whose management cluster objects hold the cluster conditions.
+func (p *localControlPanel) clusterHealthFor(cluster, local clusterView) *clusterHealth {
+	watcher, err := p.kube.get(cluster.KubeconfigPath, clusterWatchScope(cluster))
+	if err != nil {
+		return unreachableClusterHealth(cluster)
+	}
+
+	managementClusterID := "local"
+	if cluster.Type == "downstream" {
+		managementClusterID = cluster.ManagementClusterID
+	}
+	var conditions []clusterCondition
+	parent, conditionsErr := p.kube.get(local.KubeconfigPath, clusterWatchScope(local))
+	if conditionsErr == nil {
+		conditions, conditionsErr = parent.managementConditions(managementClusterID)
+	}
+
+	health := watcher.health(cluster.Type == "local", conditions, conditionsErr)
+	return &health
+}
+
+// unreachableClusterHealth scores a cluster the panel could not read.
+func unreachableClusterHealth(cluster clusterView) *clusterHealth {
+	scorer := healthScorer{score: healthRed}
+	switch {
+	case cluster.Provisioning:
+		scorer.score = healthYellow
+		scorer.reasons = append(scorer.reasons, cluster.ProvisioningMessage)
+	case cluster.Error != "":
+		scorer.reasons = append(scorer.reasons, cluster.Error)
+	default:
+		scorer.reasons = append(scorer.reasons, "cluster is not reachable")
+	}
+	return &clusterHealth{
+		Score:      scorer.score,
+		Reasons:    scorer.reasons,
+		Nodes:      []nodeHealth{},
+		Etcd:       []etcdMemberHealth{},
+		Releases:   []helmReleaseHealth{},
+		Conditions: []clusterCondition{},
+	}
+}
+
+// health scores one cluster from the watcher's caches. Conditions come
+// from the parent Rancher's management cluster object, so they are passed in.
+func (w *kubeWatcher) health(local bool, conditions []clusterCondition, conditionsErr error) clusterHealth {
+	scorer := healthScorer{score: healthGreen}
+	health := clusterHealth{
+		Nodes:      []nodeHealth{},
+		Etcd:       []etcdMemberHealth{},
+		Releases:   []helmReleaseHealth{},
+		Conditions: []clusterCondition{},
+	}
+
+	apiServers := map[string]bool{}
+	if err := w.cacheError("control plane pods", w.controlPlanePods); err != nil {
+		scorer.warn("%v", err)
+	} else {
+		health.Etcd, apiServers = controlPlaneHealth(w.controlPlanePods.GetStore().List())
+		scoreEtcd(&scorer, health.Etcd)
+	}
+
+	if err := w.cacheError("nodes", w.nodes); err != nil {
+		scorer.warn("%v", err)
+	} else {
+		health.Nodes = nodeHealthList(w.nodes.GetStore().List(), apiServers)
+		scoreNodes(&scorer, health.Nodes)
+	}
+
+	if err := w.cacheError("helm releases", w.helmReleases); err != nil {
+		scorer.warn("%v", err)
+	} else {
+		health.Releases = helmReleaseHealthList(w.helmReleases.GetStore().List(), local)
+		scoreReleases(&scorer, health.Releases)
+	}
+
+	if conditionsErr != nil {
+		scorer.warn("cluster conditions unavailable: %v", conditionsErr)
+	} else if conditions != nil {
+		health.Conditions = conditions
+		scoreConditions(&scorer, conditions)
+	}
+
+	health.Score = scorer.score
+	health.Reasons = scorer.reasons
+	return health
+}
+
+// managementConditions returns the status conditions of a management.cattle.io
+// cluster; "local" is the Rancher cluster itself.
+func (w *kubeWatcher) managementConditions(clusterID string) ([]clusterCondition, error) {
+	if w.management == nil {
+		return nil, fmt.Errorf("this watcher does not track Rancher clusters")
+	}
+	if err := w.cacheError("management clusters", w.management); err != nil {
+		return nil, err
+	}
+	obj, exists, err := w.management.GetStore().GetByKey(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("management cluster %s was not found", clusterID)
+	}
+	item, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("management cluster %s has an unexpected type", clusterID)
+	}
+
+	raw, _, _ := unstructured.NestedSlice(item.Object, "status", "conditions")
+	conditions := make([]clusterCondition, 0, len(raw))
+	for _, entry := range raw {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		condition := clusterCondition{
+			Type:    fmt.Sprint(fields["type"]),
+			Status:  fmt.Sprint(fields["status"]),
+			Reason:  stringField(fields, "reason"),
+			Message: stringField(fields, "message"),
+		}
+		conditions = append(conditions, condition)
+	}
+	sort.Slice(conditions, func(i, j int) bool {
+		return conditions[i].Type < conditions[j].Type
+	})
+	return conditions, nil
+}
+
+func stringField(fields map[string]interface{}, key string) string {
+	value, _ := fields[key].(string)
+	return strings.TrimSpace(value)
+}
+
+// controlPlaneHealth reads RKE2's static pods: etcd members, and which nodes
+// have a ready kube-apiserver.
+func controlPlaneHealth(objects []interface{}) ([]etcdMemberHealth, map[string]bool) {
+	members := []etcdMemberHealth{}
+	apiServers := map[string]bool{}
+	for _, obj := range objects {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			continue
+		}
+		ready := podReady(pod)
+		switch pod.Labels["component"] {
+		case "etcd":
+			restarts := 0
+			for _, status := range pod.Status.ContainerStatuses {
+				restarts += int(status.RestartCount)
+			}
+			members = append(members, etcdMemberHealth{Name: pod.Name, Node: pod.Spec.NodeName, Ready: ready, Restarts: restarts})
+		case "kube-apiserver":
+			apiServers[pod.Spec.NodeName] = apiServers[pod.Spec.NodeName] || ready
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+	return members, apiServers
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func nodeHealthList(objects []interface{}, apiServers map[string]bool) []nodeHealth {
+	nodes := []nodeHealth{}
+	for _, obj := range objects {
+		node, ok := obj.(*corev1.Node)
+		if !ok {
+			continue
+		}
+		health := nodeHealth{
+			Name:          node.Name,
+			Roles:         strings.Join(nodeRoles(node), ","),
+			Version:       node.Status.NodeInfo.KubeletVersion,
+			Unschedulable: node.Spec.Unschedulable,
+		}
+		for _, condition := range node.Status.Conditions {
+			switch {
+			case condition.Type == corev1.NodeReady:
+				health.Ready = condition.Status == corev1.ConditionTrue
+			case condition.Status == corev1.ConditionTrue:
+				// Every other node condition, such as MemoryPressure, is a problem when True.
+				health.Problems = append(health.Problems, string(condition.Type))
+			}
+		}
+		// Clusters without static control-plane pods, such as K3s, skip the server check.
+		if len(apiServers) > 0 && slices.Contains(nodeRoles(node), "control-plane") {
+			switch ready, found := apiServers[node.Name]; {
+			case !found:
+				health.Server = "missing"
+			case ready:
+				health.Server = "ready"
+			default:
+				health.Server = "not ready"
+			}
+		}
+		nodes = append(nodes, health)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
+
+func nodeRoles(node *corev1.Node) []string {
+	roles := []string{}
+	for label := range node.Labels {
+		if role, ok := strings.CutPrefix(label, nodeRoleLabelPrefix); ok && role != "" {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// nodeHealthSignature is what a node update must change to matter to the panel.
+func nodeHealthSignature(obj interface{}) string {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return ""
+	}
+	parts := []string{strings.Join(nodeRoles(node), ","), node.Status.NodeInfo.KubeletVersion, strconv.FormatBool(node.Spec.Unschedulable)}
+	for _, condition := range node.Status.Conditions {
+		parts = append(parts, string(condition.Type)+"="+string(condition.Status))
+	}
+	return strings.Join(parts, "|")
+}
+
+func scoreNodes(scorer *healthScorer, nodes []nodeHealth) {
+	if len(nodes) == 0 {
+		scorer.fail("no nodes are registered")
+		return
+	}
+	serversReady := 0
+	servers := 0
+	for _, node := range nodes {
+		critical := strings.Contains(node.Roles, "control-plane") || strings.Contains(node.Roles, "etcd")
+		switch {
+		case !node.Ready && critical:
+			scorer.fail("node %s is not Ready", node.Name)
+		case !node.Ready:
+			scorer.warn("node %s is not Ready", node.Name)
+		}
+		if node.Unschedulable {
+			scorer.warn("node %s is cordoned", node.Name)
+		}
+		for _, problem := range node.Problems {
+			scorer.warn("node %s reports %s", node.Name, problem)
+		}
+		if node.Server != "" {
+			servers++
+			if node.Server == "ready" {
+				serversReady++
+			} else {
+				scorer.warn("RKE2 server on %s: kube-apiserver %s", node.Name, node.Server)
+			}
+		}
+	}
+	if servers > 0 && serversReady == 0 {
+		scorer.fail("no kube-apiserver is ready")
+	}
+}
+
+func scoreEtcd(scorer *healthScorer, members []etcdMemberHealth) {
+	if len(members) == 0 {
+		return
+	}
+	ready := 0
+	for _, member := range members {
+		if member.Ready {
+			ready++
+		}
+	}
+	quorum := len(members)/2 + 1
+	switch {
+	case ready < quorum:
+		scorer.fail("etcd has %d of %d members ready and has lost quorum", ready, len(members))
+	case ready < len(members):
+		scorer.warn("etcd has %d of %d members ready", ready, len(members))
+	}
+}
+
+// helmReleaseHealthList reports the newest revision of each watched release.
+// Local clusters also list required releases that are missing.
+func helmReleaseHealthList(objects []interface{}, local bool) []helmReleaseHealth {
+	latest := map[string]helmReleaseHealth{}
+	for _, obj := range objects {
+		name, namespace, status, revision, ok := helmReleaseLabels(obj)
+		if !ok {
+			continue
+		}
+		if current, seen := latest[name]; seen && current.Revision >= revision {
+			continue
+		}
+		latest[name] = helmReleaseHealth{Name: name, Namespace: namespace, Status: status, Revision: revision}
+	}
+
+	releases := []helmReleaseHealth{}
+	for _, expected := range panelHelmReleases {
+		release, found := latest[expected.Name]
+		if !found {
+			if !local {
+				continue
+			}
+			release = helmReleaseHealth{Name: expected.Name, Status: "missing"}
+		}
+		release.Required = local && expected.Required
+		releases = append(releases, release)
+	}
+	return releases
+}
+
+func helmReleaseLabels(obj interface{}) (name, namespace, status string, revision int, ok bool) {
+	secret, isSecret := obj.(*corev1.Secret)
+	if !isSecret {
+		return "", "", "", 0, false
+	}
+	revision, _ = strconv.Atoi(secret.Labels["version"])
+	return secret.Labels["name"], secret.Namespace, secret.Labels["status"], revision, secret.Labels["name"] != ""
+}
+
+func helmReleaseStatus(obj interface{}) string {
+	_, _, status, _, _ := helmReleaseLabels(obj)
+	return status
+}
+
+func scoreReleases(scorer *healthScorer, releases []helmReleaseHealth) {
+	for _, release := range releases {
+		switch {
+		case release.Status == "deployed":
+		case release.Status == "failed", release.Status == "missing" && release.Required:
+			scorer.fail("Helm release %s is %s", release.Name, release.Status)
+		default:
+			scorer.warn("Helm release %s is %s", release.Name, release.Status)
+		}
+	}
+}
+
+func scoreConditions(scorer *healthScorer, conditions []clusterCondition) {
+	for _, condition := range conditions {
+		if !slices.Contains(importantClusterConditions, condition.Type) || condition.Status == string(corev1.ConditionTrue) {
+			continue
+		}
+		detail := condition.Message
+		if detail == "" {
+			detail = condition.Reason
+		}
+		if detail != "" {
+			detail = ": " + detail
+		}
+		if condition.Type == "Ready" && condition.Status == string(corev1.ConditionFalse) {
+			scorer.fail("cluster condition Ready is False%s", detail)
+			continue
+		}
+		scorer.warn("cluster condition %s is %s%s", condition.Type, condition.Status, detail)
+	}
+}
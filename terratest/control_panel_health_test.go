@@ -0,0 +1,250 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testNode(name string, ready bool, roles ...string) *corev1.Node {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+	for _, role := range roles {
+		node.Labels[nodeRoleLabelPrefix+role] = "true"
+	}
+	status := corev1.ConditionTrue
+	if !ready {
+		status = corev1.ConditionFalse
+	}
+	node.Status.Conditions = []corev1.NodeCondition{
+		{Type: corev1.NodeReady, Status: status},
+		{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse},
+	}
+	node.Status.NodeInfo.KubeletVersion = "v1.32.13+rke2r1"
+	return node
+}
+
+func testStaticPod(component, node string, ready bool) *corev1.Pod {
+	pod := testPod("kube-system", component+"-"+node, component)
+	pod.Labels = map[string]string{"component": component, "tier": "control-plane"}
+	pod.Spec.NodeName = node
+	status := corev1.ConditionTrue
+	if !ready {
+		status = corev1.ConditionFalse
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+	return pod
+}
+
+func testHelmRelease(namespace, name string, revision int, status string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      "sh.helm.release.v1." + name + ".v" + strconv.Itoa(revision),
+			Labels:    map[string]string{"owner": "helm", "name": name, "status": status, "version": strconv.Itoa(revision)},
+		},
+		Data: map[string][]byte{"release": []byte("payload")},
+	}
+}
+
+func healthyLocalClusterObjects() []runtime.Object {
+	objects := []runtime.Object{testPod("cattle-system", "rancher-0", "rancher")}
+	for _, node := range []string{"server-1", "server-2", "server-3"} {
+		objects = append(objects,
+			testNode(node, true, "control-plane", "etcd", "master"),
+			testStaticPod("etcd", node, true),
+			testStaticPod("kube-apiserver", node, true),
+		)
+	}
+	return append(objects,
+		testHelmRelease("cattle-system", "rancher", 1, "superseded"),
+		testHelmRelease("cattle-system", "rancher", 2, "deployed"),
+		testHelmRelease("cattle-system", "rancher-webhook", 1, "deployed"),
+		testHelmRelease("cattle-fleet-system", "fleet", 1, "deployed"),
+		testHelmRelease("cattle-fleet-local-system", "fleet-agent-local", 1, "deployed"),
+	)
+}
+
+func TestKubeWatcherHealthFromCaches(t *testing.T) {
+	watcher, _ := newFakeKubeWatcher(kubeWatchScope{PodNamespace: "cattle-system", RancherClusters: true}, nil, healthyLocalClusterObjects()...)
+	watcher.start()
+	t.Cleanup(watcher.stop)
+
+	var health clusterHealth
+	waitFor(t, "a green health score", func() bool {
+		health = watcher.health(true, nil, nil)
+		return health.Score == healthGreen
+	})
+	if len(health.Nodes) != 3 || health.Nodes[0].Roles != "control-plane,etcd,master" || health.Nodes[0].Server != "ready" || !health.Nodes[0].Ready {
+		t.Fatalf("unexpected nodes %+v", health.Nodes)
+	}
+	if len(health.Etcd) != 3 || !health.Etcd[0].Ready || health.Etcd[0].Node != "server-1" {
+		t.Fatalf("unexpected etcd members %+v", health.Etcd)
+	}
+	if len(health.Releases) != 4 || health.Releases[0].Revision != 2 || health.Releases[0].Status != "deployed" || !health.Releases[0].Required {
+		t.Fatalf("expected the newest rancher revision first, got %+v", health.Releases)
+	}
+	for _, obj := range watcher.helmReleases.GetStore().List() {
+		if secret := obj.(*corev1.Secret); secret.Data != nil {
+			t.Fatalf("expected release payloads to be dropped from the cache, got %v", secret.Data)
+		}
+	}
+
+	var conditions []clusterCondition
+	var err error
+	waitFor(t, "management cluster conditions", func() bool {
+		conditions, err = watcher.managementConditions("c-m-demo")
+		return err == nil
+	})
+	health = watcher.health(false, conditions, nil)
+	if health.Score != healthYellow || !strings.Contains(strings.Join(health.Reasons, "\n"), "Connected is False: agent disconnected") {
+		t.Fatalf("expected a disconnected cluster to be yellow, got %+v", health)
+	}
+	if _, err := watcher.managementConditions("c-missing"); err == nil {
+		t.Fatal("expected an unknown management cluster to fail")
+	}
+}
+
+func TestClusterHealthScoring(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodes   []nodeHealth
+		etcd    []etcdMemberHealth
+		release []helmReleaseHealth
+		cond    []clusterCondition
+		want    string
+		reason  string
+	}{
+		{
+			name:  "healthy",
+			nodes: []nodeHealth{{Name: "a", Roles: "control-plane,etcd", Ready: true, Server: "ready"}},
+			etcd:  []etcdMemberHealth{{Name: "etcd-a", Ready: true}},
+			want:  healthGreen,
+		},
+		{
+			name:   "worker not ready",
+			nodes:  []nodeHealth{{Name: "a", Roles: "control-plane", Ready: true}, {Name: "w", Roles: "worker"}},
+			want:   healthYellow,
+			reason: "node w is not Ready",
+		},
+		{
+			name:   "server not ready",
+			nodes:  []nodeHealth{{Name: "a", Roles: "control-plane,etcd"}},
+			want:   healthRed,
+			reason: "node a is not Ready",
+		},
+		{
+			name:   "one etcd member down",
+			etcd:   []etcdMemberHealth{{Name: "1", Ready: true}, {Name: "2", Ready: true}, {Name: "3"}},
+			want:   healthYellow,
+			reason: "2 of 3 members ready",
+		},
+		{
+			name:   "etcd quorum lost",
+			etcd:   []etcdMemberHealth{{Name: "1", Ready: true}, {Name: "2"}, {Name: "3"}},
+			want:   healthRed,
+			reason: "lost quorum",
+		},
+		{
+			name:    "required release missing",
+			release: []helmReleaseHealth{{Name: "rancher", Status: "missing", Required: true}},
+			want:    healthRed,
+			reason:  "rancher is missing",
+		},
+		{
+			name:    "release pending",
+			release: []helmReleaseHealth{{Name: "fleet", Status: "pending-upgrade"}},
+			want:    healthYellow,
+			reason:  "fleet is pending-upgrade",
+		},
+		{
+			name:   "cluster not ready",
+			cond:   []clusterCondition{{Type: "Ready", Status: "False", Reason: "Unavailable"}, {Type: "Waiting", Status: "False"}},
+			want:   healthRed,
+			reason: "Ready is False: Unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scorer := healthScorer{score: healthGreen}
+			if tt.nodes != nil {
+				scoreNodes(&scorer, tt.nodes)
+			}
+			scoreEtcd(&scorer, tt.etcd)
+			scoreReleases(&scorer, tt.release)
+			scoreConditions(&scorer, tt.cond)
+			if scorer.score != tt.want || !strings.Contains(strings.Join(scorer.reasons, "\n"), tt.reason) {
+				t.Fatalf("expected %s with %q, got %s %v", tt.want, tt.reason, scorer.score, scorer.reasons)
+			}
+		})
+	}
+}
+
+func TestControlPanelHealthEndpoint(t *testing.T) {
+	t.Chdir(t.TempDir())
+	testDir := t.TempDir()
+	kubeconfigPath := filepath.Join(testDir, "high-availability-1", "kube_config.yaml")
+	if err := os.MkdirAll(filepath.Dir(kubeconfigPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(kubeconfigPath, []byte("apiVersion: v1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	panel := &localControlPanel{
+		token:                     "panel-token",
+		config:                    panelConfig{TotalHAs: 2},
+		testDir:                   testDir,
+		jobs:                      newPanelJobRunner(""),
+		kube:                      newKubeWatchers(),
+		terraformOutputs:          map[string]string{},
+		terraformOutputsAt:        time.Now(),
+		rancherTokens:             map[int]panelRancherToken{},
+		downstreamKubeconfigCache: map[string]string{},
+	}
+	panel.kube.newWatcher = func(_ string, scope kubeWatchScope, notify func()) (*kubeWatcher, error) {
+		watcher, _ := newFakeKubeWatcher(scope, notify, healthyLocalClusterObjects()...)
+		return watcher, nil
+	}
+	t.Cleanup(panel.kube.stopAll)
+
+	var report panelHealthReport
+	byID := map[string]clusterHealthView{}
+	waitFor(t, "the health report", func() bool {
+		rec := httptest.NewRecorder()
+		panel.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/api/health?token=panel-token", nil))
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &report) != nil {
+			return false
+		}
+		for _, cluster := range report.Clusters {
+			byID[cluster.ID] = cluster
+		}
+		return byID["ha-1-local"].Health.Score == healthGreen
+	})
+
+	if local := byID["ha-1-local"]; len(local.Health.Nodes) != 3 || len(local.Health.Releases) != 4 {
+		t.Fatalf("unexpected HA 1 health %+v", local)
+	}
+	if downstream := byID["ha-1-downstream-fleet-default-demo"]; downstream.Type != "downstream" || downstream.Health.Score != healthYellow {
+		t.Fatalf("expected the downstream cluster waiting for a kubeconfig to be yellow, got %+v", downstream)
+	}
+	if missing := byID["ha-2-local"]; missing.Health.Score != healthRed || report.Score != healthRed {
+		t.Fatalf("expected HA 2 without a kubeconfig to make the report red, got %+v", report)
+	}
+
+	rec := httptest.NewRecorder()
+	panel.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected the health endpoint to require the token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,585 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+)
+
+const (
+	panelJobRunning   = "running"
+	panelJobSucceeded = "succeeded"
+	panelJobFailed    = "failed"
+	panelJobCanceled  = "canceled"
+
+	panelJobOutputLimit  = 500
+	panelJobHistoryLimit = 20
+)
+
+// panelJobAction is one guarded lifecycle test the control panel can launch.
+// Inputs are passed to the test through the same environment variables the
+// workflows use.
+type panelJobAction struct {
+	Name     string          `json:"name"`
+	Label    string          `json:"label"`
+	TestName string          `json:"testName"`
+	Timeout  string          `json:"timeout"`
+	Confirm  string          `json:"confirm,omitempty"`
+	Inputs   []panelJobInput `json:"inputs,omitempty"`
+}
+
+type panelJobInput struct {
+	Name     string   `json:"name"`
+	Label    string   `json:"label"`
+	Env      string   `json:"env"`
+	Required bool     `json:"required"`
+	Options  []string `json:"options,omitempty"`
+}
+
+var panelJobActions = []panelJobAction{
+	{Name: "wait-ready", Label: "Wait for Rancher ready", TestName: "TestHAWaitReady", Timeout: "30m"},
+	{
+		Name: "upgrade-rancher", Label: "Upgrade Rancher", TestName: "TestHAUpgradeRancher", Timeout: "60m",
+		Inputs: []panelJobInput{{Name: "version", Label: "Rancher version", Env: "RANCHER_UPGRADE_VERSION", Required: true}},
+	},
+	{Name: "provision-linode-downstream", Label: "Provision Linode downstream", TestName: "TestHAProvisionLinodeDownstream", Timeout: "60m"},
+	{Name: "delete-linode-downstream", Label: "Delete Linode downstream", TestName: "TestHADeleteLinodeDownstream", Timeout: "30m", Confirm: "delete"},
+	{
+		Name: "override-local-webhook", Label: "Override local webhook", TestName: "TestHAOverrideLocalWebhook", Timeout: "30m",
+		Inputs: []panelJobInput{{Name: "image", Label: "Webhook image", Env: "RANCHER_WEBHOOK_IMAGE", Required: true}},
+	},
+	{Name: "cleanup", Label: "Cleanup", TestName: "TestHACleanup", Timeout: "20m", Confirm: "cleanup"},
+}
+
+type panelJob struct {
+	id         int
+	action     panelJobAction
+	inputs     map[string]string
+	workspace  string
+	status     string
+	startedAt  time.Time
+	finishedAt *time.Time
+	err        string
+	output     []string
+	dropped    int
+	changed    chan struct{}
+	cancel     context.CancelFunc
+}
+
+type panelJobView struct {
+	ID         int               `json:"id"`
+	Action     string            `json:"action"`
+	Label      string            `json:"label"`
+	Command    string            `json:"command"`
+	Inputs     map[string]string `json:"inputs,omitempty"`
+	Workspace  string            `json:"workspace"`
+	Status     string            `json:"status"`
+	StartedAt  time.Time         `json:"startedAt"`
+	FinishedAt *time.Time        `json:"finishedAt,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Output     []string          `json:"output,omitempty"`
+}
+
+type panelJobsState struct {
+	Actions []panelJobAction `json:"actions"`
+	Jobs    []panelJobView   `json:"jobs"`
+}
+
+type panelJobRunner struct {
+	repoRoot string
+	// command builds the process for a job; tests replace it with a helper.
+	command func(ctx context.Context, action panelJobAction, inputs map[string]string) *exec.Cmd
+
+	mu      sync.Mutex
+	nextID  int
+	jobs    []*panelJob
+	changed chan struct{}
+}
+
+func newPanelJobRunner(repoRoot string) *panelJobRunner {
+	runner := &panelJobRunner{repoRoot: repoRoot, changed: make(chan struct{})}
+	runner.command = runner.goTestCommand
+	return runner
+}
+
+func panelJobActionByName(name string) (panelJobAction, bool) {
+	for _, action := range panelJobActions {
+		if action.Name == name {
+			return action, true
+		}
+	}
+	return panelJobAction{}, false
+}
+
+func (a panelJobAction) commandLine() string {
+	return fmt.Sprintf("go test -v -run ^%s$ -timeout %s ./terratest", a.TestName, a.Timeout)
+}
+
+func (r *panelJobRunner) goTestCommand(ctx context.Context, action panelJobAction, inputs map[string]string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "go", "test", "-v", "-run", "^"+action.TestName+"$", "-timeout", action.Timeout, "./terratest")
+	cmd.Dir = r.repoRoot
+	cmd.Env = append(os.Environ(), workspaceEnvVar+"="+activeWorkspace())
+	for _, input := range action.Inputs {
+		if value := inputs[input.Name]; value != "" {
+			cmd.Env = append(cmd.Env, input.Env+"="+value)
+		}
+	}
+	configurePanelJobProcess(cmd)
+	return cmd
+}
+
+func (r *panelJobRunner) start(actionName, confirm string, inputs map[string]string) (panelJobView, error) {
+	action, ok := panelJobActionByName(actionName)
+	if !ok {
+		return panelJobView{}, fmt.Errorf("unknown control panel action %q", actionName)
+	}
+	if action.Confirm != "" && !strings.EqualFold(strings.TrimSpace(confirm), action.Confirm) {
+		return panelJobView{}, fmt.Errorf("typed confirmation must equal %s", action.Confirm)
+	}
+	cleaned := map[string]string{}
+	for _, input := range action.Inputs {
+		value := strings.TrimSpace(inputs[input.Name])
+		if value == "" && input.Required {
+			return panelJobView{}, fmt.Errorf("%s is required for %s", input.Label, action.Label)
+		}
+		if value != "" {
+			cleaned[input.Name] = value
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Every lifecycle step works on the same Terraform state, so only one runs at a time.
+	if running := r.runningJobLocked(); running != nil {
+		return panelJobView{}, panelJobConflictError{running: running.action.Label}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.nextID++
+	workspace := workspaceLabel(activeWorkspace())
+	job := &panelJob{
+		id:        r.nextID,
+		action:    action,
+		inputs:    cleaned,
+		workspace: workspace,
+		status:    panelJobRunning,
+		startedAt: time.Now(),
+		output:    []string{fmt.Sprintf("[control-panel] Starting %s in workspace %s via %s", action.Label, workspace, action.commandLine())},
+		changed:   make(chan struct{}),
+		cancel:    cancel,
+	}
+	r.jobs = append(r.jobs, job)
+	if len(r.jobs) > panelJobHistoryLimit {
+		r.jobs = slices.Delete(r.jobs, 0, len(r.jobs)-panelJobHistoryLimit)
+	}
+
+	r.notifyLocked()
+	go r.run(ctx, job)
+	return job.view(true), nil
+}
+
+type panelJobConflictError struct {
+	running string
+}
+
+func (e panelJobConflictError) Error() string {
+	return fmt.Sprintf("%s is already running", e.running)
+}
+
+func (r *panelJobRunner) run(ctx context.Context, job *panelJob) {
+	cmd := r.command(ctx, job.action, job.inputs)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		r.finish(job, fmt.Errorf("failed to capture %s output: %w", job.action.Label, err))
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		r.finish(job, fmt.Errorf("failed to capture %s output: %w", job.action.Label, err))
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		r.finish(job, fmt.Errorf("failed to start %s: %w", job.action.Label, err))
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go r.capture(&wg, job, stdout)
+	go r.capture(&wg, job, stderr)
+	wg.Wait()
+
+	r.finish(job, cmd.Wait())
+}
+
+func (r *panelJobRunner) capture(wg *sync.WaitGroup, job *panelJob, reader io.Reader) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		r.appendOutput(job, scanner.Text())
+	}
+}
+
+func (r *panelJobRunner) appendOutput(job *panelJob, lines ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appendOutputLocked(job, lines...)
+}
+
+func (r *panelJobRunner) appendOutputLocked(job *panelJob, lines ...string) {
+	job.output = append(job.output, lines...)
+	if overflow := len(job.output) - panelJobOutputLimit; overflow > 0 {
+		job.output = append([]string(nil), job.output[overflow:]...)
+		job.dropped += overflow
+	}
+	close(job.changed)
+	job.changed = make(chan struct{})
+	r.notifyLocked()
+}
+
+func (r *panelJobRunner) notifyLocked() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// changes returns a channel closed the next time any job starts, prints
+// output, or finishes.
+func (r *panelJobRunner) changes() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.changed
+}
+
+func (r *panelJobRunner) finish(job *panelJob, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	finishedAt := time.Now()
+	job.finishedAt = &finishedAt
+	line := fmt.Sprintf("[control-panel] %s completed successfully", job.action.Label)
+	switch {
+	case job.status == panelJobCanceled:
+		line = fmt.Sprintf("[control-panel] %s was canceled", job.action.Label)
+	case err != nil:
+		job.status = panelJobFailed
+		job.err = err.Error()
+		line = fmt.Sprintf("[control-panel] %s finished with error: %v", job.action.Label, err)
+	default:
+		job.status = panelJobSucceeded
+	}
+	job.cancel()
+	r.appendOutputLocked(job, line)
+}
+
+func (r *panelJobRunner) cancel(id int) (panelJobView, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := r.jobLocked(id)
+	if job == nil {
+		return panelJobView{}, fmt.Errorf("job %d was not found", id)
+	}
+	if job.status != panelJobRunning {
+		return panelJobView{}, fmt.Errorf("job %d is not running", id)
+	}
+	job.status = panelJobCanceled
+	job.err = "canceled from the control panel"
+	job.cancel()
+	r.notifyLocked()
+	return job.view(false), nil
+}
+
+func (r *panelJobRunner) snapshot(includeOutput bool) []panelJobView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	views := make([]panelJobView, 0, len(r.jobs))
+	for i := len(r.jobs) - 1; i >= 0; i-- {
+		views = append(views, r.jobs[i].view(includeOutput))
+	}
+	return views
+}
+
+func (r *panelJobRunner) job(id int) (panelJobView, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := r.jobLocked(id)
+	if job == nil {
+		return panelJobView{}, false
+	}
+	return job.view(true), true
+}
+
+func (r *panelJobRunner) running() (panelJobView, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job := r.runningJobLocked(); job != nil {
+		return job.view(false), true
+	}
+	return panelJobView{}, false
+}
+
+// latest returns the newest job for an action, used to keep the cleanup card
+// and cluster view in sync with the job history.
+func (r *panelJobRunner) latest(actionName string) (panelJobView, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.jobs) - 1; i >= 0; i-- {
+		if r.jobs[i].action.Name == actionName {
+			return r.jobs[i].view(true), true
+		}
+	}
+	return panelJobView{}, false
+}
+
+// follow returns output lines after cursor, the cursor for the next call, a
+// channel closed when more output arrives, and whether the job has finished.
+func (r *panelJobRunner) follow(id, cursor int) ([]string, int, <-chan struct{}, bool, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := r.jobLocked(id)
+	if job == nil {
+		return nil, cursor, nil, false, false
+	}
+	start := max(cursor-job.dropped, 0)
+	lines := append([]string(nil), job.output[min(start, len(job.output)):]...)
+	next := job.dropped + len(job.output)
+	return lines, next, job.changed, job.finishedAt != nil, true
+}
+
+func (r *panelJobRunner) runningJobLocked() *panelJob {
+	for _, job := range r.jobs {
+		if job.status == panelJobRunning {
+			return job
+		}
+	}
+	return nil
+}
+
+func (r *panelJobRunner) jobLocked(id int) *panelJob {
+	for _, job := range r.jobs {
+		if job.id == id {
+			return job
+		}
+	}
+	return nil
+}
+
+func (j *panelJob) view(includeOutput bool) panelJobView {
+	view := panelJobView{
+		ID:         j.id,
+		Action:     j.action.Name,
+		Label:      j.action.Label,
+		Command:    j.action.commandLine(),
+		Inputs:     j.inputs,
+		Workspace:  j.workspace,
+		Status:     j.status,
+		StartedAt:  j.startedAt,
+		FinishedAt: j.finishedAt,
+		Error:      j.err,
+	}
+	if includeOutput {
+		view.Output = append([]string{}, j.output...)
+	}
+	return view
+}
+
+func (p *localControlPanel) jobActions() []panelJobAction {
+	actions := slices.Clone(panelJobActions)
+	for i, action := range actions {
+		if action.Name != "upgrade-rancher" {
+			continue
+		}
+		inputs := slices.Clone(action.Inputs)
+		inputs[0].Options = p.upgradeVersionOptions()
+		actions[i].Inputs = inputs
+	}
+	return actions
+}
+
+// upgradeVersionOptions lists released Rancher charts from the local Helm repo
+// cache for the version picker. It is looked up once per panel session.
+func (p *localControlPanel) upgradeVersionOptions() []string {
+	p.upgradeVersionsOnce.Do(func() {
+		results, err := resolver.SearchAllHelmRepoVersions()
+		if err != nil {
+			return
+		}
+		seen := map[string]bool{}
+		for _, result := range results {
+			if !strings.HasSuffix(result.Name, "/rancher") || strings.Contains(result.Version, "-") || seen[result.Version] {
+				continue
+			}
+			seen[result.Version] = true
+			p.upgradeVersions = append(p.upgradeVersions, result.Version)
+		}
+		slices.SortFunc(p.upgradeVersions, func(a, b string) int {
+			left, leftErr := resolver.ParseRancherVersion(a)
+			right, rightErr := resolver.ParseRancherVersion(b)
+			if leftErr != nil || rightErr != nil {
+				return strings.Compare(b, a)
+			}
+			return right.Compare(left)
+		})
+		if len(p.upgradeVersions) > 25 {
+			p.upgradeVersions = p.upgradeVersions[:25]
+		}
+	})
+	return p.upgradeVersions
+}
+
+func (p *localControlPanel) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !p.authorizedReadOnly(r) {
+			http.Error(w, "invalid control panel token", http.StatusForbidden)
+			return
+		}
+		if rawID := strings.TrimSpace(r.URL.Query().Get("id")); rawID != "" {
+			id, err := strconv.Atoi(rawID)
+			if err != nil {
+				http.Error(w, "id must be a job number", http.StatusBadRequest)
+				return
+			}
+			job, ok := p.jobs.job(id)
+			if !ok {
+				http.Error(w, fmt.Sprintf("job %d was not found", id), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, job)
+			return
+		}
+		writeJSON(w, panelJobsState{Actions: p.jobActions(), Jobs: p.jobs.snapshot(true)})
+	case http.MethodPost:
+		if !p.authorizedLocalAction(r) {
+			http.Error(w, "invalid control panel token", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Action  string            `json:"action"`
+			Confirm string            `json:"confirm"`
+			Inputs  map[string]string `json:"inputs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		job, err := p.jobs.start(req.Action, req.Confirm, req.Inputs)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.As(err, &panelJobConflictError{}) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, job)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *localControlPanel) handleJobCancel(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizedLocalAction(r) {
+		http.Error(w, "invalid control panel token", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get("id")))
+	if err != nil {
+		http.Error(w, "id must be a job number", http.StatusBadRequest)
+		return
+	}
+	job, err := p.jobs.cancel(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, job)
+}
+
+// handleJobStream replays a job's buffered output and then follows it as
+// server-sent events until the job finishes or the browser disconnects.
+func (p *localControlPanel) handleJobStream(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizedReadOnly(r) {
+		http.Error(w, "invalid control panel token", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get("id")))
+	if err != nil {
+		http.Error(w, "id must be a job number", http.StatusBadRequest)
+		return
+	}
+	if _, ok := p.jobs.job(id); !ok {
+		http.Error(w, fmt.Sprintf("job %d was not found", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	sendEvent := func(eventName, data string) {
+		fmt.Fprintf(w, "event: %s\n", eventName)
+		fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(data, "\n", "\\n"))
+	}
+
+	cursor := 0
+	for {
+		lines, next, changed, finished, _ := p.jobs.follow(id, cursor)
+		cursor = next
+		for _, line := range lines {
+			sendEvent("line", line)
+		}
+		flusher.Flush()
+
+		if finished {
+			job, _ := p.jobs.job(id)
+			job.Output = nil
+			status, _ := json.Marshal(job)
+			sendEvent("status", string(status))
+			sendEvent("end", "job finished")
+			flusher.Flush()
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
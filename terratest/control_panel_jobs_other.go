@@ -0,0 +1,12 @@
+//go:build !unix
+
+package test
+
+import (
+	"os/exec"
+	"time"
+)
+
+func configurePanelJobProcess(cmd *exec.Cmd) {
+	cmd.WaitDelay = 30 * time.Second
+}
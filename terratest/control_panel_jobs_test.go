@@ -0,0 +1,154 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPanelJobHelperProcess(t *testing.T) {
+	mode := os.Getenv("PANEL_JOB_HELPER")
+	if mode == "" {
+		return
+	}
+	fmt.Println("helper output for " + os.Getenv("RANCHER_UPGRADE_VERSION"))
+	fmt.Fprintln(os.Stderr, "helper stderr")
+	switch mode {
+	case "sleep":
+		time.Sleep(30 * time.Second)
+	case "fail":
+		os.Exit(3)
+	}
+	os.Exit(0)
+}
+
+func newHelperPanelJobRunner(mode string) *panelJobRunner {
+	runner := newPanelJobRunner("")
+	runner.command = func(ctx context.Context, action panelJobAction, inputs map[string]string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestPanelJobHelperProcess$")
+		cmd.Env = append(os.Environ(), "PANEL_JOB_HELPER="+mode, "RANCHER_UPGRADE_VERSION="+inputs["version"])
+		return cmd
+	}
+	return runner
+}
+
+func waitForPanelJob(t *testing.T, runner *panelJobRunner, id int) panelJobView {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := runner.job(id); ok && job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("job %d did not finish", id)
+	return panelJobView{}
+}
+
+func TestPanelJobRunnerRecordsHistory(t *testing.T) {
+	runner := newHelperPanelJobRunner("ok")
+
+	if _, err := runner.start("upgrade-rancher", "", nil); err == nil || !strings.Contains(err.Error(), "Rancher version is required") {
+		t.Fatalf("expected required input error, got %v", err)
+	}
+	if _, err := runner.start("cleanup", "nope", nil); err == nil || !strings.Contains(err.Error(), "must equal cleanup") {
+		t.Fatalf("expected confirmation error, got %v", err)
+	}
+
+	started, err := runner.start("upgrade-rancher", "", map[string]string{"version": " 2.13.4 "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job := waitForPanelJob(t, runner, started.ID)
+	output := strings.Join(job.Output, "\n")
+	if job.Status != panelJobSucceeded || !strings.Contains(output, "helper output for 2.13.4") || !strings.Contains(output, "helper stderr") {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	if !strings.Contains(output, "go test -v -run ^TestHAUpgradeRancher$ -timeout 60m ./terratest") {
+		t.Fatalf("expected the go test command in output:\n%s", output)
+	}
+
+	runner.command = newHelperPanelJobRunner("fail").command
+	failed, err := runner.start("wait-ready", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := waitForPanelJob(t, runner, failed.ID); job.Status != panelJobFailed || job.Error == "" {
+		t.Fatalf("expected failed job, got %+v", job)
+	}
+
+	history := runner.snapshot(false)
+	if len(history) != 2 || history[0].ID != failed.ID || history[0].Output != nil {
+		t.Fatalf("expected newest-first history without output, got %+v", history)
+	}
+}
+
+func TestPanelJobRunnerCancelsRunningJob(t *testing.T) {
+	runner := newHelperPanelJobRunner("sleep")
+
+	started, err := runner.start("wait-ready", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.start("cleanup", "cleanup", nil); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Fatalf("expected a conflict while a job is running, got %v", err)
+	}
+	if _, err := runner.cancel(started.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	job := waitForPanelJob(t, runner, started.ID)
+	if job.Status != panelJobCanceled || !strings.Contains(strings.Join(job.Output, "\n"), "was canceled") {
+		t.Fatalf("expected canceled job, got %+v", job)
+	}
+	if _, err := runner.cancel(started.ID); err == nil {
+		t.Fatal("expected canceling a finished job to fail")
+	}
+}
+
+func TestControlPanelJobEndpoints(t *testing.T) {
+	panel := &localControlPanel{token: "panel-token", jobs: newHelperPanelJobRunner("ok")}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/jobs?token=panel-token", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		panel.handleJobs(rec, req)
+		return rec
+	}
+	if rec := post(`{"action":"cleanup","confirm":"no"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected bad request without confirmation, got %d", rec.Code)
+	}
+	if rec := post(`{"action":"reboot"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected bad request for an unknown action, got %d", rec.Code)
+	}
+	rec := post(`{"action":"cleanup","confirm":"cleanup"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"action": "cleanup"`) {
+		t.Fatalf("expected cleanup job to start, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	stream := httptest.NewRecorder()
+	panel.handleJobStream(stream, httptest.NewRequest(http.MethodGet, "/api/jobs/stream?token=panel-token&id=1", nil))
+	body := stream.Body.String()
+	for _, want := range []string{"event: line\ndata: [control-panel] Starting Cleanup", "data: helper output", "event: status", "event: end"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in stream:\n%s", want, body)
+		}
+	}
+
+	cleanup := panel.snapshotCleanupState()
+	if cleanup.Running || cleanup.FinishedAt == nil || cleanup.Error != "" {
+		t.Fatalf("expected finished cleanup state, got %+v", cleanup)
+	}
+
+	missing := httptest.NewRecorder()
+	panel.handleJobStream(missing, httptest.NewRequest(http.MethodGet, "/api/jobs/stream?token=panel-token&id=9", nil))
+	if missing.Code != http.StatusNotFound {
+		t.Fatalf("expected missing job to 404, got %d", missing.Code)
+	}
+}
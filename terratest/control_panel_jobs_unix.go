@@ -0,0 +1,19 @@
+//go:build unix
+
+package test
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// configurePanelJobProcess runs go test in its own process group so canceling
+// a job interrupts the test binary too, not just the go command wrapping it.
+func configurePanelJobProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+	}
+	cmd.WaitDelay = 30 * time.Second
+}
@@ -0,0 +1,681 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	kubeWatcherSyncTimeout  = 5 * time.Second
+	kubeRequestTimeout      = 10 * time.Second
+	panelLogTailLines       = 200
+	panelLogStreamTailLines = 20
+)
+
+var (
+	provisioningClustersResource = schema.GroupVersionResource{Group: "provisioning.cattle.io", Version: "v1", Resource: "clusters"}
+	managementClustersResource   = schema.GroupVersionResource{Group: "management.cattle.io", Version: "v3", Resource: "clusters"}
+)
+
+// panelLeaderLeases are the leases whose holder gets a leader badge in the pod table.
+var panelLeaderLeases = []struct {
+	Namespace string
+	Name      string
+	Label     string
+}{
+	{Namespace: "kube-system", Name: "cattle-controllers", Label: "Leader"},
+	{Namespace: "cattle-system", Name: "rancher-webhook-leader", Label: "Webhook Leader"},
+}
+
+// kubeWatchScope is what a watcher caches. Local clusters only show
+// cattle-system pods but also list Rancher's downstream clusters; downstream
+// clusters show every pod.
+type kubeWatchScope struct {
+	PodNamespace    string
+	RancherClusters bool
+}
+
+// kubeWatcher keeps informer caches for one kubeconfig, so the panel reads
+// pods, leases, nodes, Helm releases and Rancher clusters from memory instead
+// of the API server.
+type kubeWatcher struct {
+	clientset kubernetes.Interface
+	scope     kubeWatchScope
+	modTime   time.Time
+	notify    func()
+	stopCh    chan struct{}
+	stopOnce  sync.Once
+
+	pods             cache.SharedIndexInformer
+	leases           cache.SharedIndexInformer
+	nodes            cache.SharedIndexInformer
+	controlPlanePods cache.SharedIndexInformer
+	helmReleases     cache.SharedIndexInformer
+	provisioning     cache.SharedIndexInformer
+	management       cache.SharedIndexInformer
+
+	mu       sync.Mutex
+	lastErrs map[string]error
+}
+
+func newKubeWatcher(clientset kubernetes.Interface, dynamicClient dynamic.Interface, scope kubeWatchScope, notify func()) *kubeWatcher {
+	w := &kubeWatcher{
+		clientset: clientset,
+		scope:     scope,
+		notify:    notify,
+		stopCh:    make(chan struct{}),
+		lastErrs:  map[string]error{},
+	}
+
+	pods := clientset.CoreV1().Pods(scope.PodNamespace)
+	w.pods = w.newInformer("pods", &corev1.Pod{},
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return pods.List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return pods.Watch(ctx, options)
+		},
+		func(_, _ interface{}) bool { return true },
+	)
+
+	leases := clientset.CoordinationV1().Leases(metav1.NamespaceAll)
+	w.leases = w.newInformer("leases", &coordinationv1.Lease{},
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return leases.List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return leases.Watch(ctx, options)
+		},
+		// Leases renew every few seconds; only a new holder changes what the panel shows.
+		func(oldObj, newObj interface{}) bool {
+			return leaseHolder(oldObj) != leaseHolder(newObj)
+		},
+	)
+
+	nodes := clientset.CoreV1().Nodes()
+	w.nodes = w.newInformer("nodes", &corev1.Node{},
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return nodes.List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return nodes.Watch(ctx, options)
+		},
+		// Kubelets post heartbeats constantly; only what the health view shows counts.
+		func(oldObj, newObj interface{}) bool {
+			return nodeHealthSignature(oldObj) != nodeHealthSignature(newObj)
+		},
+	)
+
+	// RKE2 runs etcd and the API server as static pods labelled tier=control-plane.
+	staticPods := clientset.CoreV1().Pods("kube-system")
+	w.controlPlanePods = w.newInformer("control plane pods", &corev1.Pod{},
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = controlPlanePodSelector
+			return staticPods.List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = controlPlanePodSelector
+			return staticPods.Watch(ctx, options)
+		},
+		func(_, _ interface{}) bool { return true },
+	)
+
+	secrets := clientset.CoreV1().Secrets(metav1.NamespaceAll)
+	w.helmReleases = w.newInformer("helm releases", &corev1.Secret{},
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = helmReleaseSelector
+			return secrets.List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = helmReleaseSelector
+			return secrets.Watch(ctx, options)
+		},
+		func(oldObj, newObj interface{}) bool {
+			return helmReleaseStatus(oldObj) != helmReleaseStatus(newObj)
+		},
+	)
+	// Only the labels are read; dropping the release payload keeps the cache small.
+	_ = w.helmReleases.SetTransform(func(obj interface{}) (interface{}, error) {
+		if secret, ok := obj.(*corev1.Secret); ok {
+			secret.Data = nil
+			secret.StringData = nil
+		}
+		return obj, nil
+	})
+
+	if scope.RancherClusters && dynamicClient != nil {
+		w.provisioning = w.newDynamicInformer("provisioning clusters", dynamicClient.Resource(provisioningClustersResource))
+		w.management = w.newDynamicInformer("management clusters", dynamicClient.Resource(managementClustersResource))
+	}
+	return w
+}
+
+// newInformer wraps list and watch so a successful list clears the error the
+// watch handler recorded, which is how the panel notices a cluster recovering.
+func (w *kubeWatcher) newInformer(name string, example runtime.Object, list cache.ListWithContextFunc, watchFunc cache.WatchFuncWithContext, changed func(oldObj, newObj interface{}) bool) cache.SharedIndexInformer {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListWithContextFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			obj, err := list(ctx, options)
+			w.recordError(name, err)
+			return obj, err
+		},
+		WatchFuncWithContext: watchFunc,
+	}, example, 0, cache.Indexers{})
+
+	_ = informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		if errors.Is(err, io.EOF) || apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+			return
+		}
+		w.recordError(name, err)
+	})
+	_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { w.changed() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if changed(oldObj, newObj) {
+				w.changed()
+			}
+		},
+		DeleteFunc: func(interface{}) { w.changed() },
+	})
+	return informer
+}
+
+func (w *kubeWatcher) newDynamicInformer(name string, resource dynamic.NamespaceableResourceInterface) cache.SharedIndexInformer {
+	return w.newInformer(name, &unstructured.Unstructured{},
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return resource.List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return resource.Watch(ctx, options)
+		},
+		func(_, _ interface{}) bool { return true },
+	)
+}
+
+func (w *kubeWatcher) start() {
+	for _, informer := range w.informers() {
+		go informer.Run(w.stopCh)
+	}
+}
+
+func (w *kubeWatcher) stop() {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+}
+
+// waitForSync waits for pods and leases; Rancher cluster caches are allowed to
+// lag because their CRDs may not exist yet on a fresh install.
+func (w *kubeWatcher) waitForSync(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return cache.WaitForCacheSync(ctx.Done(), w.pods.HasSynced, w.leases.HasSynced)
+}
+
+func (w *kubeWatcher) informers() []cache.SharedIndexInformer {
+	informers := []cache.SharedIndexInformer{w.pods, w.leases, w.nodes, w.controlPlanePods, w.helmReleases}
+	if w.provisioning != nil {
+		informers = append(informers, w.provisioning, w.management)
+	}
+	return informers
+}
+
+func (w *kubeWatcher) changed() {
+	if w.notify != nil {
+		w.notify()
+	}
+}
+
+func (w *kubeWatcher) recordError(name string, err error) {
+	w.mu.Lock()
+	previous := w.lastErrs[name]
+	if err == nil {
+		delete(w.lastErrs, name)
+	} else {
+		w.lastErrs[name] = err
+	}
+	w.mu.Unlock()
+
+	if (previous == nil) != (err == nil) {
+		w.changed()
+	}
+}
+
+// cacheError reports why an informer's cache cannot be trusted right now.
+func (w *kubeWatcher) cacheError(name string, informer cache.SharedIndexInformer) error {
+	w.mu.Lock()
+	err := w.lastErrs[name]
+	w.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", name, err)
+	}
+	if !informer.HasSynced() {
+		return fmt.Errorf("waiting for the %s cache to sync", name)
+	}
+	return nil
+}
+
+// podViews returns the cached pods that match keep, sorted by name, with the
+// leader badges applied.
+func (w *kubeWatcher) podViews(keep func(name string) bool) ([]podView, error) {
+	if err := w.cacheError("pods", w.pods); err != nil {
+		return nil, err
+	}
+
+	leaderLabels := leaderLabelsFromLeases(func(namespace, name string) *coordinationv1.Lease {
+		obj, exists, err := w.leases.GetStore().GetByKey(namespace + "/" + name)
+		if err != nil || !exists {
+			return nil
+		}
+		lease, _ := obj.(*coordinationv1.Lease)
+		return lease
+	})
+
+	pods := make([]podView, 0)
+	for _, obj := range w.pods.GetStore().List() {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || (keep != nil && !keep(pod.Name)) {
+			continue
+		}
+		pods = append(pods, podViewFromPod(pod, leaderLabels))
+	}
+	sortPodViews(pods)
+	return pods, nil
+}
+
+// downstreamClusters lists Rancher's provisioning clusters and adds management
+// clusters that have no provisioning object, such as imported clusters.
+func (w *kubeWatcher) downstreamClusters() ([]discoveredDownstreamCluster, error) {
+	if w.provisioning == nil {
+		return nil, errors.New("this watcher does not track Rancher clusters")
+	}
+	if err := w.cacheError("provisioning clusters", w.provisioning); err != nil {
+		return nil, err
+	}
+
+	clusters := make([]discoveredDownstreamCluster, 0)
+	for _, obj := range w.provisioning.GetStore().List() {
+		item, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		name := strings.TrimSpace(item.GetName())
+		namespace := strings.TrimSpace(item.GetNamespace())
+		if name == "" || namespace == "" {
+			continue
+		}
+		if name == "local" || namespace == "local" {
+			continue
+		}
+		managementClusterID, _, _ := unstructured.NestedString(item.Object, "status", "clusterName")
+		clusters = append(clusters, discoveredDownstreamCluster{
+			Name:                name,
+			Namespace:           namespace,
+			ManagementClusterID: strings.TrimSpace(managementClusterID),
+		})
+	}
+
+	if w.cacheError("management clusters", w.management) == nil {
+		seenManagementIDs := map[string]bool{}
+		for _, cluster := range clusters {
+			if cluster.ManagementClusterID != "" {
+				seenManagementIDs[cluster.ManagementClusterID] = true
+			}
+		}
+		for _, obj := range w.management.GetStore().List() {
+			item, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			clusterID := strings.TrimSpace(item.GetName())
+			if clusterID == "" || clusterID == "local" || seenManagementIDs[clusterID] {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(item.Object, "spec", "displayName")
+			name = strings.TrimSpace(name)
+			if name == "" {
+				name = clusterID
+			}
+			clusters = append(clusters, discoveredDownstreamCluster{
+				Name:                name,
+				ManagementClusterID: clusterID,
+			})
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		left := provisioningClusterRecordKey(clusters[i].Namespace, clusters[i].Name)
+		right := provisioningClusterRecordKey(clusters[j].Namespace, clusters[j].Name)
+		return left < right
+	})
+	return clusters, nil
+}
+
+// kubeWatchers hands out one watcher per kubeconfig and broadcasts when any
+// cached object changes, so state streams can push a fresh snapshot.
+type kubeWatchers struct {
+	// newWatcher builds a watcher for a kubeconfig; tests replace it with fake clients.
+	newWatcher func(kubeconfigPath string, scope kubeWatchScope, notify func()) (*kubeWatcher, error)
+
+	mu       sync.Mutex
+	watchers map[string]*kubeWatcher
+	changed  chan struct{}
+	closed   chan struct{}
+	isClosed bool
+}
+
+func newKubeWatchers() *kubeWatchers {
+	return &kubeWatchers{
+		newWatcher: newKubeconfigWatcher,
+		watchers:   map[string]*kubeWatcher{},
+		changed:    make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+}
+
+func newKubeconfigWatcher(kubeconfigPath string, scope kubeWatchScope, notify func()) (*kubeWatcher, error) {
+	clientset, dynamicClient, err := newKubeClients(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return newKubeWatcher(clientset, dynamicClient, scope, notify), nil
+}
+
+func newKubeClients(kubeconfigPath string) (*kubernetes.Clientset, *dynamic.DynamicClient, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load kubeconfig %s: %w", kubeconfigPath, err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Kubernetes client for %s: %w", kubeconfigPath, err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Kubernetes client for %s: %w", kubeconfigPath, err)
+	}
+	return clientset, dynamicClient, nil
+}
+
+// get returns the running watcher for a kubeconfig, starting one on first use.
+// A rewritten kubeconfig, such as after a rebuild, replaces the old watcher.
+func (k *kubeWatchers) get(kubeconfigPath string, scope kubeWatchScope) (*kubeWatcher, error) {
+	info, err := os.Stat(kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig not found: %w", err)
+	}
+
+	k.mu.Lock()
+	if k.isClosed {
+		k.mu.Unlock()
+		return nil, errors.New("control panel is shutting down")
+	}
+	existing := k.watchers[kubeconfigPath]
+	if existing != nil && existing.scope == scope && existing.modTime.Equal(info.ModTime()) {
+		k.mu.Unlock()
+		return existing, nil
+	}
+	if existing != nil {
+		existing.stop()
+		delete(k.watchers, kubeconfigPath)
+	}
+	watcher, err := k.newWatcher(kubeconfigPath, scope, k.notify)
+	if err != nil {
+		k.mu.Unlock()
+		return nil, err
+	}
+	watcher.modTime = info.ModTime()
+	k.watchers[kubeconfigPath] = watcher
+	watcher.start()
+	k.mu.Unlock()
+
+	// Only the first read waits, so an unreachable cluster costs one timeout
+	// instead of one per render.
+	watcher.waitForSync(kubeWatcherSyncTimeout)
+	return watcher, nil
+}
+
+func (k *kubeWatchers) notify() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	close(k.changed)
+	k.changed = make(chan struct{})
+}
+
+// changes returns a channel closed the next time any watched object changes.
+func (k *kubeWatchers) changes() <-chan struct{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.changed
+}
+
+// retain stops watchers for kubeconfigs the panel no longer shows, such as a
+// deleted downstream cluster.
+func (k *kubeWatchers) retain(active map[string]bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for path, watcher := range k.watchers {
+		if active[path] {
+			continue
+		}
+		watcher.stop()
+		delete(k.watchers, path)
+	}
+}
+
+func (k *kubeWatchers) stopAll() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for path, watcher := range k.watchers {
+		watcher.stop()
+		delete(k.watchers, path)
+	}
+	if !k.isClosed {
+		k.isClosed = true
+		close(k.closed)
+	}
+}
+
+func podViewFromPod(pod *corev1.Pod, leaderLabels map[string]string) podView {
+	totalContainers := len(pod.Spec.Containers)
+	readyContainers := 0
+	restarts := 0
+	status := string(pod.Status.Phase)
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.Ready {
+			readyContainers++
+		}
+		restarts += int(containerStatus.RestartCount)
+		if containerStatus.State.Waiting != nil && containerStatus.State.Waiting.Reason != "" {
+			status = containerStatus.State.Waiting.Reason
+		}
+		if containerStatus.State.Terminated != nil && containerStatus.State.Terminated.Reason != "" {
+			status = containerStatus.State.Terminated.Reason
+		}
+	}
+	if pod.Status.Reason != "" {
+		status = pod.Status.Reason
+	}
+	if pod.DeletionTimestamp != nil {
+		status = "Terminating"
+	}
+
+	containerNames := make([]string, 0, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		containerNames = append(containerNames, container.Name)
+	}
+
+	leaderLabel := leaderLabels[pod.Name]
+	return podView{
+		Namespace:   pod.Namespace,
+		Name:        pod.Name,
+		Ready:       fmt.Sprintf("%d/%d", readyContainers, totalContainers),
+		Status:      status,
+		Restarts:    restarts,
+		Age:         humanDurationSince(pod.CreationTimestamp.Time),
+		Node:        pod.Spec.NodeName,
+		Containers:  strings.Join(containerNames, ", "),
+		Leader:      leaderLabel != "",
+		LeaderLabel: leaderLabel,
+	}
+}
+
+func sortPodViews(pods []podView) {
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+}
+
+func leaderLabelsFromLeases(lookup func(namespace, name string) *coordinationv1.Lease) map[string]string {
+	leaders := map[string]string{}
+	for _, leader := range panelLeaderLeases {
+		if holder := leaseHolder(lookup(leader.Namespace, leader.Name)); holder != "" {
+			leaders[holder] = leader.Label
+		}
+	}
+	return leaders
+}
+
+func leaseHolder(obj interface{}) string {
+	lease, ok := obj.(*coordinationv1.Lease)
+	if !ok || lease == nil || lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return strings.TrimSpace(*lease.Spec.HolderIdentity)
+}
+
+func isRancherPodName(name string) bool {
+	nameLower := strings.ToLower(name)
+	return strings.Contains(nameLower, "rancher") || strings.Contains(nameLower, "webhook")
+}
+
+// listRancherPods is a one-shot read for callers outside the panel, such as
+// the readiness wait, that do not keep a watcher running.
+func listRancherPods(ctx context.Context, clientset kubernetes.Interface) ([]podView, error) {
+	ctx, cancel := context.WithTimeout(ctx, kubeRequestTimeout)
+	defer cancel()
+
+	list, err := clientset.CoreV1().Pods("cattle-system").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cattle-system pods: %w", err)
+	}
+	leaderLabels := leaderLabelsFromLeases(func(namespace, name string) *coordinationv1.Lease {
+		lease, err := clientset.CoordinationV1().Leases(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		return lease
+	})
+
+	pods := make([]podView, 0, len(list.Items))
+	for i := range list.Items {
+		if !isRancherPodName(list.Items[i].Name) {
+			continue
+		}
+		pods = append(pods, podViewFromPod(&list.Items[i], leaderLabels))
+	}
+	sortPodViews(pods)
+	return pods, nil
+}
+
+// podLogContainers returns the container to read, or every init and regular
+// container when none was chosen, matching kubectl logs --all-containers.
+func podLogContainers(ctx context.Context, clientset kubernetes.Interface, namespace, podName, container string) ([]string, error) {
+	if container != "" {
+		return []string{container}, nil
+	}
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s/%s: %w", namespace, podName, err)
+	}
+	containers := make([]string, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for _, c := range pod.Spec.InitContainers {
+		containers = append(containers, c.Name)
+	}
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, c.Name)
+	}
+	return containers, nil
+}
+
+func readPodLogs(ctx context.Context, clientset kubernetes.Interface, namespace, podName, container string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, kubeRequestTimeout)
+	defer cancel()
+
+	containers, err := podLogContainers(ctx, clientset, namespace, podName, container)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	tail := int64(panelLogTailLines)
+	for _, name := range containers {
+		body, err := clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{Container: name, TailLines: &tail}).DoRaw(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to read logs for %s/%s container %s: %w", namespace, podName, name, err)
+		}
+		out.Write(body)
+	}
+	return out.String(), nil
+}
+
+// streamPodLogs follows each container's log until ctx ends, prefixing lines
+// with the container name when more than one is followed.
+func streamPodLogs(ctx context.Context, clientset kubernetes.Interface, namespace, podName, container string, send func(eventName, line string)) error {
+	containers, err := podLogContainers(ctx, clientset, namespace, podName, container)
+	if err != nil {
+		return err
+	}
+
+	var sendMu sync.Mutex
+	sendLocked := func(eventName, line string) {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		send(eventName, line)
+	}
+
+	var wg sync.WaitGroup
+	tail := int64(panelLogStreamTailLines)
+	for _, name := range containers {
+		prefix := ""
+		if len(containers) > 1 {
+			prefix = "[" + name + "] "
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{Container: name, Follow: true, TailLines: &tail}).Stream(ctx)
+			if err != nil {
+				sendLocked("error", fmt.Sprintf("failed to stream logs for container %s: %v", name, err))
+				return
+			}
+			defer stream.Close()
+			scanner := bufio.NewScanner(stream)
+			for scanner.Scan() {
+				sendLocked("line", prefix+scanner.Text())
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
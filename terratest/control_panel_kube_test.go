@@ -0,0 +1,310 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPod(namespace, name string, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, CreationTimestamp: metav1.NewTime(time.Now().Add(-2 * time.Hour))},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	for _, container := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: container})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{Name: container, Ready: true, RestartCount: 1})
+	}
+	return pod
+}
+
+func testRancherCluster(gvr schema.GroupVersionResource, namespace, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	for key, value := range fields {
+		obj.Object[key] = value
+	}
+	obj.SetAPIVersion(gvr.GroupVersion().String())
+	obj.SetKind("Cluster")
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+func newFakeKubeWatcher(scope kubeWatchScope, notify func(), objects ...runtime.Object) (*kubeWatcher, *fake.Clientset) {
+	holder := "rancher-0"
+	clientset := fake.NewClientset(append(objects, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "cattle-controllers"},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder},
+	})...)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			provisioningClustersResource: "ClusterList",
+			managementClustersResource:   "ClusterList",
+		},
+		testRancherCluster(provisioningClustersResource, "fleet-default", "demo", map[string]interface{}{"status": map[string]interface{}{"clusterName": "c-m-demo"}}),
+		testRancherCluster(provisioningClustersResource, "fleet-local", "local", nil),
+		testRancherCluster(managementClustersResource, "", "local", nil),
+		testRancherCluster(managementClustersResource, "", "c-m-demo", map[string]interface{}{"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Ready", "status": "True"},
+			map[string]interface{}{"type": "Connected", "status": "False", "message": "agent disconnected"},
+		}}}),
+		testRancherCluster(managementClustersResource, "", "c-imported", map[string]interface{}{"spec": map[string]interface{}{"displayName": "imported"}}),
+	)
+	return newKubeWatcher(clientset, dynamicClient, scope, notify), clientset
+}
+
+func waitFor(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if check() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestKubeWatcherCachesPodsLeasesAndClusters(t *testing.T) {
+	changed := make(chan struct{}, 100)
+	watcher, clientset := newFakeKubeWatcher(
+		kubeWatchScope{PodNamespace: "cattle-system", RancherClusters: true},
+		func() { changed <- struct{}{} },
+		testPod("cattle-system", "rancher-0", "rancher"),
+		testPod("cattle-system", "rancher-webhook-1", "rancher-webhook"),
+		testPod("cattle-system", "helm-operation-abc", "helm"),
+		testPod("kube-system", "rancher-elsewhere", "rancher"),
+	)
+	watcher.start()
+	t.Cleanup(watcher.stop)
+	if !watcher.waitForSync(10 * time.Second) {
+		t.Fatal("pod and lease caches did not sync")
+	}
+
+	pods, err := watcher.podViews(isRancherPodName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 2 || pods[0].Name != "rancher-0" || pods[1].Name != "rancher-webhook-1" {
+		t.Fatalf("expected the two cattle-system Rancher pods, got %+v", pods)
+	}
+	if pods[0].LeaderLabel != "Leader" || pods[0].Ready != "1/1" || pods[0].Restarts != 1 || pods[0].Status != "Running" || pods[0].Age != "2h" {
+		t.Fatalf("unexpected pod view %+v", pods[0])
+	}
+
+	var clusters []discoveredDownstreamCluster
+	waitFor(t, "Rancher cluster caches", func() bool {
+		clusters, err = watcher.downstreamClusters()
+		return err == nil && len(clusters) == 2
+	})
+	if clusters[0].Name != "imported" || clusters[0].ManagementClusterID != "c-imported" {
+		t.Fatalf("expected the imported management cluster first, got %+v", clusters)
+	}
+	if clusters[1].Name != "demo" || clusters[1].Namespace != "fleet-default" || clusters[1].ManagementClusterID != "c-m-demo" {
+		t.Fatalf("expected the provisioning cluster, got %+v", clusters)
+	}
+
+	for len(changed) > 0 {
+		<-changed
+	}
+	if _, err := clientset.CoreV1().Pods("cattle-system").Create(context.Background(), testPod("cattle-system", "rancher-2", "rancher"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected a change notification for the new pod")
+	}
+	pods, _ = watcher.podViews(isRancherPodName)
+	if len(pods) != 3 {
+		t.Fatalf("expected the new pod in the cache, got %+v", pods)
+	}
+}
+
+func TestPodLogsUseClientset(t *testing.T) {
+	clientset := fake.NewClientset(testPod("cattle-system", "rancher-0", "rancher", "agent"))
+
+	text, err := readPodLogs(context.Background(), clientset, "cattle-system", "rancher-0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "fake logsfake logs" {
+		t.Fatalf("expected logs from both containers, got %q", text)
+	}
+
+	var lines []string
+	send := func(eventName, line string) { lines = append(lines, eventName+": "+line) }
+	if err := streamPodLogs(context.Background(), clientset, "cattle-system", "rancher-0", "", send); err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(lines, "\n")
+	if !strings.Contains(joined, "line: [rancher] fake logs") || !strings.Contains(joined, "line: [agent] fake logs") {
+		t.Fatalf("expected prefixed lines per container, got %q", joined)
+	}
+
+	lines = nil
+	if err := streamPodLogs(context.Background(), clientset, "cattle-system", "rancher-0", "agent", send); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0] != "line: fake logs" {
+		t.Fatalf("expected one unprefixed line, got %q", lines)
+	}
+
+	if _, err := readPodLogs(context.Background(), clientset, "cattle-system", "missing", ""); err == nil {
+		t.Fatal("expected an error for a missing pod")
+	}
+}
+
+func TestKubeWatchersReuseAndReplaceWatchers(t *testing.T) {
+	kubeconfigPath := filepath.Join(t.TempDir(), "kube_config.yaml")
+	if err := os.WriteFile(kubeconfigPath, []byte("apiVersion: v1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	watchers := newKubeWatchers()
+	created := 0
+	watchers.newWatcher = func(_ string, scope kubeWatchScope, notify func()) (*kubeWatcher, error) {
+		created++
+		watcher, _ := newFakeKubeWatcher(scope, notify)
+		return watcher, nil
+	}
+	scope := kubeWatchScope{PodNamespace: "cattle-system"}
+
+	first, err := watchers.get(kubeconfigPath, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := watchers.get(kubeconfigPath, scope); again != first || created != 1 {
+		t.Fatalf("expected the running watcher to be reused, created %d", created)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(kubeconfigPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := watchers.get(kubeconfigPath, scope)
+	if err != nil || replaced == first || created != 2 {
+		t.Fatalf("expected a rewritten kubeconfig to get a new watcher, created %d (%v)", created, err)
+	}
+	select {
+	case <-first.stopCh:
+	default:
+		t.Fatal("expected the replaced watcher to be stopped")
+	}
+
+	watchers.retain(map[string]bool{})
+	select {
+	case <-replaced.stopCh:
+	default:
+		t.Fatal("expected retain to stop watchers that are no longer shown")
+	}
+
+	if _, err := watchers.get(filepath.Join(t.TempDir(), "missing.yaml"), scope); err == nil {
+		t.Fatal("expected a missing kubeconfig to fail")
+	}
+	watchers.stopAll()
+	if _, err := watchers.get(kubeconfigPath, scope); err == nil || !strings.Contains(err.Error(), "shutting down") {
+		t.Fatalf("expected watchers to refuse new work after stopAll, got %v", err)
+	}
+}
+
+func TestControlPanelStateStreamPushesChanges(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Chdir(t.TempDir())
+
+	testDir := t.TempDir()
+	kubeconfigPath := filepath.Join(testDir, "high-availability-1", "kube_config.yaml")
+	if err := os.MkdirAll(filepath.Dir(kubeconfigPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(kubeconfigPath, []byte("apiVersion: v1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var clientset *fake.Clientset
+	panel := &localControlPanel{
+		token:                     "panel-token",
+		config:                    panelConfig{TotalHAs: 1},
+		repoRoot:                  t.TempDir(),
+		testDir:                   testDir,
+		jobs:                      newPanelJobRunner(""),
+		kube:                      newKubeWatchers(),
+		terraformOutputs:          map[string]string{"ha_1_rancher_url": "rancher.example.com"},
+		terraformOutputsAt:        time.Now(),
+		rancherTokens:             map[int]panelRancherToken{},
+		downstreamKubeconfigCache: map[string]string{},
+	}
+	panel.kube.newWatcher = func(_ string, scope kubeWatchScope, notify func()) (*kubeWatcher, error) {
+		var watcher *kubeWatcher
+		watcher, clientset = newFakeKubeWatcher(scope, notify, testPod("cattle-system", "rancher-0", "rancher"))
+		return watcher, nil
+	}
+	t.Cleanup(panel.kube.stopAll)
+
+	server := httptest.NewServer(http.HandlerFunc(panel.handleStateStream))
+	t.Cleanup(server.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/state/stream?token=panel-token", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	events := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if line, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				events <- line
+			}
+		}
+		close(events)
+	}()
+
+	nextState := func() string {
+		select {
+		case state, ok := <-events:
+			if !ok {
+				t.Fatal("state stream closed")
+			}
+			return state
+		case <-time.After(15 * time.Second):
+			t.Fatal("timed out waiting for a state event")
+		}
+		return ""
+	}
+
+	first := nextState()
+	if !strings.Contains(first, `"name":"rancher-0"`) || !strings.Contains(first, `"leaderLabel":"Leader"`) || !strings.Contains(first, `"reachable":true`) {
+		t.Fatalf("expected the cached Rancher pod in the first state, got %s", first)
+	}
+
+	if _, err := clientset.CoreV1().Pods("cattle-system").Create(context.Background(), testPod("cattle-system", "rancher-webhook-1", "rancher-webhook"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if state := nextState(); strings.Contains(state, `"name":"rancher-webhook-1"`) {
+			break
+		}
+	}
+}
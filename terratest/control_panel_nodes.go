@@ -0,0 +1,403 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	panelJournalTailLines    = 200
+	panelJournalPollInterval = 5 * time.Second
+	rke2ConfigPath           = "/etc/rancher/rke2/config.yaml"
+)
+
+// panelNodeCommands is the allow-list of read-only diagnostics the node view
+// can run. Nothing else is ever sent to a node from the control panel.
+var panelNodeCommands = []panelNodeCommand{
+	{
+		Name:        "status",
+		Label:       "systemctl status",
+		Description: "RKE2 service status and its most recent log lines",
+		command: func(node panelNode) string {
+			return fmt.Sprintf("sudo systemctl status %s --no-pager --full --lines=20 || true", node.Unit)
+		},
+	},
+	{
+		Name:        "containers",
+		Label:       "crictl ps",
+		Description: "Containers known to the RKE2 containerd runtime",
+		command: func(panelNode) string {
+			return "sudo /var/lib/rancher/rke2/bin/crictl --config /var/lib/rancher/rke2/agent/etc/crictl.yaml ps -a"
+		},
+	},
+	{
+		Name:        "disk",
+		Label:       "Disk usage",
+		Description: "Filesystem usage and the size of the RKE2 data directories",
+		command: func(panelNode) string {
+			return "df -h -x tmpfs -x overlay -x squashfs; echo; sudo du -sh /var/lib/rancher/rke2/agent /var/lib/rancher/rke2/server 2>/dev/null || true"
+		},
+	},
+	{
+		Name:        "config",
+		Label:       "RKE2 config",
+		Description: rke2ConfigPath + " with tokens and secrets redacted",
+		command: func(panelNode) string {
+			// Redact on the node as well, so the secrets never reach SSM output.
+			return fmt.Sprintf("sudo sed -E %s %s", shellSingleQuote(`s/^([[:space:]]*[A-Za-z0-9_-]*(token|secret|password)[A-Za-z0-9_-]*[[:space:]]*:).*/\1 <redacted>/`), rke2ConfigPath)
+		},
+		filter: redactRKE2Config,
+	},
+}
+
+var (
+	rke2ConfigSecretLine = regexp.MustCompile(`(?m)^(\s*[A-Za-z0-9_-]*(?:token|secret|password)[A-Za-z0-9_-]*\s*:).*$`)
+	journalCursorPattern = regexp.MustCompile(`^[A-Za-z0-9=;_-]+$`)
+)
+
+type panelNode struct {
+	ID        string `json:"id"`
+	HAIndex   int    `json:"haIndex"`
+	Role      string `json:"role"`
+	Name      string `json:"name"`
+	PublicIP  string `json:"publicIp"`
+	PrivateIP string `json:"privateIp,omitempty"`
+	Unit      string `json:"unit"`
+}
+
+type panelNodeCommand struct {
+	Name        string `json:"name"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+
+	command func(node panelNode) string
+	filter  func(output string) string
+}
+
+type panelNodesState struct {
+	Nodes    []panelNode        `json:"nodes"`
+	Commands []panelNodeCommand `json:"commands"`
+}
+
+type panelNodeCommandResult struct {
+	Node    string `json:"node"`
+	Command string `json:"command"`
+	Output  string `json:"output"`
+}
+
+type panelNodeAuditEntry struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Node     string    `json:"node"`
+	IP       string    `json:"ip"`
+	Command  string    `json:"command"`
+	Remote   string    `json:"remote"`
+	Duration string    `json:"duration"`
+	Polls    int       `json:"polls,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// panelNodeConsole runs allow-listed commands on cluster nodes through the
+// same transport the setup tests use and records every run in an audit log.
+type panelNodeConsole struct {
+	run          func(cmd, pubIP string) (string, error)
+	pollInterval time.Duration
+	// auditPath overrides the audit file, which otherwise lives in the active
+	// workspace's automation-output.
+	auditPath string
+
+	mu sync.Mutex
+}
+
+func newPanelNodeConsole() *panelNodeConsole {
+	return &panelNodeConsole{
+		run:          RunCommand,
+		pollInterval: panelJournalPollInterval,
+	}
+}
+
+// audit logs a remote run and appends it to the JSON lines audit file. A
+// failure to write the file is logged but never blocks the command.
+func (c *panelNodeConsole) audit(entry panelNodeAuditEntry) {
+	result := "ok"
+	if entry.Error != "" {
+		result = "error: " + entry.Error
+	}
+	log.Printf("[control-panel] audit source=%s node=%s ip=%s command=%s duration=%s result=%s", entry.Source, entry.Node, entry.IP, entry.Command, entry.Duration, result)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	path := c.auditPath
+	if path == "" {
+		path = filepath.Join(automationOutputDir(), "control-panel", "node-audit.jsonl")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		log.Printf("[control-panel] failed to create node audit directory: %v", err)
+		return
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("[control-panel] failed to open node audit log: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("[control-panel] failed to write node audit log: %v", err)
+	}
+}
+
+func (c *panelNodeConsole) runCommand(r *http.Request, node panelNode, command panelNodeCommand) (string, error) {
+	remote := command.command(node)
+	started := time.Now()
+	output, err := c.run(remote, node.PublicIP)
+	entry := panelNodeAuditEntry{
+		Time:     started.UTC(),
+		Source:   r.RemoteAddr,
+		Node:     node.ID,
+		IP:       node.PublicIP,
+		Command:  command.Name,
+		Remote:   remote,
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	c.audit(entry)
+	if err != nil {
+		return "", err
+	}
+	if command.filter != nil {
+		output = command.filter(output)
+	}
+	return output, nil
+}
+
+// followJournal tails the node's RKE2 unit and then polls for entries after
+// the last journal cursor, since neither SSM nor the SSH transport keeps an
+// interactive session open. It returns once the context ends or a poll fails.
+func (c *panelNodeConsole) followJournal(ctx context.Context, r *http.Request, node panelNode, send func(eventName, line string)) error {
+	started := time.Now()
+	cursor := ""
+	polls := 0
+	var runErr error
+	for following := true; following; {
+		output, err := c.run(journalCommand(node.Unit, cursor), node.PublicIP)
+		polls++
+		if err != nil {
+			runErr = fmt.Errorf("failed to read the %s journal on %s: %w", node.Unit, node.ID, err)
+			break
+		}
+		lines, next := parseJournalOutput(output)
+		if next != "" {
+			cursor = next
+		}
+		for _, line := range lines {
+			send("line", line)
+		}
+
+		select {
+		case <-ctx.Done():
+			following = false
+		case <-time.After(c.pollInterval):
+		}
+	}
+
+	entry := panelNodeAuditEntry{
+		Time:     started.UTC(),
+		Source:   r.RemoteAddr,
+		Node:     node.ID,
+		IP:       node.PublicIP,
+		Command:  "journal",
+		Remote:   journalCommand(node.Unit, ""),
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+		Polls:    polls,
+	}
+	if runErr != nil {
+		entry.Error = runErr.Error()
+	}
+	c.audit(entry)
+	return runErr
+}
+
+func journalCommand(unit, cursor string) string {
+	base := fmt.Sprintf("sudo journalctl -u %s --no-pager --output=short-iso --show-cursor", unit)
+	if cursor == "" {
+		return fmt.Sprintf("%s -n %d", base, panelJournalTailLines)
+	}
+	return base + " --after-cursor=" + shellSingleQuote(cursor)
+}
+
+// parseJournalOutput splits journalctl output into log lines and the trailing
+// cursor. Cursors that do not look like journal cursors are dropped, since the
+// next poll passes them back to a root shell.
+func parseJournalOutput(output string) ([]string, string) {
+	var lines []string
+	cursor := ""
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "-- cursor: "):
+			if candidate := strings.TrimSpace(strings.TrimPrefix(line, "-- cursor: ")); journalCursorPattern.MatchString(candidate) {
+				cursor = candidate
+			}
+		case line == "" || line == "-- No entries --":
+		default:
+			lines = append(lines, line)
+		}
+	}
+	return lines, cursor
+}
+
+func redactRKE2Config(config string) string {
+	return rke2ConfigSecretLine.ReplaceAllString(config, "$1 <redacted>")
+}
+
+func findPanelNodeCommand(name string) (panelNodeCommand, bool) {
+	for _, command := range panelNodeCommands {
+		if command.Name == name {
+			return command, true
+		}
+	}
+	return panelNodeCommand{}, false
+}
+
+// panelNodes lists every server and agent from the Terraform outputs.
+func (p *localControlPanel) panelNodes() []panelNode {
+	outputs := p.cachedTerraformOutputs()
+	nodes := []panelNode{}
+	for i := 1; i <= p.haCount(); i++ {
+		haOutputs := getHAOutputs(i, outputs)
+		nodes = append(nodes, haPanelNodes(i, "server", haOutputs.ServerIPs, haOutputs.ServerPrivateIPs)...)
+		nodes = append(nodes, haPanelNodes(i, "agent", haOutputs.AgentIPs, haOutputs.AgentPrivateIPs)...)
+	}
+	return nodes
+}
+
+func haPanelNodes(haIndex int, role string, publicIPs, privateIPs []string) []panelNode {
+	nodes := make([]panelNode, 0, len(publicIPs))
+	for n, ip := range publicIPs {
+		name := fmt.Sprintf("%s-%d", role, n+1)
+		node := panelNode{
+			ID:       fmt.Sprintf("ha-%d-%s", haIndex, name),
+			HAIndex:  haIndex,
+			Role:     role,
+			Name:     name,
+			PublicIP: ip,
+			Unit:     "rke2-" + role,
+		}
+		if n < len(privateIPs) {
+			node.PrivateIP = privateIPs[n]
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func (p *localControlPanel) panelNodeByID(id string) (panelNode, error) {
+	id = strings.TrimSpace(id)
+	for _, node := range p.panelNodes() {
+		if node.ID == id {
+			return node, nil
+		}
+	}
+	return panelNode{}, fmt.Errorf("node %q was not found", id)
+}
+
+func (p *localControlPanel) handleNodes(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizedLocalBrowserRead(r) {
+		http.Error(w, "invalid control panel token", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, panelNodesState{Nodes: p.panelNodes(), Commands: panelNodeCommands})
+}
+
+func (p *localControlPanel) handleNodeRun(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizedLocalAction(r) {
+		http.Error(w, "invalid control panel token", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Node    string `json:"node"`
+		Command string `json:"command"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	command, ok := findPanelNodeCommand(req.Command)
+	if !ok {
+		http.Error(w, fmt.Sprintf("command %q is not allowed", req.Command), http.StatusBadRequest)
+		return
+	}
+	node, err := p.panelNodeByID(req.Node)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	output, err := p.nodes.runCommand(r, node, command)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, panelNodeCommandResult{Node: node.ID, Command: command.Name, Output: output})
+}
+
+func (p *localControlPanel) handleNodeJournalStream(w http.ResponseWriter, r *http.Request) {
+	if !p.authorizedLocalBrowserRead(r) {
+		http.Error(w, "invalid control panel token", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	node, err := p.panelNodeByID(r.URL.Query().Get("node"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	sendLine := func(eventName, line string) {
+		fmt.Fprintf(w, "event: %s\n", eventName)
+		fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(line, "\n", "\\n"))
+		flusher.Flush()
+	}
+
+	if err := p.nodes.followJournal(r.Context(), r, node, sendLine); err != nil {
+		sendLine("error", err.Error())
+	}
+	sendLine("end", "stream closed")
+}
@@ -0,0 +1,243 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestNodePanel(t *testing.T, run func(cmd, pubIP string) (string, error)) *localControlPanel {
+	t.Helper()
+	nodes := newPanelNodeConsole()
+	nodes.run = run
+	nodes.pollInterval = 10 * time.Millisecond
+	nodes.auditPath = filepath.Join(t.TempDir(), "node-audit.jsonl")
+	return &localControlPanel{
+		token:  "panel-token",
+		config: panelConfig{TotalHAs: 2},
+		nodes:  nodes,
+		terraformOutputs: map[string]string{
+			"ha_1_server1_ip":         "1.1.1.1",
+			"ha_1_server1_private_ip": "10.0.0.1",
+			"ha_1_server2_ip":         "1.1.1.2",
+			"ha_1_agent1_ip":          "1.1.1.9",
+			"ha_2_server1_ip":         "2.2.2.1",
+		},
+		terraformOutputsAt: time.Now(),
+	}
+}
+
+func readNodeAudit(t *testing.T, path string) []panelNodeAuditEntry {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []panelNodeAuditEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var entry panelNodeAuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid audit line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestControlPanelNodesFromTerraformOutputs(t *testing.T) {
+	panel := newTestNodePanel(t, nil)
+
+	rec := httptest.NewRecorder()
+	panel.handleNodes(rec, httptest.NewRequest(http.MethodGet, "/api/nodes?token=panel-token", nil))
+	var state panelNodesState
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+
+	var ids []string
+	for _, node := range state.Nodes {
+		ids = append(ids, node.ID)
+	}
+	if got := strings.Join(ids, ","); got != "ha-1-server-1,ha-1-server-2,ha-1-agent-1,ha-2-server-1" {
+		t.Fatalf("unexpected nodes %s", got)
+	}
+	if first := state.Nodes[0]; first.PrivateIP != "10.0.0.1" || first.Unit != "rke2-server" || state.Nodes[2].Unit != "rke2-agent" {
+		t.Fatalf("unexpected node details %+v", state.Nodes)
+	}
+	if len(state.Commands) != len(panelNodeCommands) || state.Commands[0].Name != "status" {
+		t.Fatalf("unexpected commands %+v", state.Commands)
+	}
+}
+
+func TestControlPanelNodeRunIsAllowListedAndAudited(t *testing.T) {
+	var mu sync.Mutex
+	var ran []string
+	panel := newTestNodePanel(t, func(cmd, pubIP string) (string, error) {
+		mu.Lock()
+		ran = append(ran, pubIP+" "+cmd)
+		mu.Unlock()
+		switch {
+		case strings.Contains(cmd, "crictl"):
+			return "", errors.New("crictl not found")
+		case strings.Contains(cmd, rke2ConfigPath):
+			return "write-kubeconfig-mode: \"0644\"\ntoken: leaked-by-an-old-sed\nagent-token: abc\ntls-san:\n  - rancher.example.com\n", nil
+		}
+		return "active (running)\n", nil
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		panel.handleNodeRun(rec, httptest.NewRequest(http.MethodPost, "/api/nodes/run?token=panel-token", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := post(`{"node":"ha-1-server-1","command":"rm -rf /"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected commands outside the allow-list to be rejected, got %d", rec.Code)
+	}
+	if rec := post(`{"node":"ha-9-server-1","command":"status"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown node to 404, got %d", rec.Code)
+	}
+
+	rec := post(`{"node":"ha-1-agent-1","command":"status"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "active (running)") {
+		t.Fatalf("unexpected status response %d %s", rec.Code, rec.Body.String())
+	}
+	rec = post(`{"node":"ha-1-server-1","command":"config"}`)
+	var result panelNodeCommandResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("unexpected config response %d %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(result.Output, "leaked-by-an-old-sed") || strings.Contains(result.Output, "abc") ||
+		!strings.Contains(result.Output, "token: <redacted>") || !strings.Contains(result.Output, "rancher.example.com") {
+		t.Fatalf("expected tokens to be redacted, got:\n%s", result.Output)
+	}
+	if rec := post(`{"node":"ha-2-server-1","command":"containers"}`); rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "crictl not found") {
+		t.Fatalf("expected a failed command to surface its error, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if len(ran) != 3 || !strings.HasPrefix(ran[0], "1.1.1.9 sudo systemctl status rke2-agent") || !strings.HasPrefix(ran[2], "2.2.2.1 ") {
+		t.Fatalf("unexpected remote commands %q", ran)
+	}
+
+	entries := readNodeAudit(t, panel.nodes.auditPath)
+	if len(entries) != 3 {
+		t.Fatalf("expected one audit entry per command, got %+v", entries)
+	}
+	if entries[1].Node != "ha-1-server-1" || entries[1].Command != "config" || entries[1].Error != "" || !strings.Contains(entries[1].Remote, "<redacted>") {
+		t.Fatalf("unexpected config audit entry %+v", entries[1])
+	}
+	if entries[2].Command != "containers" || entries[2].Error != "crictl not found" {
+		t.Fatalf("expected the failed command to be audited, got %+v", entries[2])
+	}
+
+	forbidden := httptest.NewRecorder()
+	panel.handleNodeRun(forbidden, httptest.NewRequest(http.MethodPost, "/api/nodes/run", strings.NewReader(`{"node":"ha-1-server-1","command":"status"}`)))
+	if forbidden.Code != http.StatusForbidden {
+		t.Fatalf("expected node commands to require the token, got %d", forbidden.Code)
+	}
+}
+
+func TestParseJournalOutput(t *testing.T) {
+	lines, cursor := parseJournalOutput("2026-10-16T10:00:00+0000 node rke2[1]: started\n-- cursor: s=abc;i=1f;b=def\n")
+	if len(lines) != 1 || cursor != "s=abc;i=1f;b=def" {
+		t.Fatalf("unexpected parse %q %q", lines, cursor)
+	}
+	if lines, cursor := parseJournalOutput("-- No entries --\n"); len(lines) != 0 || cursor != "" {
+		t.Fatalf("expected no lines or cursor, got %q %q", lines, cursor)
+	}
+	if _, cursor := parseJournalOutput("-- cursor: s=abc'; reboot; '\n"); cursor != "" {
+		t.Fatalf("expected a cursor with shell characters to be dropped, got %q", cursor)
+	}
+}
+
+func TestControlPanelNodeJournalStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var commands []string
+	panel := newTestNodePanel(t, func(cmd, pubIP string) (string, error) {
+		commands = append(commands, cmd)
+		if len(commands) == 1 {
+			return "first line\nsecond line\n-- cursor: s=1;i=2\n", nil
+		}
+		cancel()
+		return "third line\n-- cursor: s=1;i=3\n", nil
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/nodes/journal/stream?token=panel-token&node=ha-1-server-2", nil).WithContext(ctx)
+	panel.handleNodeJournalStream(rec, req)
+
+	body := rec.Body.String()
+	for _, want := range []string{"event: line\ndata: first line", "data: second line", "data: third line", "event: end"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in stream:\n%s", want, body)
+		}
+	}
+	if len(commands) != 2 || !strings.Contains(commands[0], "journalctl -u rke2-server") || !strings.Contains(commands[0], "-n 200") ||
+		!strings.Contains(commands[1], "--after-cursor='s=1;i=2'") {
+		t.Fatalf("unexpected journal commands %q", commands)
+	}
+
+	entries := readNodeAudit(t, panel.nodes.auditPath)
+	if len(entries) != 1 || entries[0].Command != "journal" || entries[0].Polls != 2 || entries[0].Node != "ha-1-server-2" {
+		t.Fatalf("expected one audit entry for the journal follow, got %+v", entries)
+	}
+
+	missing := httptest.NewRecorder()
+	panel.handleNodeJournalStream(missing, httptest.NewRequest(http.MethodGet, "/api/nodes/journal/stream?token=panel-token&node=nope", nil))
+	if missing.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown node to 404, got %d", missing.Code)
+	}
+}
+
+func TestControlPanelNodeRoutesRejectCrossOriginReads(t *testing.T) {
+	ran := 0
+	panel := newTestNodePanel(t, func(cmd, pubIP string) (string, error) {
+		ran++
+		return "", nil
+	})
+
+	for _, path := range []string{"/api/nodes", "/api/nodes/journal/stream?node=ha-1-server-1"} {
+		for name, header := range map[string][2]string{
+			"no origin":     {},
+			"cross origin":  {"Origin", "http://evil.example"},
+			"cross referer": {"Referer", "http://evil.example/page"},
+		} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.RemoteAddr = "127.0.0.1:50000"
+			if header[0] != "" {
+				req.Header.Set(header[0], header[1])
+			}
+			rec := httptest.NewRecorder()
+			if strings.HasPrefix(path, "/api/nodes/journal") {
+				panel.handleNodeJournalStream(rec, req)
+			} else {
+				panel.handleNodes(rec, req)
+			}
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("expected a loopback %s read of %s to be rejected, got %d", name, path, rec.Code)
+			}
+		}
+	}
+	if ran != 0 {
+		t.Fatalf("expected no node commands for rejected requests, ran %d", ran)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/nodes", nil)
+	req.RemoteAddr = "127.0.0.1:50000"
+	req.Header.Set("Referer", "http://"+req.Host+"/")
+	rec := httptest.NewRecorder()
+	panel.handleNodes(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected a same-origin panel read to be allowed, got %d", rec.Code)
+	}
+}
@@ -68,12 +68,12 @@ func TestPruneStaleDownstreamKubeconfigsRemovesMissingClusters(t *testing.T) {
 func runHAControlPanelTest(t *testing.T) {
 	setupConfig(t)
 
-	totalHAs := viper.GetInt("total_has")
-	if totalHAs < 1 {
+	config := panelConfigFrom(viper.GetViper())
+	if config.TotalHAs < 1 {
 		t.Fatal("total_has must be at least 1")
 	}
 
-	panel, err := newLocalControlPanel(totalHAs)
+	panel, err := newLocalControlPanel(config)
 	if err != nil {
 		t.Fatalf("Failed to start local control panel: %v", err)
 	}
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"context"
+	"log"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+)
+
+// panelRancherToken is a token the panel created and revokes when it shuts
+// down or switches workspace. The ledger path is captured at creation so the
+// right ledger is updated after a workspace switch.
+type panelRancherToken struct {
+	rancherURL string
+	ledgerPath string
+	token      string
+}
+
+func (p *localControlPanel) rancherToken(haIndex int, rancherURL string) (string, error) {
+	p.mu.Lock()
+	if cached := p.rancherTokens[haIndex]; cached.token != "" {
+		p.mu.Unlock()
+		return cached.token, nil
+	}
+	p.mu.Unlock()
+
+	token, err := createRancherToken(haIndex, rancherURL, p.currentConfig().BootstrapPassword, rancherTokenPurposeControlPanel)
+	if err != nil {
+		return "", err
+	}
+	issued := panelRancherToken{rancherURL: rancherURL, ledgerPath: rancherTokenLedgerPath(haIndex), token: token}
+
+	p.mu.Lock()
+	if cached := p.rancherTokens[haIndex]; cached.token != "" {
+		p.mu.Unlock()
+		if err := revokeOwnRancherToken(issued.ledgerPath, rancherURL, token); err != nil {
+			log.Printf("[control-panel] Failed to revoke duplicate Rancher token for HA %d: %v", haIndex, err)
+		}
+		return cached.token, nil
+	}
+	p.rancherTokens[haIndex] = issued
+	p.mu.Unlock()
+	return token, nil
+}
+
+// generateKubeconfig generates a kubeconfig with the panel's cached token,
+// replacing the token once if Rancher no longer accepts it. The token Rancher
+// puts in the kubeconfig is revoked with the panel's own token when the panel
+// shuts down or switches workspace.
+func (p *localControlPanel) generateKubeconfig(haIndex int, rancherURL, managementClusterID string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := p.rancherToken(haIndex, rancherURL)
+		if err != nil {
+			return "", err
+		}
+		kubeconfig, err := generateRecordedKubeconfig(haIndex, rancherURL, token, managementClusterID)
+		if err != nil {
+			if attempt == 0 && rancherclient.IsUnauthorized(err) {
+				p.dropRancherToken(haIndex, token, err)
+				continue
+			}
+			return "", err
+		}
+		p.mu.Lock()
+		if p.kubeconfigTokens == nil {
+			p.kubeconfigTokens = map[int][]string{}
+		}
+		p.kubeconfigTokens[haIndex] = append(p.kubeconfigTokens[haIndex], kubeconfigTokenNames(kubeconfig)...)
+		p.mu.Unlock()
+		return kubeconfig, nil
+	}
+}
+
+// dropRancherToken stops using a cached token Rancher rejected. A 401 means
+// the token expired or was revoked elsewhere, so it is only dropped from the
+// ledger. A 403 means it still exists, so it is revoked first and stays in the
+// ledger for TestHACleanup if that fails.
+func (p *localControlPanel) dropRancherToken(haIndex int, token string, rejection error) {
+	p.mu.Lock()
+	cached := p.rancherTokens[haIndex]
+	if cached.token != token {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.rancherTokens, haIndex)
+	p.mu.Unlock()
+
+	if rancherclient.IsUnauthenticated(rejection) {
+		if err := forgetRancherTokens(cached.ledgerPath, rancherTokenName(token)); err != nil {
+			log.Printf("[control-panel] Failed to update token ledger for HA %d: %v", haIndex, err)
+		}
+	} else if err := revokeOwnRancherToken(cached.ledgerPath, cached.rancherURL, token); err != nil {
+		log.Printf("[control-panel] Failed to revoke the rejected Rancher token for HA %d; leaving it for cleanup: %v", haIndex, err)
+	}
+	log.Printf("[control-panel] Rancher rejected the cached token for HA %d; creating a new one", haIndex)
+}
+
+// revokeRancherTokens revokes every token the panel issued for itself, the
+// tokens in every kubeconfig it generated, and removes the cached kubeconfigs.
+// Kubeconfig tokens for an HA whose panel token was lost stay in the ledger
+// for TestHACleanup.
+func (p *localControlPanel) revokeRancherTokens() {
+	p.mu.Lock()
+	tokens := p.rancherTokens
+	kubeconfigTokens := p.kubeconfigTokens
+	cache := p.downstreamKubeconfigCache
+	p.rancherTokens = map[int]panelRancherToken{}
+	p.kubeconfigTokens = map[int][]string{}
+	p.downstreamKubeconfigCache = map[string]string{}
+	p.mu.Unlock()
+
+	for _, path := range cache {
+		RemoveFile(path)
+	}
+	for haIndex, names := range kubeconfigTokens {
+		if _, ok := tokens[haIndex]; !ok && len(names) > 0 {
+			log.Printf("[control-panel] No Rancher token left to revoke %d kubeconfig tokens for HA %d; leaving them for cleanup", len(names), haIndex)
+		}
+	}
+	for haIndex, issued := range tokens {
+		if names := kubeconfigTokens[haIndex]; len(names) > 0 {
+			if err := revokeRancherTokensWith(issued, names); err != nil {
+				log.Printf("[control-panel] Failed to revoke kubeconfig tokens for HA %d: %v", haIndex, err)
+			}
+		}
+		if err := revokeOwnRancherToken(issued.ledgerPath, issued.rancherURL, issued.token); err != nil {
+			log.Printf("[control-panel] Failed to revoke Rancher token for HA %d: %v", haIndex, err)
+			continue
+		}
+		log.Printf("[control-panel] Revoked Rancher tokens for HA %d", haIndex)
+	}
+}
+
+func revokeRancherTokensWith(issued panelRancherToken, names []string) error {
+	client, err := newRancherAPIClient(issued.rancherURL, rancherclient.Options{Token: issued.token})
+	if err != nil {
+		return err
+	}
+	var revoked []string
+	var firstErr error
+	for _, name := range names {
+		if err := client.RevokeToken(context.Background(), name); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		revoked = append(revoked, name)
+	}
+	if err := forgetRancherTokens(issued.ledgerPath, revoked...); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
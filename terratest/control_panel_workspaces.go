@@ -0,0 +1,113 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type panelWorkspacesState struct {
+	Active string          `json:"active"`
+	Items  []workspaceView `json:"items"`
+}
+
+type panelWorkspaceNotFoundError struct {
+	name string
+}
+
+func (e panelWorkspaceNotFoundError) Error() string {
+	return fmt.Sprintf("workspace %q has no tool-config.yml", e.name)
+}
+
+func (p *localControlPanel) haCount() int {
+	return p.currentConfig().TotalHAs
+}
+
+func (p *localControlPanel) currentConfig() panelConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.config
+}
+
+func (p *localControlPanel) handleWorkspaces(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !p.authorizedReadOnly(r) {
+			http.Error(w, "invalid control panel token", http.StatusForbidden)
+			return
+		}
+		writeJSON(w, panelWorkspacesState{Active: workspaceLabel(activeWorkspace()), Items: listWorkspaces(p.repoRoot)})
+	case http.MethodPost:
+		if !p.authorizedLocalAction(r) {
+			http.Error(w, "invalid control panel token", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := p.switchWorkspace(req.Name); err != nil {
+			status := http.StatusBadRequest
+			switch {
+			case errors.As(err, &panelJobConflictError{}):
+				status = http.StatusConflict
+			case errors.As(err, &panelWorkspaceNotFoundError{}):
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, panelWorkspacesState{Active: workspaceLabel(activeWorkspace()), Items: listWorkspaces(p.repoRoot)})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// switchWorkspace loads another workspace's tool-config.yml and drops
+// everything cached for the previous one. Jobs run against the workspace that
+// was active when they started, so switching waits until no job is running.
+func (p *localControlPanel) switchWorkspace(rawName string) error {
+	name, err := normalizeWorkspaceName(rawName)
+	if err != nil {
+		return err
+	}
+	configured := false
+	for _, workspace := range listWorkspaces(p.repoRoot) {
+		if workspace.Name == workspaceLabel(name) {
+			configured = workspace.Configured
+		}
+	}
+	if !configured {
+		return panelWorkspaceNotFoundError{name: workspaceLabel(name)}
+	}
+	if running, ok := p.jobs.running(); ok {
+		return panelJobConflictError{running: running.Label}
+	}
+
+	config, err := readWorkspaceConfig(name)
+	if err != nil {
+		return err
+	}
+	snapshot := panelConfigFrom(config.values)
+	if snapshot.TotalHAs < 1 {
+		return fmt.Errorf("workspace %s: total_has must be at least 1", workspaceLabel(name))
+	}
+	if err := applyWorkspaceConfig(config); err != nil {
+		return err
+	}
+
+	p.revokeRancherTokens()
+	p.mu.Lock()
+	p.config = snapshot
+	p.terraformOutputs = nil
+	p.mu.Unlock()
+
+	log.Printf("[control-panel] Switched to workspace %s", workspaceLabel(name))
+	p.kube.notify()
+	return nil
+}
@@ -1,15 +1,12 @@
 package test
 
 import (
+	"context"
 	"crypto/rand"
-	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -19,6 +16,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 	goversion "github.com/hashicorp/go-version"
 	"github.com/spf13/viper"
 )
@@ -27,7 +26,6 @@ const (
 	defaultLinodeRegion       = "us-ord"
 	defaultLinodeInstanceType = "g6-standard-2"
 	defaultLinodeImage        = "linode/ubuntu22.04"
-	defaultLinodeNamespace    = "fleet-default"
 )
 
 type downstreamProvisioningConfig struct {
@@ -122,7 +120,7 @@ func TestHAProvisionLinodeDownstream(t *testing.T) {
 }
 
 func provisionLinodeDownstreamForHA(instanceNum int, haOutputs TerraformOutputs, linodeToken, namePrefix, runID string, timeout time.Duration) error {
-	kubeconfigPath := filepath.Join(fmt.Sprintf("high-availability-%d", instanceNum), "kube_config.yaml")
+	kubeconfigPath := filepath.Join(haInstanceDir(instanceNum), "kube_config.yaml")
 	if _, err := os.Stat(kubeconfigPath); err != nil {
 		return fmt.Errorf("kubeconfig not available for HA %d at %s: %w", instanceNum, kubeconfigPath, err)
 	}
@@ -137,18 +135,6 @@ func provisionLinodeDownstreamForHA(instanceNum int, haOutputs TerraformOutputs,
 		clusterName = dnsLabel(fmt.Sprintf("%s-%s-ha%d-%s", namePrefix, shortRunID(runID), instanceNum, suffix))
 	}
 
-	adminToken, err := createRancherAdminToken(haOutputs.RancherURL, viper.GetString("rancher.bootstrap_password"))
-	if err != nil {
-		return err
-	}
-	if err := configureRancherServerURL(haOutputs.RancherURL, adminToken); err != nil {
-		return err
-	}
-	k3sVersion, err := resolveK3SDefaultVersion(haOutputs.RancherURL, adminToken)
-	if err != nil {
-		return err
-	}
-
 	cfg := downstreamProvisioningConfig{
 		ClusterName:  clusterName,
 		SecretName:   dnsLabel("cc-" + clusterName),
@@ -156,23 +142,38 @@ func provisionLinodeDownstreamForHA(instanceNum int, haOutputs TerraformOutputs,
 		Region:       envOrDefaultTrimmed("LINODE_REGION", defaultLinodeRegion),
 		InstanceType: envOrDefaultTrimmed("LINODE_INSTANCE_TYPE", defaultLinodeInstanceType),
 		Image:        envOrDefaultTrimmed("LINODE_IMAGE", defaultLinodeImage),
-		K3SVersion:   k3sVersion,
 		LinodeToken:  linodeToken,
 	}
 
-	log.Printf("[downstream][ha-%d] Creating one-node Linode K3s cluster %s on %s (%s, %s, %s)",
-		instanceNum, cfg.ClusterName, clickableURL(haOutputs.RancherURL), cfg.K3SVersion, cfg.Region, cfg.InstanceType)
+	// The provisioning token is only needed until the machine config exists.
+	err := withRancherToken(instanceNum, haOutputs.RancherURL, viper.GetString("rancher.bootstrap_password"), rancherTokenPurposeProvisioning, func(adminToken string) error {
+		if err := configureRancherServerURL(haOutputs.RancherURL, adminToken); err != nil {
+			return err
+		}
+		k3sVersion, err := resolveK3SDefaultVersion(haOutputs.RancherURL, adminToken)
+		if err != nil {
+			return err
+		}
+		cfg.K3SVersion = k3sVersion
 
-	if err := kubectlApply(kubeconfigPath, renderLinodeCredentialSecretManifest(cfg)); err != nil {
-		return err
-	}
+		log.Printf("[downstream][ha-%d] Creating one-node Linode K3s cluster %s on %s (%s, %s, %s)",
+			instanceNum, cfg.ClusterName, clickableURL(haOutputs.RancherURL), cfg.K3SVersion, cfg.Region, cfg.InstanceType)
 
-	machineName, err := createLinodeMachineConfig(haOutputs.RancherURL, adminToken, cfg)
+		if err := kubectlApply(kubeconfigPath, renderLinodeCredentialSecretManifest(cfg)); err != nil {
+			return err
+		}
+
+		machineName, err := createLinodeMachineConfig(haOutputs.RancherURL, adminToken, cfg)
+		if err != nil {
+			_ = runKubectlDirect(kubeconfigPath, "delete", "secret", cfg.SecretName, "-n", "cattle-global-data", "--ignore-not-found=true")
+			return err
+		}
+		cfg.MachineName = machineName
+		return nil
+	})
 	if err != nil {
-		_ = runKubectlDirect(kubeconfigPath, "delete", "secret", cfg.SecretName, "-n", "cattle-global-data", "--ignore-not-found=true")
 		return err
 	}
-	cfg.MachineName = machineName
 	log.Printf("[downstream][ha-%d] Created Linode machine config %s", instanceNum, cfg.MachineName)
 
 	if err := kubectlApply(kubeconfigPath, renderLinodeDownstreamClusterManifest(cfg)); err != nil {
@@ -289,50 +290,8 @@ func TestHADeleteLinodeDownstream(t *testing.T) {
 	}
 }
 
-type downstreamOutputRecord struct {
-	HAIndex             int    `json:"ha_index"`
-	RancherHost         string `json:"rancher_host"`
-	ClusterName         string `json:"cluster_name"`
-	ManagementClusterID string `json:"management_cluster_id"`
-	KubeconfigPath      string `json:"kubeconfig_path"`
-	K3SVersion          string `json:"k3s_version"`
-	LinodeRegion        string `json:"linode_region"`
-	LinodeType          string `json:"linode_type"`
-	LinodeImage         string `json:"linode_image"`
-	MachineConfig       string `json:"machine_config"`
-	SecretName          string `json:"secret_name"`
-	Namespace           string `json:"namespace"`
-}
-
-func readDownstreamOutputRecords() ([]downstreamOutputRecord, error) {
-	paths, err := filepath.Glob(automationOutputPath("downstream-ha-*.json"))
-	if err != nil {
-		return nil, err
-	}
-
-	records := make([]downstreamOutputRecord, 0, len(paths))
-	for _, path := range paths {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return nil, err
-		}
-		var record downstreamOutputRecord
-		if err := json.Unmarshal(data, &record); err != nil {
-			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
-		}
-		if record.ClusterName == "" || record.HAIndex < 1 {
-			return nil, fmt.Errorf("invalid downstream output record %s", path)
-		}
-		if record.Namespace == "" {
-			record.Namespace = defaultLinodeNamespace
-		}
-		records = append(records, record)
-	}
-	return records, nil
-}
-
 func deleteLinodeDownstream(record downstreamOutputRecord, timeout time.Duration) error {
-	kubeconfigPath := filepath.Join(fmt.Sprintf("high-availability-%d", record.HAIndex), "kube_config.yaml")
+	kubeconfigPath := filepath.Join(haInstanceDir(record.HAIndex), "kube_config.yaml")
 	if _, err := os.Stat(kubeconfigPath); err != nil {
 		return fmt.Errorf("kubeconfig not available for HA %d at %s: %w", record.HAIndex, kubeconfigPath, err)
 	}
@@ -392,23 +351,19 @@ func resolveK3SDefaultVersion(rancherURL, bearerToken string) (string, error) {
 }
 
 func latestK3SReleaseVersionFromRancherMetadata(rancherURL, bearerToken string) (string, error) {
-	rancherURL = strings.TrimRight(clickableURL(rancherURL), "/")
 	if strings.TrimSpace(bearerToken) == "" {
 		return "", fmt.Errorf("bearer token must not be empty")
 	}
-
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	client, err := newRancherAPIClient(rancherURL, rancherclient.Options{Token: bearerToken})
+	if err != nil {
+		return "", err
 	}
 
-	serverVersion, err := rancherServerVersion(client, rancherURL, bearerToken)
+	serverVersion, err := rancherServerVersion(client)
 	if err != nil {
 		return "", err
 	}
-	releases, err := k3sReleasesFromRancherMetadataConfig(client, rancherURL, bearerToken, serverVersion)
+	releases, err := k3sReleasesFromRancherMetadataConfig(client, serverVersion)
 	if err != nil {
 		return "", err
 	}
@@ -420,19 +375,13 @@ func latestK3SReleaseVersionFromRancherMetadata(rancherURL, bearerToken string)
 	return version, nil
 }
 
-func k3sReleasesFromRancherMetadataConfig(client *http.Client, rancherURL, bearerToken, serverVersion string) ([]k3sRelease, error) {
-	var setting struct {
-		Value   string `json:"value"`
-		Default string `json:"default"`
-	}
-	if err := getRancherJSON(client, rancherURL+"/v3/settings/rke-metadata-config", bearerToken, &setting); err != nil {
+func k3sReleasesFromRancherMetadataConfig(client *rancherclient.Client, serverVersion string) ([]k3sRelease, error) {
+	setting, err := client.GetSetting(context.Background(), "rke-metadata-config")
+	if err != nil {
 		return nil, fmt.Errorf("failed to read rke-metadata-config setting: %w", err)
 	}
 
-	metadataConfig := strings.TrimSpace(setting.Value)
-	if metadataConfig == "" {
-		metadataConfig = strings.TrimSpace(setting.Default)
-	}
+	metadataConfig := setting.Effective()
 	if metadataConfig == "" {
 		return nil, fmt.Errorf("rke-metadata-config setting was empty")
 	}
@@ -448,7 +397,7 @@ func k3sReleasesFromRancherMetadataConfig(client *http.Client, rancherURL, beare
 	}
 	log.Printf("[downstream] Reading K3s releases from Rancher KDM metadata %s", config.URL)
 
-	releases, err := fetchK3SReleasesFromMetadataURL(client, config.URL)
+	releases, err := fetchK3SReleasesFromMetadataURL(config.URL)
 	if err != nil {
 		return nil, err
 	}
@@ -456,12 +405,12 @@ func k3sReleasesFromRancherMetadataConfig(client *http.Client, rancherURL, beare
 		return releases, nil
 	}
 
-	candidateURL := kdmMetadataURLForRancherVersion(serverVersion)
+	candidateURL := resolver.KDMMetadataURLForRancherVersion(serverVersion)
 	if candidateURL == "" || candidateURL == config.URL {
 		return releases, nil
 	}
 	log.Printf("[downstream] Rancher KDM metadata %s has no provisionable K3s versions for %s; checking %s", config.URL, serverVersion, candidateURL)
-	candidateReleases, err := fetchK3SReleasesFromMetadataURL(client, candidateURL)
+	candidateReleases, err := fetchK3SReleasesFromMetadataURL(candidateURL)
 	if err != nil {
 		return releases, nil
 	}
@@ -471,60 +420,29 @@ func k3sReleasesFromRancherMetadataConfig(client *http.Client, rancherURL, beare
 	return nil, fmt.Errorf("Rancher KDM metadata %s has no provisionable K3s versions for %s, but %s does; Rancher also needs working /v1-k3s-release/releases and /v1-rke2-release/releases endpoints before downstream provisioning can proceed", config.URL, serverVersion, candidateURL)
 }
 
-func fetchK3SReleasesFromMetadataURL(client *http.Client, metadataURL string) ([]k3sRelease, error) {
-	req, err := http.NewRequest(http.MethodGet, metadataURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch KDM metadata %s: %w", metadataURL, err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+func fetchK3SReleasesFromMetadataURL(metadataURL string) ([]k3sRelease, error) {
+	body, err := resolver.FetchURLBody(metadataURL)
 	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("KDM metadata %s returned HTTP %d: %s", metadataURL, resp.StatusCode, strings.TrimSpace(string(body)))
+		return nil, fmt.Errorf("failed to fetch KDM metadata: %w", err)
 	}
 	var metadata struct {
 		K3S struct {
 			Releases []k3sRelease `json:"releases"`
 		} `json:"k3s"`
 	}
-	if err := json.Unmarshal(body, &metadata); err != nil {
+	if err := json.Unmarshal([]byte(body), &metadata); err != nil {
 		return nil, fmt.Errorf("failed to parse KDM metadata %s: %w", metadataURL, err)
 	}
 	return metadata.K3S.Releases, nil
 }
 
-func kdmMetadataURLForRancherVersion(rancherVersion string) string {
-	version, err := parseRancherVersion(rancherVersion)
+func rancherServerVersion(client *rancherclient.Client) (string, error) {
+	setting, err := client.GetSetting(context.Background(), "server-version")
 	if err != nil {
-		return ""
-	}
-	segments := version.Segments64()
-	if len(segments) < 2 {
-		return ""
-	}
-	return fmt.Sprintf("https://releases.rancher.com/kontainer-driver-metadata/dev-v%d.%d/data.json", segments[0], segments[1])
-}
-
-func rancherServerVersion(client *http.Client, rancherURL, bearerToken string) (string, error) {
-	var setting struct {
-		Value   string `json:"value"`
-		Default string `json:"default"`
-	}
-	if err := getRancherJSON(client, rancherURL+"/v3/settings/server-version", bearerToken, &setting); err != nil {
 		return "", fmt.Errorf("failed to read server-version setting: %w", err)
 	}
 
-	version := strings.TrimSpace(setting.Value)
-	if version == "" {
-		version = strings.TrimSpace(setting.Default)
-	}
+	version := setting.Effective()
 	if version == "" {
 		return "", fmt.Errorf("server-version setting was empty")
 	}
@@ -540,7 +458,7 @@ type k3sRelease struct {
 }
 
 func selectLatestK3SReleaseVersion(releases []k3sRelease, rancherVersion string) (string, error) {
-	serverVersion, err := parseRancherVersion(rancherVersion)
+	serverVersion, err := resolver.ParseRancherVersion(rancherVersion)
 	if err != nil {
 		return "", err
 	}
@@ -560,7 +478,7 @@ func selectLatestK3SReleaseVersion(releases []k3sRelease, rancherVersion string)
 			continue
 		}
 		compatibleCount++
-		parsed, err := parseRancherVersion(version)
+		parsed, err := resolver.ParseRancherVersion(version)
 		if err != nil {
 			continue
 		}
@@ -577,29 +495,17 @@ func selectLatestK3SReleaseVersion(releases []k3sRelease, rancherVersion string)
 }
 
 func k3sReleaseSupportsRancherVersion(release k3sRelease, serverVersion *goversion.Version) bool {
-	minVersion, err := parseRancherVersion(release.MinChannelServerVersion)
+	minVersion, err := resolver.ParseRancherVersion(release.MinChannelServerVersion)
 	if err != nil {
 		return false
 	}
-	maxVersion, err := parseRancherVersion(release.MaxChannelServerVersion)
+	maxVersion, err := resolver.ParseRancherVersion(release.MaxChannelServerVersion)
 	if err != nil {
 		return false
 	}
 	return !serverVersion.LessThan(minVersion) && !maxVersion.LessThan(serverVersion)
 }
 
-func parseRancherVersion(version string) (*goversion.Version, error) {
-	version = strings.TrimSpace(version)
-	if version == "" {
-		return nil, fmt.Errorf("version must not be empty")
-	}
-	parsed, err := goversion.NewVersion(strings.TrimPrefix(version, "v"))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse version %q: %w", version, err)
-	}
-	return parsed, nil
-}
-
 func normalizeK3SVersion(version string) string {
 	return normalizeVersion(version)
 }
@@ -613,32 +519,11 @@ func normalizeVersion(version string) string {
 }
 
 func createLinodeMachineConfig(rancherURL, bearerToken string, cfg downstreamProvisioningConfig) (string, error) {
-	rancherURL = strings.TrimRight(clickableURL(rancherURL), "/")
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
-
-	var out struct {
-		ID       string `json:"id"`
-		Metadata struct {
-			Name string `json:"name"`
-		} `json:"metadata"`
-	}
-	apiURL := fmt.Sprintf("%s/v1/rke-machine-config.cattle.io.linodeconfigs/%s", rancherURL, url.PathEscape(cfg.Namespace))
-	if err := postRancherJSON(client, apiURL, bearerToken, linodeMachineConfigPayload(cfg), &out); err != nil {
+	client, err := newRancherAPIClient(rancherURL, rancherclient.Options{Token: bearerToken})
+	if err != nil {
 		return "", err
 	}
-	if strings.TrimSpace(out.Metadata.Name) != "" {
-		return out.Metadata.Name, nil
-	}
-	if strings.TrimSpace(out.ID) != "" {
-		parts := strings.Split(out.ID, "/")
-		return parts[len(parts)-1], nil
-	}
-	return "", fmt.Errorf("Rancher LinodeConfig response did not include a machine config name")
+	return client.CreateMachineConfig(context.Background(), "linodeconfig", cfg.Namespace, linodeMachineConfigPayload(cfg))
 }
 
 func linodeMachineConfigPayload(cfg downstreamProvisioningConfig) map[string]interface{} {
@@ -909,7 +794,7 @@ func writeDownstreamOutputs(instanceNum int, cfg downstreamProvisioningConfig, h
 	}
 
 	envPath := filepath.Join(outputDir, fmt.Sprintf("downstream-ha-%d.env", instanceNum))
-	adminToken, err := createRancherAdminToken(haOutputs.RancherURL, viper.GetString("rancher.bootstrap_password"))
+	adminToken, err := createRancherToken(instanceNum, haOutputs.RancherURL, viper.GetString("rancher.bootstrap_password"), rancherTokenPurposeSuiteEnv)
 	if err != nil {
 		return err
 	}
@@ -956,11 +841,7 @@ func writeDownstreamKubeconfig(instanceNum int, cfg downstreamProvisioningConfig
 		return "", err
 	}
 	kubeconfigPath := downstreamKubeconfigPath(instanceNum)
-	adminToken, err := createRancherAdminToken(haOutputs.RancherURL, viper.GetString("rancher.bootstrap_password"))
-	if err != nil {
-		return "", err
-	}
-	kubeconfig, err := generateRancherKubeconfig(haOutputs.RancherURL, adminToken, managementClusterID)
+	kubeconfig, err := generateKubeconfigWithTemporaryToken(instanceNum, haOutputs.RancherURL, viper.GetString("rancher.bootstrap_password"), managementClusterID)
 	if err != nil {
 		return "", err
 	}
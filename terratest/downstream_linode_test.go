@@ -3,6 +3,8 @@ package test
 import (
 	"strings"
 	"testing"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 )
 
 func TestRenderLinodeDownstreamResources(t *testing.T) {
@@ -167,10 +169,10 @@ func TestSelectLatestK3SReleaseVersionRequiresCompatibleRange(t *testing.T) {
 }
 
 func TestKDMMetadataURLForRancherVersion(t *testing.T) {
-	got := kdmMetadataURLForRancherVersion("v2.15.0-alpha3")
+	got := resolver.KDMMetadataURLForRancherVersion("v2.15.0-alpha3")
 	want := "https://releases.rancher.com/kontainer-driver-metadata/dev-v2.15/data.json"
 	if got != want {
-		t.Fatalf("kdmMetadataURLForRancherVersion() = %q, want %q", got, want)
+		t.Fatalf("resolver.KDMMetadataURLForRancherVersion() = %q, want %q", got, want)
 	}
 }
 
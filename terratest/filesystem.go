@@ -11,7 +11,7 @@ import (
 )
 
 func cleanupHAInstance(instanceNum int) {
-	haDir := fmt.Sprintf("high-availability-%d", instanceNum)
+	haDir := haInstanceDir(instanceNum)
 
 	filesToRemove := []string{
 		fmt.Sprintf("%s/install.sh", haDir),
@@ -26,7 +26,18 @@ func cleanupHAInstance(instanceNum int) {
 	RemoveFolder(haDir)
 }
 
+// cleanupTerraformFiles removes the active workspace's Terraform files. A named
+// workspace only owns its data dir and state folder; the lock file, backend.tf
+// and tfvars in modules/aws belong to the default workspace.
 func cleanupTerraformFiles() {
+	if name := activeWorkspace(); name != "" {
+		if dataDir, err := terraformDataDir(name); err == nil {
+			RemoveFolder(dataDir)
+		}
+		RemoveFolder(filepath.Join("..", "modules", "aws", "terraform.tfstate.d", name))
+		return
+	}
+
 	files := []string{
 		"../modules/aws/.terraform.lock.hcl",
 		"../modules/aws/backend.tf",
@@ -47,6 +58,9 @@ func cleanupAutomationOutput() {
 }
 
 func automationOutputDir() string {
+	if name := activeWorkspace(); name != "" {
+		return filepath.Join(workspaceRoot(name), "automation-output")
+	}
 	if workspace := strings.TrimSpace(os.Getenv("GITHUB_WORKSPACE")); workspace != "" {
 		return filepath.Join(workspace, "automation-output")
 	}
@@ -57,7 +71,14 @@ func automationOutputPath(name string) string {
 	return filepath.Join(automationOutputDir(), name)
 }
 
-func CreateInstallScript(helmCommand, haDir string) {
+func CreateInstallScript(helmCommand, haDir string, setupCommands ...string) {
+	setup := ""
+	for _, command := range setupCommands {
+		if strings.TrimSpace(command) != "" {
+			setup += command + "\n\n"
+		}
+	}
+
 	installScript := fmt.Sprintf(`#!/bin/bash
 set -euo pipefail
 
@@ -83,10 +104,10 @@ helm repo update
 echo "Creating namespace..."
 kubectl create namespace cattle-system --dry-run=client -o yaml | kubectl apply -f -
 
-echo "Installing Rancher..."
+%secho "Installing Rancher..."
 %s
 
-echo "Rancher installation complete!"`, helmCommand)
+echo "Rancher installation complete!"`, setup, helmCommand)
 
 	currentDir, err := os.Getwd()
 	if err != nil {
@@ -54,7 +54,7 @@ func TestHAWriteLocalSuiteEnv(t *testing.T) {
 }
 
 func writeLocalSuiteEnv(instanceNum int, haOutputs TerraformOutputs) error {
-	adminToken, err := createRancherAdminToken(haOutputs.RancherURL, viper.GetString("rancher.bootstrap_password"))
+	adminToken, err := createRancherToken(instanceNum, haOutputs.RancherURL, viper.GetString("rancher.bootstrap_password"), rancherTokenPurposeSuiteEnv)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,245 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+)
+
+const (
+	haPhaseTerraformApply    = "terraform-apply"
+	haPhaseFirstServer       = "first-server"
+	haPhaseAirgapRegistry    = "airgap-registry"
+	haPhaseNodeToken         = "token"
+	haPhaseAdditionalServers = "additional-servers"
+	haPhaseAgents            = "agents"
+	haPhaseKubeconfig        = "kubeconfig"
+	haPhaseInstallScript     = "install-script"
+
+	haSetupCheckpointFile = "setup-checkpoint.json"
+)
+
+var haSetupPhaseOrder = []string{
+	haPhaseTerraformApply,
+	haPhaseFirstServer,
+	haPhaseAirgapRegistry,
+	haPhaseNodeToken,
+	haPhaseAdditionalServers,
+	haPhaseAgents,
+	haPhaseKubeconfig,
+	haPhaseInstallScript,
+}
+
+type haSetupCheckpoint struct {
+	HAIndex         int                           `json:"ha_index"`
+	Server1IP       string                        `json:"server1_ip,omitempty"`
+	ResolvedPlan    *resolver.RancherResolvedPlan `json:"resolved_plan,omitempty"`
+	CompletedPhases map[string]string             `json:"completed_phases"`
+	UpdatedAt       string                        `json:"updated_at,omitempty"`
+}
+
+type haSetupPhase struct {
+	name   string
+	verify func() (bool, error)
+	run    func() error
+}
+
+func haSetupCheckpointPath(instanceNum int) string {
+	return filepath.Join(haInstanceDir(instanceNum), haSetupCheckpointFile)
+}
+
+func newHASetupCheckpoint(instanceNum int) *haSetupCheckpoint {
+	return &haSetupCheckpoint{
+		HAIndex:         instanceNum,
+		CompletedPhases: map[string]string{},
+	}
+}
+
+func readHASetupCheckpoint(instanceNum int) (*haSetupCheckpoint, error) {
+	data, err := os.ReadFile(haSetupCheckpointPath(instanceNum))
+	if os.IsNotExist(err) {
+		return newHASetupCheckpoint(instanceNum), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HA %d setup checkpoint: %w", instanceNum, err)
+	}
+
+	var checkpoint haSetupCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse HA %d setup checkpoint: %w", instanceNum, err)
+	}
+	checkpoint.HAIndex = instanceNum
+	if checkpoint.CompletedPhases == nil {
+		checkpoint.CompletedPhases = map[string]string{}
+	}
+	return &checkpoint, nil
+}
+
+func writeHASetupCheckpoint(checkpoint *haSetupCheckpoint) error {
+	path := haSetupCheckpointPath(checkpoint.HAIndex)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create HA checkpoint directory: %w", err)
+	}
+
+	checkpoint.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal HA %d setup checkpoint: %w", checkpoint.HAIndex, err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write HA %d setup checkpoint: %w", checkpoint.HAIndex, err)
+	}
+	return nil
+}
+
+func resetHASetupCheckpoint(instanceNum int, server1IP string, resolvedPlan *resolver.RancherResolvedPlan) error {
+	checkpoint := newHASetupCheckpoint(instanceNum)
+	checkpoint.Server1IP = server1IP
+	checkpoint.ResolvedPlan = resolvedPlan
+	checkpoint.CompletedPhases[haPhaseTerraformApply] = time.Now().UTC().Format(time.RFC3339)
+	return writeHASetupCheckpoint(checkpoint)
+}
+
+func (c *haSetupCheckpoint) completed(phase string) bool {
+	return c.CompletedPhases[phase] != ""
+}
+
+func (c *haSetupCheckpoint) markCompleted(phase string) error {
+	c.CompletedPhases[phase] = time.Now().UTC().Format(time.RFC3339)
+	return writeHASetupCheckpoint(c)
+}
+
+// invalidate drops a phase whose work is gone. Later phases keep their records
+// because each one is verified again before it is skipped.
+func (c *haSetupCheckpoint) invalidate(phase string) error {
+	delete(c.CompletedPhases, phase)
+	return writeHASetupCheckpoint(c)
+}
+
+func (c *haSetupCheckpoint) firstIncompletePhase() string {
+	for _, phase := range haSetupPhaseOrder {
+		if !c.completed(phase) {
+			return phase
+		}
+	}
+	return ""
+}
+
+func runHASetupPhases(checkpoint *haSetupCheckpoint, phases []haSetupPhase) error {
+	for _, phase := range phases {
+		if checkpoint.completed(phase.name) {
+			done, err := phase.verify()
+			if err == nil && done {
+				log.Printf("[checkpoint] HA %d phase %s already complete, skipping", checkpoint.HAIndex, phase.name)
+				continue
+			}
+			if err != nil {
+				log.Printf("[checkpoint] HA %d phase %s could not be verified (%v); re-running", checkpoint.HAIndex, phase.name, err)
+			} else {
+				log.Printf("[checkpoint] HA %d phase %s is recorded but no longer in place; re-running", checkpoint.HAIndex, phase.name)
+			}
+			if err := checkpoint.invalidate(phase.name); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("[checkpoint] HA %d running phase %s", checkpoint.HAIndex, phase.name)
+		if err := phase.run(); err != nil {
+			return fmt.Errorf("%s phase failed: %w", phase.name, err)
+		}
+		if err := checkpoint.markCompleted(phase.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rke2FirstServerReady(ip string) (bool, error) {
+	status, err := RunCommand("sudo systemctl is-active --quiet rke2-server && sudo test -f /var/lib/rancher/rke2/server/node-token && echo 'ready' || echo 'not-ready'", ip)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(status) == "ready", nil
+}
+
+func rke2ServiceActive(ip, nodeType string) (bool, error) {
+	service := rke2ServiceName(nodeType)
+	status, err := RunCommand(fmt.Sprintf("sudo systemctl is-active --quiet %s && echo 'active' || echo 'inactive'", service), ip)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(status) == "active", nil
+}
+
+func rke2ServicesActive(ips []string, nodeType string) (bool, error) {
+	for _, ip := range ips {
+		active, err := rke2ServiceActive(ip, nodeType)
+		if err != nil || !active {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func savedKubeconfigTargetsServer(kubeconfigPath, serverIP string) (bool, error) {
+	data, err := os.ReadFile(kubeconfigPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(data), fmt.Sprintf("https://%s:6443", serverIP)), nil
+}
+
+func rancherHelmReleaseDeployed(kubeconfigPath string) (bool, error) {
+	status, err := rancherHelmReleaseStatus(kubeconfigPath)
+	if err != nil {
+		return false, err
+	}
+	return status == "deployed", nil
+}
+
+func rancherHelmReleaseStatus(kubeconfigPath string) (string, error) {
+	output, err := exec.Command("helm", "status", "rancher", "--namespace", "cattle-system", "--kubeconfig", kubeconfigPath, "-o", "json").CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "release: not found") {
+			return "", nil
+		}
+		return "", fmt.Errorf("helm status rancher failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	var status struct {
+		Info struct {
+			Status string `json:"status"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(output, &status); err != nil {
+		return "", fmt.Errorf("failed to parse helm status output: %w", err)
+	}
+	return status.Info.Status, nil
+}
+
+// A release left behind by an interrupted install blocks "helm install", so it is
+// removed before install.sh runs again.
+func removeIncompleteRancherRelease(kubeconfigPath string) error {
+	status, err := rancherHelmReleaseStatus(kubeconfigPath)
+	if err != nil || status == "" || status == "deployed" {
+		return err
+	}
+
+	log.Printf("[checkpoint] Removing Rancher release left in %q state before re-running install.sh", status)
+	output, err := exec.Command("helm", "uninstall", "rancher", "--namespace", "cattle-system", "--kubeconfig", kubeconfigPath, "--wait").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remove incomplete Rancher release: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+)
+
+func TestReadHASetupCheckpointDefaultsWhenMissing(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	checkpoint, err := readHASetupCheckpoint(2)
+	if err != nil {
+		t.Fatalf("expected missing checkpoint to be empty, got %v", err)
+	}
+	if checkpoint.HAIndex != 2 || len(checkpoint.CompletedPhases) != 0 {
+		t.Fatalf("unexpected checkpoint %#v", checkpoint)
+	}
+	if checkpoint.firstIncompletePhase() != haPhaseTerraformApply {
+		t.Fatalf("expected terraform-apply to be first, got %s", checkpoint.firstIncompletePhase())
+	}
+}
+
+func TestResetHASetupCheckpointRecordsTerraformApply(t *testing.T) {
+	t.Chdir(t.TempDir())
+	plan := &resolver.RancherResolvedPlan{RecommendedRKE2Version: "v1.34.6+rke2r3", HelmCommands: []string{"helm install rancher"}}
+
+	if err := resetHASetupCheckpoint(1, "1.2.3.4", plan); err != nil {
+		t.Fatalf("failed to reset checkpoint: %v", err)
+	}
+	info, err := os.Stat(haSetupCheckpointPath(1))
+	if err != nil {
+		t.Fatalf("expected checkpoint file: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected checkpoint mode 0600, got %v", info.Mode().Perm())
+	}
+
+	checkpoint, err := readHASetupCheckpoint(1)
+	if err != nil {
+		t.Fatalf("failed to read checkpoint: %v", err)
+	}
+	if checkpoint.Server1IP != "1.2.3.4" || checkpoint.ResolvedPlan == nil || checkpoint.ResolvedPlan.RecommendedRKE2Version != "v1.34.6+rke2r3" {
+		t.Fatalf("unexpected checkpoint %#v", checkpoint)
+	}
+	if checkpoint.firstIncompletePhase() != haPhaseFirstServer {
+		t.Fatalf("expected first-server to be next, got %s", checkpoint.firstIncompletePhase())
+	}
+}
+
+func TestRunHASetupPhasesSkipsVerifiedPhasesAndResumes(t *testing.T) {
+	t.Chdir(t.TempDir())
+	checkpoint := newHASetupCheckpoint(1)
+	checkpoint.CompletedPhases[haPhaseTerraformApply] = "2026-01-01T00:00:00Z"
+	checkpoint.CompletedPhases[haPhaseFirstServer] = "2026-01-01T00:00:00Z"
+
+	var ran []string
+	phases := fakeHASetupPhases(&ran, map[string]bool{
+		haPhaseTerraformApply: true,
+		haPhaseFirstServer:    true,
+	}, "")
+
+	if err := runHASetupPhases(checkpoint, phases); err != nil {
+		t.Fatalf("expected phases to succeed, got %v", err)
+	}
+	expected := []string{haPhaseAirgapRegistry, haPhaseNodeToken, haPhaseAdditionalServers, haPhaseAgents, haPhaseKubeconfig, haPhaseInstallScript}
+	if !reflect.DeepEqual(ran, expected) {
+		t.Fatalf("expected %v to run, got %v", expected, ran)
+	}
+	if checkpoint.firstIncompletePhase() != "" {
+		t.Fatalf("expected all phases complete, got %s pending", checkpoint.firstIncompletePhase())
+	}
+}
+
+func TestRunHASetupPhasesRerunsPhaseThatIsNoLongerInPlace(t *testing.T) {
+	t.Chdir(t.TempDir())
+	checkpoint := newHASetupCheckpoint(1)
+	for _, phase := range haSetupPhaseOrder {
+		checkpoint.CompletedPhases[phase] = "2026-01-01T00:00:00Z"
+	}
+
+	var ran []string
+	phases := fakeHASetupPhases(&ran, map[string]bool{
+		haPhaseTerraformApply: true,
+		haPhaseFirstServer:    true,
+		haPhaseAirgapRegistry: true,
+		haPhaseNodeToken:      true,
+		haPhaseAgents:         true,
+		haPhaseKubeconfig:     true,
+		haPhaseInstallScript:  true,
+	}, "")
+
+	if err := runHASetupPhases(checkpoint, phases); err != nil {
+		t.Fatalf("expected phases to succeed, got %v", err)
+	}
+	expected := []string{haPhaseAdditionalServers}
+	if !reflect.DeepEqual(ran, expected) {
+		t.Fatalf("expected %v to run, got %v", expected, ran)
+	}
+}
+
+func TestRunHASetupPhasesStopsAtFailureAndKeepsEarlierPhases(t *testing.T) {
+	t.Chdir(t.TempDir())
+	checkpoint := newHASetupCheckpoint(3)
+
+	var ran []string
+	phases := fakeHASetupPhases(&ran, nil, haPhaseInstallScript)
+
+	err := runHASetupPhases(checkpoint, phases)
+	if err == nil || !strings.Contains(err.Error(), "install-script phase failed") {
+		t.Fatalf("expected install-script failure, got %v", err)
+	}
+
+	reloaded, readErr := readHASetupCheckpoint(3)
+	if readErr != nil {
+		t.Fatalf("failed to read checkpoint: %v", readErr)
+	}
+	if reloaded.firstIncompletePhase() != haPhaseInstallScript {
+		t.Fatalf("expected install-script to be next, got %s", reloaded.firstIncompletePhase())
+	}
+}
+
+func fakeHASetupPhases(ran *[]string, verified map[string]bool, failing string) []haSetupPhase {
+	phases := make([]haSetupPhase, 0, len(haSetupPhaseOrder))
+	for _, name := range haSetupPhaseOrder {
+		phases = append(phases, haSetupPhase{
+			name: name,
+			verify: func() (bool, error) {
+				return verified[name], nil
+			},
+			run: func() error {
+				*ran = append(*ran, name)
+				if name == failing {
+					return errors.New("boom")
+				}
+				return nil
+			},
+		})
+	}
+	return phases
+}
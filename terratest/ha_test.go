@@ -6,6 +6,7 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/spf13/viper"
@@ -33,13 +34,29 @@ func TestHaSetup(t *testing.T) {
 	if err := settings.ValidateCustomHostnameConfig(totalHAs); err != nil {
 		t.Fatalf("Custom Rancher URL preflight failed: %v", err)
 	}
+	if err := settings.ValidateTopologyConfig(); err != nil {
+		t.Fatalf("Node topology preflight failed: %v", err)
+	}
+	if err := settings.ValidateTLSConfig(); err != nil {
+		t.Fatalf("TLS preflight failed: %v", err)
+	}
+	if err := settings.ValidateRancherSettingsConfig(); err != nil {
+		t.Fatalf("Rancher settings preflight failed: %v", err)
+	}
+	if err := validateRKE2ConfigPreflight(totalHAs); err != nil {
+		t.Fatalf("RKE2 config preflight failed: %v", err)
+	}
 
 	helmCommands := viper.GetStringSlice("rancher.helm_commands")
 	if len(helmCommands) != totalHAs {
 		t.Fatalf("Number of Helm commands (%d) does not match the number of HA instances (%d). Please ensure you have exactly %d Helm commands in your configuration.",
 			len(helmCommands), totalHAs, totalHAs)
 	}
-	if err := validateRancherHelmCommandsUseExternalTLS(helmCommands); err != nil {
+	tlsMode, err := settings.ConfiguredTLSMode()
+	if err != nil {
+		t.Fatalf("TLS preflight failed: %v", err)
+	}
+	if err := validateRancherHelmCommandsForTLSMode(tlsMode, helmCommands); err != nil {
 		t.Fatalf("Rancher Helm command preflight failed before provisioning infrastructure: %v", err)
 	}
 
@@ -54,10 +71,23 @@ func TestHaSetup(t *testing.T) {
 	if err := validateSecretEnvironment(); err != nil {
 		t.Fatalf("Secret environment preflight failed before provisioning infrastructure: %v", err)
 	}
+	if err := validateRegistriesPreflight(); err != nil {
+		t.Fatalf("Registries preflight failed before provisioning infrastructure: %v", err)
+	}
+
+	if err := validateNodeTransportPreflight(); err != nil {
+		t.Fatalf("Node transport preflight failed before provisioning infrastructure: %v", err)
+	}
+	if err := validateAirgapPreflight(); err != nil {
+		t.Fatalf("Airgap preflight failed before provisioning infrastructure: %v", err)
+	}
 
 	if err := validatePinnedRKE2InstallerChecksum(resolvedPlans); err != nil {
 		t.Fatalf("RKE2 installer checksum preflight failed before provisioning infrastructure: %v", err)
 	}
+	if err := stageAirgapArtifacts(totalHAs, resolvedPlans); err != nil {
+		t.Fatalf("Airgap artifact staging failed before provisioning infrastructure: %v", err)
+	}
 
 	for i, plan := range resolvedPlans {
 		if err := writeRancherResolutionArtifact("install", i+1, plan); err != nil {
@@ -66,13 +96,98 @@ func TestHaSetup(t *testing.T) {
 	}
 
 	terraformOptions := getTerraformOptions(t, totalHAs)
-	terraform.InitAndApply(t, terraformOptions)
+	initTerraformWorkspace(t, terraformOptions)
+	terraform.Apply(t, terraformOptions)
 
 	outputs := getTerraformOutputs(t, terraformOptions)
 	if len(outputs) == 0 {
 		t.Fatal("No outputs received from terraform")
 	}
 
+	for i := 1; i <= totalHAs; i++ {
+		var resolvedPlan *resolver.RancherResolvedPlan
+		if len(resolvedPlans) >= i {
+			resolvedPlan = resolvedPlans[i-1]
+		}
+		if err := resetHASetupCheckpoint(i, getHAOutputs(i, outputs).FirstServerIP(), resolvedPlan); err != nil {
+			t.Fatalf("Failed to write HA %d setup checkpoint: %v", i, err)
+		}
+	}
+
+	if err := setupHAInstances(t, totalHAs, outputs, resolvedPlans); err != nil {
+		t.Fatalf("Error during parallel HA setup: %v", err)
+	}
+
+	logHASummary(totalHAs, outputs, resolvedPlans)
+}
+
+func TestHAResume(t *testing.T) {
+	requireExplicitLifecycleTest(t, "TestHAResume")
+	setupConfig(t)
+
+	totalHAs := viper.GetInt("total_has")
+	if totalHAs < 1 {
+		t.Fatal("total_has must be at least 1")
+	}
+	if err := validateSecretEnvironment(); err != nil {
+		t.Fatalf("Secret environment preflight failed before resume: %v", err)
+	}
+	if err := validateRegistriesPreflight(); err != nil {
+		t.Fatalf("Registries preflight failed before resume: %v", err)
+	}
+	if err := validateNodeTransportPreflight(); err != nil {
+		t.Fatalf("Node transport preflight failed before resume: %v", err)
+	}
+	if err := validateAirgapPreflight(); err != nil {
+		t.Fatalf("Airgap preflight failed before resume: %v", err)
+	}
+	if err := validateRKE2ConfigPreflight(totalHAs); err != nil {
+		t.Fatalf("RKE2 config preflight failed before resume: %v", err)
+	}
+	if err := settings.ValidateTLSConfig(); err != nil {
+		t.Fatalf("TLS preflight failed before resume: %v", err)
+	}
+	if err := settings.ValidateRancherSettingsConfig(); err != nil {
+		t.Fatalf("Rancher settings preflight failed before resume: %v", err)
+	}
+
+	resolvedPlans := make([]*resolver.RancherResolvedPlan, totalHAs)
+	for i := 1; i <= totalHAs; i++ {
+		checkpoint, err := readHASetupCheckpoint(i)
+		if err != nil {
+			t.Fatalf("Failed to read HA %d setup checkpoint: %v", i, err)
+		}
+		if !checkpoint.completed(haPhaseTerraformApply) {
+			t.Fatalf("HA %d has no terraform-apply checkpoint in %s; run TestHaSetup first", i, haSetupCheckpointPath(i))
+		}
+		if phase := checkpoint.firstIncompletePhase(); phase != "" {
+			log.Printf("[resume] HA %d will continue from phase %s", i, phase)
+		} else {
+			log.Printf("[resume] HA %d has every phase recorded; verifying each one", i)
+		}
+		resolvedPlans[i-1] = checkpoint.ResolvedPlan
+	}
+	if err := stageAirgapArtifacts(totalHAs, resolvedPlans); err != nil {
+		t.Fatalf("Airgap artifact staging failed before resume: %v", err)
+	}
+
+	terraformOptions := getTerraformOptions(t, totalHAs)
+	outputs, err := getTerraformOutputsE(t, terraformOptions)
+	if err != nil {
+		t.Fatalf("Failed to read Terraform outputs for resume: %v", err)
+	}
+	if len(outputs) == 0 {
+		t.Fatal("No outputs received from terraform")
+	}
+
+	if err := setupHAInstances(t, totalHAs, outputs, resolvedPlans); err != nil {
+		t.Fatalf("Error during parallel HA resume: %v", err)
+	}
+
+	logHASummary(totalHAs, outputs, resolvedPlans)
+}
+
+func setupHAInstances(t *testing.T, totalHAs int, outputs map[string]string, resolvedPlans []*resolver.RancherResolvedPlan) error {
 	var wg sync.WaitGroup
 	var setupErr error
 	var setupErrMutex sync.Mutex
@@ -87,7 +202,7 @@ func TestHaSetup(t *testing.T) {
 			log.Printf("Starting setup for HA instance %d", instanceNum)
 
 			t.Run(fmt.Sprintf("HA%d", instanceNum), func(subT *testing.T) {
-				var resolvedPlan *RancherResolvedPlan
+				var resolvedPlan *resolver.RancherResolvedPlan
 				if len(resolvedPlans) >= instanceNum {
 					resolvedPlan = resolvedPlans[instanceNum-1]
 				}
@@ -102,12 +217,7 @@ func TestHaSetup(t *testing.T) {
 	}
 
 	wg.Wait()
-
-	if setupErr != nil {
-		t.Fatalf("Error during parallel HA setup: %v", setupErr)
-	}
-
-	logHASummary(totalHAs, outputs, resolvedPlans)
+	return setupErr
 }
 
 func TestHACleanup(t *testing.T) {
@@ -119,6 +229,11 @@ func TestHACleanup(t *testing.T) {
 	}
 
 	terraformOptions := getTerraformOptions(t, totalHAs)
+	workspace := activeWorkspace()
+	if workspace != "" {
+		// Cleanup of another workspace may have removed the shared lock file.
+		initTerraformWorkspace(t, terraformOptions)
+	}
 	var costEstimate *cleanupCostEstimate
 	outputs, outputsErr := getTerraformOutputsE(t, terraformOptions)
 	if outputsErr != nil {
@@ -130,7 +245,17 @@ func TestHACleanup(t *testing.T) {
 			log.Printf("[cleanup] Could not estimate EC2/EBS cost before destroy: %v", estimateErr)
 		}
 	}
+	for i := 1; i <= totalHAs; i++ {
+		if err := revokeRecordedRancherTokens(rancherTokenLedgerPath(i), viper.GetString("rancher.bootstrap_password")); err != nil {
+			log.Printf("[cleanup] Could not revoke recorded Rancher tokens for HA %d: %v", i, err)
+		}
+	}
 	terraform.Destroy(t, terraformOptions)
+	if workspace != "" {
+		if _, err := terraform.WorkspaceDeleteE(t, terraformOptions, workspace); err != nil {
+			log.Printf("[cleanup] Could not delete Terraform workspace %s: %v", workspace, err)
+		}
+	}
 
 	for i := 1; i <= totalHAs; i++ {
 		cleanupHAInstance(i)
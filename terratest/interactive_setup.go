@@ -15,6 +15,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
 	"github.com/brudnak/ha-rancher-rke2/terratest/ui"
 	"github.com/spf13/viper"
@@ -38,7 +39,7 @@ type interactiveEvent struct {
 }
 
 type interactiveResult struct {
-	plans []*RancherResolvedPlan
+	plans []*resolver.RancherResolvedPlan
 	err   error
 }
 
@@ -60,14 +61,14 @@ type interactiveServer struct {
 	logs        []string
 	planText    string
 	resolveErr  string
-	plans       []*RancherResolvedPlan
+	plans       []*resolver.RancherResolvedPlan
 	subscribers []chan interactiveEvent
 	submitted   bool
 
 	resultCh chan interactiveResult
 }
 
-func resolveRancherSetup() ([]*RancherResolvedPlan, error) {
+func resolveRancherSetup() ([]*resolver.RancherResolvedPlan, error) {
 	mode := rancherMode()
 	autoApprove := viper.GetBool("rancher.auto_approve")
 
@@ -96,7 +97,7 @@ func resolveRancherSetup() ([]*RancherResolvedPlan, error) {
 	return plans, nil
 }
 
-func runInteractiveAutoModeSetup() ([]*RancherResolvedPlan, error) {
+func runInteractiveAutoModeSetup() ([]*resolver.RancherResolvedPlan, error) {
 	configPath := strings.TrimSpace(viper.ConfigFileUsed())
 	if configPath == "" {
 		return nil, fmt.Errorf("failed to determine tool-config.yml path for interactive setup")
@@ -434,7 +435,7 @@ func (s *interactiveServer) runResolution() {
 
 	totalHAs := viper.GetInt("total_has")
 	err := settings.ValidateCustomHostnameConfig(totalHAs)
-	var plans []*RancherResolvedPlan
+	var plans []*resolver.RancherResolvedPlan
 	if err == nil {
 		plans, err = prepareRancherConfiguration(totalHAs)
 	}
@@ -14,7 +14,9 @@ var explicitLifecycleTests = []string{
 	"TestHAWaitWebhookChartVersion",
 	"TestHAWaitReady",
 	"TestHAUpgradeRancher",
+	"TestHABackupRestore",
 	"TestHaSetup",
+	"TestHAResume",
 	"TestHACleanup",
 	"TestHAControlPanel",
 	"TestHAProvisionLinodeDownstream",
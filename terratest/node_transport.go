@@ -0,0 +1,395 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	nodeTransportSSM = "ssm"
+	nodeTransportSSH = "ssh"
+
+	defaultSSHUser           = "ubuntu"
+	defaultSSHPort           = 22
+	defaultSSHDialTimeout    = 10 * time.Second
+	defaultSSHReadyTimeout   = 120 * time.Second
+	defaultSSHRetryInterval  = 5 * time.Second
+	defaultSSHCommandTimeout = 600 * time.Second
+)
+
+// NodeExecutor runs a shell command as root on a cluster node addressed by its
+// public IP and returns trimmed stdout.
+type NodeExecutor interface {
+	Name() string
+	Run(cmd string, pubIP string) (string, error)
+}
+
+type sshNodeExecutor struct {
+	user            string
+	port            int
+	signer          ssh.Signer
+	hostKeyCallback ssh.HostKeyCallback
+	dialTimeout     time.Duration
+	readyTimeout    time.Duration
+	retryInterval   time.Duration
+	commandTimeout  time.Duration
+}
+
+func configuredNodeTransport() (string, error) {
+	transport := strings.ToLower(strings.TrimSpace(viper.GetString("node_transport")))
+	switch transport {
+	case "", nodeTransportSSM:
+		return nodeTransportSSM, nil
+	case nodeTransportSSH:
+		return nodeTransportSSH, nil
+	default:
+		return "", fmt.Errorf("node_transport must be %q or %q, got %q", nodeTransportSSM, nodeTransportSSH, transport)
+	}
+}
+
+func configuredNodeExecutor() (NodeExecutor, error) {
+	transport, err := configuredNodeTransport()
+	if err != nil {
+		return nil, err
+	}
+	if transport == nodeTransportSSM {
+		return ssmNodeExecutor{}, nil
+	}
+	return configuredSSHNodeExecutor()
+}
+
+// sshNodeExecutorSettings is everything an SSH executor is built from. The
+// executor is cached per settings so RunCommand does not reread and re-parse
+// the private key for every command.
+type sshNodeExecutorSettings struct {
+	keyPath        string
+	user           string
+	port           int
+	knownHostsPath string
+	// insecureIgnoreHostKey is the explicit opt-out from host key checking
+	// when there is no known_hosts file to pin against.
+	insecureIgnoreHostKey bool
+}
+
+type cachedSSHNodeExecutor struct {
+	once     sync.Once
+	executor *sshNodeExecutor
+	err      error
+}
+
+var sshNodeExecutorCache struct {
+	mu       sync.Mutex
+	settings sshNodeExecutorSettings
+	entry    *cachedSSHNodeExecutor
+}
+
+func configuredSSHNodeExecutor() (*sshNodeExecutor, error) {
+	settings, err := configuredSSHNodeExecutorSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	sshNodeExecutorCache.mu.Lock()
+	if sshNodeExecutorCache.entry == nil || sshNodeExecutorCache.settings != settings {
+		sshNodeExecutorCache.settings = settings
+		sshNodeExecutorCache.entry = &cachedSSHNodeExecutor{}
+	}
+	entry := sshNodeExecutorCache.entry
+	sshNodeExecutorCache.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.executor, entry.err = settings.newExecutor()
+	})
+	if entry.err != nil {
+		// Don't cache failures: a missing key or known_hosts file may be
+		// created before the next command.
+		sshNodeExecutorCache.mu.Lock()
+		if sshNodeExecutorCache.entry == entry {
+			sshNodeExecutorCache.entry = nil
+		}
+		sshNodeExecutorCache.mu.Unlock()
+	}
+	return entry.executor, entry.err
+}
+
+func configuredSSHNodeExecutorSettings() (sshNodeExecutorSettings, error) {
+	keyPath, err := configuredSSHPrivateKeyPath()
+	if err != nil {
+		return sshNodeExecutorSettings{}, err
+	}
+
+	user := strings.TrimSpace(viper.GetString("ssh.user"))
+	if user == "" {
+		user = defaultSSHUser
+	}
+	port := viper.GetInt("ssh.port")
+	if port == 0 {
+		port = defaultSSHPort
+	}
+	if port < 0 || port > 65535 {
+		return sshNodeExecutorSettings{}, fmt.Errorf("ssh.port must be between 1 and 65535, got %d", port)
+	}
+
+	knownHostsPath := strings.TrimSpace(viper.GetString("ssh.known_hosts_path"))
+	insecureIgnoreHostKey := false
+	if knownHostsPath != "" {
+		knownHostsPath, err = settings.ExpandHomePath(knownHostsPath)
+		if err != nil {
+			return sshNodeExecutorSettings{}, err
+		}
+	} else {
+		insecureIgnoreHostKey = viper.GetBool("ssh.insecure_ignore_host_key")
+		if !insecureIgnoreHostKey {
+			return sshNodeExecutorSettings{}, fmt.Errorf("ssh.known_hosts_path must be set when node_transport is ssh, or set ssh.insecure_ignore_host_key: true to skip host key checking")
+		}
+	}
+
+	return sshNodeExecutorSettings{
+		keyPath:               keyPath,
+		user:                  user,
+		port:                  port,
+		knownHostsPath:        knownHostsPath,
+		insecureIgnoreHostKey: insecureIgnoreHostKey,
+	}, nil
+}
+
+func (s sshNodeExecutorSettings) newExecutor() (*sshNodeExecutor, error) {
+	keyPEM, err := os.ReadFile(s.keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH private key %s: %w", s.keyPath, err)
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if s.insecureIgnoreHostKey {
+		log.Printf("[SSH] WARNING: ssh.insecure_ignore_host_key is set; node host keys are NOT verified and connections can be intercepted")
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		hostKeyCallback, err = knownhosts.New(s.knownHostsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ssh.known_hosts_path %s: %w", s.knownHostsPath, err)
+		}
+	}
+
+	return newSSHNodeExecutor(s.user, s.port, keyPEM, hostKeyCallback)
+}
+
+func newSSHNodeExecutor(user string, port int, keyPEM []byte, hostKeyCallback ssh.HostKeyCallback) (*sshNodeExecutor, error) {
+	signer, err := ssh.ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH private key: %w", err)
+	}
+	if hostKeyCallback == nil {
+		return nil, errors.New("an SSH host key callback is required")
+	}
+	return &sshNodeExecutor{
+		user:            user,
+		port:            port,
+		signer:          signer,
+		hostKeyCallback: hostKeyCallback,
+		dialTimeout:     defaultSSHDialTimeout,
+		readyTimeout:    defaultSSHReadyTimeout,
+		retryInterval:   defaultSSHRetryInterval,
+		commandTimeout:  defaultSSHCommandTimeout,
+	}, nil
+}
+
+func configuredSSHPrivateKeyPath() (string, error) {
+	if keyPath := strings.TrimSpace(viper.GetString("ssh.private_key_path")); keyPath != "" {
+		return settings.ExpandHomePath(keyPath)
+	}
+
+	keyName := strings.TrimSpace(viper.GetString("tf_vars.aws_pem_key_name"))
+	if keyName == "" {
+		return "", fmt.Errorf("ssh.private_key_path or tf_vars.aws_pem_key_name must be set when node_transport is ssh")
+	}
+	if !strings.HasSuffix(keyName, ".pem") {
+		keyName += ".pem"
+	}
+	return settings.ExpandHomePath(filepath.Join("~", ".ssh", keyName))
+}
+
+func (e *sshNodeExecutor) Name() string {
+	return nodeTransportSSH
+}
+
+func (e *sshNodeExecutor) Run(cmd string, pubIP string) (string, error) {
+	client, err := e.dial(pubIP)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to open SSH session to %s: %w", pubIP, err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	log.Printf("[SSH] Sending command to %s@%s", e.user, pubIP)
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(sshRemoteCommand(cmd))
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(e.commandTimeout):
+		client.Close()
+		return "", fmt.Errorf("command timed out after %s", e.commandTimeout)
+	}
+
+	if err != nil {
+		log.Printf("[SSH] Command FAILED on %s: %v", pubIP, err)
+		log.Printf("[SSH] Failure output sizes: stdout=%d bytes stderr=%d bytes", stdout.Len(), stderr.Len())
+		if isRKE2InstallerChecksumFailure(stdout.String(), stderr.String()) {
+			log.Printf("[SSH] SECURITY ERROR: RKE2 installer checksum validation failed on remote node")
+			return "", fmt.Errorf("remote RKE2 installer checksum validation failed")
+		}
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("command failed with exit status %d", exitErr.ExitStatus())
+		}
+		return "", fmt.Errorf("failed to run SSH command on %s: %w", pubIP, err)
+	}
+
+	if stderr.Len() > 0 {
+		log.Printf("[SSH] Command completed with stderr output (%d bytes)", stderr.Len())
+	}
+
+	trimmedOutput := strings.TrimRight(stdout.String(), "\r\n")
+	log.Printf("[SSH] Command completed successfully. Output length: %d bytes", len(trimmedOutput))
+	return trimmedOutput, nil
+}
+
+func (e *sshNodeExecutor) dial(pubIP string) (*ssh.Client, error) {
+	address := net.JoinHostPort(pubIP, strconv.Itoa(e.port))
+	config := &ssh.ClientConfig{
+		User:            e.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(e.signer)},
+		HostKeyCallback: e.hostKeyCallback,
+		Timeout:         e.dialTimeout,
+	}
+
+	deadline := time.Now().Add(e.readyTimeout)
+	for attempt := 1; ; attempt++ {
+		client, err := ssh.Dial("tcp", address, config)
+		if err == nil {
+			return client, nil
+		}
+		if !time.Now().Add(e.retryInterval).Before(deadline) {
+			return nil, fmt.Errorf("SSH not reachable at %s after %s: %w", address, e.readyTimeout, err)
+		}
+		if attempt%6 == 0 {
+			log.Printf("[SSH] Still waiting for %s to accept connections: %v", address, err)
+		}
+		time.Sleep(e.retryInterval)
+	}
+}
+
+// CopyFile streams a local file to the node over an SSH session and installs
+// it as root, so large artifacts never pass through a shell command.
+func (e *sshNodeExecutor) CopyFile(localPath, remotePath, mode, pubIP string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	client, err := e.dial(pubIP)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session to %s: %w", pubIP, err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = file
+	session.Stderr = &stderr
+
+	log.Printf("[SSH] Copying %s to %s:%s", filepath.Base(localPath), pubIP, remotePath)
+	cmd := fmt.Sprintf("sudo -n install -D -m %s /dev/stdin %s", shellSingleQuote(mode), shellSingleQuote(remotePath))
+	if err := session.Run(cmd); err != nil {
+		return fmt.Errorf("failed to copy %s to %s:%s: %w: %s", localPath, pubIP, remotePath, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Forward listens on a local port and tunnels each connection to remoteAddr as
+// seen from the node. The returned stop function closes the listener and the
+// SSH connection.
+func (e *sshNodeExecutor) Forward(pubIP, remoteAddr string) (string, func(), error) {
+	client, err := e.dial(pubIP)
+	if err != nil {
+		return "", nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		client.Close()
+		return "", nil, fmt.Errorf("failed to open local tunnel listener: %w", err)
+	}
+
+	go func() {
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer local.Close()
+				remote, err := client.Dial("tcp", remoteAddr)
+				if err != nil {
+					log.Printf("[SSH] Tunnel to %s via %s failed: %v", remoteAddr, pubIP, err)
+					return
+				}
+				defer remote.Close()
+
+				done := make(chan struct{}, 2)
+				go func() {
+					io.Copy(remote, local)
+					done <- struct{}{}
+				}()
+				go func() {
+					io.Copy(local, remote)
+					done <- struct{}{}
+				}()
+				<-done
+			}()
+		}
+	}()
+
+	stop := func() {
+		listener.Close()
+		client.Close()
+	}
+	return listener.Addr().String(), stop, nil
+}
+
+// SSM's AWS-RunShellScript runs commands as root, so the SSH path escalates the
+// same way to keep node commands transport-agnostic.
+func sshRemoteCommand(cmd string) string {
+	return "sudo -n bash -c " + shellSingleQuote(cmd)
+}
@@ -0,0 +1,358 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+)
+
+type testSSHServer struct {
+	address string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newTestSSHKeyPEM(t *testing.T) ([]byte, ssh.PublicKey) {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(block), signer.PublicKey()
+}
+
+// startTestSSHServer accepts exec requests from authorizedKey and answers them
+// through respond, which returns stdout, stderr, and the exit status.
+func startTestSSHServer(t *testing.T, authorizedKey ssh.PublicKey, respond func(command string) (string, string, uint32)) *testSSHServer {
+	t.Helper()
+	hostKeyPEM, _ := newTestSSHKeyPEM(t)
+	hostSigner, err := ssh.ParsePrivateKey(hostKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "ubuntu" && bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &testSSHServer{address: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serveConn(conn, config, respond)
+		}
+	}()
+	return server
+}
+
+func (s *testSSHServer) serveConn(conn net.Conn, config *ssh.ServerConfig, respond func(string) (string, string, uint32)) {
+	defer conn.Close()
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for request := range channelRequests {
+				if request.Type != "exec" {
+					request.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				if err := ssh.Unmarshal(request.Payload, &payload); err != nil {
+					request.Reply(false, nil)
+					return
+				}
+				request.Reply(true, nil)
+
+				s.mu.Lock()
+				s.commands = append(s.commands, payload.Command)
+				s.mu.Unlock()
+
+				stdout, stderr, status := respond(payload.Command)
+				channel.Write([]byte(stdout))
+				channel.Stderr().Write([]byte(stderr))
+				exitStatus := make([]byte, 4)
+				binary.BigEndian.PutUint32(exitStatus, status)
+				channel.SendRequest("exit-status", false, exitStatus)
+				return
+			}
+		}()
+	}
+}
+
+func (s *testSSHServer) hostAndPort(t *testing.T) (string, int) {
+	t.Helper()
+	host, portValue, err := net.SplitHostPort(s.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, port
+}
+
+func newTestSSHNodeExecutor(t *testing.T, port int, keyPEM []byte) *sshNodeExecutor {
+	t.Helper()
+	executor, err := newSSHNodeExecutor("ubuntu", port, keyPEM, ssh.InsecureIgnoreHostKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor.readyTimeout = 2 * time.Second
+	executor.retryInterval = 10 * time.Millisecond
+	executor.commandTimeout = 5 * time.Second
+	return executor
+}
+
+func TestSSHNodeExecutorRunsCommandAsRootAndTrimsOutput(t *testing.T) {
+	keyPEM, publicKey := newTestSSHKeyPEM(t)
+	server := startTestSSHServer(t, publicKey, func(command string) (string, string, uint32) {
+		return "node-token\r\n", "warning: noisy\n", 0
+	})
+	host, port := server.hostAndPort(t)
+
+	output, err := newTestSSHNodeExecutor(t, port, keyPEM).Run("cat /var/lib/rancher/rke2/server/node-token", host)
+	if err != nil {
+		t.Fatalf("expected command to succeed, got %v", err)
+	}
+	if output != "node-token" {
+		t.Fatalf("expected trimmed output, got %q", output)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.commands) != 1 {
+		t.Fatalf("expected one remote command, got %#v", server.commands)
+	}
+	expected := "sudo -n bash -c 'cat /var/lib/rancher/rke2/server/node-token'"
+	if server.commands[0] != expected {
+		t.Fatalf("expected %q, got %q", expected, server.commands[0])
+	}
+}
+
+func TestSSHNodeExecutorReportsExitStatus(t *testing.T) {
+	keyPEM, publicKey := newTestSSHKeyPEM(t)
+	server := startTestSSHServer(t, publicKey, func(command string) (string, string, uint32) {
+		return "", "systemctl failed\n", 3
+	})
+	host, port := server.hostAndPort(t)
+
+	_, err := newTestSSHNodeExecutor(t, port, keyPEM).Run("systemctl start rke2-server", host)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("expected exit status error, got %v", err)
+	}
+}
+
+func TestSSHNodeExecutorDetectsInstallerChecksumFailure(t *testing.T) {
+	keyPEM, publicKey := newTestSSHKeyPEM(t)
+	server := startTestSSHServer(t, publicKey, func(command string) (string, string, uint32) {
+		return "", "SECURITY ERROR: RKE2 installer checksum validation failed\n", 1
+	})
+	host, port := server.hostAndPort(t)
+
+	_, err := newTestSSHNodeExecutor(t, port, keyPEM).Run("install rke2", host)
+	if err == nil || !strings.Contains(err.Error(), "checksum validation failed") {
+		t.Fatalf("expected checksum failure, got %v", err)
+	}
+}
+
+func TestSSHNodeExecutorRejectsUnauthorizedKey(t *testing.T) {
+	_, authorizedKey := newTestSSHKeyPEM(t)
+	otherKeyPEM, _ := newTestSSHKeyPEM(t)
+	server := startTestSSHServer(t, authorizedKey, func(command string) (string, string, uint32) {
+		return "unexpected", "", 0
+	})
+	host, port := server.hostAndPort(t)
+
+	executor := newTestSSHNodeExecutor(t, port, otherKeyPEM)
+	executor.readyTimeout = 50 * time.Millisecond
+	if _, err := executor.Run("true", host); err == nil || !strings.Contains(err.Error(), "SSH not reachable") {
+		t.Fatalf("expected authentication failure, got %v", err)
+	}
+}
+
+func TestConfiguredNodeExecutorDefaultsToSSM(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	executor, err := configuredNodeExecutor()
+	if err != nil {
+		t.Fatalf("expected default transport, got %v", err)
+	}
+	if executor.Name() != nodeTransportSSM {
+		t.Fatalf("expected ssm transport, got %s", executor.Name())
+	}
+}
+
+func TestConfiguredNodeExecutorRejectsUnknownTransport(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("node_transport", "telnet")
+
+	if _, err := configuredNodeExecutor(); err == nil || !strings.Contains(err.Error(), "node_transport") {
+		t.Fatalf("expected node_transport error, got %v", err)
+	}
+}
+
+func TestConfiguredNodeExecutorUsesPemKeyNameForSSH(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	keyPEM, _ := newTestSSHKeyPEM(t)
+	if err := os.MkdirAll(filepath.Join(homeDir, ".ssh"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(homeDir, ".ssh", "qa-key.pem"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("node_transport", "SSH")
+	viper.Set("ssh.insecure_ignore_host_key", true)
+	viper.Set("tf_vars.aws_pem_key_name", "qa-key")
+
+	executor, err := configuredNodeExecutor()
+	if err != nil {
+		t.Fatalf("expected ssh executor, got %v", err)
+	}
+	sshExecutor, ok := executor.(*sshNodeExecutor)
+	if !ok {
+		t.Fatalf("expected *sshNodeExecutor, got %T", executor)
+	}
+	if sshExecutor.user != defaultSSHUser || sshExecutor.port != defaultSSHPort {
+		t.Fatalf("expected default user and port, got %s:%d", sshExecutor.user, sshExecutor.port)
+	}
+}
+
+func TestConfiguredNodeExecutorReportsMissingSSHKey(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("node_transport", "ssh")
+	viper.Set("ssh.insecure_ignore_host_key", true)
+	viper.Set("ssh.private_key_path", filepath.Join(t.TempDir(), "missing.pem"))
+
+	if _, err := configuredNodeExecutor(); err == nil || !strings.Contains(err.Error(), "missing.pem") {
+		t.Fatalf("expected missing key error, got %v", err)
+	}
+}
+
+func TestConfiguredSSHNodeExecutorIsReusedUntilSettingsChange(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	keyPath := filepath.Join(t.TempDir(), "node.pem")
+	viper.Set("node_transport", "ssh")
+	viper.Set("ssh.insecure_ignore_host_key", true)
+	viper.Set("ssh.private_key_path", keyPath)
+
+	if _, err := configuredSSHNodeExecutor(); err == nil || !strings.Contains(err.Error(), "node.pem") {
+		t.Fatalf("expected missing key error, got %v", err)
+	}
+	keyPEM, _ := newTestSSHKeyPEM(t)
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := configuredSSHNodeExecutor()
+	if err != nil {
+		t.Fatalf("expected the key written after a failure to be picked up, got %v", err)
+	}
+	if err := os.Remove(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	again, err := configuredSSHNodeExecutor()
+	if err != nil || again != first {
+		t.Fatalf("expected the cached executor without rereading the key, got %p, %v", again, err)
+	}
+
+	viper.Set("ssh.user", "ec2-user")
+	if _, err := configuredSSHNodeExecutor(); err == nil || !strings.Contains(err.Error(), "node.pem") {
+		t.Fatalf("expected changed settings to rebuild the executor and reread the key, got %v", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := configuredSSHNodeExecutor()
+	if err != nil || rebuilt == first || rebuilt.user != "ec2-user" {
+		t.Fatalf("expected a new executor for ec2-user, got %+v, %v", rebuilt, err)
+	}
+}
+
+func TestConfiguredSSHNodeExecutorRequiresKnownHostsOrInsecureOptIn(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "node.pem")
+	keyPEM, _ := newTestSSHKeyPEM(t)
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("node_transport", "ssh")
+	viper.Set("ssh.private_key_path", keyPath)
+
+	if _, err := configuredSSHNodeExecutor(); err == nil || !strings.Contains(err.Error(), "ssh.known_hosts_path") {
+		t.Fatalf("expected ssh without known_hosts_path or the opt-in to fail, got %v", err)
+	}
+
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHostsPath, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("ssh.known_hosts_path", knownHostsPath)
+	viper.Set("ssh.insecure_ignore_host_key", true)
+	settings, err := configuredSSHNodeExecutorSettings()
+	if err != nil || settings.insecureIgnoreHostKey || settings.knownHostsPath != knownHostsPath {
+		t.Fatalf("expected known_hosts_path to take precedence over the opt-in, got %+v, %v", settings, err)
+	}
+	if _, err := configuredSSHNodeExecutor(); err != nil {
+		t.Fatalf("expected an executor pinned to known_hosts, got %v", err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"slices"
 	"strings"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 	"github.com/spf13/viper"
 )
 
@@ -27,11 +28,11 @@ func validateLocalToolingPreflight(helmCommands []string) error {
 	}
 
 	helmRepoAliases := helmRepoAliasesFromCommands(helmCommands)
-	if err := ensureRancherHelmRepos(helmRepoAliases, true); err != nil {
+	if err := resolver.EnsureRancherHelmRepos(helmRepoAliases, true); err != nil {
 		return err
 	}
 
-	if err := refreshHelmRepoIndexes(); err != nil {
+	if err := resolver.RefreshHelmRepoIndexes(); err != nil {
 		return err
 	}
 
@@ -92,47 +93,6 @@ func isExternalTLSHelmSet(value string) bool {
 	return value == "tls=external" || strings.HasPrefix(value, "tls=external,")
 }
 
-var rancherHelmRepoURLs = map[string]string{
-	"rancher-latest":         "https://releases.rancher.com/server-charts/latest",
-	"rancher-stable":         "https://releases.rancher.com/server-charts/stable",
-	"rancher-alpha":          "https://releases.rancher.com/server-charts/alpha",
-	"rancher-prime":          "https://charts.rancher.com/server-charts/prime",
-	"optimus-rancher-latest": "https://charts.optimus.rancher.io/server-charts/latest",
-	"optimus-rancher-alpha":  "https://charts.optimus.rancher.io/server-charts/alpha",
-	"rancher-optimus-alpha":  "https://s3.amazonaws.com/charts.optimus.rancher.io/server-charts/bin/chart/alpha",
-	"optimus-s3":             "http://charts.optimus.rancher.io.s3.amazonaws.com/server-charts/latest",
-}
-
-func ensureRancherHelmRepos(repoAliases []string, required bool) error {
-	for _, repoAlias := range repoAliases {
-		repoURL, ok := rancherHelmRepoURLs[repoAlias]
-		if !ok {
-			continue
-		}
-
-		log.Printf("[preflight] Ensuring Helm repo %s -> %s", repoAlias, repoURL)
-		output, err := exec.Command("helm", "repo", "add", repoAlias, repoURL, "--force-update").CombinedOutput()
-		if err != nil {
-			message := fmt.Sprintf("failed to add or update Helm repo %s (%s): %v (%s)", repoAlias, repoURL, err, strings.TrimSpace(string(output)))
-			if required {
-				return fmt.Errorf("%s", message)
-			}
-			log.Printf("[preflight] Optional Helm repo unavailable, resolver will try remaining repos: %s", message)
-		}
-	}
-	return nil
-}
-
-func refreshHelmRepoIndexes() error {
-	log.Printf("[preflight] Running 'helm repo update'...")
-	helmRepoUpdateOutput, err := exec.Command("helm", "repo", "update").CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to run 'helm repo update': %w", err)
-	}
-	log.Printf("[preflight] Helm repo update completed (%d bytes)", len(strings.TrimSpace(string(helmRepoUpdateOutput))))
-	return nil
-}
-
 func validateSecretEnvironment() error {
 	loadSecretEnvironmentFromZProfile()
 
@@ -153,6 +113,16 @@ func validateSecretEnvironment() error {
 	return nil
 }
 
+func validateNodeTransportPreflight() error {
+	executor, err := configuredNodeExecutor()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[preflight] Node commands will use the %s transport", executor.Name())
+	return nil
+}
+
 func validateWebhookImagePreflight() error {
 	webhookImage := strings.TrimSpace(os.Getenv("RANCHER_WEBHOOK_IMAGE"))
 	if webhookImage == "" {
@@ -161,11 +131,11 @@ func validateWebhookImagePreflight() error {
 	}
 
 	log.Printf("[preflight] Validating webhook image manifest before provisioning: %s", webhookImage)
-	registry, repository, tag, err := parseRegistryImage(webhookImage)
+	registry, repository, tag, err := resolver.ParseRegistryImage(webhookImage)
 	if err != nil {
 		return err
 	}
-	found, err := registryImageTagExists(registry, repository, tag)
+	found, err := resolver.RegistryImageTagExists(registry, repository, tag)
 	if err != nil {
 		return fmt.Errorf("validate webhook image %s: %w", webhookImage, err)
 	}
@@ -184,6 +154,7 @@ func loadSecretEnvironmentFromZProfile() {
 		"DOCKERHUB_USERNAME",
 		"DOCKERHUB_PASSWORD",
 	}
+	desiredVars = append(desiredVars, configuredRegistryEnvVars()...)
 
 	missingVars := 0
 	for _, envVar := range desiredVars {
@@ -335,7 +306,7 @@ func getRKE2InstallScriptURL(rke2Version, expectedInstallerSHA256 string) (strin
 	return installScriptURL, expectedInstallerSHA256, nil
 }
 
-func validatePinnedRKE2InstallerChecksum(plans []*RancherResolvedPlan) error {
+func validatePinnedRKE2InstallerChecksum(plans []*resolver.RancherResolvedPlan) error {
 	log.Printf("[preflight] Validating pinned RKE2 installer checksum before provisioning...")
 
 	if len(plans) == 0 {
@@ -431,6 +402,9 @@ rm -f /tmp/rke2-sha256sum-amd64.txt`,
 }
 
 func buildRKE2InstallCommand(nodeType string, rke2Version string, expectedInstallerSHA256 string) (string, error) {
+	if nodeType != "server" && nodeType != "agent" {
+		return "", fmt.Errorf("unsupported RKE2 node type %q", nodeType)
+	}
 	installScriptURL, expectedInstallerSHA256, err := getRKE2InstallScriptURL(rke2Version, expectedInstallerSHA256)
 	if err != nil {
 		return "", err
@@ -44,23 +44,6 @@ rancher-latest   https://releases.rancher.com/server-charts/latest
 	}
 }
 
-func TestKnownRancherHelmRepoURLs(t *testing.T) {
-	required := []string{
-		"rancher-latest",
-		"rancher-stable",
-		"rancher-alpha",
-		"rancher-prime",
-		"optimus-rancher-latest",
-		"optimus-rancher-alpha",
-	}
-
-	for _, repoAlias := range required {
-		if rancherHelmRepoURLs[repoAlias] == "" {
-			t.Fatalf("expected %s to have a known URL", repoAlias)
-		}
-	}
-}
-
 func TestRancherHelmCommandUsesExternalTLS(t *testing.T) {
 	tests := []string{
 		`helm install rancher rancher-latest/rancher --set tls=external`,
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient/rancherfake"
+	"github.com/spf13/viper"
+)
+
+func TestRancherAPIHelpersUseTheClient(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Chdir(t.TempDir())
+	fake := rancherfake.New()
+	t.Cleanup(fake.Close)
+
+	token, err := createRancherToken(1, fake.URL, rancherfake.DefaultPassword, rancherTokenPurposeProvisioning)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := configureRancherServerURL(fake.URL+"/", token); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Snapshot().Settings["server-url"].Value; got != fake.URL {
+		t.Fatalf("expected server-url to be set without a trailing slash, got %q", got)
+	}
+	kubeconfig, err := generateRancherKubeconfig(fake.URL, token, "local")
+	if err != nil || !strings.Contains(kubeconfig, "kind: Config") {
+		t.Fatalf("unexpected kubeconfig %q %v", kubeconfig, err)
+	}
+}
+
+func TestNewRancherAPIClientVerifiesAgainstConfiguredCA(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Chdir(t.TempDir())
+	fake := rancherfake.New()
+	t.Cleanup(fake.Close)
+
+	caFile := filepath.Join(t.TempDir(), "rancher-ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fake.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("rancher.api_ca_file", caFile)
+	if _, err := createRancherToken(1, fake.URL, rancherfake.DefaultPassword, rancherTokenPurposeKubeconfig); err != nil {
+		t.Fatalf("expected the configured CA to be trusted, got %v", err)
+	}
+
+	viper.Set("rancher.api_ca_file", filepath.Join(t.TempDir(), "missing.pem"))
+	if _, err := createRancherToken(1, fake.URL, rancherfake.DefaultPassword, rancherTokenPurposeKubeconfig); err == nil || !strings.Contains(err.Error(), "rancher.api_ca_file") {
+		t.Fatalf("expected a missing CA file to be reported, got %v", err)
+	}
+}
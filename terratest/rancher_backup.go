@@ -0,0 +1,390 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
+	goversion "github.com/hashicorp/go-version"
+)
+
+const (
+	rancherBackupNamespace      = "cattle-resources-system"
+	rancherBackupRepoAlias      = "rancher-charts"
+	rancherBackupDefaultRepoURL = "https://charts.rancher.io"
+	rancherBackupMinIONamespace = "backup-restore-minio"
+	rancherBackupBucket         = "rancher-backups"
+	rancherBackupCredentials    = "backup-restore-minio-creds"
+	rancherBackupName           = "signoff-backup"
+	rancherRestoreName          = "signoff-restore"
+	rancherBackupMarkerName     = "signoff-backup-restore-marker"
+	rancherBackupMarkerKey      = "ha-rancher-rke2/backup-marker"
+
+	// rancherBackupMinIOImage is pinned so a MinIO release cannot change the
+	// round trip between runs. It is pulled from quay.io unless a registry is
+	// configured.
+	rancherBackupMinIOImage    = "minio/minio:RELEASE.2025-04-22T22-12-26Z"
+	rancherBackupMinIORegistry = "quay.io"
+
+	rancherBackupFamilyCommunity = "community"
+	rancherBackupFamilyPrime     = "prime"
+	rancherBackupFamilyStaging   = "staging"
+)
+
+// rancherBackupChartSource is where the rancher-backup charts come from for
+// one HA. Prime installs from the Prime chart repo; the other families install
+// from charts.rancher.io and differ in the registry the operator images are
+// pulled from and whether prerelease charts count.
+type rancherBackupChartSource struct {
+	Family   string
+	RepoURL  string
+	Registry string
+	Devel    bool
+}
+
+// rancherBackupChartSourceFor follows the chart repo family the resolver
+// picked for Rancher itself. RANCHER_BACKUP_CHART_REPO overrides the repo URL
+// for staging charts that are not published publicly yet.
+func rancherBackupChartSourceFor(plan *resolver.RancherResolvedPlan) rancherBackupChartSource {
+	source := rancherBackupChartSource{Family: rancherBackupFamilyCommunity, RepoURL: rancherBackupDefaultRepoURL}
+	if plan != nil {
+		switch {
+		case plan.ChartRepoAlias == "rancher-prime":
+			source.Family = rancherBackupFamilyPrime
+			if repoURL, ok := resolver.RancherHelmRepoURL(plan.ChartRepoAlias); ok {
+				source.RepoURL = repoURL
+			}
+		case resolver.IsExactStagingPrereleaseChart(plan.ChartRepoAlias):
+			source.Family = rancherBackupFamilyStaging
+		}
+		source.Devel = plan.BuildType != "" && plan.BuildType != "release"
+		if registry, _, ok := resolver.SplitRegistryRepository(plan.RancherImage); ok {
+			source.Registry = registry
+		}
+	}
+	if override := strings.TrimSpace(os.Getenv("RANCHER_BACKUP_CHART_REPO")); override != "" {
+		source.RepoURL = override
+	}
+	return source
+}
+
+// rancherBackupChartMajor maps a Rancher server version to the rancher-backup
+// chart major that ships with it: 2.9 ships 104.x, 2.12 ships 107.x.
+func rancherBackupChartMajor(serverVersion string) (int, error) {
+	version := strings.TrimPrefix(strings.TrimSpace(serverVersion), "v")
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 || parts[0] != "2" {
+		return 0, fmt.Errorf("cannot map Rancher version %q to a rancher-backup chart", serverVersion)
+	}
+	minor, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err != nil || minor < 8 {
+		return 0, fmt.Errorf("cannot map Rancher version %q to a rancher-backup chart; 2.8 or later is required", serverVersion)
+	}
+	return minor + 95, nil
+}
+
+// selectRancherBackupChartVersion picks the newest chart with the given major,
+// skipping prereleases unless devel is set.
+func selectRancherBackupChartVersion(results []resolver.HelmSearchResult, major int, devel bool) (string, error) {
+	var candidates []*goversion.Version
+	for _, result := range results {
+		parsed, err := goversion.NewVersion(result.Version)
+		if err != nil || parsed.Segments()[0] != major {
+			continue
+		}
+		if parsed.Prerelease() != "" && !devel {
+			continue
+		}
+		candidates = append(candidates, parsed)
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no rancher-backup chart found for major %d", major)
+	}
+	slices.SortFunc(candidates, func(a, b *goversion.Version) int {
+		return b.Compare(a)
+	})
+	return candidates[0].Original(), nil
+}
+
+// rancherBackupHelmArgs returns the helm arguments that install chart from
+// the backup chart repo.
+func rancherBackupHelmArgs(source rancherBackupChartSource, chart, version, kubeconfigPath string) []string {
+	args := []string{
+		"upgrade", "--install", chart, rancherBackupRepoAlias + "/" + chart,
+		"--namespace", rancherBackupNamespace, "--create-namespace",
+		"--version", version,
+		"--kubeconfig", kubeconfigPath,
+		"--wait", "--timeout", "10m",
+	}
+	if source.Registry != "" && chart == "rancher-backup" {
+		args = append(args, "--set", "global.cattle.systemDefaultRegistry="+source.Registry)
+	}
+	return args
+}
+
+// pickRancherResourceSet prefers the basic resource set newer charts ship and
+// falls back to the single set older charts have.
+func pickRancherResourceSet(names []string) (string, error) {
+	for _, want := range []string{"rancher-resource-set-basic", "rancher-resource-set"} {
+		if slices.Contains(names, want) {
+			return want, nil
+		}
+	}
+	return "", fmt.Errorf("no Rancher resource set found among %v", names)
+}
+
+// rancherBackupMinIORegistryFor returns the registry MinIO is pulled from for
+// one HA: the first server's registry in airgap mode, otherwise
+// registries.system_default_registry, which may be empty.
+func rancherBackupMinIORegistryFor(haOutputs TerraformOutputs) string {
+	if airgapEnabled() {
+		return airgapRegistryHost(haOutputs)
+	}
+	return settings.ConfiguredSystemDefaultRegistry()
+}
+
+// rancherBackupMinIOImageRef returns the MinIO image to deploy, pulled from
+// registry when one is set, such as the airgap registry or
+// registries.system_default_registry.
+func rancherBackupMinIOImageRef(registry string) string {
+	registry = strings.TrimSuffix(strings.TrimSpace(registry), "/")
+	if registry == "" {
+		registry = rancherBackupMinIORegistry
+	}
+	return registry + "/" + rancherBackupMinIOImage
+}
+
+func rancherBackupMinIOEndpoint() string {
+	return fmt.Sprintf("minio.%s.svc.cluster.local:9000", rancherBackupMinIONamespace)
+}
+
+// generateMinIOServingCert returns a self-signed certificate for the in-cluster
+// MinIO service. rancher-backup only talks to S3 over TLS, so the same
+// certificate is passed to it as endpointCA.
+func generateMinIOServingCert() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate MinIO key: %w", err)
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "minio"},
+		DNSNames: []string{
+			"minio",
+			"minio." + rancherBackupMinIONamespace,
+			"minio." + rancherBackupMinIONamespace + ".svc",
+			"minio." + rancherBackupMinIONamespace + ".svc.cluster.local",
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(7 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create MinIO certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode MinIO key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// minIOManifest deploys a single MinIO with ephemeral storage that creates the
+// backup bucket on start.
+func minIOManifest(image, accessKey, secretKey string, certPEM, keyPEM []byte) string {
+	ns := rancherBackupMinIONamespace
+	return fmt.Sprintf(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: %[1]s
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: minio
+  namespace: %[1]s
+type: Opaque
+stringData:
+  MINIO_ROOT_USER: %[2]s
+  MINIO_ROOT_PASSWORD: %[3]s
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: minio-tls
+  namespace: %[1]s
+type: Opaque
+data:
+  public.crt: %[4]s
+  private.key: %[5]s
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: minio
+  namespace: %[1]s
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: minio
+  template:
+    metadata:
+      labels:
+        app: minio
+    spec:
+      containers:
+      - name: minio
+        image: %[7]s
+        command: ["sh", "-c", "mkdir -p /data/%[6]s && exec minio server /data --certs-dir /certs"]
+        envFrom:
+        - secretRef:
+            name: minio
+        ports:
+        - containerPort: 9000
+        readinessProbe:
+          httpGet:
+            path: /minio/health/ready
+            port: 9000
+            scheme: HTTPS
+        volumeMounts:
+        - name: data
+          mountPath: /data
+        - name: certs
+          mountPath: /certs
+          readOnly: true
+      volumes:
+      - name: data
+        emptyDir: {}
+      - name: certs
+        secret:
+          secretName: minio-tls
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: minio
+  namespace: %[1]s
+spec:
+  selector:
+    app: minio
+  ports:
+  - port: 9000
+    targetPort: 9000
+`, ns, strconv.Quote(accessKey), strconv.Quote(secretKey),
+		base64.StdEncoding.EncodeToString(certPEM), base64.StdEncoding.EncodeToString(keyPEM), rancherBackupBucket, image)
+}
+
+func rancherBackupCredentialsManifest(accessKey, secretKey string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: Secret
+metadata:
+  name: %s
+  namespace: %s
+type: Opaque
+stringData:
+  accessKey: %s
+  secretKey: %s
+`, rancherBackupCredentials, rancherBackupNamespace, strconv.Quote(accessKey), strconv.Quote(secretKey))
+}
+
+func rancherBackupStorageLocation(certPEM []byte) string {
+	return fmt.Sprintf(`  storageLocation:
+    s3:
+      credentialSecretName: %s
+      credentialSecretNamespace: %s
+      bucketName: %s
+      endpoint: %s
+      endpointCA: %s
+`, rancherBackupCredentials, rancherBackupNamespace, rancherBackupBucket, rancherBackupMinIOEndpoint(),
+		base64.StdEncoding.EncodeToString(certPEM))
+}
+
+func rancherBackupManifest(resourceSet string, certPEM []byte) string {
+	return fmt.Sprintf(`apiVersion: resources.cattle.io/v1
+kind: Backup
+metadata:
+  name: %s
+spec:
+  resourceSetName: %s
+%s`, rancherBackupName, resourceSet, rancherBackupStorageLocation(certPEM))
+}
+
+// rancherRestoreManifest restores without pruning, so only the marker the
+// test removed after the backup comes back and nothing else is deleted.
+func rancherRestoreManifest(backupFilename string, certPEM []byte) string {
+	return fmt.Sprintf(`apiVersion: resources.cattle.io/v1
+kind: Restore
+metadata:
+  name: %s
+spec:
+  backupFilename: %s
+  prune: false
+%s`, rancherRestoreName, strconv.Quote(backupFilename), rancherBackupStorageLocation(certPEM))
+}
+
+// rancherBackupMarkerManifest is a global role that carries the run's marker
+// value. Global roles are in every Rancher resource set.
+func rancherBackupMarkerManifest(value string) string {
+	return fmt.Sprintf(`apiVersion: management.cattle.io/v3
+kind: GlobalRole
+metadata:
+  name: %s
+  annotations:
+    %s: %s
+displayName: Backup restore marker
+rules:
+- apiGroups: [""]
+  resources: ["configmaps"]
+  verbs: ["get"]
+`, rancherBackupMarkerName, rancherBackupMarkerKey, strconv.Quote(value))
+}
+
+type rancherBackupRestoreArtifact struct {
+	HAIndex        int     `json:"ha_index"`
+	ServerVersion  string  `json:"server_version,omitempty"`
+	ChartFamily    string  `json:"chart_family"`
+	ChartRepo      string  `json:"chart_repo"`
+	ChartVersion   string  `json:"chart_version,omitempty"`
+	ResourceSet    string  `json:"resource_set,omitempty"`
+	BackupFilename string  `json:"backup_filename,omitempty"`
+	BackupSeconds  float64 `json:"backup_seconds,omitempty"`
+	RestoreSeconds float64 `json:"restore_seconds,omitempty"`
+	MarkerRestored bool    `json:"marker_restored"`
+	Passed         bool    `json:"passed"`
+	Error          string  `json:"error,omitempty"`
+	FinishedAt     string  `json:"finished_at"`
+}
+
+func writeRancherBackupRestoreArtifact(artifact rancherBackupRestoreArtifact) error {
+	data, err := json.MarshalIndent(artifact, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := automationOutputPath(fmt.Sprintf("backup-restore-ha-%d.json", artifact.HAIndex))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
@@ -0,0 +1,244 @@
+package test
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+	"github.com/spf13/viper"
+)
+
+func TestHABackupRestore(t *testing.T) {
+	requireExplicitLifecycleTest(t, "TestHABackupRestore")
+	setupConfig(t)
+
+	totalHAs := viper.GetInt("total_has")
+	if totalHAs < 1 {
+		t.Fatal("total_has must be at least 1")
+	}
+
+	terraformOptions := getTerraformOptions(t, totalHAs)
+	outputs := getTerraformOutputs(t, terraformOptions)
+	if len(outputs) == 0 {
+		t.Fatal("No outputs received from terraform")
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, totalHAs)
+	for i := 1; i <= totalHAs; i++ {
+		instanceNum := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			artifact, err := runRancherBackupRestore(instanceNum, outputs)
+			artifact.Passed = err == nil
+			if err != nil {
+				artifact.Error = err.Error()
+			}
+			artifact.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+			if writeErr := writeRancherBackupRestoreArtifact(artifact); writeErr != nil {
+				log.Printf("[backup][ha-%d] Failed to write backup/restore artifact: %v", instanceNum, writeErr)
+			}
+			if err != nil {
+				errCh <- fmt.Errorf("[ha-%d] %w", instanceNum, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	var failures []string
+	for err := range errCh {
+		failures = append(failures, err.Error())
+	}
+	if len(failures) > 0 {
+		t.Fatalf("Rancher backup/restore round trip failed:\n%s", strings.Join(failures, "\n"))
+	}
+}
+
+// runRancherBackupRestore installs rancher-backup, backs Rancher up to an
+// in-cluster MinIO, deletes a marker global role, restores and checks the
+// marker came back.
+func runRancherBackupRestore(instanceNum int, outputs map[string]string) (rancherBackupRestoreArtifact, error) {
+	artifact := rancherBackupRestoreArtifact{HAIndex: instanceNum}
+	rancherURL := clickableURL(getHAOutputs(instanceNum, outputs).RancherURL)
+	kubeconfigPath := filepath.Join(haInstanceDir(instanceNum), "kube_config.yaml")
+	timeout := durationFromEnv("RANCHER_BACKUP_TIMEOUT", 15*time.Minute)
+
+	var plan *resolver.RancherResolvedPlan
+	if checkpoint, err := readHASetupCheckpoint(instanceNum); err != nil {
+		log.Printf("[backup][ha-%d] No setup checkpoint, using community backup charts: %v", instanceNum, err)
+	} else {
+		plan = checkpoint.ResolvedPlan
+	}
+	source := rancherBackupChartSourceFor(plan)
+	artifact.ChartFamily = source.Family
+	artifact.ChartRepo = source.RepoURL
+
+	serverVersion, err := runKubectlOutput(kubeconfigPath, "get", "settings.management.cattle.io", "server-version", "-o", "jsonpath={.value}")
+	if err != nil {
+		return artifact, fmt.Errorf("failed to read the Rancher server version: %w", err)
+	}
+	artifact.ServerVersion = strings.TrimSpace(serverVersion)
+
+	chartVersion, err := installRancherBackupCharts(instanceNum, kubeconfigPath, source, artifact.ServerVersion)
+	artifact.ChartVersion = chartVersion
+	if err != nil {
+		return artifact, err
+	}
+
+	minIOImage := rancherBackupMinIOImageRef(rancherBackupMinIORegistryFor(getHAOutputs(instanceNum, outputs)))
+	certPEM, err := deployBackupMinIO(instanceNum, kubeconfigPath, minIOImage, timeout)
+	if err != nil {
+		return artifact, err
+	}
+
+	resourceSets, err := runKubectlOutput(kubeconfigPath, "get", "resourcesets.resources.cattle.io", "-o", "jsonpath={.items[*].metadata.name}")
+	if err != nil {
+		return artifact, fmt.Errorf("failed to list Rancher resource sets: %w", err)
+	}
+	if artifact.ResourceSet, err = pickRancherResourceSet(strings.Fields(resourceSets)); err != nil {
+		return artifact, err
+	}
+
+	marker := fmt.Sprintf("ha-%d-%d", instanceNum, time.Now().Unix())
+	if err := kubectlApply(kubeconfigPath, rancherBackupMarkerManifest(marker)); err != nil {
+		return artifact, fmt.Errorf("failed to create the backup marker: %w", err)
+	}
+
+	log.Printf("[backup][ha-%d] Backing up resource set %s to MinIO", instanceNum, artifact.ResourceSet)
+	started := time.Now()
+	backupRef := "backups.resources.cattle.io/" + rancherBackupName
+	if err := recreateRancherBackupResource(kubeconfigPath, backupRef, rancherBackupManifest(artifact.ResourceSet, certPEM), timeout); err != nil {
+		return artifact, fmt.Errorf("backup did not complete: %w", err)
+	}
+	artifact.BackupSeconds = time.Since(started).Round(time.Second).Seconds()
+	filename, err := runKubectlOutput(kubeconfigPath, "get", backupRef, "-o", "jsonpath={.status.filename}")
+	if err != nil {
+		return artifact, fmt.Errorf("failed to read the backup filename: %w", err)
+	}
+	artifact.BackupFilename = strings.TrimSpace(filename)
+	if artifact.BackupFilename == "" {
+		return artifact, fmt.Errorf("backup %s reported ready without a filename", rancherBackupName)
+	}
+	log.Printf("[backup][ha-%d] Backup %s written in %.0fs", instanceNum, artifact.BackupFilename, artifact.BackupSeconds)
+
+	if err := runKubectlDirect(kubeconfigPath, "delete", "globalroles.management.cattle.io", rancherBackupMarkerName, "--wait"); err != nil {
+		return artifact, fmt.Errorf("failed to delete the backup marker: %w", err)
+	}
+
+	log.Printf("[backup][ha-%d] Restoring %s", instanceNum, artifact.BackupFilename)
+	started = time.Now()
+	restoreRef := "restores.resources.cattle.io/" + rancherRestoreName
+	if err := recreateRancherBackupResource(kubeconfigPath, restoreRef, rancherRestoreManifest(artifact.BackupFilename, certPEM), timeout); err != nil {
+		return artifact, fmt.Errorf("restore did not complete: %w", err)
+	}
+	// The restore scales Rancher down while it runs.
+	if err := waitForRancherRollout(kubeconfigPath, rancherURL, timeout); err != nil {
+		return artifact, fmt.Errorf("rancher did not come back after the restore: %w", err)
+	}
+	artifact.RestoreSeconds = time.Since(started).Round(time.Second).Seconds()
+
+	restored, err := runKubectlOutput(kubeconfigPath, "get", "globalroles.management.cattle.io", rancherBackupMarkerName, "-o", "jsonpath={.metadata.annotations."+rancherBackupMarkerKey+"}")
+	if err != nil {
+		return artifact, fmt.Errorf("backup marker was not restored: %w", err)
+	}
+	if strings.TrimSpace(restored) != marker {
+		return artifact, fmt.Errorf("backup marker came back as %q, want %q", strings.TrimSpace(restored), marker)
+	}
+	artifact.MarkerRestored = true
+	log.Printf("[backup][ha-%d] Restore brought the marker back in %.0fs", instanceNum, artifact.RestoreSeconds)
+	return artifact, nil
+}
+
+func installRancherBackupCharts(instanceNum int, kubeconfigPath string, source rancherBackupChartSource, serverVersion string) (string, error) {
+	major, err := rancherBackupChartMajor(serverVersion)
+	if err != nil {
+		return "", err
+	}
+	if output, err := exec.Command("helm", "repo", "add", rancherBackupRepoAlias, source.RepoURL, "--force-update").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to add Helm repo %s (%s): %w: %s", rancherBackupRepoAlias, source.RepoURL, err, strings.TrimSpace(string(output)))
+	}
+
+	searchArgs := []string{"search", "repo", rancherBackupRepoAlias + "/rancher-backup", "--versions", "-o", "json"}
+	if source.Devel {
+		searchArgs = append(searchArgs, "--devel")
+	}
+	output, err := exec.Command("helm", searchArgs...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to search %s for rancher-backup: %w: %s", source.RepoURL, err, strings.TrimSpace(string(output)))
+	}
+	results, err := resolver.ParseHelmSearchResults(output)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse rancher-backup search results: %w", err)
+	}
+	version, err := selectRancherBackupChartVersion(filterHelmSearchResultsByName(results, rancherBackupRepoAlias+"/rancher-backup"), major, source.Devel)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("[backup][ha-%d] Installing rancher-backup %s from the %s family (%s)", instanceNum, version, source.Family, source.RepoURL)
+	for _, chart := range []string{"rancher-backup-crd", "rancher-backup"} {
+		if output, err := exec.Command("helm", rancherBackupHelmArgs(source, chart, version, kubeconfigPath)...).CombinedOutput(); err != nil {
+			return version, fmt.Errorf("failed to install %s %s: %w: %s", chart, version, err, strings.TrimSpace(string(output)))
+		}
+	}
+	return version, nil
+}
+
+func filterHelmSearchResultsByName(results []resolver.HelmSearchResult, name string) []resolver.HelmSearchResult {
+	var filtered []resolver.HelmSearchResult
+	for _, result := range results {
+		if result.Name == name {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+// deployBackupMinIO runs MinIO in the local cluster and writes the credential
+// secret rancher-backup reads. It returns MinIO's certificate.
+func deployBackupMinIO(instanceNum int, kubeconfigPath, image string, timeout time.Duration) ([]byte, error) {
+	certPEM, keyPEM, err := generateMinIOServingCert()
+	if err != nil {
+		return nil, err
+	}
+	accessKey := "backup-" + randomHex(6)
+	secretKey := randomHex(24)
+
+	log.Printf("[backup][ha-%d] Deploying MinIO %s in namespace %s", instanceNum, image, rancherBackupMinIONamespace)
+	if err := kubectlApply(kubeconfigPath, minIOManifest(image, accessKey, secretKey, certPEM, keyPEM)); err != nil {
+		return nil, fmt.Errorf("failed to deploy MinIO: %w", err)
+	}
+	// Re-runs generate new credentials and certificates, so restart MinIO to
+	// pick them up.
+	if err := runKubectlDirect(kubeconfigPath, "-n", rancherBackupMinIONamespace, "rollout", "restart", "deployment/minio"); err != nil {
+		return nil, err
+	}
+	if err := runKubectlDirect(kubeconfigPath, "-n", rancherBackupMinIONamespace, "rollout", "status", "deployment/minio", "--timeout", timeout.String()); err != nil {
+		return nil, fmt.Errorf("MinIO did not become ready: %w", err)
+	}
+	if err := kubectlApply(kubeconfigPath, rancherBackupCredentialsManifest(accessKey, secretKey)); err != nil {
+		return nil, fmt.Errorf("failed to write the backup credential secret: %w", err)
+	}
+	return certPEM, nil
+}
+
+// recreateRancherBackupResource replaces a Backup or Restore left by an
+// earlier run and waits for the operator to mark the new one ready.
+func recreateRancherBackupResource(kubeconfigPath, ref, manifest string, timeout time.Duration) error {
+	if err := runKubectlDirect(kubeconfigPath, "delete", ref, "--ignore-not-found", "--wait"); err != nil {
+		return err
+	}
+	if err := kubectlApply(kubeconfigPath, manifest); err != nil {
+		return err
+	}
+	return runKubectlDirect(kubeconfigPath, "wait", ref, "--for=condition=Ready", "--timeout", timeout.String())
+}
@@ -0,0 +1,192 @@
+package test
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+	"github.com/spf13/viper"
+)
+
+func TestRancherBackupChartSourceFollowsResolverFamily(t *testing.T) {
+	t.Setenv("RANCHER_BACKUP_CHART_REPO", "")
+	tests := []struct {
+		name     string
+		plan     *resolver.RancherResolvedPlan
+		family   string
+		registry string
+		devel    bool
+	}{
+		{name: "no plan", plan: nil, family: rancherBackupFamilyCommunity},
+		{name: "community release", plan: &resolver.RancherResolvedPlan{ChartRepoAlias: "rancher-latest", BuildType: "release"}, family: rancherBackupFamilyCommunity},
+		{name: "prime", plan: &resolver.RancherResolvedPlan{ChartRepoAlias: "rancher-prime", BuildType: "release", RancherImage: "registry.rancher.com/rancher/rancher"}, family: rancherBackupFamilyPrime, registry: "registry.rancher.com"},
+		{name: "staging alpha", plan: &resolver.RancherResolvedPlan{ChartRepoAlias: "optimus-rancher-alpha", BuildType: "alpha", RancherImage: "stgregistry.suse.com/rancher/rancher"}, family: rancherBackupFamilyStaging, registry: "stgregistry.suse.com", devel: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := rancherBackupChartSourceFor(tt.plan)
+			if source.Family != tt.family || source.Registry != tt.registry || source.Devel != tt.devel {
+				t.Fatalf("unexpected source: %+v", source)
+			}
+			wantRepo := rancherBackupDefaultRepoURL
+			if tt.family == rancherBackupFamilyPrime {
+				wantRepo = "https://charts.rancher.com/server-charts/prime"
+			}
+			if source.RepoURL != wantRepo {
+				t.Fatalf("expected repo %s, got %s", wantRepo, source.RepoURL)
+			}
+		})
+	}
+
+	t.Setenv("RANCHER_BACKUP_CHART_REPO", "https://charts.example.com")
+	if source := rancherBackupChartSourceFor(nil); source.RepoURL != "https://charts.example.com" {
+		t.Fatalf("expected repo override, got %s", source.RepoURL)
+	}
+}
+
+func TestRancherBackupChartVersionSelection(t *testing.T) {
+	if major, err := rancherBackupChartMajor("v2.12.3"); err != nil || major != 107 {
+		t.Fatalf("expected 107, got %d, %v", major, err)
+	}
+	if major, err := rancherBackupChartMajor("v2.13.0-alpha4"); err != nil || major != 108 {
+		t.Fatalf("expected 108, got %d, %v", major, err)
+	}
+	for _, version := range []string{"v2.7.5", "head", ""} {
+		if _, err := rancherBackupChartMajor(version); err == nil {
+			t.Fatalf("expected %q to be rejected", version)
+		}
+	}
+
+	results := []resolver.HelmSearchResult{
+		{Name: "rancher-charts/rancher-backup", Version: "106.0.3+up6.0.3"},
+		{Name: "rancher-charts/rancher-backup", Version: "107.0.1+up7.0.1"},
+		{Name: "rancher-charts/rancher-backup", Version: "107.0.2+up7.0.2"},
+		{Name: "rancher-charts/rancher-backup", Version: "107.0.3-rc.1+up7.0.3-rc.1"},
+	}
+	if version, err := selectRancherBackupChartVersion(results, 107, false); err != nil || version != "107.0.2+up7.0.2" {
+		t.Fatalf("expected newest release, got %q, %v", version, err)
+	}
+	if version, err := selectRancherBackupChartVersion(results, 107, true); err != nil || version != "107.0.3-rc.1+up7.0.3-rc.1" {
+		t.Fatalf("expected newest prerelease with devel, got %q, %v", version, err)
+	}
+	if _, err := selectRancherBackupChartVersion(results, 108, true); err == nil {
+		t.Fatal("expected an error when no chart matches the major")
+	}
+}
+
+func TestRancherBackupHelmArgsSetRegistryOnOperatorOnly(t *testing.T) {
+	source := rancherBackupChartSource{Family: rancherBackupFamilyPrime, Registry: "registry.rancher.com"}
+	crd := strings.Join(rancherBackupHelmArgs(source, "rancher-backup-crd", "107.0.2", "kube.yaml"), " ")
+	operator := strings.Join(rancherBackupHelmArgs(source, "rancher-backup", "107.0.2", "kube.yaml"), " ")
+	if strings.Contains(crd, "systemDefaultRegistry") {
+		t.Fatalf("CRD chart should not get a registry: %s", crd)
+	}
+	for _, want := range []string{"rancher-charts/rancher-backup", "--namespace cattle-resources-system", "--version 107.0.2", "global.cattle.systemDefaultRegistry=registry.rancher.com"} {
+		if !strings.Contains(operator, want) {
+			t.Fatalf("expected %q in %s", want, operator)
+		}
+	}
+}
+
+func TestPickRancherResourceSet(t *testing.T) {
+	if name, err := pickRancherResourceSet([]string{"rancher-resource-set-full", "rancher-resource-set-basic"}); err != nil || name != "rancher-resource-set-basic" {
+		t.Fatalf("expected basic set, got %q, %v", name, err)
+	}
+	if name, err := pickRancherResourceSet([]string{"rancher-resource-set"}); err != nil || name != "rancher-resource-set" {
+		t.Fatalf("expected legacy set, got %q, %v", name, err)
+	}
+	if _, err := pickRancherResourceSet(nil); err == nil {
+		t.Fatal("expected an error without resource sets")
+	}
+}
+
+func TestRancherBackupMinIOImageFollowsConfiguredRegistry(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	outputs := TerraformOutputs{ServerPrivateIPs: []string{"10.0.0.1"}}
+
+	if image := rancherBackupMinIOImageRef(rancherBackupMinIORegistryFor(outputs)); image != "quay.io/"+rancherBackupMinIOImage {
+		t.Fatalf("expected the pinned quay.io image, got %s", image)
+	}
+	if strings.HasSuffix(rancherBackupMinIOImage, ":latest") || !strings.Contains(rancherBackupMinIOImage, ":") {
+		t.Fatalf("expected MinIO to be pinned to a release, got %s", rancherBackupMinIOImage)
+	}
+
+	viper.Set("registries.system_default_registry", "registry.example.com/")
+	if image := rancherBackupMinIOImageRef(rancherBackupMinIORegistryFor(outputs)); image != "registry.example.com/"+rancherBackupMinIOImage {
+		t.Fatalf("expected the system default registry, got %s", image)
+	}
+
+	viper.Set("airgap", true)
+	if image := rancherBackupMinIOImageRef(rancherBackupMinIORegistryFor(outputs)); image != "10.0.0.1:5000/"+rancherBackupMinIOImage {
+		t.Fatalf("expected the airgap registry, got %s", image)
+	}
+
+	manifest := minIOManifest("10.0.0.1:5000/"+rancherBackupMinIOImage, "user", "pass", nil, nil)
+	if !strings.Contains(manifest, "image: 10.0.0.1:5000/"+rancherBackupMinIOImage) {
+		t.Fatalf("expected the MinIO image in the manifest:\n%s", manifest)
+	}
+}
+
+func TestRancherBackupManifestsShareStorageLocation(t *testing.T) {
+	certPEM, keyPEM, err := generateMinIOServingCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("minio." + rancherBackupMinIONamespace + ".svc.cluster.local"); err != nil {
+		t.Fatalf("certificate does not cover the MinIO service: %v", err)
+	}
+	if key, _ := pem.Decode(keyPEM); key == nil || key.Type != "EC PRIVATE KEY" {
+		t.Fatalf("unexpected key PEM: %s", keyPEM)
+	}
+
+	backup := rancherBackupManifest("rancher-resource-set-basic", certPEM)
+	restore := rancherRestoreManifest("signoff-backup-abc.tar.gz", certPEM)
+	for _, want := range []string{"kind: Backup", "resourceSetName: rancher-resource-set-basic", "endpoint: " + rancherBackupMinIOEndpoint(), "bucketName: rancher-backups"} {
+		if !strings.Contains(backup, want) {
+			t.Fatalf("expected %q in backup manifest:\n%s", want, backup)
+		}
+	}
+	for _, want := range []string{"kind: Restore", `backupFilename: "signoff-backup-abc.tar.gz"`, "prune: false", "credentialSecretName: " + rancherBackupCredentials} {
+		if !strings.Contains(restore, want) {
+			t.Fatalf("expected %q in restore manifest:\n%s", want, restore)
+		}
+	}
+	if !strings.Contains(minIOManifest(rancherBackupMinIOImageRef(""), "user", "pass", certPEM, keyPEM), "mkdir -p /data/rancher-backups") {
+		t.Fatal("expected MinIO to create the backup bucket on start")
+	}
+	if !strings.Contains(rancherBackupMarkerManifest("ha-1-42"), `ha-rancher-rke2/backup-marker: "ha-1-42"`) {
+		t.Fatal("expected the marker value on the global role")
+	}
+}
+
+func TestWriteRancherBackupRestoreArtifact(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("GITHUB_WORKSPACE", dir)
+
+	artifact := rancherBackupRestoreArtifact{HAIndex: 2, ChartFamily: "community", ChartVersion: "107.0.2", MarkerRestored: true, Passed: true}
+	if err := writeRancherBackupRestoreArtifact(artifact); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(automationOutputPath("backup-restore-ha-2.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["chart_version"] != "107.0.2" || got["marker_restored"] != true || got["passed"] != true {
+		t.Fatalf("unexpected artifact: %s", data)
+	}
+}
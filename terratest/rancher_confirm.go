@@ -18,10 +18,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 	"github.com/spf13/viper"
 )
 
-func confirmResolvedPlans(plans []*RancherResolvedPlan) error {
+func confirmResolvedPlans(plans []*resolver.RancherResolvedPlan) error {
 	if len(plans) == 0 {
 		return nil
 	}
@@ -99,7 +100,7 @@ func confirmResolvedPlans(plans []*RancherResolvedPlan) error {
 	}
 }
 
-func confirmResolvedPlansWithDialog(plans []*RancherResolvedPlan) (bool, error) {
+func confirmResolvedPlansWithDialog(plans []*resolver.RancherResolvedPlan) (bool, error) {
 	planMessage := buildResolvedPlansDialogMessage(plans)
 	return confirmResolvedPlansWithBrowserDialog(planMessage)
 }
@@ -351,7 +352,7 @@ func openBrowser(targetURL string) error {
 	return cmd.Start()
 }
 
-func buildResolvedPlansDialogMessage(plans []*RancherResolvedPlan) string {
+func buildResolvedPlansDialogMessage(plans []*resolver.RancherResolvedPlan) string {
 	sections := []string{"Continue with this Rancher plan?"}
 
 	for i, plan := range plans {
@@ -371,6 +372,9 @@ func buildResolvedPlansDialogMessage(plans []*RancherResolvedPlan) string {
 		if plan.RecommendedRKE2Version != "" {
 			sectionLines = append(sectionLines, "Resolved RKE2/K8s: "+plan.RecommendedRKE2Version)
 		}
+		if plan.RKE2Unsupported {
+			sectionLines = append(sectionLines, fmt.Sprintf("WARNING: RKE2 is outside the supported %s lines (allow_unsupported)", plan.SupportedRKE2Lines))
+		}
 		for commandIndex, helmCommand := range plan.HelmCommands {
 			sectionLines = append(sectionLines, fmt.Sprintf("Helm command %d:", commandIndex+1))
 			sectionLines = append(sectionLines, sanitizeHelmCommandForDialog(helmCommand))
@@ -382,7 +386,7 @@ func buildResolvedPlansDialogMessage(plans []*RancherResolvedPlan) string {
 	return strings.Join(sections, "\n\n")
 }
 
-func logResolvedPlans(plans []*RancherResolvedPlan) {
+func logResolvedPlans(plans []*resolver.RancherResolvedPlan) {
 	for i, plan := range plans {
 		log.Printf("[resolver] Rancher resolution summary for HA %d:", i+1)
 		log.Printf("[resolver] Requested version: %s", plan.RequestedVersion)
@@ -401,6 +405,9 @@ func logResolvedPlans(plans []*RancherResolvedPlan) {
 		log.Printf("[resolver] Compatibility baseline: %s", plan.CompatibilityBaseline)
 		log.Printf("[resolver] Support matrix: %s", plan.SupportMatrixURL)
 		log.Printf("[resolver] Recommended RKE2 version: %s", plan.RecommendedRKE2Version)
+		if plan.RKE2Strategy != "" {
+			log.Printf("[resolver] RKE2 strategy: %s (supported lines %s)", plan.RKE2Strategy, plan.SupportedRKE2Lines)
+		}
 		log.Printf("[resolver] Resolved installer SHA256: %s", plan.InstallerSHA256)
 		for _, explanation := range plan.Explanation {
 			log.Printf("[resolver] Reason: %s", explanation)
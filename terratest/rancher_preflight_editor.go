@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
@@ -48,12 +49,12 @@ func currentPreflightVersions() []string {
 	if len(requestedVersions) > 0 {
 		versions := make([]string, 0, len(requestedVersions))
 		for _, version := range requestedVersions {
-			versions = append(versions, normalizeVersionInput(version))
+			versions = append(versions, resolver.NormalizeVersionInput(version))
 		}
 		return versions
 	}
 
-	if singleVersion := normalizeVersionInput(viper.GetString("rancher.version")); singleVersion != "" {
+	if singleVersion := resolver.NormalizeVersionInput(viper.GetString("rancher.version")); singleVersion != "" {
 		return []string{singleVersion}
 	}
 
@@ -701,7 +702,7 @@ func normalizePreflightVersions(versions []string) ([]string, error) {
 
 	normalized := make([]string, 0, len(versions))
 	for i, version := range versions {
-		normalizedVersion := normalizeVersionInput(version)
+		normalizedVersion := resolver.NormalizeVersionInput(version)
 		if normalizedVersion == "" {
 			return nil, fmt.Errorf("version for HA %d cannot be empty", i+1)
 		}
@@ -0,0 +1,234 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+)
+
+// The API probe runs once the HTTP and pod checks pass. Its login uses a short
+// session TTL because the wait loop may log in on several attempts.
+const rancherReadinessLoginTTL = 10 * time.Minute
+
+const (
+	readinessCheckHTTP         = "http"
+	readinessCheckPods         = "pods"
+	readinessCheckLogin        = "login"
+	readinessCheckLocalCluster = "local-cluster"
+	readinessCheckCatalogRepos = "catalog-repos"
+	readinessCheckFeatures     = "features"
+)
+
+type rancherReadinessCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+type rancherReadinessArtifact struct {
+	HAIndex      int                     `json:"ha_index"`
+	RancherURL   string                  `json:"rancher_url"`
+	Ready        bool                    `json:"ready"`
+	Attempts     int                     `json:"attempts"`
+	CheckedAt    string                  `json:"checked_at"`
+	FailedChecks []string                `json:"failed_checks,omitempty"`
+	Checks       []rancherReadinessCheck `json:"checks"`
+}
+
+// probeRancherAPI logs in with the bootstrap password and checks that the
+// local cluster, the catalog repos and the features API are usable. The API
+// checks are reported as failed without being run when the login fails.
+// Requests are not retried here because the wait loop already retries.
+func probeRancherAPI(rancherURL, bootstrapPassword string) []rancherReadinessCheck {
+	apiChecks := []string{readinessCheckLocalCluster, readinessCheckCatalogRepos, readinessCheckFeatures}
+
+	bootstrapPassword = strings.TrimSpace(bootstrapPassword)
+	if bootstrapPassword == "" {
+		return skippedReadinessChecks(
+			rancherReadinessCheck{Name: readinessCheckLogin, Detail: "rancher.bootstrap_password must be set"},
+			apiChecks)
+	}
+	client, err := newRancherAPIClient(rancherURL, rancherclient.Options{Timeout: 15 * time.Second, MaxRetries: -1})
+	if err != nil {
+		return skippedReadinessChecks(rancherReadinessCheck{Name: readinessCheckLogin, Detail: err.Error()}, apiChecks)
+	}
+	ctx := context.Background()
+	session, err := client.Login(ctx, rancherclient.LoginInput{
+		Password:    bootstrapPassword,
+		Description: "ha-rancher-rke2 readiness probe",
+		TTL:         rancherReadinessLoginTTL,
+	})
+	if err != nil {
+		return skippedReadinessChecks(rancherReadinessCheck{Name: readinessCheckLogin, Detail: err.Error()}, apiChecks)
+	}
+	maskGitHubActionsValue(session)
+	client = client.WithToken(session)
+
+	return []rancherReadinessCheck{
+		{Name: readinessCheckLogin, Passed: true, Detail: "admin login accepted"},
+		checkRancherLocalCluster(ctx, client),
+		checkRancherCatalogRepos(ctx, client),
+		checkRancherFeatures(ctx, client),
+	}
+}
+
+func skippedReadinessChecks(login rancherReadinessCheck, names []string) []rancherReadinessCheck {
+	checks := []rancherReadinessCheck{login}
+	for _, name := range names {
+		checks = append(checks, rancherReadinessCheck{Name: name, Detail: "skipped: login failed"})
+	}
+	return checks
+}
+
+func checkRancherLocalCluster(ctx context.Context, client *rancherclient.Client) rancherReadinessCheck {
+	check := rancherReadinessCheck{Name: readinessCheckLocalCluster}
+	cluster, err := client.GetCluster(ctx, "local")
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	notTrue := falseConditions(cluster.Conditions)
+	switch {
+	case !strings.EqualFold(cluster.State, "active"):
+		check.Detail = fmt.Sprintf("state=%s", valueOrUnknown(cluster.State))
+		if len(notTrue) > 0 {
+			check.Detail += "; " + strings.Join(notTrue, "; ")
+		}
+	case len(notTrue) > 0:
+		check.Detail = "state=active; " + strings.Join(notTrue, "; ")
+	default:
+		check.Passed = true
+		check.Detail = fmt.Sprintf("state=active, %d conditions true", len(cluster.Conditions))
+	}
+	return check
+}
+
+func checkRancherCatalogRepos(ctx context.Context, client *rancherclient.Client) rancherReadinessCheck {
+	check := rancherReadinessCheck{Name: readinessCheckCatalogRepos}
+	repos, err := client.ListClusterRepos(ctx)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	if len(repos) == 0 {
+		check.Detail = "no cluster repos listed"
+		return check
+	}
+
+	var pending []string
+	for _, repo := range repos {
+		downloaded := repo.Status.DownloadTime != ""
+		reason := ""
+		for _, condition := range repo.Status.Conditions {
+			if condition.Type == "Downloaded" {
+				downloaded = strings.EqualFold(condition.Status, "True")
+				reason = condition.Message
+			}
+		}
+		if downloaded {
+			continue
+		}
+		if reason != "" {
+			pending = append(pending, fmt.Sprintf("%s (%s)", repo.ID, reason))
+		} else {
+			pending = append(pending, repo.ID)
+		}
+	}
+	if len(pending) > 0 {
+		check.Detail = "not downloaded: " + strings.Join(pending, ", ")
+		return check
+	}
+	check.Passed = true
+	check.Detail = fmt.Sprintf("%d cluster repos downloaded", len(repos))
+	return check
+}
+
+func checkRancherFeatures(ctx context.Context, client *rancherclient.Client) rancherReadinessCheck {
+	check := rancherReadinessCheck{Name: readinessCheckFeatures}
+	features, err := client.ListFeatures(ctx)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	if len(features) == 0 {
+		check.Detail = "no features listed"
+		return check
+	}
+	check.Passed = true
+	check.Detail = fmt.Sprintf("%d features listed", len(features))
+	return check
+}
+
+func falseConditions(conditions []rancherclient.Condition) []string {
+	var notTrue []string
+	for _, condition := range conditions {
+		if strings.EqualFold(condition.Status, "True") {
+			continue
+		}
+		entry := fmt.Sprintf("%s=%s", condition.Type, valueOrUnknown(condition.Status))
+		if condition.Message != "" {
+			entry += fmt.Sprintf(" (%s)", condition.Message)
+		}
+		notTrue = append(notTrue, entry)
+	}
+	return notTrue
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+func readinessChecksPassed(checks []rancherReadinessCheck) bool {
+	if len(checks) == 0 {
+		return false
+	}
+	for _, check := range checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func summarizeReadinessChecks(checks []rancherReadinessCheck) string {
+	parts := make([]string, 0, len(checks))
+	for _, check := range checks {
+		parts = append(parts, fmt.Sprintf("%s=%s", check.Name, check.Detail))
+	}
+	return strings.Join(parts, " ")
+}
+
+func writeRancherReadinessArtifact(instanceNum int, rancherURL string, attempts int, checks []rancherReadinessCheck) error {
+	artifact := rancherReadinessArtifact{
+		HAIndex:    instanceNum,
+		RancherURL: rancherURL,
+		Ready:      readinessChecksPassed(checks),
+		Attempts:   attempts,
+		CheckedAt:  time.Now().UTC().Format(time.RFC3339),
+		Checks:     checks,
+	}
+	for _, check := range checks {
+		if !check.Passed {
+			artifact.FailedChecks = append(artifact.FailedChecks, check.Name)
+		}
+	}
+	data, err := json.MarshalIndent(artifact, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := automationOutputPath(fmt.Sprintf("rancher-readiness-ha-%d.json", instanceNum))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
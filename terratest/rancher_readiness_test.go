@@ -0,0 +1,152 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient/rancherfake"
+	"github.com/spf13/viper"
+)
+
+func newReadinessFake(t *testing.T) *rancherfake.Server {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	fake := rancherfake.New()
+	t.Cleanup(fake.Close)
+	fake.Update(func(state *rancherfake.State) {
+		repo := rancherclient.ClusterRepo{ID: "rancher-partner-charts"}
+		repo.Status.DownloadTime = "2026-10-16T10:00:00Z"
+		state.ClusterRepos = append(state.ClusterRepos, repo)
+	})
+	return fake
+}
+
+func readinessCheckByName(t *testing.T, checks []rancherReadinessCheck, name string) rancherReadinessCheck {
+	t.Helper()
+	for _, check := range checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	t.Fatalf("no %s check in %+v", name, checks)
+	return rancherReadinessCheck{}
+}
+
+func TestProbeRancherAPIReady(t *testing.T) {
+	fake := newReadinessFake(t)
+
+	checks := probeRancherAPI(fake.URL+"/", rancherfake.DefaultPassword)
+	if !readinessChecksPassed(checks) || len(checks) != 4 {
+		t.Fatalf("expected every API check to pass, got %+v", checks)
+	}
+	if got := readinessCheckByName(t, checks, readinessCheckCatalogRepos).Detail; got != "2 cluster repos downloaded" {
+		t.Fatalf("unexpected catalog detail %q", got)
+	}
+	if got := readinessCheckByName(t, checks, readinessCheckFeatures).Detail; got != "2 features listed" {
+		t.Fatalf("unexpected features detail %q", got)
+	}
+	for _, token := range fake.Snapshot().Tokens {
+		if token.TTL != rancherReadinessLoginTTL.Milliseconds() {
+			t.Fatalf("expected the probe login to use a short session TTL, got %+v", token)
+		}
+	}
+}
+
+func TestProbeRancherAPIReportsEachFailure(t *testing.T) {
+	fake := newReadinessFake(t)
+	fake.Update(func(state *rancherfake.State) {
+		state.Clusters[0].State = "updating"
+		state.Clusters[0].Conditions = []rancherclient.Condition{
+			{Type: "Ready", Status: "True"},
+			{Type: "Updated", Status: "Unknown", Message: "waiting for agent"},
+		}
+		failed := rancherclient.ClusterRepo{ID: "rancher-charts"}
+		failed.Status.Conditions = []rancherclient.Condition{{Type: "Downloaded", Status: "False", Message: "git clone failed"}}
+		state.ClusterRepos = []rancherclient.ClusterRepo{failed, {ID: "rancher-rke2-charts"}}
+		state.Features = nil
+	})
+
+	checks := probeRancherAPI(fake.URL, rancherfake.DefaultPassword)
+	if readinessChecksPassed(checks) {
+		t.Fatalf("expected the probe to fail, got %+v", checks)
+	}
+	if check := readinessCheckByName(t, checks, readinessCheckLogin); !check.Passed {
+		t.Fatalf("expected the login to pass, got %+v", check)
+	}
+	cluster := readinessCheckByName(t, checks, readinessCheckLocalCluster)
+	if cluster.Passed || cluster.Detail != "state=updating; Updated=Unknown (waiting for agent)" {
+		t.Fatalf("unexpected local cluster check %+v", cluster)
+	}
+	repos := readinessCheckByName(t, checks, readinessCheckCatalogRepos)
+	if repos.Passed || repos.Detail != "not downloaded: rancher-charts (git clone failed), rancher-rke2-charts" {
+		t.Fatalf("unexpected catalog check %+v", repos)
+	}
+	if check := readinessCheckByName(t, checks, readinessCheckFeatures); check.Passed || check.Detail != "no features listed" {
+		t.Fatalf("unexpected features check %+v", check)
+	}
+
+	fake.Update(func(state *rancherfake.State) {
+		state.Clusters[0].State = "active"
+		state.Clusters[0].Conditions = []rancherclient.Condition{{Type: "Ready", Status: "False"}}
+	})
+	checks = probeRancherAPI(fake.URL, rancherfake.DefaultPassword)
+	if check := readinessCheckByName(t, checks, readinessCheckLocalCluster); check.Passed || check.Detail != "state=active; Ready=False" {
+		t.Fatalf("expected an active cluster with a false condition to fail, got %+v", check)
+	}
+}
+
+func TestProbeRancherAPISkipsChecksWhenLoginFails(t *testing.T) {
+	fake := newReadinessFake(t)
+
+	checks := probeRancherAPI(fake.URL, "wrong")
+	login := readinessCheckByName(t, checks, readinessCheckLogin)
+	if login.Passed || !strings.Contains(login.Detail, "HTTP 401") {
+		t.Fatalf("expected the rejected login to be reported, got %+v", login)
+	}
+	for _, check := range checks[1:] {
+		if check.Passed || check.Detail != "skipped: login failed" {
+			t.Fatalf("expected %s to be skipped, got %+v", check.Name, check)
+		}
+	}
+
+	checks = probeRancherAPI(fake.URL, " ")
+	if len(fake.Requests()) != 1 || readinessCheckByName(t, checks, readinessCheckLogin).Detail != "rancher.bootstrap_password must be set" {
+		t.Fatalf("expected a missing password to skip the login, got %q %+v", fake.Requests(), checks)
+	}
+}
+
+func TestWriteRancherReadinessArtifact(t *testing.T) {
+	workspace := t.TempDir()
+	t.Setenv("GITHUB_WORKSPACE", workspace)
+
+	checks := []rancherReadinessCheck{
+		{Name: readinessCheckHTTP, Passed: true, Detail: "root=200 api=401"},
+		{Name: readinessCheckPods, Passed: true, Detail: "rancher and rancher-webhook pods ready (4 relevant pods)"},
+		{Name: readinessCheckLogin, Passed: true, Detail: "admin login accepted"},
+		{Name: readinessCheckLocalCluster, Detail: "state=provisioning"},
+	}
+	if err := writeRancherReadinessArtifact(2, "https://ha2.example.com", 7, checks); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(workspace, "automation-output", "rancher-readiness-ha-2.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var artifact rancherReadinessArtifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		t.Fatal(err)
+	}
+	if artifact.HAIndex != 2 || artifact.Ready || artifact.Attempts != 7 || artifact.CheckedAt == "" ||
+		strings.Join(artifact.FailedChecks, ",") != readinessCheckLocalCluster || len(artifact.Checks) != 4 {
+		t.Fatalf("unexpected artifact %+v", artifact)
+	}
+	if !strings.Contains(string(data), `"ha_index": 2`) || !strings.Contains(string(data), `"failed_checks"`) {
+		t.Fatalf("expected snake_case fields, got:\n%s", data)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 )
 
 type rancherResolutionArtifact struct {
@@ -23,10 +25,14 @@ type rancherResolutionArtifact struct {
 	UseRancherImageFields  bool     `json:"use_rancher_image_fields,omitempty"`
 	CompatibilityBaseline  string   `json:"compatibility_baseline,omitempty"`
 	RecommendedRKE2Version string   `json:"recommended_rke2_version,omitempty"`
+	RKE2Strategy           string   `json:"rke2_strategy,omitempty"`
+	RKE2VersionConstraint  string   `json:"rke2_version_constraint,omitempty"`
+	SupportedRKE2Lines     string   `json:"supported_rke2_lines,omitempty"`
+	RKE2Unsupported        bool     `json:"rke2_unsupported,omitempty"`
 	ResolutionNotes        []string `json:"resolution_notes,omitempty"`
 }
 
-func writeRancherResolutionArtifact(phase string, instanceNum int, plan *RancherResolvedPlan) error {
+func writeRancherResolutionArtifact(phase string, instanceNum int, plan *resolver.RancherResolvedPlan) error {
 	if plan == nil {
 		return nil
 	}
@@ -46,6 +52,10 @@ func writeRancherResolutionArtifact(phase string, instanceNum int, plan *Rancher
 		UseRancherImageFields:  plan.UseRancherImageFields,
 		CompatibilityBaseline:  plan.CompatibilityBaseline,
 		RecommendedRKE2Version: plan.RecommendedRKE2Version,
+		RKE2Strategy:           plan.RKE2Strategy,
+		RKE2VersionConstraint:  plan.RKE2VersionConstraint,
+		SupportedRKE2Lines:     plan.SupportedRKE2Lines,
+		RKE2Unsupported:        plan.RKE2Unsupported,
 		ResolutionNotes:        plan.Explanation,
 	}
 	data, err := json.MarshalIndent(artifact, "", "  ")
@@ -59,7 +69,7 @@ func writeRancherResolutionArtifact(phase string, instanceNum int, plan *Rancher
 	return os.WriteFile(path, append(data, '\n'), 0o600)
 }
 
-func rancherChartSource(plan *RancherResolvedPlan) string {
+func rancherChartSource(plan *resolver.RancherResolvedPlan) string {
 	if plan == nil || plan.ChartRepoAlias == "" || plan.ChartVersion == "" {
 		return ""
 	}
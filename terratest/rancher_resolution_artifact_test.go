@@ -5,13 +5,15 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 )
 
 func TestWriteRancherResolutionArtifact(t *testing.T) {
 	workspace := t.TempDir()
 	t.Setenv("GITHUB_WORKSPACE", workspace)
 
-	plan := &RancherResolvedPlan{
+	plan := &resolver.RancherResolvedPlan{
 		RequestedVersion:       "2.14.1-alpha7",
 		RequestedDistro:        "auto",
 		BuildType:              "alpha",
@@ -23,6 +25,8 @@ func TestWriteRancherResolutionArtifact(t *testing.T) {
 		AgentImage:             "stgregistry.suse.com/rancher/rancher-agent:v2.14.1-alpha7",
 		CompatibilityBaseline:  "2.14.0",
 		RecommendedRKE2Version: "v1.34.6+rke2r3",
+		RKE2Strategy:           "latest",
+		SupportedRKE2Lines:     "v1.32-v1.34",
 		Explanation:            []string{"Using exact chart match optimus-rancher-alpha/rancher@2.14.1-alpha7"},
 	}
 
@@ -41,6 +45,8 @@ func TestWriteRancherResolutionArtifact(t *testing.T) {
 		`"chart_version": "2.14.1-alpha7"`,
 		`"chart_source": "optimus-rancher-alpha/rancher@2.14.1-alpha7"`,
 		`"resolved_distro": "community-staging"`,
+		`"rke2_strategy": "latest"`,
+		`"supported_rke2_lines": "v1.32-v1.34"`,
 	} {
 		if !strings.Contains(got, want) {
 			t.Fatalf("expected artifact to contain %s:\n%s", want, got)
@@ -0,0 +1,265 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
+	"github.com/spf13/viper"
+)
+
+const (
+	rancherConfigKindSetting = "setting"
+	rancherConfigKindFeature = "feature"
+)
+
+// rancherConfigResult is one rancher.settings or rancher.features entry as it
+// was applied and read back.
+type rancherConfigResult struct {
+	Kind            string `json:"kind"`
+	Name            string `json:"name"`
+	Desired         string `json:"desired"`
+	Previous        string `json:"previous"`
+	Actual          string `json:"actual"`
+	Changed         bool   `json:"changed"`
+	Verified        bool   `json:"verified"`
+	RestartRequired bool   `json:"restart_required,omitempty"`
+	Detail          string `json:"detail,omitempty"`
+}
+
+type rancherConfigArtifact struct {
+	HAIndex   int                   `json:"ha_index"`
+	AppliedAt string                `json:"applied_at"`
+	Restarted bool                  `json:"restarted"`
+	Verified  bool                  `json:"verified"`
+	Results   []rancherConfigResult `json:"results"`
+}
+
+// desiredRancherSettings returns rancher.settings with server-url filled in
+// from the HA's Rancher URL unless the config sets it.
+func desiredRancherSettings(rancherURL string) (map[string]string, error) {
+	values, err := settings.ConfiguredRancherSettings()
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]string{}
+	}
+	values["server-url"] = desiredRancherServerURL(rancherURL)
+	return values, nil
+}
+
+func desiredRancherServerURL(rancherURL string) string {
+	if value := strings.TrimSpace(viper.GetString(settings.RancherSettingsConfigKey + ".server-url")); value != "" {
+		return value
+	}
+	return strings.TrimRight(clickableURL(rancherURL), "/")
+}
+
+// applyRancherConfiguration applies rancher.settings and rancher.features to
+// one HA and reads every value back. restartRancher is called when a changed
+// feature only takes effect after a restart, and must return once Rancher
+// serves its API again.
+func applyRancherConfiguration(instanceNum int, rancherURL, bootstrapPassword string, restartRancher func() error) (rancherConfigArtifact, error) {
+	artifact := rancherConfigArtifact{HAIndex: instanceNum}
+	desiredSettings, err := desiredRancherSettings(rancherURL)
+	if err != nil {
+		return artifact, err
+	}
+	desiredFeatures, err := settings.ConfiguredRancherFeatures()
+	if err != nil {
+		return artifact, err
+	}
+
+	err = withRancherToken(instanceNum, rancherURL, bootstrapPassword, rancherTokenPurposeSettings, func(token string) error {
+		client, err := newRancherAPIClient(rancherURL, rancherclient.Options{Token: token})
+		if err != nil {
+			return err
+		}
+		ctx := context.Background()
+
+		results, err := applyRancherSettings(ctx, client, desiredSettings)
+		if err != nil {
+			return err
+		}
+		featureResults, err := applyRancherFeatures(ctx, client, desiredFeatures)
+		if err != nil {
+			return err
+		}
+		artifact.Results = append(results, featureResults...)
+
+		for _, result := range featureResults {
+			if result.Changed && result.RestartRequired {
+				log.Printf("[settings][ha-%d] Feature %s needs a Rancher restart", instanceNum, result.Name)
+				artifact.Restarted = true
+			}
+		}
+		if artifact.Restarted {
+			if err := restartRancher(); err != nil {
+				return fmt.Errorf("rancher did not come back after the feature restart: %w", err)
+			}
+		}
+		return verifyRancherConfiguration(ctx, client, artifact.Results)
+	})
+	artifact.AppliedAt = time.Now().UTC().Format(time.RFC3339)
+	artifact.Verified = err == nil && len(artifact.Results) > 0
+	for _, result := range artifact.Results {
+		if !result.Verified {
+			artifact.Verified = false
+		}
+	}
+	if err != nil {
+		return artifact, err
+	}
+	if !artifact.Verified {
+		return artifact, fmt.Errorf("rancher settings did not apply: %s", unverifiedRancherConfig(artifact.Results))
+	}
+	return artifact, nil
+}
+
+func applyRancherSettings(ctx context.Context, client *rancherclient.Client, desired map[string]string) ([]rancherConfigResult, error) {
+	var results []rancherConfigResult
+	for _, name := range sortedKeys(desired) {
+		setting, err := client.GetSetting(ctx, name)
+		if err != nil {
+			return results, fmt.Errorf("failed to read setting %s: %w", name, err)
+		}
+		result := rancherConfigResult{Kind: rancherConfigKindSetting, Name: name, Desired: desired[name], Previous: strings.TrimSpace(setting.Value)}
+		if strings.TrimSpace(setting.Value) != desired[name] {
+			if err := client.PutSetting(ctx, name, desired[name]); err != nil {
+				return results, fmt.Errorf("failed to set setting %s: %w", name, err)
+			}
+			result.Changed = true
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func applyRancherFeatures(ctx context.Context, client *rancherclient.Client, desired map[string]bool) ([]rancherConfigResult, error) {
+	if len(desired) == 0 {
+		return nil, nil
+	}
+	features, err := listFeaturesByID(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	var results []rancherConfigResult
+	for _, name := range sortedKeys(desired) {
+		feature, ok := features[name]
+		if !ok {
+			return results, fmt.Errorf("rancher has no feature %s", name)
+		}
+		result := rancherConfigResult{
+			Kind:            rancherConfigKindFeature,
+			Name:            name,
+			Desired:         strconv.FormatBool(desired[name]),
+			Previous:        strconv.FormatBool(feature.Enabled()),
+			RestartRequired: !feature.Status.Dynamic,
+		}
+		// Set the value explicitly even when it matches the default, so a later
+		// Rancher release changing the default does not change the stack.
+		if feature.Spec.Value == nil || *feature.Spec.Value != desired[name] {
+			if err := client.SetFeature(ctx, name, desired[name]); err != nil {
+				return results, fmt.Errorf("failed to set feature %s: %w", name, err)
+			}
+			result.Changed = feature.Enabled() != desired[name]
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// verifyRancherConfiguration reads every applied value back and fills in
+// Actual and Verified.
+func verifyRancherConfiguration(ctx context.Context, client *rancherclient.Client, results []rancherConfigResult) error {
+	var features map[string]rancherclient.Feature
+	for i := range results {
+		result := &results[i]
+		switch result.Kind {
+		case rancherConfigKindSetting:
+			setting, err := client.GetSetting(ctx, result.Name)
+			if err != nil {
+				return fmt.Errorf("failed to read back setting %s: %w", result.Name, err)
+			}
+			// Compare the stored value, so an empty value resets to the
+			// default.
+			result.Actual = strings.TrimSpace(setting.Value)
+		case rancherConfigKindFeature:
+			if features == nil {
+				var err error
+				if features, err = listFeaturesByID(ctx, client); err != nil {
+					return fmt.Errorf("failed to read back features: %w", err)
+				}
+			}
+			result.Actual = strconv.FormatBool(features[result.Name].Enabled())
+		}
+		result.Verified = result.Actual == result.Desired
+		if !result.Verified {
+			result.Detail = fmt.Sprintf("read back %q", result.Actual)
+		}
+	}
+	return nil
+}
+
+func listFeaturesByID(ctx context.Context, client *rancherclient.Client) (map[string]rancherclient.Feature, error) {
+	list, err := client.ListFeatures(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list features: %w", err)
+	}
+	features := make(map[string]rancherclient.Feature, len(list))
+	for _, feature := range list {
+		features[feature.ID] = feature
+	}
+	return features, nil
+}
+
+func unverifiedRancherConfig(results []rancherConfigResult) string {
+	var parts []string
+	for _, result := range results {
+		if !result.Verified {
+			parts = append(parts, fmt.Sprintf("%s %s wants %q, got %q", result.Kind, result.Name, result.Desired, result.Actual))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func logRancherConfigSummary(instanceNum int, artifact rancherConfigArtifact) {
+	for _, result := range artifact.Results {
+		state := "unchanged"
+		if result.Changed {
+			state = fmt.Sprintf("changed from %q", result.Previous)
+		}
+		log.Printf("[settings][ha-%d] %s %s=%q (%s, verified=%t)", instanceNum, result.Kind, result.Name, result.Actual, state, result.Verified)
+	}
+}
+
+func writeRancherConfigArtifact(artifact rancherConfigArtifact) error {
+	data, err := json.MarshalIndent(artifact, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := automationOutputPath(fmt.Sprintf("rancher-settings-ha-%d.json", artifact.HAIndex))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
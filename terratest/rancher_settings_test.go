@@ -0,0 +1,196 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient/rancherfake"
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
+	"github.com/spf13/viper"
+)
+
+func TestConfiguredRancherSettingsAndFeatures(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	if err := settings.ValidateRancherSettingsConfig(); err != nil {
+		t.Fatalf("expected no settings to be valid, got %v", err)
+	}
+
+	viper.Set("rancher.settings", map[string]interface{}{
+		"agent-tls-mode":      " system-store ",
+		"password-min-length": 14,
+		"telemetry-opt":       "out",
+	})
+	viper.Set("rancher.features", map[string]interface{}{"ui-sql-cache": true, "fleet": "false"})
+	values, err := settings.ConfiguredRancherSettings()
+	if err != nil || values["agent-tls-mode"] != "system-store" || values["password-min-length"] != "14" || values["telemetry-opt"] != "out" {
+		t.Fatalf("unexpected settings %v %v", values, err)
+	}
+	features, err := settings.ConfiguredRancherFeatures()
+	if err != nil || !features["ui-sql-cache"] || features["fleet"] {
+		t.Fatalf("unexpected features %v %v", features, err)
+	}
+
+	for _, tc := range []struct {
+		key      string
+		value    interface{}
+		expected string
+	}{
+		{"rancher.settings", []string{"server-url"}, "rancher.settings must be a map"},
+		{"rancher.settings", map[string]interface{}{"server_url": "x"}, `invalid name "server_url"`},
+		{"rancher.settings", map[string]interface{}{"server-url": []string{"x"}}, "rancher.settings.server-url must be a string, number or boolean"},
+		{"rancher.features", map[string]interface{}{"ui-sql-cache": "yes"}, `rancher.features.ui-sql-cache must be true or false; got "yes"`},
+		{"rancher.features", map[string]interface{}{"ui-sql-cache": 1}, "rancher.features.ui-sql-cache must be true or false"},
+	} {
+		viper.Reset()
+		viper.Set(tc.key, tc.value)
+		if err := settings.ValidateRancherSettingsConfig(); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("expected %q for %v, got %v", tc.expected, tc.value, err)
+		}
+	}
+}
+
+func TestApplyRancherConfiguration(t *testing.T) {
+	fake := newTokenFake(t)
+	fake.Update(func(state *rancherfake.State) {
+		state.Settings["password-min-length"] = rancherclient.Setting{ID: "password-min-length", Default: "12"}
+		restart := rancherclient.Feature{ID: "harvester-baremetal-container-workload"}
+		state.Features = append(state.Features, restart)
+	})
+	viper.Set("rancher.settings", map[string]interface{}{"password-min-length": 14})
+	viper.Set("rancher.features", map[string]interface{}{
+		"fleet":                                  true,
+		"ui-sql-cache":                           true,
+		"harvester-baremetal-container-workload": true,
+	})
+
+	restarts := 0
+	artifact, err := applyRancherConfiguration(1, fake.URL, rancherfake.DefaultPassword, func() error {
+		restarts++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarts != 1 || !artifact.Restarted || !artifact.Verified || len(artifact.Results) != 5 {
+		t.Fatalf("unexpected artifact %+v (restarts %d)", artifact, restarts)
+	}
+	byName := map[string]rancherConfigResult{}
+	for _, result := range artifact.Results {
+		byName[result.Name] = result
+	}
+	if got := byName["server-url"]; got.Actual != fake.URL || !got.Changed || !got.Verified {
+		t.Fatalf("expected server-url to default to the Rancher URL, got %+v", got)
+	}
+	if got := byName["password-min-length"]; got.Previous != "" || got.Actual != "14" || !got.Changed {
+		t.Fatalf("unexpected password-min-length result %+v", got)
+	}
+	if got := byName["fleet"]; got.Changed || !got.Verified || got.RestartRequired {
+		t.Fatalf("expected fleet to be pinned without counting as a change, got %+v", got)
+	}
+	if got := byName["ui-sql-cache"]; got.Previous != "false" || got.Actual != "true" || !got.Changed || got.RestartRequired {
+		t.Fatalf("unexpected ui-sql-cache result %+v", got)
+	}
+	if got := byName["harvester-baremetal-container-workload"]; !got.Changed || !got.RestartRequired {
+		t.Fatalf("expected the non-dynamic feature to need a restart, got %+v", got)
+	}
+
+	state := fake.Snapshot()
+	if state.Settings["password-min-length"].Value != "14" || len(state.Tokens) != 0 {
+		t.Fatalf("expected the setting to be stored and the settings token revoked, got %+v", state)
+	}
+	for _, feature := range state.Features {
+		if feature.Spec.Value == nil || !*feature.Spec.Value {
+			t.Fatalf("expected every feature to be set, got %+v", state.Features)
+		}
+	}
+
+	// A second run changes nothing and does not restart Rancher again.
+	artifact, err = applyRancherConfiguration(1, fake.URL, rancherfake.DefaultPassword, func() error {
+		restarts++
+		return nil
+	})
+	if err != nil || restarts != 1 || artifact.Restarted {
+		t.Fatalf("expected an unchanged second run, got %+v %v", artifact, err)
+	}
+	for _, result := range artifact.Results {
+		if result.Changed {
+			t.Fatalf("expected nothing to change, got %+v", result)
+		}
+	}
+}
+
+func TestApplyRancherConfigurationReportsFailures(t *testing.T) {
+	fake := newTokenFake(t)
+	viper.Set("rancher.features", map[string]interface{}{"no-such-feature": true})
+	if _, err := applyRancherConfiguration(1, fake.URL, rancherfake.DefaultPassword, nil); err == nil || !strings.Contains(err.Error(), "rancher has no feature no-such-feature") {
+		t.Fatalf("expected a missing feature to be reported, got %v", err)
+	}
+
+	fake.Update(func(state *rancherfake.State) {
+		state.Features = append(state.Features, rancherclient.Feature{ID: "restart-me"})
+	})
+	viper.Set("rancher.features", map[string]interface{}{"restart-me": true})
+	artifact, err := applyRancherConfiguration(1, fake.URL, rancherfake.DefaultPassword, func() error {
+		return errors.New("rollout timed out")
+	})
+	if err == nil || !strings.Contains(err.Error(), "rollout timed out") || artifact.Verified || !artifact.Restarted {
+		t.Fatalf("expected the restart failure to be reported, got %+v %v", artifact, err)
+	}
+	if tokens := fake.Snapshot().Tokens; len(tokens) != 0 {
+		t.Fatalf("expected the settings token to be revoked after a failure, got %+v", tokens)
+	}
+}
+
+func TestVerifyRancherConfigurationDetectsDrift(t *testing.T) {
+	fake := newTokenFake(t)
+	results := []rancherConfigResult{{Kind: rancherConfigKindSetting, Name: "server-url", Desired: "https://rancher.example.com"}}
+	token, err := createRancherToken(1, fake.URL, rancherfake.DefaultPassword, rancherTokenPurposeSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newRancherAPIClient(fake.URL, rancherclient.Options{Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRancherConfiguration(t.Context(), client, results); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Verified || results[0].Detail != `read back ""` {
+		t.Fatalf("expected an unset value to fail verification, got %+v", results[0])
+	}
+	if got := unverifiedRancherConfig(results); got != `setting server-url wants "https://rancher.example.com", got ""` {
+		t.Fatalf("unexpected summary %q", got)
+	}
+}
+
+func TestWriteRancherConfigArtifact(t *testing.T) {
+	workspace := t.TempDir()
+	t.Setenv("GITHUB_WORKSPACE", workspace)
+
+	artifact := rancherConfigArtifact{
+		HAIndex:  3,
+		Verified: true,
+		Results:  []rancherConfigResult{{Kind: rancherConfigKindFeature, Name: "ui-sql-cache", Desired: "true", Actual: "true", Verified: true, RestartRequired: true}},
+	}
+	if err := writeRancherConfigArtifact(artifact); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(workspace, "automation-output", "rancher-settings-ha-3.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded rancherConfigArtifact
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.HAIndex != 3 || len(decoded.Results) != 1 {
+		t.Fatalf("unexpected artifact %s %v", data, err)
+	}
+	if !strings.Contains(string(data), `"restart_required": true`) || !strings.Contains(string(data), `"ha_index": 3`) {
+		t.Fatalf("expected snake_case fields, got:\n%s", data)
+	}
+}
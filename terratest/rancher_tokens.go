@@ -0,0 +1,338 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Every Rancher token the tests create is recorded, by name only, in a ledger
+// next to the HA's kubeconfig so TestHACleanup can revoke whatever is left.
+const rancherTokenLedgerFile = "rancher-tokens.json"
+
+// Token purposes. Each one gets the shortest TTL its caller needs.
+const (
+	rancherTokenPurposeSuiteEnv     = "suite-env"
+	rancherTokenPurposeProvisioning = "downstream-provisioning"
+	rancherTokenPurposeKubeconfig   = "kubeconfig"
+	rancherTokenPurposeControlPanel = "control-panel"
+	rancherTokenPurposeSettings     = "settings"
+	rancherTokenPurposeSession      = "session"
+)
+
+var rancherTokenTTLs = map[string]time.Duration{
+	// rancher/tests suites read the token from the env file and can run for
+	// several hours after it is written.
+	rancherTokenPurposeSuiteEnv: 12 * time.Hour,
+	// Only used for a handful of API calls, then revoked.
+	rancherTokenPurposeProvisioning: time.Hour,
+	// Only used to call generateKubeconfig, then revoked.
+	rancherTokenPurposeKubeconfig: 15 * time.Minute,
+	// Cached by the control panel and revoked when it shuts down.
+	rancherTokenPurposeControlPanel: 12 * time.Hour,
+	// Covers applying rancher.settings and a Rancher restart, then revoked.
+	rancherTokenPurposeSettings: 30 * time.Minute,
+}
+
+// Login sessions are only needed long enough to create a token or revoke the
+// recorded ones.
+const rancherSessionTTL = 10 * time.Minute
+
+var rancherTokenLedgerMu sync.Mutex
+
+type rancherTokenLedger struct {
+	RancherURL string               `json:"rancher_url"`
+	Tokens     []rancherTokenRecord `json:"tokens"`
+}
+
+type rancherTokenRecord struct {
+	Name      string `json:"name"`
+	Purpose   string `json:"purpose"`
+	ClusterID string `json:"cluster_id,omitempty"`
+	CreatedAt string `json:"created_at"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+func rancherTokenLedgerPath(instanceNum int) string {
+	return filepath.Join(haInstanceDir(instanceNum), rancherTokenLedgerFile)
+}
+
+// rancherTokenName returns the token name from a bearer token of the form
+// name:secret.
+func rancherTokenName(bearerToken string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(bearerToken), ":")
+	return name
+}
+
+func readRancherTokenLedger(path string) (rancherTokenLedger, error) {
+	var ledger rancherTokenLedger
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ledger, err
+	}
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		return ledger, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return ledger, nil
+}
+
+// updateRancherTokenLedger rewrites the ledger at path under a lock. The file
+// is removed once it holds no tokens.
+func updateRancherTokenLedger(path string, update func(*rancherTokenLedger)) error {
+	rancherTokenLedgerMu.Lock()
+	defer rancherTokenLedgerMu.Unlock()
+
+	ledger, err := readRancherTokenLedger(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	update(&ledger)
+	if len(ledger.Tokens) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(ledger, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func recordRancherToken(ledgerPath, rancherURL string, record rancherTokenRecord) error {
+	if record.CreatedAt == "" {
+		record.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	return updateRancherTokenLedger(ledgerPath, func(ledger *rancherTokenLedger) {
+		ledger.RancherURL = strings.TrimRight(clickableURL(rancherURL), "/")
+		ledger.Tokens = append(ledger.Tokens, record)
+	})
+}
+
+func forgetRancherTokens(ledgerPath string, names ...string) error {
+	forget := map[string]bool{}
+	for _, name := range names {
+		forget[name] = true
+	}
+	return updateRancherTokenLedger(ledgerPath, func(ledger *rancherTokenLedger) {
+		kept := ledger.Tokens[:0]
+		for _, record := range ledger.Tokens {
+			if !forget[record.Name] {
+				kept = append(kept, record)
+			}
+		}
+		ledger.Tokens = kept
+	})
+}
+
+// createRancherToken logs in with the bootstrap password and creates a token
+// with the TTL for purpose. The token is recorded in the HA's ledger before it
+// is returned, and the login session is revoked straight away.
+func createRancherToken(instanceNum int, rancherURL, bootstrapPassword, purpose string) (string, error) {
+	return createScopedRancherToken(instanceNum, rancherURL, bootstrapPassword, purpose, "")
+}
+
+// createScopedRancherToken is createRancherToken for a token scoped to one
+// management cluster. An empty clusterID creates an unscoped token.
+func createScopedRancherToken(instanceNum int, rancherURL, bootstrapPassword, purpose, clusterID string) (string, error) {
+	ttl, ok := rancherTokenTTLs[purpose]
+	if !ok {
+		return "", fmt.Errorf("unknown Rancher token purpose %q", purpose)
+	}
+	bootstrapPassword = strings.TrimSpace(bootstrapPassword)
+	if bootstrapPassword == "" {
+		return "", fmt.Errorf("rancher.bootstrap_password must be set to create a Rancher token")
+	}
+
+	client, err := newRancherAPIClient(rancherURL, rancherclient.Options{})
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	session, err := client.Login(ctx, rancherclient.LoginInput{
+		Password:    bootstrapPassword,
+		Description: "ha-rancher-rke2 " + rancherTokenPurposeSession,
+		TTL:         rancherSessionTTL,
+	})
+	if err != nil {
+		return "", err
+	}
+	sessionClient := client.WithToken(session)
+	defer func() {
+		if err := sessionClient.RevokeToken(ctx, rancherTokenName(session)); err != nil {
+			log.Printf("[tokens][ha-%d] Failed to revoke login session: %v", instanceNum, err)
+		}
+	}()
+
+	token, err := sessionClient.CreateToken(ctx, rancherclient.TokenInput{
+		Description: "ha-rancher-rke2 " + purpose,
+		TTL:         ttl,
+		ClusterID:   clusterID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Record the token before anything else can fail, so TestHACleanup can
+	// still find it if this process dies.
+	record := rancherTokenRecord{Name: token.Name, Purpose: purpose, ClusterID: clusterID, ExpiresAt: token.ExpiresAt}
+	if record.Name == "" {
+		record.Name = rancherTokenName(token.Token)
+	}
+	if err := recordRancherToken(rancherTokenLedgerPath(instanceNum), rancherURL, record); err != nil {
+		if revokeErr := client.WithToken(token.Token).RevokeToken(ctx, record.Name); revokeErr != nil {
+			log.Printf("[tokens][ha-%d] Failed to revoke unrecorded token %s: %v", instanceNum, record.Name, revokeErr)
+		}
+		return "", fmt.Errorf("failed to record Rancher token: %w", err)
+	}
+	maskGitHubActionsValue(token.Token)
+	log.Printf("[tokens][ha-%d] Created %s token %s (ttl %s)", instanceNum, purpose, record.Name, ttl)
+	return token.Token, nil
+}
+
+// revokeOwnRancherToken revokes a token using the token itself, which Rancher
+// allows for the token's owner, and drops it from the ledger. A token Rancher
+// no longer recognizes is dropped too; one it refuses with 403 stays recorded
+// for TestHACleanup.
+func revokeOwnRancherToken(ledgerPath, rancherURL, bearerToken string) error {
+	client, err := newRancherAPIClient(rancherURL, rancherclient.Options{Token: bearerToken})
+	if err != nil {
+		return err
+	}
+	name := rancherTokenName(bearerToken)
+	if err := client.RevokeToken(context.Background(), name); err != nil && !rancherclient.IsUnauthenticated(err) {
+		return err
+	}
+	return forgetRancherTokens(ledgerPath, name)
+}
+
+// withRancherToken creates a token for a short task and revokes it when fn
+// returns.
+func withRancherToken(instanceNum int, rancherURL, bootstrapPassword, purpose string, fn func(token string) error) error {
+	return withScopedRancherToken(instanceNum, rancherURL, bootstrapPassword, purpose, "", fn)
+}
+
+func withScopedRancherToken(instanceNum int, rancherURL, bootstrapPassword, purpose, clusterID string, fn func(token string) error) error {
+	token, err := createScopedRancherToken(instanceNum, rancherURL, bootstrapPassword, purpose, clusterID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := revokeOwnRancherToken(rancherTokenLedgerPath(instanceNum), rancherURL, token); err != nil {
+			log.Printf("[tokens][ha-%d] Failed to revoke %s token: %v", instanceNum, purpose, err)
+		}
+	}()
+	return fn(token)
+}
+
+// generateRecordedKubeconfig generates a kubeconfig for a management cluster
+// and records the cluster-scoped token Rancher puts in it.
+func generateRecordedKubeconfig(instanceNum int, rancherURL, bearerToken, clusterID string) (string, error) {
+	kubeconfig, err := generateRancherKubeconfig(rancherURL, bearerToken, clusterID)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range kubeconfigTokenNames(kubeconfig) {
+		record := rancherTokenRecord{Name: name, Purpose: rancherTokenPurposeKubeconfig, ClusterID: clusterID}
+		if err := recordRancherToken(rancherTokenLedgerPath(instanceNum), rancherURL, record); err != nil {
+			return "", fmt.Errorf("failed to record kubeconfig token: %w", err)
+		}
+	}
+	return kubeconfig, nil
+}
+
+// generateKubeconfigWithTemporaryToken generates a recorded kubeconfig using a
+// token scoped to the cluster that is revoked once the kubeconfig exists.
+func generateKubeconfigWithTemporaryToken(instanceNum int, rancherURL, bootstrapPassword, clusterID string) (string, error) {
+	var kubeconfig string
+	err := withScopedRancherToken(instanceNum, rancherURL, bootstrapPassword, rancherTokenPurposeKubeconfig, clusterID, func(token string) error {
+		var err error
+		kubeconfig, err = generateRecordedKubeconfig(instanceNum, rancherURL, token, clusterID)
+		return err
+	})
+	return kubeconfig, err
+}
+
+func kubeconfigTokenNames(kubeconfig string) []string {
+	config, err := clientcmd.Load([]byte(kubeconfig))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, authInfo := range config.AuthInfos {
+		if name := rancherTokenName(authInfo.Token); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// revokeRecordedRancherTokens logs in with the bootstrap password and revokes
+// every token in the ledger at path. Tokens that could not be revoked stay in
+// the ledger.
+func revokeRecordedRancherTokens(ledgerPath, bootstrapPassword string) error {
+	ledger, err := readRancherTokenLedger(ledgerPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(ledger.Tokens) == 0 {
+		return forgetRancherTokens(ledgerPath)
+	}
+
+	client, err := newRancherAPIClient(ledger.RancherURL, rancherclient.Options{})
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	session, err := client.Login(ctx, rancherclient.LoginInput{
+		Password:    strings.TrimSpace(bootstrapPassword),
+		Description: "ha-rancher-rke2 token cleanup",
+		TTL:         rancherSessionTTL,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to log in to %s to revoke %d recorded tokens: %w", ledger.RancherURL, len(ledger.Tokens), err)
+	}
+	sessionClient := client.WithToken(session)
+
+	var revoked, failures []string
+	for _, record := range ledger.Tokens {
+		if err := sessionClient.RevokeToken(ctx, record.Name); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", record.Name, err))
+			continue
+		}
+		revoked = append(revoked, record.Name)
+	}
+	if err := sessionClient.RevokeToken(ctx, rancherTokenName(session)); err != nil {
+		failures = append(failures, fmt.Sprintf("cleanup session: %v", err))
+	}
+	if err := forgetRancherTokens(ledgerPath, revoked...); err != nil {
+		return err
+	}
+	log.Printf("[tokens] Revoked %d recorded tokens on %s", len(revoked), ledger.RancherURL)
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to revoke %d tokens on %s: %s", len(failures), ledger.RancherURL, strings.Join(failures, "; "))
+	}
+	return nil
+}
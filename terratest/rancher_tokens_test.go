@@ -0,0 +1,244 @@
+package test
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient/rancherfake"
+	"github.com/spf13/viper"
+)
+
+func newTokenFake(t *testing.T) *rancherfake.Server {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Chdir(t.TempDir())
+	fake := rancherfake.New()
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func ledgerTokenNames(t *testing.T, instanceNum int) []string {
+	t.Helper()
+	ledger, err := readRancherTokenLedger(rancherTokenLedgerPath(instanceNum))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, record := range ledger.Tokens {
+		names = append(names, record.Purpose+"="+record.Name)
+	}
+	return names
+}
+
+func TestCreateRancherTokenRecordsShortLivedTokens(t *testing.T) {
+	fake := newTokenFake(t)
+
+	if _, err := createRancherToken(1, fake.URL, " ", rancherTokenPurposeSuiteEnv); err == nil {
+		t.Fatal("expected an empty bootstrap password to be rejected")
+	}
+	if _, err := createRancherToken(1, fake.URL, rancherfake.DefaultPassword, "forever"); err == nil {
+		t.Fatal("expected an unknown purpose to be rejected")
+	}
+
+	token, err := createRancherToken(1, fake.URL, rancherfake.DefaultPassword, rancherTokenPurposeSuiteEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := fake.Snapshot().Tokens
+	stored, ok := tokens[rancherTokenName(token)]
+	if len(tokens) != 1 || !ok || stored.TTL != (12*time.Hour).Milliseconds() || stored.Description != "ha-rancher-rke2 suite-env" {
+		t.Fatalf("expected only a 12h suite token to remain after the login session was revoked, got %+v", tokens)
+	}
+	if got := strings.Join(ledgerTokenNames(t, 1), ","); got != "suite-env="+stored.Name {
+		t.Fatalf("unexpected ledger %q", got)
+	}
+	ledger, err := readRancherTokenLedger(rancherTokenLedgerPath(1))
+	if err != nil || ledger.RancherURL != fake.URL || ledger.Tokens[0].CreatedAt == "" {
+		t.Fatalf("unexpected ledger %+v %v", ledger, err)
+	}
+	data, err := os.ReadFile(rancherTokenLedgerPath(1))
+	if err != nil || strings.Contains(string(data), ":secret") {
+		t.Fatalf("expected the ledger to hold token names only, got %s %v", data, err)
+	}
+}
+
+func TestWithRancherTokenRevokesAfterUse(t *testing.T) {
+	fake := newTokenFake(t)
+
+	var used string
+	err := withRancherToken(2, fake.URL, rancherfake.DefaultPassword, rancherTokenPurposeProvisioning, func(token string) error {
+		used = token
+		if stored := fake.Snapshot().Tokens[rancherTokenName(token)]; stored.TTL != time.Hour.Milliseconds() {
+			t.Fatalf("expected a 1h provisioning token, got %+v", stored)
+		}
+		return nil
+	})
+	if err != nil || used == "" {
+		t.Fatalf("unexpected result %q %v", used, err)
+	}
+	if tokens := fake.Snapshot().Tokens; len(tokens) != 0 {
+		t.Fatalf("expected the provisioning token to be revoked, got %+v", tokens)
+	}
+	if names := ledgerTokenNames(t, 2); len(names) != 0 {
+		t.Fatalf("expected the ledger to be removed, got %q", names)
+	}
+
+	kubeconfig, err := generateKubeconfigWithTemporaryToken(2, fake.URL, rancherfake.DefaultPassword, "local")
+	if err != nil || !strings.Contains(kubeconfig, "kind: Config") {
+		t.Fatalf("unexpected kubeconfig %q %v", kubeconfig, err)
+	}
+	tokens := fake.Snapshot().Tokens
+	var kubeconfigToken rancherclient.Token
+	for _, stored := range tokens {
+		kubeconfigToken = stored
+	}
+	if len(tokens) != 1 || kubeconfigToken.ClusterID != "local" {
+		t.Fatalf("expected only the cluster-scoped kubeconfig token to remain, got %+v", tokens)
+	}
+	if got := strings.Join(ledgerTokenNames(t, 2), ","); got != "kubeconfig="+kubeconfigToken.Name {
+		t.Fatalf("expected the kubeconfig token to be recorded, got %q", got)
+	}
+}
+
+func TestRevokeRecordedRancherTokens(t *testing.T) {
+	fake := newTokenFake(t)
+
+	if err := revokeRecordedRancherTokens(rancherTokenLedgerPath(1), rancherfake.DefaultPassword); err != nil {
+		t.Fatalf("expected a missing ledger to be a no-op, got %v", err)
+	}
+	for _, purpose := range []string{rancherTokenPurposeSuiteEnv, rancherTokenPurposeControlPanel} {
+		if _, err := createRancherToken(1, fake.URL, rancherfake.DefaultPassword, purpose); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordRancherToken(rancherTokenLedgerPath(1), fake.URL, rancherTokenRecord{Name: "token-gone", Purpose: rancherTokenPurposeKubeconfig}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := revokeRecordedRancherTokens(rancherTokenLedgerPath(1), "wrong"); err == nil {
+		t.Fatal("expected a rejected login to be reported")
+	}
+	if names := ledgerTokenNames(t, 1); len(names) != 3 {
+		t.Fatalf("expected the ledger to be kept when nothing was revoked, got %q", names)
+	}
+
+	if err := revokeRecordedRancherTokens(rancherTokenLedgerPath(1), rancherfake.DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if tokens := fake.Snapshot().Tokens; len(tokens) != 0 {
+		t.Fatalf("expected every recorded token and the cleanup session to be revoked, got %+v", tokens)
+	}
+	if _, err := os.Stat(rancherTokenLedgerPath(1)); !os.IsNotExist(err) {
+		t.Fatalf("expected the empty ledger to be removed, got %v", err)
+	}
+}
+
+func TestControlPanelRevokesItsTokens(t *testing.T) {
+	fake := newTokenFake(t)
+	panel := &localControlPanel{
+		config:                    panelConfig{BootstrapPassword: rancherfake.DefaultPassword},
+		rancherTokens:             map[int]panelRancherToken{},
+		downstreamKubeconfigCache: map[string]string{},
+	}
+
+	if _, err := panel.generateKubeconfig(1, fake.URL, "local"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := panel.generateKubeconfig(1, fake.URL, "local"); err != nil {
+		t.Fatal(err)
+	}
+	if tokens := fake.Snapshot().Tokens; len(tokens) != 3 {
+		t.Fatalf("expected one panel token and two kubeconfig tokens, got %+v", tokens)
+	}
+
+	// A token revoked behind the panel's back is replaced once.
+	fake.Update(func(state *rancherfake.State) {
+		for name, stored := range state.Tokens {
+			if stored.Description == "ha-rancher-rke2 control-panel" {
+				delete(state.Tokens, name)
+			}
+		}
+	})
+	if _, err := panel.generateKubeconfig(1, fake.URL, "local"); err != nil {
+		t.Fatalf("expected a rejected token to be replaced, got %v", err)
+	}
+
+	panel.revokeRancherTokens()
+	if tokens := fake.Snapshot().Tokens; len(tokens) != 0 {
+		t.Fatalf("expected the panel token and every kubeconfig token to be revoked, got %+v", tokens)
+	}
+	if names := ledgerTokenNames(t, 1); len(names) != 0 {
+		t.Fatalf("expected the ledger to be removed, got %q", names)
+	}
+}
+
+func TestControlPanelRevokesAForbiddenToken(t *testing.T) {
+	fake := newTokenFake(t)
+	panel := &localControlPanel{
+		config:                    panelConfig{BootstrapPassword: rancherfake.DefaultPassword},
+		rancherTokens:             map[int]panelRancherToken{},
+		downstreamKubeconfigCache: map[string]string{},
+	}
+
+	// Login, create the panel token, revoke the login session, then refuse
+	// generateKubeconfig with 403. The panel revokes the refused token before
+	// it creates a new one.
+	before := len(fake.Requests())
+	first, err := panel.rancherToken(1, fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(fake.Requests()) - before; got != 3 {
+		t.Fatalf("expected login, token create and session revoke, got %d requests", got)
+	}
+	fake.FailNext(http.StatusForbidden)
+	if _, err := panel.generateKubeconfig(1, fake.URL, "local"); err != nil {
+		t.Fatalf("expected a forbidden token to be replaced, got %v", err)
+	}
+	if _, ok := fake.Snapshot().Tokens[rancherTokenName(first)]; ok {
+		t.Fatal("expected the forbidden token to be revoked")
+	}
+	for _, name := range ledgerTokenNames(t, 1) {
+		if name == "control-panel="+rancherTokenName(first) {
+			t.Fatalf("expected the revoked token to leave the ledger, got %q", ledgerTokenNames(t, 1))
+		}
+	}
+
+	// When revoking the refused token fails too, it stays in the ledger.
+	second, err := panel.rancherToken(1, fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.FailNext(http.StatusForbidden, http.StatusForbidden)
+	if _, err := panel.generateKubeconfig(1, fake.URL, "local"); err != nil {
+		t.Fatalf("expected a forbidden token to be replaced, got %v", err)
+	}
+	if got := strings.Join(ledgerTokenNames(t, 1), ","); !strings.Contains(got, "control-panel="+rancherTokenName(second)) {
+		t.Fatalf("expected the unrevoked token to stay recorded for cleanup, got %q", got)
+	}
+	panel.revokeRancherTokens()
+}
+
+func TestScopedRancherTokenIsRecordedWithItsCluster(t *testing.T) {
+	fake := newTokenFake(t)
+
+	token, err := createScopedRancherToken(1, fake.URL, rancherfake.DefaultPassword, rancherTokenPurposeKubeconfig, "c-m-abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := fake.Snapshot().Tokens[rancherTokenName(token)]; stored.ClusterID != "c-m-abc" {
+		t.Fatalf("expected the token to be scoped to c-m-abc, got %+v", stored)
+	}
+	ledger, err := readRancherTokenLedger(rancherTokenLedgerPath(1))
+	if err != nil || len(ledger.Tokens) != 1 || ledger.Tokens[0].ClusterID != "c-m-abc" {
+		t.Fatalf("expected the cluster to be recorded, got %+v %v", ledger, err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
 	"github.com/spf13/viper"
 )
 
@@ -18,7 +19,7 @@ func TestHAUpgradeRancher(t *testing.T) {
 	requireExplicitLifecycleTest(t, "TestHAUpgradeRancher")
 	setupConfig(t)
 
-	upgradeVersion := normalizeVersionInput(os.Getenv("RANCHER_UPGRADE_VERSION"))
+	upgradeVersion := resolver.NormalizeVersionInput(os.Getenv("RANCHER_UPGRADE_VERSION"))
 	if upgradeVersion == "" {
 		t.Skip("RANCHER_UPGRADE_VERSION is not set; skipping Rancher upgrade")
 	}
@@ -38,7 +39,7 @@ func TestHAUpgradeRancher(t *testing.T) {
 		}
 		viper.Set("rancher.versions", upgradeVersions)
 	}
-	upgradePlans, err := resolveAutoRancherPlans(totalHAs)
+	upgradePlans, err := resolver.ResolveAutoPlans(totalHAs)
 	if err != nil {
 		t.Fatalf("failed to resolve Rancher upgrade plan for %s: %v", upgradeVersion, err)
 	}
@@ -107,9 +108,9 @@ func TestHAUpgradeRancher(t *testing.T) {
 	}
 }
 
-func upgradeHAInstanceRancher(instanceNum int, outputs map[string]string, plan *RancherResolvedPlan) error {
+func upgradeHAInstanceRancher(instanceNum int, outputs map[string]string, plan *resolver.RancherResolvedPlan) error {
 	haOutputs := getHAOutputs(instanceNum, outputs)
-	haDir := fmt.Sprintf("high-availability-%d", instanceNum)
+	haDir := haInstanceDir(instanceNum)
 	currentDir, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
@@ -121,8 +122,8 @@ func upgradeHAInstanceRancher(instanceNum int, outputs map[string]string, plan *
 		return fmt.Errorf("kubeconfig not available for HA %d at %s: %w", instanceNum, absKubeConfigPath, err)
 	}
 
-	helmCommand := buildAutoHelmCommand(
-		rancherHelmOperationUpgrade,
+	helmCommand := resolver.BuildHelmCommand(
+		resolver.HelmOperationUpgrade,
 		plan.ChartRepoAlias,
 		plan.ChartVersion,
 		viper.GetString("rancher.bootstrap_password"),
@@ -13,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
 	"github.com/spf13/viper"
 )
 
@@ -25,6 +26,10 @@ func TestHAWaitReady(t *testing.T) {
 		t.Fatal("total_has must be at least 1")
 	}
 
+	if err := settings.ValidateRancherSettingsConfig(); err != nil {
+		t.Fatalf("Rancher settings preflight failed: %v", err)
+	}
+
 	terraformOptions := getTerraformOptions(t, totalHAs)
 	outputs := getTerraformOutputs(t, terraformOptions)
 	if len(outputs) == 0 {
@@ -44,6 +49,10 @@ func TestHAWaitReady(t *testing.T) {
 			defer wg.Done()
 			if err := waitForHAReady(instanceNum, outputs, timeout, initialDelay, settleDelay); err != nil {
 				errCh <- err
+				return
+			}
+			if err := configureRancherForHA(instanceNum, outputs); err != nil {
+				errCh <- err
 			}
 		}()
 	}
@@ -63,7 +72,7 @@ func TestHAWaitReady(t *testing.T) {
 func waitForHAReady(instanceNum int, outputs map[string]string, timeout, initialDelay, settleDelay time.Duration) error {
 	haOutputs := getHAOutputs(instanceNum, outputs)
 	rancherURL := clickableURL(haOutputs.RancherURL)
-	kubeconfigPath := filepath.Join(fmt.Sprintf("high-availability-%d", instanceNum), "kube_config.yaml")
+	kubeconfigPath := filepath.Join(haInstanceDir(instanceNum), "kube_config.yaml")
 
 	log.Printf("[ready][ha-%d] Waiting for Rancher to become ready at %s", instanceNum, rancherURL)
 	log.Printf("[ready][ha-%d] Kubeconfig: %s", instanceNum, kubeconfigPath)
@@ -74,9 +83,11 @@ func waitForHAReady(instanceNum int, outputs map[string]string, timeout, initial
 	}
 
 	client := rancherReadyHTTPClient()
+	bootstrapPassword := viper.GetString("rancher.bootstrap_password")
 	deadline := time.Now().Add(timeout)
 	attempt := 0
 	consecutiveReady := 0
+	var checks []rancherReadinessCheck
 
 	for time.Now().Before(deadline) {
 		attempt++
@@ -86,31 +97,109 @@ func waitForHAReady(instanceNum int, outputs map[string]string, timeout, initial
 		if podsErr != nil {
 			podsSummary = podsErr.Error()
 		}
+		checks = []rancherReadinessCheck{
+			{Name: readinessCheckHTTP, Passed: httpReady, Detail: httpSummary},
+			{Name: readinessCheckPods, Passed: podsReady, Detail: podsSummary},
+		}
 
+		// The API probe logs in, so only run it once Rancher answers and its
+		// pods are up.
+		apiSummary := "not checked"
 		if httpReady && podsReady {
+			apiChecks := probeRancherAPI(rancherURL, bootstrapPassword)
+			checks = append(checks, apiChecks...)
+			apiSummary = summarizeReadinessChecks(apiChecks)
+		}
+
+		if readinessChecksPassed(checks) {
 			consecutiveReady++
-			log.Printf("[ready][ha-%d] Attempt %d ready check passed (%d/2): http=%s pods=%s",
-				instanceNum, attempt, consecutiveReady, httpSummary, podsSummary)
+			log.Printf("[ready][ha-%d] Attempt %d ready check passed (%d/2): http=%s pods=%s api=[%s]",
+				instanceNum, attempt, consecutiveReady, httpSummary, podsSummary, apiSummary)
 			if consecutiveReady >= 2 {
 				if settleDelay > 0 {
 					log.Printf("[ready][ha-%d] Rancher is ready; settling for %s before continuing", instanceNum, settleDelay)
 					time.Sleep(settleDelay)
 				}
+				recordRancherReadiness(instanceNum, rancherURL, attempt, checks)
 				log.Printf("[ready][ha-%d] Rancher readiness confirmed", instanceNum)
 				return nil
 			}
 		} else {
 			consecutiveReady = 0
-			log.Printf("[ready][ha-%d] Attempt %d not ready yet: http=%s pods=%s",
-				instanceNum, attempt, httpSummary, podsSummary)
+			log.Printf("[ready][ha-%d] Attempt %d not ready yet: http=%s pods=%s api=[%s]",
+				instanceNum, attempt, httpSummary, podsSummary, apiSummary)
 		}
 
 		time.Sleep(20 * time.Second)
 	}
 
+	recordRancherReadiness(instanceNum, rancherURL, attempt, checks)
 	return fmt.Errorf("[ha-%d] timed out after %s waiting for Rancher readiness", instanceNum, timeout)
 }
 
+// configureRancherForHA applies rancher.settings and rancher.features once
+// Rancher is ready and records the result in rancher-settings-ha-N.json.
+func configureRancherForHA(instanceNum int, outputs map[string]string) error {
+	rancherURL := clickableURL(getHAOutputs(instanceNum, outputs).RancherURL)
+	kubeconfigPath := filepath.Join(haInstanceDir(instanceNum), "kube_config.yaml")
+	restart := func() error {
+		return restartRancherAndWait(instanceNum, kubeconfigPath, rancherURL, durationFromEnv("RANCHER_RESTART_TIMEOUT", 15*time.Minute))
+	}
+
+	artifact, err := applyRancherConfiguration(instanceNum, rancherURL, viper.GetString("rancher.bootstrap_password"), restart)
+	if len(artifact.Results) > 0 {
+		logRancherConfigSummary(instanceNum, artifact)
+		if writeErr := writeRancherConfigArtifact(artifact); writeErr != nil {
+			log.Printf("[settings][ha-%d] Failed to write settings artifact: %v", instanceNum, writeErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("[ha-%d] failed to configure Rancher: %w", instanceNum, err)
+	}
+	return nil
+}
+
+// restartRancherAndWait restarts the rancher deployment so features that are
+// not dynamic take effect, then waits for the rollout and the API checks.
+func restartRancherAndWait(instanceNum int, kubeconfigPath, rancherURL string, timeout time.Duration) error {
+	log.Printf("[settings][ha-%d] Restarting Rancher and waiting up to %s for the rollout", instanceNum, timeout)
+	if err := runKubectlDirect(kubeconfigPath, "-n", "cattle-system", "rollout", "restart", "deployment/rancher"); err != nil {
+		return err
+	}
+	if err := waitForRancherRollout(kubeconfigPath, rancherURL, timeout); err != nil {
+		return err
+	}
+	log.Printf("[settings][ha-%d] Rancher is back after the restart", instanceNum)
+	return nil
+}
+
+// waitForRancherRollout waits for the rancher deployment to finish rolling out
+// and for the API checks to pass again.
+func waitForRancherRollout(kubeconfigPath, rancherURL string, timeout time.Duration) error {
+	if err := runKubectlDirect(kubeconfigPath, "-n", "cattle-system", "rollout", "status", "deployment/rancher", "--timeout", timeout.String()); err != nil {
+		return err
+	}
+
+	bootstrapPassword := viper.GetString("rancher.bootstrap_password")
+	deadline := time.Now().Add(timeout)
+	for {
+		checks := probeRancherAPI(rancherURL, bootstrapPassword)
+		if readinessChecksPassed(checks) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for the Rancher API after the rollout: %s", timeout, summarizeReadinessChecks(checks))
+		}
+		time.Sleep(20 * time.Second)
+	}
+}
+
+func recordRancherReadiness(instanceNum int, rancherURL string, attempts int, checks []rancherReadinessCheck) {
+	if err := writeRancherReadinessArtifact(instanceNum, rancherURL, attempts, checks); err != nil {
+		log.Printf("[ready][ha-%d] Failed to write readiness artifact: %v", instanceNum, err)
+	}
+}
+
 func rancherReadyHTTPClient() *http.Client {
 	return &http.Client{
 		Timeout: 15 * time.Second,
@@ -0,0 +1,298 @@
+package rancherclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Condition is a status condition as the v3 and v1 APIs report it.
+type Condition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type LoginInput struct {
+	Username    string
+	Password    string
+	Description string
+	// TTL is the session lifetime. Zero keeps Rancher's default.
+	TTL time.Duration
+}
+
+type TokenInput struct {
+	Description string
+	// TTL is the token lifetime. Zero creates a token that never expires when
+	// the server allows it.
+	TTL time.Duration
+	// ClusterID scopes the token to one cluster. Empty creates an unscoped
+	// token.
+	ClusterID string
+}
+
+type Token struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Token       string `json:"token"`
+	Description string `json:"description"`
+	ClusterID   string `json:"clusterId"`
+	TTL         int64  `json:"ttl"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+type Setting struct {
+	ID      string `json:"id"`
+	Value   string `json:"value"`
+	Default string `json:"default"`
+}
+
+// Effective returns the value Rancher uses: the value if set, else the default.
+func (s Setting) Effective() string {
+	if value := strings.TrimSpace(s.Value); value != "" {
+		return value
+	}
+	return strings.TrimSpace(s.Default)
+}
+
+type Cluster struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	State      string      `json:"state"`
+	Conditions []Condition `json:"conditions"`
+}
+
+type ClusterRepo struct {
+	ID     string `json:"id"`
+	Status struct {
+		DownloadTime string      `json:"downloadTime"`
+		Conditions   []Condition `json:"conditions"`
+	} `json:"status"`
+}
+
+type Feature struct {
+	ID   string `json:"id"`
+	Spec struct {
+		Value *bool `json:"value"`
+	} `json:"spec"`
+	Status struct {
+		Default bool `json:"default"`
+		// Dynamic is false for features that only take effect after Rancher
+		// restarts.
+		Dynamic bool `json:"dynamic"`
+	} `json:"status"`
+}
+
+// Enabled returns the value Rancher uses: the value if set, else the default.
+func (f Feature) Enabled() bool {
+	if f.Spec.Value != nil {
+		return *f.Spec.Value
+	}
+	return f.Status.Default
+}
+
+type CloudCredentialInput struct {
+	Name string
+	// Driver is the node driver the credential is for, for example linode.
+	Driver string
+	// Fields are the driver's credential fields, for example token.
+	Fields map[string]string
+}
+
+type CloudCredential struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type listResponse[T any] struct {
+	Data []T `json:"data"`
+}
+
+// Login logs in to the local auth provider and returns the session token.
+func (c *Client) Login(ctx context.Context, in LoginInput) (string, error) {
+	if strings.TrimSpace(in.Password) == "" {
+		return "", errors.New("password must not be empty")
+	}
+	username := in.Username
+	if username == "" {
+		username = "admin"
+	}
+	payload := map[string]interface{}{
+		"description":  in.Description,
+		"responseType": "token",
+		"username":     username,
+		"password":     in.Password,
+	}
+	if in.TTL > 0 {
+		payload["ttl"] = in.TTL.Milliseconds()
+	}
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := c.post(ctx, "/v3-public/localProviders/local?action=login", payload, &out); err != nil {
+		return "", err
+	}
+	if out.Token == "" {
+		return "", errors.New("Rancher login response did not include a token")
+	}
+	return out.Token, nil
+}
+
+// CreateToken creates an API token for the authenticated user.
+func (c *Client) CreateToken(ctx context.Context, in TokenInput) (Token, error) {
+	payload := map[string]interface{}{
+		"type":        "token",
+		"metadata":    struct{}{},
+		"description": in.Description,
+		"ttl":         in.TTL.Milliseconds(),
+	}
+	if in.ClusterID != "" {
+		payload["clusterId"] = in.ClusterID
+	}
+	var out Token
+	if err := c.post(ctx, "/v3/tokens", payload, &out); err != nil {
+		return Token{}, err
+	}
+	if out.Token == "" {
+		return Token{}, errors.New("Rancher token response did not include a token")
+	}
+	return out, nil
+}
+
+// RevokeToken deletes the token with the given name, for example token-abcde.
+// Revoking a token that no longer exists is not an error.
+func (c *Client) RevokeToken(ctx context.Context, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("token name must not be empty")
+	}
+	if err := c.delete(ctx, "/v3/tokens/"+url.PathEscape(name)); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) GetSetting(ctx context.Context, name string) (Setting, error) {
+	var out Setting
+	err := c.get(ctx, "/v3/settings/"+url.PathEscape(name), &out)
+	return out, err
+}
+
+func (c *Client) PutSetting(ctx context.Context, name, value string) error {
+	payload := map[string]string{"name": name, "value": value}
+	return c.put(ctx, "/v3/settings/"+url.PathEscape(name), payload, nil)
+}
+
+func (c *Client) ListClusters(ctx context.Context) ([]Cluster, error) {
+	var out listResponse[Cluster]
+	err := c.get(ctx, "/v3/clusters", &out)
+	return out.Data, err
+}
+
+func (c *Client) GetCluster(ctx context.Context, id string) (Cluster, error) {
+	var out Cluster
+	err := c.get(ctx, "/v3/clusters/"+url.PathEscape(id), &out)
+	return out, err
+}
+
+// GenerateKubeconfig returns a kubeconfig for the management cluster id, for
+// example local or c-m-abcde.
+func (c *Client) GenerateKubeconfig(ctx context.Context, clusterID string) (string, error) {
+	clusterID = strings.TrimSpace(clusterID)
+	if clusterID == "" {
+		return "", errors.New("cluster id must not be empty")
+	}
+	var out struct {
+		Config string `json:"config"`
+	}
+	if err := c.post(ctx, "/v3/clusters/"+url.PathEscape(clusterID)+"?action=generateKubeconfig", map[string]string{}, &out); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(out.Config) == "" {
+		return "", fmt.Errorf("Rancher generateKubeconfig response did not include config for cluster %s", clusterID)
+	}
+	return out.Config, nil
+}
+
+func (c *Client) ListClusterRepos(ctx context.Context) ([]ClusterRepo, error) {
+	var out listResponse[ClusterRepo]
+	err := c.get(ctx, "/v1/catalog.cattle.io.clusterrepos", &out)
+	return out.Data, err
+}
+
+func (c *Client) ListFeatures(ctx context.Context) ([]Feature, error) {
+	var out listResponse[Feature]
+	err := c.get(ctx, "/v1/management.cattle.io.features", &out)
+	return out.Data, err
+}
+
+// SetFeature sets the value of the feature flag with the given name, for
+// example ui-sql-cache.
+func (c *Client) SetFeature(ctx context.Context, name string, value bool) error {
+	payload := map[string]interface{}{"name": name, "value": value}
+	return c.put(ctx, "/v3/features/"+url.PathEscape(name), payload, nil)
+}
+
+// CreateMachineConfig creates a node driver machine config, for example kind
+// linodeconfig, and returns its generated name. payload is the Steve object
+// without its type, which is filled in from kind.
+func (c *Client) CreateMachineConfig(ctx context.Context, kind, namespace string, payload map[string]interface{}) (string, error) {
+	body := make(map[string]interface{}, len(payload)+1)
+	for key, value := range payload {
+		body[key] = value
+	}
+	body["type"] = "rke-machine-config.cattle.io." + kind
+	var out struct {
+		ID       string `json:"id"`
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+	path := fmt.Sprintf("/v1/rke-machine-config.cattle.io.%ss/%s", kind, url.PathEscape(namespace))
+	if err := c.post(ctx, path, body, &out); err != nil {
+		return "", err
+	}
+	if name := strings.TrimSpace(out.Metadata.Name); name != "" {
+		return name, nil
+	}
+	if id := strings.TrimSpace(out.ID); id != "" {
+		parts := strings.Split(id, "/")
+		return parts[len(parts)-1], nil
+	}
+	return "", fmt.Errorf("Rancher %s response did not include a machine config name", kind)
+}
+
+// CreateCloudCredential creates a cloud credential for a node driver. The
+// returned ID has the form cattle-global-data:cc-xxxxx.
+func (c *Client) CreateCloudCredential(ctx context.Context, in CloudCredentialInput) (CloudCredential, error) {
+	if in.Driver == "" {
+		return CloudCredential{}, errors.New("cloud credential driver must not be empty")
+	}
+	payload := map[string]interface{}{
+		"type":                         "cloudCredential",
+		"name":                         in.Name,
+		"metadata":                     map[string]string{"generateName": "cc-"},
+		in.Driver + "credentialConfig": in.Fields,
+	}
+	var out CloudCredential
+	if err := c.post(ctx, "/v3/cloudcredentials", payload, &out); err != nil {
+		return CloudCredential{}, err
+	}
+	if out.ID == "" {
+		return CloudCredential{}, errors.New("Rancher cloud credential response did not include an id")
+	}
+	return out, nil
+}
+
+// DeleteCloudCredential deletes a cloud credential by ID. Deleting one that no
+// longer exists is not an error.
+func (c *Client) DeleteCloudCredential(ctx context.Context, id string) error {
+	if err := c.delete(ctx, "/v3/cloudcredentials/"+url.PathEscape(id)); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
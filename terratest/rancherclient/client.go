@@ -0,0 +1,280 @@
+// Package rancherclient is a small typed client for the parts of the Rancher
+// v3 and v1 (Steve) APIs the HA lifecycle tests use.
+package rancherclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	defaultBackoff    = time.Second
+	maxBackoff        = 15 * time.Second
+)
+
+// Options configures a Client. The zero value verifies TLS against the system
+// roots and retries transient failures three times.
+type Options struct {
+	// Token is sent as a bearer token on every request.
+	Token string
+	// CACert is a PEM bundle to verify Rancher's certificate against instead
+	// of the system roots.
+	CACert []byte
+	// Insecure skips TLS verification. It is ignored when CACert is set.
+	Insecure bool
+	// Timeout bounds each HTTP attempt. Defaults to 30s.
+	Timeout time.Duration
+	// MaxRetries is the number of retries after the first attempt for
+	// transient failures. Negative disables retries; zero uses the default.
+	MaxRetries int
+	// Backoff is the delay before the first retry. It doubles on each retry
+	// up to 15s. Defaults to 1s.
+	Backoff time.Duration
+}
+
+type Client struct {
+	baseURL    string
+	token      string
+	http       *http.Client
+	maxRetries int
+	backoff    time.Duration
+}
+
+// New returns a client for the Rancher server at rancherURL, for example
+// https://rancher.example.com.
+func New(rancherURL string, opts Options) (*Client, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(rancherURL), "/")
+	if baseURL == "" {
+		return nil, errors.New("Rancher URL must not be empty")
+	}
+	if !strings.HasPrefix(baseURL, "https://") && !strings.HasPrefix(baseURL, "http://") {
+		baseURL = "https://" + baseURL
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if len(opts.CACert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(opts.CACert) {
+			return nil, errors.New("Rancher CA bundle did not contain any PEM certificates")
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	maxRetries := opts.MaxRetries
+	switch {
+	case maxRetries == 0:
+		maxRetries = defaultMaxRetries
+	case maxRetries < 0:
+		maxRetries = 0
+	}
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	return &Client{
+		baseURL: baseURL,
+		token:   strings.TrimSpace(opts.Token),
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+		maxRetries: maxRetries,
+		backoff:    backoff,
+	}, nil
+}
+
+// WithToken returns a copy of c that authenticates with token.
+func (c *Client) WithToken(token string) *Client {
+	clone := *c
+	clone.token = strings.TrimSpace(token)
+	return &clone
+}
+
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// APIError is returned when Rancher answers with a non-2xx status.
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Rancher API %s %s returned HTTP %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether err is an APIError with HTTP 404.
+func IsNotFound(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
+
+// IsUnauthorized reports whether err is an APIError with HTTP 401 or 403.
+func IsUnauthorized(err error) bool {
+	code := statusCode(err)
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+// IsUnauthenticated reports whether err is an APIError with HTTP 401, which
+// Rancher returns when the token is unknown, expired, or revoked. A 403 means
+// the token is still valid but not allowed to make the request.
+func IsUnauthenticated(err error) bool {
+	return statusCode(err) == http.StatusUnauthorized
+}
+
+func statusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	return c.do(ctx, http.MethodGet, path, nil, out)
+}
+
+func (c *Client) post(ctx context.Context, path string, payload, out interface{}) error {
+	return c.do(ctx, http.MethodPost, path, payload, out)
+}
+
+func (c *Client) put(ctx context.Context, path string, payload, out interface{}) error {
+	return c.do(ctx, http.MethodPut, path, payload, out)
+}
+
+func (c *Client) delete(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// do sends one API request, retrying connection failures and responses that
+// mean Rancher or its load balancer is briefly unavailable when retryable
+// says that is safe for the method. out may be nil when the response body is
+// not needed.
+func (c *Client) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var data []byte
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+	target := c.baseURL + path
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		body, err := c.send(ctx, method, target, data)
+		if err == nil {
+			if out == nil || len(bytes.TrimSpace(body)) == 0 {
+				return nil
+			}
+			if err := json.Unmarshal(body, out); err != nil {
+				return fmt.Errorf("failed to parse Rancher API response from %s: %w", target, err)
+			}
+			return nil
+		}
+		if attempt >= c.maxRetries || ctx.Err() != nil || !retryable(method, err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (giving up after %d attempts: %v)", err, attempt+1, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, target string, data []byte) ([]byte, error) {
+	var reader io.Reader
+	if data != nil {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &APIError{Method: method, URL: target, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
+
+// retryable repeats GET, PUT, and DELETE on gateway errors, 429, 500, and
+// connection failures, since sending them twice leaves Rancher in the same
+// state. A POST may already have created something by the time the response
+// is lost, so it is only repeated when the connection could not be dialed and
+// no part of the request was sent.
+func retryable(method string, err error) bool {
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return false
+	}
+	if !idempotent(method) {
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial"
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
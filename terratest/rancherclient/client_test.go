@@ -0,0 +1,252 @@
+package rancherclient_test
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient/rancherfake"
+)
+
+func newFake(t *testing.T) *rancherfake.Server {
+	t.Helper()
+	fake := rancherfake.New()
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func newClient(t *testing.T, fake *rancherfake.Server, opts rancherclient.Options) *rancherclient.Client {
+	t.Helper()
+	opts.Insecure = true
+	if opts.Backoff == 0 {
+		opts.Backoff = time.Millisecond
+	}
+	client, err := rancherclient.New(fake.URL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func login(t *testing.T, client *rancherclient.Client) *rancherclient.Client {
+	t.Helper()
+	session, err := client.Login(context.Background(), rancherclient.LoginInput{Password: rancherfake.DefaultPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.WithToken(session)
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	fake := newFake(t)
+	ctx := context.Background()
+	client := newClient(t, fake, rancherclient.Options{})
+
+	if _, err := client.Login(ctx, rancherclient.LoginInput{Password: "wrong"}); !rancherclient.IsUnauthorized(err) {
+		t.Fatalf("expected a rejected login to be an unauthorized APIError, got %v", err)
+	}
+	session, err := client.Login(ctx, rancherclient.LoginInput{Password: rancherfake.DefaultPassword, Description: "probe", TTL: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authed := client.WithToken(session)
+
+	token, err := authed.CreateToken(ctx, rancherclient.TokenInput{Description: "automation", TTL: time.Hour, ClusterID: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := fake.Snapshot()
+	if len(state.Tokens) != 2 || state.Tokens[token.Name].TTL != time.Hour.Milliseconds() || state.Tokens[token.Name].ClusterID != "local" {
+		t.Fatalf("unexpected tokens %+v", state.Tokens)
+	}
+	for _, stored := range state.Tokens {
+		if stored.Description == "probe" && stored.TTL != (10*time.Minute).Milliseconds() {
+			t.Fatalf("expected the login TTL to be sent in milliseconds, got %+v", stored)
+		}
+	}
+
+	if err := authed.RevokeToken(ctx, token.Name); err != nil {
+		t.Fatal(err)
+	}
+	if err := authed.RevokeToken(ctx, token.Name); err != nil {
+		t.Fatalf("expected revoking a missing token to succeed, got %v", err)
+	}
+	if _, ok := fake.Snapshot().Tokens[token.Name]; ok {
+		t.Fatal("expected the token to be revoked")
+	}
+	if _, err := client.WithToken(token.Token).ListClusters(ctx); !rancherclient.IsUnauthorized(err) {
+		t.Fatalf("expected the revoked token to be rejected, got %v", err)
+	}
+}
+
+func TestSettingsAndClusters(t *testing.T) {
+	fake := newFake(t)
+	ctx := context.Background()
+	client := login(t, newClient(t, fake, rancherclient.Options{}))
+
+	if err := client.PutSetting(ctx, "server-url", "https://rancher.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	setting, err := client.GetSetting(ctx, "server-url")
+	if err != nil || setting.Effective() != "https://rancher.example.com" {
+		t.Fatalf("unexpected setting %+v %v", setting, err)
+	}
+	if (rancherclient.Setting{Default: " 12 "}).Effective() != "12" {
+		t.Fatal("expected Effective to fall back to the default")
+	}
+
+	clusters, err := client.ListClusters(ctx)
+	if err != nil || len(clusters) != 1 || clusters[0].State != "active" {
+		t.Fatalf("unexpected clusters %+v %v", clusters, err)
+	}
+	if _, err := client.GetCluster(ctx, "c-m-missing"); !rancherclient.IsNotFound(err) {
+		t.Fatalf("expected a missing cluster to be a not found APIError, got %v", err)
+	}
+	var apiErr *rancherclient.APIError
+	if _, err := client.GetSetting(ctx, "nope"); !errors.As(err, &apiErr) || apiErr.Method != http.MethodGet || !strings.HasSuffix(apiErr.URL, "/v3/settings/nope") {
+		t.Fatalf("expected a typed APIError, got %v", err)
+	}
+	kubeconfig, err := client.GenerateKubeconfig(ctx, "local")
+	if err != nil || !strings.Contains(kubeconfig, "kind: Config") {
+		t.Fatalf("unexpected kubeconfig %q %v", kubeconfig, err)
+	}
+
+	repos, err := client.ListClusterRepos(ctx)
+	if err != nil || len(repos) != 1 || repos[0].Status.Conditions[0].Type != "Downloaded" {
+		t.Fatalf("unexpected repos %+v %v", repos, err)
+	}
+	features, err := client.ListFeatures(ctx)
+	if err != nil || len(features) != 2 {
+		t.Fatalf("unexpected features %+v %v", features, err)
+	}
+}
+
+func TestMachineConfigsAndCloudCredentials(t *testing.T) {
+	fake := newFake(t)
+	ctx := context.Background()
+	client := login(t, newClient(t, fake, rancherclient.Options{}))
+
+	name, err := client.CreateMachineConfig(ctx, "linodeconfig", "fleet-default", map[string]interface{}{
+		"region":   "us-ord",
+		"metadata": map[string]interface{}{"generateName": "nc-demo-pool1-"},
+	})
+	if err != nil || !strings.HasPrefix(name, "nc-demo-pool1-") {
+		t.Fatalf("unexpected machine config %q %v", name, err)
+	}
+	stored := fake.Snapshot().MachineConfigs["fleet-default/"+name]
+	if stored["type"] != "rke-machine-config.cattle.io.linodeconfig" || stored["region"] != "us-ord" {
+		t.Fatalf("unexpected machine config body %+v", stored)
+	}
+
+	credential, err := client.CreateCloudCredential(ctx, rancherclient.CloudCredentialInput{
+		Name:   "linode",
+		Driver: "linode",
+		Fields: map[string]string{"token": "linode-token"},
+	})
+	if err != nil || !strings.HasPrefix(credential.ID, "cattle-global-data:cc-") {
+		t.Fatalf("unexpected credential %+v %v", credential, err)
+	}
+	body := fake.Snapshot().CloudCredentials[credential.ID]
+	if fields, ok := body["linodecredentialConfig"].(map[string]interface{}); !ok || fields["token"] != "linode-token" {
+		t.Fatalf("expected the driver fields under linodecredentialConfig, got %+v", body)
+	}
+	if err := client.DeleteCloudCredential(ctx, credential.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Snapshot().CloudCredentials) != 0 {
+		t.Fatal("expected the credential to be deleted")
+	}
+}
+
+func TestRetriesTransientFailures(t *testing.T) {
+	fake := newFake(t)
+	ctx := context.Background()
+	client := login(t, newClient(t, fake, rancherclient.Options{MaxRetries: 2}))
+
+	fake.FailNext(http.StatusServiceUnavailable, http.StatusBadGateway)
+	if _, err := client.ListClusters(ctx); err != nil {
+		t.Fatalf("expected two gateway errors to be retried, got %v", err)
+	}
+
+	fake.FailNext(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	if _, err := client.ListClusters(ctx); statusOf(err) != http.StatusServiceUnavailable {
+		t.Fatalf("expected the last error after the retries ran out, got %v", err)
+	}
+
+	before := len(fake.Requests())
+	fake.FailNext(http.StatusInternalServerError)
+	if _, err := client.CreateToken(ctx, rancherclient.TokenInput{}); statusOf(err) != http.StatusInternalServerError {
+		t.Fatalf("expected a POST 500 to fail, got %v", err)
+	}
+	fake.FailNext(http.StatusServiceUnavailable)
+	if _, err := client.CreateToken(ctx, rancherclient.TokenInput{}); statusOf(err) != http.StatusServiceUnavailable {
+		t.Fatalf("expected a POST 503 to fail, got %v", err)
+	}
+	fake.FailNext(http.StatusInternalServerError)
+	if _, err := client.GetSetting(ctx, "server-url"); err != nil {
+		t.Fatalf("expected a GET 500 to be retried, got %v", err)
+	}
+	if got := len(fake.Requests()) - before; got != 4 {
+		t.Fatalf("expected each POST once and the GET twice, got %d requests", got)
+	}
+
+	noRetry, err := rancherclient.New(fake.URL, rancherclient.Options{Insecure: true, MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.FailNext(http.StatusServiceUnavailable)
+	if _, err := noRetry.Login(ctx, rancherclient.LoginInput{Password: rancherfake.DefaultPassword}); statusOf(err) != http.StatusServiceUnavailable {
+		t.Fatalf("expected retries to be disabled, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.ListClusters(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled context to stop the request, got %v", err)
+	}
+}
+
+func TestTLSVerification(t *testing.T) {
+	fake := newFake(t)
+	ctx := context.Background()
+
+	strict, err := rancherclient.New(fake.URL, rancherclient.Options{Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strict.Login(ctx, rancherclient.LoginInput{Password: rancherfake.DefaultPassword}); err == nil {
+		t.Fatal("expected the self-signed certificate to be rejected")
+	}
+	if len(fake.Requests()) != 0 {
+		t.Fatalf("expected the TLS failure not to reach the server, got %q", fake.Requests())
+	}
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fake.Certificate().Raw})
+	trusted, err := rancherclient.New(fake.URL, rancherclient.Options{CACert: caPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trusted.Login(ctx, rancherclient.LoginInput{Password: rancherfake.DefaultPassword}); err != nil {
+		t.Fatalf("expected the CA bundle to be trusted, got %v", err)
+	}
+
+	if _, err := rancherclient.New(fake.URL, rancherclient.Options{CACert: []byte("not pem")}); err == nil {
+		t.Fatal("expected an invalid CA bundle to be rejected")
+	}
+	if _, err := rancherclient.New(" ", rancherclient.Options{}); err == nil {
+		t.Fatal("expected an empty URL to be rejected")
+	}
+}
+
+func statusOf(err error) int {
+	var apiErr *rancherclient.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,352 @@
+// Package rancherfake serves the subset of the Rancher API that rancherclient
+// covers, for unit tests.
+package rancherfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/rancherclient"
+)
+
+const DefaultPassword = "bootstrap-password"
+
+// State is everything the fake Rancher knows. Tests change it through
+// Server.Update and read it back through Server.Snapshot.
+type State struct {
+	Password         string
+	Settings         map[string]rancherclient.Setting
+	Clusters         []rancherclient.Cluster
+	ClusterRepos     []rancherclient.ClusterRepo
+	Features         []rancherclient.Feature
+	Kubeconfigs      map[string]string
+	Tokens           map[string]rancherclient.Token
+	MachineConfigs   map[string]map[string]interface{}
+	CloudCredentials map[string]map[string]interface{}
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	state    State
+	requests []string
+	failures []int
+	nextID   int
+}
+
+// New starts a TLS fake with an active local cluster, one downloaded cluster
+// repo and a couple of features. Close it when the test ends.
+func New() *Server {
+	s := &Server{state: State{
+		Password: DefaultPassword,
+		Settings: map[string]rancherclient.Setting{
+			"server-url":     {ID: "server-url"},
+			"server-version": {ID: "server-version", Value: "v2.12.3"},
+		},
+		Clusters: []rancherclient.Cluster{{
+			ID:         "local",
+			Name:       "local",
+			State:      "active",
+			Conditions: []rancherclient.Condition{{Type: "Ready", Status: "True"}, {Type: "Provisioned", Status: "True"}},
+		}},
+		Features: []rancherclient.Feature{
+			dynamicFeature("fleet", true),
+			dynamicFeature("ui-sql-cache", false),
+		},
+		Kubeconfigs:      map[string]string{"local": "apiVersion: v1\nkind: Config\n"},
+		Tokens:           map[string]rancherclient.Token{},
+		MachineConfigs:   map[string]map[string]interface{}{},
+		CloudCredentials: map[string]map[string]interface{}{},
+	}}
+	repo := rancherclient.ClusterRepo{ID: "rancher-charts"}
+	repo.Status.Conditions = []rancherclient.Condition{{Type: "Downloaded", Status: "True"}}
+	s.state.ClusterRepos = []rancherclient.ClusterRepo{repo}
+
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func dynamicFeature(id string, enabled bool) rancherclient.Feature {
+	feature := rancherclient.Feature{ID: id}
+	feature.Status.Default = enabled
+	feature.Status.Dynamic = true
+	return feature
+}
+
+// Update changes the state under the server's lock.
+func (s *Server) Update(fn func(*State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.state)
+}
+
+// Snapshot returns a copy of the state. Maps are copied one level deep.
+func (s *Server) Snapshot() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.state
+	state.Settings = copyMap(s.state.Settings)
+	state.Tokens = copyMap(s.state.Tokens)
+	state.MachineConfigs = copyMap(s.state.MachineConfigs)
+	state.CloudCredentials = copyMap(s.state.CloudCredentials)
+	state.Clusters = append([]rancherclient.Cluster(nil), s.state.Clusters...)
+	state.Features = append([]rancherclient.Feature(nil), s.state.Features...)
+	return state
+}
+
+// Requests lists the requests served so far as "METHOD /path".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// FailNext answers the next requests with the given statuses, in order,
+// before serving normally again.
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, status, "injected failure")
+		return
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == "/v3-public/localProviders/local" && r.URL.Query().Get("action") == "login" {
+		s.login(w, r)
+		return
+	}
+	if !s.authenticated(r) {
+		writeError(w, http.StatusUnauthorized, "must authenticate")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/v3/tokens" && r.Method == http.MethodPost:
+		s.createToken(w, r)
+	case len(parts) == 3 && parts[0] == "v3" && parts[1] == "tokens" && r.Method == http.MethodDelete:
+		if _, ok := s.state.Tokens[parts[2]]; !ok {
+			writeError(w, http.StatusNotFound, "token not found")
+			return
+		}
+		delete(s.state.Tokens, parts[2])
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[0] == "v3" && parts[1] == "settings":
+		s.setting(w, r, parts[2])
+	case r.URL.Path == "/v3/clusters" && r.Method == http.MethodGet:
+		writeJSON(w, map[string]interface{}{"data": s.state.Clusters})
+	case len(parts) == 3 && parts[0] == "v3" && parts[1] == "clusters":
+		s.cluster(w, r, parts[2])
+	case r.URL.Path == "/v1/catalog.cattle.io.clusterrepos" && r.Method == http.MethodGet:
+		writeJSON(w, map[string]interface{}{"data": s.state.ClusterRepos})
+	case r.URL.Path == "/v1/management.cattle.io.features" && r.Method == http.MethodGet:
+		writeJSON(w, map[string]interface{}{"data": s.state.Features})
+	case len(parts) == 3 && parts[0] == "v3" && parts[1] == "features" && r.Method == http.MethodPut:
+		s.setFeature(w, r, parts[2])
+	case len(parts) == 3 && parts[0] == "v1" && strings.HasPrefix(parts[1], "rke-machine-config.cattle.io.") && r.Method == http.MethodPost:
+		s.createMachineConfig(w, r, parts[2])
+	case r.URL.Path == "/v3/cloudcredentials" && r.Method == http.MethodPost:
+		s.createCloudCredential(w, r)
+	case len(parts) == 3 && parts[0] == "v3" && parts[1] == "cloudcredentials" && r.Method == http.MethodDelete:
+		if _, ok := s.state.CloudCredentials[parts[2]]; !ok {
+			writeError(w, http.StatusNotFound, "cloud credential not found")
+			return
+		}
+		delete(s.state.CloudCredentials, parts[2])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) authenticated(r *http.Request) bool {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for _, token := range s.state.Tokens {
+		if token.Token == bearer {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) issueToken(description, clusterID string, ttl int64) rancherclient.Token {
+	s.nextID++
+	name := fmt.Sprintf("token-%05d", s.nextID)
+	token := rancherclient.Token{
+		ID:          name,
+		Name:        name,
+		Token:       name + ":secret",
+		Description: description,
+		ClusterID:   clusterID,
+		TTL:         ttl,
+	}
+	s.state.Tokens[name] = token
+	return token
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		Description string `json:"description"`
+		TTL         int64  `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if in.Username != "admin" || in.Password != s.state.Password {
+		writeError(w, http.StatusUnauthorized, "authentication failed")
+		return
+	}
+	token := s.issueToken(in.Description, "", in.TTL)
+	writeJSON(w, map[string]string{"token": token.Token})
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Description string `json:"description"`
+		ClusterID   string `json:"clusterId"`
+		TTL         int64  `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, s.issueToken(in.Description, in.ClusterID, in.TTL))
+}
+
+func (s *Server) setting(w http.ResponseWriter, r *http.Request, name string) {
+	setting, ok := s.state.Settings[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "setting not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, setting)
+	case http.MethodPut:
+		var in struct {
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		setting.Value = in.Value
+		s.state.Settings[name] = setting
+		writeJSON(w, setting)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) setFeature(w http.ResponseWriter, r *http.Request, name string) {
+	var in struct {
+		Value *bool `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for i := range s.state.Features {
+		if s.state.Features[i].ID == name {
+			s.state.Features[i].Spec.Value = in.Value
+			writeJSON(w, s.state.Features[i])
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "feature not found")
+}
+
+func (s *Server) cluster(w http.ResponseWriter, r *http.Request, id string) {
+	for _, cluster := range s.state.Clusters {
+		if cluster.ID != id {
+			continue
+		}
+		switch {
+		case r.Method == http.MethodGet:
+			writeJSON(w, cluster)
+		case r.Method == http.MethodPost && r.URL.Query().Get("action") == "generateKubeconfig":
+			// Like Rancher, every kubeconfig carries a new cluster-scoped token.
+			token := s.issueToken("Kubeconfig token", id, 0)
+			config := s.state.Kubeconfigs[id] + fmt.Sprintf("users:\n- name: %s\n  user:\n    token: %s\n", id, token.Token)
+			writeJSON(w, map[string]string{"config": config})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+	writeError(w, http.StatusNotFound, "cluster not found")
+}
+
+func (s *Server) createMachineConfig(w http.ResponseWriter, r *http.Request, namespace string) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.nextID++
+	prefix := "nc-"
+	if metadata, ok := body["metadata"].(map[string]interface{}); ok {
+		if generateName, ok := metadata["generateName"].(string); ok && generateName != "" {
+			prefix = generateName
+		}
+	}
+	name := fmt.Sprintf("%s%05d", prefix, s.nextID)
+	s.state.MachineConfigs[namespace+"/"+name] = body
+	writeJSONStatus(w, http.StatusCreated, map[string]interface{}{
+		"id":       namespace + "/" + name,
+		"type":     body["type"],
+		"metadata": map[string]string{"name": name, "namespace": namespace},
+	})
+}
+
+func (s *Server) createCloudCredential(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.nextID++
+	id := fmt.Sprintf("cattle-global-data:cc-%05d", s.nextID)
+	s.state.CloudCredentials[id] = body
+	writeJSONStatus(w, http.StatusCreated, map[string]interface{}{"id": id, "name": body["name"]})
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	writeJSONStatus(w, http.StatusOK, value)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSONStatus(w, status, map[string]interface{}{"type": "error", "status": status, "message": message})
+}
+
+func copyMap[K comparable, V any](in map[K]V) map[K]V {
+	out := make(map[K]V, len(in))
+	for key, value := range in {
+		out[key] = value
+	}
+	return out
+}
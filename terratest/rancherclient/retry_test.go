@@ -0,0 +1,39 @@
+package rancherclient
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+)
+
+func TestRetryableOnlyRepeatsPostBeforeItIsSent(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	gateway := &APIError{StatusCode: http.StatusBadGateway}
+
+	tests := []struct {
+		method string
+		err    error
+		want   bool
+	}{
+		{method: http.MethodGet, err: gateway, want: true},
+		{method: http.MethodPut, err: &APIError{StatusCode: http.StatusInternalServerError}, want: true},
+		{method: http.MethodDelete, err: readErr, want: true},
+		{method: http.MethodGet, err: io.ErrUnexpectedEOF, want: true},
+		{method: http.MethodGet, err: &APIError{StatusCode: http.StatusConflict}, want: false},
+		{method: http.MethodPost, err: dialErr, want: true},
+		{method: http.MethodPost, err: readErr, want: false},
+		{method: http.MethodPost, err: gateway, want: false},
+		{method: http.MethodPost, err: &APIError{StatusCode: http.StatusTooManyRequests}, want: false},
+		{method: http.MethodPost, err: io.EOF, want: false},
+		{method: http.MethodPost, err: errors.New("boom"), want: false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.method, tt.err); got != tt.want {
+			t.Errorf("retryable(%s, %v) = %v, want %v", tt.method, tt.err, got, tt.want)
+		}
+	}
+}
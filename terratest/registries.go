@@ -0,0 +1,329 @@
+package test
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	rke2RegistriesPath   = "/etc/rancher/rke2/registries.yaml"
+	rke2RegistryCADir    = "/etc/rancher/rke2/registry-ca"
+	dockerHubRegistry    = "docker.io"
+	dockerHubRegistryAPI = "registry-1.docker.io"
+)
+
+// registriesSettings is the tool-config.yml "registries" section. Credentials
+// are referenced by environment variable name so secrets never live in the file.
+// Rewrites are a list rather than a map because viper lowercases map keys,
+// which would change the meaning of case-sensitive patterns.
+type registriesSettings struct {
+	SystemDefaultRegistry string                            `mapstructure:"system_default_registry"`
+	Mirrors               map[string]registryMirrorSettings `mapstructure:"mirrors"`
+	Configs               map[string]registryConfigSettings `mapstructure:"configs"`
+}
+
+type registryMirrorSettings struct {
+	Endpoints []string                  `mapstructure:"endpoints"`
+	Rewrites  []registryRewriteSettings `mapstructure:"rewrites"`
+}
+
+type registryRewriteSettings struct {
+	Pattern     string `mapstructure:"pattern"`
+	Replacement string `mapstructure:"replacement"`
+}
+
+type registryConfigSettings struct {
+	UsernameEnv        string `mapstructure:"username_env"`
+	PasswordEnv        string `mapstructure:"password_env"`
+	IdentityTokenEnv   string `mapstructure:"identity_token_env"`
+	CAFile             string `mapstructure:"ca_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+type rke2RegistriesFile struct {
+	Mirrors map[string]rke2RegistryMirror `yaml:"mirrors,omitempty"`
+	Configs map[string]rke2RegistryConfig `yaml:"configs,omitempty"`
+}
+
+type rke2RegistryMirror struct {
+	Endpoint []string          `yaml:"endpoint,omitempty"`
+	Rewrite  map[string]string `yaml:"rewrite,omitempty"`
+}
+
+type rke2RegistryConfig struct {
+	Auth *rke2RegistryAuth `yaml:"auth,omitempty"`
+	TLS  *rke2RegistryTLS  `yaml:"tls,omitempty"`
+}
+
+type rke2RegistryAuth struct {
+	Username      string `yaml:"username,omitempty"`
+	Password      string `yaml:"password,omitempty"`
+	Auth          string `yaml:"auth,omitempty"`
+	IdentityToken string `yaml:"identity_token,omitempty"`
+}
+
+type rke2RegistryTLS struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// rke2RegistryFiles holds the rendered registries.yaml plus any CA bundles it
+// references, keyed by their path on the node.
+type rke2RegistryFiles struct {
+	RegistriesYAML string
+	CABundles      map[string]string
+}
+
+var registryHostPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?(:[0-9]+)?$`)
+
+func configuredRegistries() (registriesSettings, error) {
+	var settings registriesSettings
+	if err := viper.UnmarshalKey("registries", &settings); err != nil {
+		return registriesSettings{}, fmt.Errorf("failed to parse registries config: %w", err)
+	}
+	settings.SystemDefaultRegistry = strings.TrimSpace(settings.SystemDefaultRegistry)
+	return settings, nil
+}
+
+func configuredRegistryEnvVars() []string {
+	settings, err := configuredRegistries()
+	if err != nil {
+		return nil
+	}
+
+	var envVars []string
+	for _, host := range slices.Sorted(maps.Keys(settings.Configs)) {
+		config := settings.Configs[host]
+		for _, envVar := range []string{config.UsernameEnv, config.PasswordEnv, config.IdentityTokenEnv} {
+			if envVar = strings.TrimSpace(envVar); envVar != "" && !slices.Contains(envVars, envVar) {
+				envVars = append(envVars, envVar)
+			}
+		}
+	}
+	return envVars
+}
+
+func validateRegistriesPreflight() error {
+	settings, err := configuredRegistries()
+	if err != nil {
+		return err
+	}
+	if settings.SystemDefaultRegistry != "" && !registryHostPattern.MatchString(settings.SystemDefaultRegistry) {
+		return fmt.Errorf("registries.system_default_registry must be a registry host such as stgregistry.suse.com, got %q", settings.SystemDefaultRegistry)
+	}
+	if _, err := buildRKE2RegistryFiles(settings); err != nil {
+		return err
+	}
+	return nil
+}
+
+func renderConfiguredRKE2RegistryFiles(haOutputs TerraformOutputs) (rke2RegistryFiles, error) {
+	settings, err := configuredRegistries()
+	if err != nil {
+		return rke2RegistryFiles{}, err
+	}
+	if airgapEnabled() {
+		addAirgapRegistryMirrors(&settings, airgapRegistryHost(haOutputs))
+	}
+	return buildRKE2RegistryFiles(settings)
+}
+
+func buildRKE2RegistryFiles(settings registriesSettings) (rke2RegistryFiles, error) {
+	file := rke2RegistriesFile{
+		Mirrors: map[string]rke2RegistryMirror{},
+		Configs: map[string]rke2RegistryConfig{},
+	}
+	files := rke2RegistryFiles{CABundles: map[string]string{}}
+
+	for _, host := range slices.Sorted(maps.Keys(settings.Mirrors)) {
+		mirror, err := buildRKE2RegistryMirror(host, settings.Mirrors[host])
+		if err != nil {
+			return rke2RegistryFiles{}, err
+		}
+		file.Mirrors[host] = mirror
+	}
+
+	for _, host := range slices.Sorted(maps.Keys(settings.Configs)) {
+		config, caBundle, err := buildRKE2RegistryConfig(host, settings.Configs[host])
+		if err != nil {
+			return rke2RegistryFiles{}, err
+		}
+		if caBundle != "" {
+			files.CABundles[config.TLS.CAFile] = caBundle
+		}
+		file.Configs[host] = config
+	}
+
+	addDockerHubEnvironmentAuth(file.Configs)
+
+	if len(file.Mirrors) == 0 && len(file.Configs) == 0 {
+		return files, nil
+	}
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		return rke2RegistryFiles{}, fmt.Errorf("failed to render registries.yaml: %w", err)
+	}
+	files.RegistriesYAML = string(data)
+	return files, nil
+}
+
+func buildRKE2RegistryMirror(host string, settings registryMirrorSettings) (rke2RegistryMirror, error) {
+	if host != "*" && !registryHostPattern.MatchString(host) {
+		return rke2RegistryMirror{}, fmt.Errorf("registries.mirrors key %q must be a registry host or \"*\"", host)
+	}
+
+	mirror := rke2RegistryMirror{}
+	for _, endpoint := range settings.Endpoints {
+		endpoint = strings.TrimSpace(endpoint)
+		parsed, err := url.Parse(endpoint)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return rke2RegistryMirror{}, fmt.Errorf("registries.mirrors.%s endpoint %q must be an http or https URL", host, endpoint)
+		}
+		mirror.Endpoint = append(mirror.Endpoint, endpoint)
+	}
+
+	for i, rewrite := range settings.Rewrites {
+		if strings.TrimSpace(rewrite.Pattern) == "" {
+			return rke2RegistryMirror{}, fmt.Errorf("registries.mirrors.%s rewrites[%d] is missing a pattern", host, i)
+		}
+		if _, err := regexp.Compile(rewrite.Pattern); err != nil {
+			return rke2RegistryMirror{}, fmt.Errorf("registries.mirrors.%s rewrites[%d] pattern is not a valid regular expression: %w", host, i, err)
+		}
+		if mirror.Rewrite == nil {
+			mirror.Rewrite = map[string]string{}
+		}
+		if _, exists := mirror.Rewrite[rewrite.Pattern]; exists {
+			return rke2RegistryMirror{}, fmt.Errorf("registries.mirrors.%s has duplicate rewrite pattern %q", host, rewrite.Pattern)
+		}
+		mirror.Rewrite[rewrite.Pattern] = rewrite.Replacement
+	}
+
+	if len(mirror.Endpoint) == 0 && len(mirror.Rewrite) == 0 {
+		return rke2RegistryMirror{}, fmt.Errorf("registries.mirrors.%s needs at least one endpoint or rewrite", host)
+	}
+	return mirror, nil
+}
+
+func buildRKE2RegistryConfig(host string, settings registryConfigSettings) (rke2RegistryConfig, string, error) {
+	if !registryHostPattern.MatchString(host) {
+		return rke2RegistryConfig{}, "", fmt.Errorf("registries.configs key %q must be a registry host", host)
+	}
+
+	config := rke2RegistryConfig{}
+	username, err := registryEnvValue(host, "username_env", settings.UsernameEnv)
+	if err != nil {
+		return rke2RegistryConfig{}, "", err
+	}
+	password, err := registryEnvValue(host, "password_env", settings.PasswordEnv)
+	if err != nil {
+		return rke2RegistryConfig{}, "", err
+	}
+	identityToken, err := registryEnvValue(host, "identity_token_env", settings.IdentityTokenEnv)
+	if err != nil {
+		return rke2RegistryConfig{}, "", err
+	}
+	if (username == "") != (password == "") {
+		return rke2RegistryConfig{}, "", fmt.Errorf("registries.configs.%s must set both username_env and password_env, or neither", host)
+	}
+	if username != "" || identityToken != "" {
+		config.Auth = &rke2RegistryAuth{Username: username, Password: password, IdentityToken: identityToken}
+	}
+
+	var caBundle string
+	if caFile := strings.TrimSpace(settings.CAFile); caFile != "" {
+		caBundle, err = readRegistryCABundle(host, caFile)
+		if err != nil {
+			return rke2RegistryConfig{}, "", err
+		}
+		config.TLS = &rke2RegistryTLS{CAFile: registryCANodePath(host)}
+	}
+	if settings.InsecureSkipVerify {
+		if config.TLS == nil {
+			config.TLS = &rke2RegistryTLS{}
+		}
+		config.TLS.InsecureSkipVerify = true
+	}
+
+	if config.Auth == nil && config.TLS == nil {
+		return rke2RegistryConfig{}, "", fmt.Errorf("registries.configs.%s needs credentials, a ca_file, or insecure_skip_verify", host)
+	}
+	return config, caBundle, nil
+}
+
+func registryEnvValue(host, field, envVar string) (string, error) {
+	envVar = strings.TrimSpace(envVar)
+	if envVar == "" {
+		return "", nil
+	}
+	value := strings.TrimSpace(os.Getenv(envVar))
+	if value == "" {
+		return "", fmt.Errorf("registries.configs.%s.%s names %s, but it is not set in the environment", host, field, envVar)
+	}
+	return value, nil
+}
+
+func readRegistryCABundle(host, caFile string) (string, error) {
+	caPath, err := settings.ExpandHomePath(caFile)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(caPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read registries.configs.%s.ca_file: %w", host, err)
+	}
+	if block, _ := pem.Decode(data); block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("registries.configs.%s.ca_file %s does not contain a PEM certificate", host, caPath)
+	}
+	return string(data), nil
+}
+
+func registryCANodePath(host string) string {
+	return path.Join(rke2RegistryCADir, strings.ReplaceAll(host, ":", "_")+".pem")
+}
+
+// DOCKERHUB_USERNAME/DOCKERHUB_PASSWORD predate the registries section and
+// still authenticate Docker Hub unless the section configures it explicitly.
+func addDockerHubEnvironmentAuth(configs map[string]rke2RegistryConfig) {
+	dockerUsername := strings.TrimSpace(os.Getenv("DOCKERHUB_USERNAME"))
+	dockerPassword := strings.TrimSpace(os.Getenv("DOCKERHUB_PASSWORD"))
+	if dockerUsername == "" || dockerPassword == "" {
+		return
+	}
+
+	encodedAuth := base64.StdEncoding.EncodeToString([]byte(dockerUsername + ":" + dockerPassword))
+	for _, host := range []string{dockerHubRegistryAPI, dockerHubRegistry} {
+		config := configs[host]
+		if config.Auth != nil {
+			continue
+		}
+		config.Auth = &rke2RegistryAuth{Auth: encodedAuth}
+		configs[host] = config
+	}
+}
+
+func configureRKE2Registries(ip string, files rke2RegistryFiles) error {
+	if files.RegistriesYAML == "" {
+		return nil
+	}
+	for _, caPath := range slices.Sorted(maps.Keys(files.CABundles)) {
+		if _, err := RunCommand(writeRemoteFileCommand(caPath, files.CABundles[caPath], "0644"), ip); err != nil {
+			return fmt.Errorf("failed to write registry CA bundle %s: %w", caPath, err)
+		}
+	}
+	if _, err := RunCommand(writeRemoteFileCommand(rke2RegistriesPath, files.RegistriesYAML, "0600"), ip); err != nil {
+		return fmt.Errorf("failed to create registries.yaml: %w", err)
+	}
+	return nil
+}
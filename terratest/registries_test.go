@@ -0,0 +1,174 @@
+package test
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+func clearDockerHubEnvironment(t *testing.T) {
+	t.Helper()
+	t.Setenv("DOCKERHUB_USERNAME", "")
+	t.Setenv("DOCKERHUB_PASSWORD", "")
+}
+
+func writeTestRegistryCA(t *testing.T) string {
+	t.Helper()
+	caPath := filepath.Join(t.TempDir(), "stg-ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("test-ca")})
+	if err := os.WriteFile(caPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return caPath
+}
+
+func TestRenderConfiguredRKE2RegistryFilesRendersFullSchema(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	clearDockerHubEnvironment(t)
+	t.Setenv("STG_REGISTRY_USERNAME", "qa-bot")
+	t.Setenv("STG_REGISTRY_PASSWORD", "s3cret")
+	caPath := writeTestRegistryCA(t)
+
+	viper.SetConfigType("yaml")
+	config := `
+registries:
+  mirrors:
+    docker.io:
+      endpoints:
+        - "https://mirror.example.com"
+      rewrites:
+        - pattern: "^Rancher/(.*)"
+          replacement: "mirror/rancher/$1"
+  configs:
+    stgregistry.suse.com:
+      username_env: STG_REGISTRY_USERNAME
+      password_env: STG_REGISTRY_PASSWORD
+      ca_file: "` + caPath + `"
+`
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := renderConfiguredRKE2RegistryFiles(TerraformOutputs{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var rendered rke2RegistriesFile
+	if err := yaml.Unmarshal([]byte(files.RegistriesYAML), &rendered); err != nil {
+		t.Fatalf("registries.yaml is not valid YAML: %v\n%s", err, files.RegistriesYAML)
+	}
+	expected := rke2RegistriesFile{
+		Mirrors: map[string]rke2RegistryMirror{
+			"docker.io": {
+				Endpoint: []string{"https://mirror.example.com"},
+				Rewrite:  map[string]string{"^Rancher/(.*)": "mirror/rancher/$1"},
+			},
+		},
+		Configs: map[string]rke2RegistryConfig{
+			"stgregistry.suse.com": {
+				Auth: &rke2RegistryAuth{Username: "qa-bot", Password: "s3cret"},
+				TLS:  &rke2RegistryTLS{CAFile: "/etc/rancher/rke2/registry-ca/stgregistry.suse.com.pem"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(rendered, expected) {
+		t.Fatalf("unexpected registries.yaml:\n%s", files.RegistriesYAML)
+	}
+	if !strings.Contains(files.CABundles["/etc/rancher/rke2/registry-ca/stgregistry.suse.com.pem"], "BEGIN CERTIFICATE") {
+		t.Fatalf("expected CA bundle to be uploaded, got %#v", files.CABundles)
+	}
+}
+
+func TestRenderConfiguredRKE2RegistryFilesKeepsDockerHubEnvironmentAuth(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Setenv("DOCKERHUB_USERNAME", "user")
+	t.Setenv("DOCKERHUB_PASSWORD", "pass")
+
+	files, err := renderConfiguredRKE2RegistryFiles(TerraformOutputs{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, host := range []string{"docker.io", "registry-1.docker.io"} {
+		if !strings.Contains(files.RegistriesYAML, host+":\n        auth:\n            auth: dXNlcjpwYXNz") {
+			t.Fatalf("expected Docker Hub auth for %s, got:\n%s", host, files.RegistriesYAML)
+		}
+	}
+}
+
+func TestRenderConfiguredRKE2RegistryFilesSkipsEmptyConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	clearDockerHubEnvironment(t)
+
+	files, err := renderConfiguredRKE2RegistryFiles(TerraformOutputs{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files.RegistriesYAML != "" {
+		t.Fatalf("expected no registries.yaml, got:\n%s", files.RegistriesYAML)
+	}
+}
+
+func TestValidateRegistriesPreflightRejectsInvalidConfig(t *testing.T) {
+	clearDockerHubEnvironment(t)
+	t.Setenv("STG_REGISTRY_USERNAME", "qa-bot")
+	t.Setenv("STG_REGISTRY_PASSWORD", "")
+
+	cases := map[string]map[string]interface{}{
+		"STG_REGISTRY_PASSWORD, but it is not set": {
+			"configs": map[string]interface{}{
+				"stgregistry.suse.com": map[string]interface{}{"username_env": "STG_REGISTRY_USERNAME", "password_env": "STG_REGISTRY_PASSWORD"},
+			},
+		},
+		"both username_env and password_env": {
+			"configs": map[string]interface{}{
+				"stgregistry.suse.com": map[string]interface{}{"username_env": "STG_REGISTRY_USERNAME"},
+			},
+		},
+		"must be an http or https URL": {
+			"mirrors": map[string]interface{}{
+				"docker.io": map[string]interface{}{"endpoints": []interface{}{"mirror.example.com"}},
+			},
+		},
+		"not a valid regular expression": {
+			"mirrors": map[string]interface{}{
+				"docker.io": map[string]interface{}{"rewrites": []interface{}{map[string]interface{}{"pattern": "^(rancher", "replacement": "x"}}},
+			},
+		},
+		"must be a registry host": {
+			"system_default_registry": "https://registry.example.com",
+		},
+	}
+
+	for expected, registries := range cases {
+		viper.Reset()
+		viper.Set("registries", registries)
+		if err := validateRegistriesPreflight(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected error containing %q, got %v", expected, err)
+		}
+	}
+	viper.Reset()
+}
+
+func TestConfiguredRegistryEnvVarsListsReferencedNames(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("registries.configs", map[string]interface{}{
+		"b.example.com": map[string]interface{}{"username_env": "SHARED_USER", "password_env": "B_PASS"},
+		"a.example.com": map[string]interface{}{"username_env": "SHARED_USER", "password_env": "A_PASS"},
+	})
+
+	expected := []string{"SHARED_USER", "A_PASS", "B_PASS"}
+	if got := configuredRegistryEnvVars(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,164 @@
+package resolver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	resolverFixtureModeRecord = "record"
+	resolverFixtureModeReplay = "replay"
+
+	resolverFixtureIndexFile = "fixtures.json"
+
+	resolverFixtureHelm      = "helm"
+	resolverFixtureHTTP      = "http"
+	resolverFixtureRegistry  = "registry"
+	resolverFixtureInstaller = "installer-sha256"
+)
+
+// resolverFixtures is set while the resolver records or replays every external
+// lookup it makes: Helm index queries, support matrix and release note pages,
+// registry manifest probes, and RKE2 installer hashes. It is nil for live runs.
+var resolverFixtures *resolverFixtureStore
+
+type resolverFixtureStore struct {
+	mode  string
+	dir   string
+	mu    sync.Mutex
+	index resolverFixtureIndex
+}
+
+// resolverFixtureIndex maps each lookup kind and key to the file holding its
+// recorded response, relative to the fixture directory.
+type resolverFixtureIndex struct {
+	Entries map[string]map[string]string `json:"entries"`
+}
+
+func openResolverFixtures(mode, dir string) (*resolverFixtureStore, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode != resolverFixtureModeRecord && mode != resolverFixtureModeReplay {
+		return nil, fmt.Errorf("resolver fixture mode must be %s or %s; got %q", resolverFixtureModeRecord, resolverFixtureModeReplay, mode)
+	}
+	if strings.TrimSpace(dir) == "" {
+		return nil, fmt.Errorf("resolver fixture directory must be set")
+	}
+
+	store := &resolverFixtureStore{mode: mode, dir: dir, index: resolverFixtureIndex{Entries: map[string]map[string]string{}}}
+	data, err := os.ReadFile(filepath.Join(dir, resolverFixtureIndexFile))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &store.index); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(dir, resolverFixtureIndexFile), err)
+		}
+		if store.index.Entries == nil {
+			store.index.Entries = map[string]map[string]string{}
+		}
+	case errors.Is(err, os.ErrNotExist) && mode == resolverFixtureModeRecord:
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create fixture directory %s: %w", dir, err)
+		}
+	default:
+		return nil, fmt.Errorf("failed to read resolver fixtures in %s: %w", dir, err)
+	}
+	return store, nil
+}
+
+// resolverLookup returns the recorded response for kind/key when replaying,
+// otherwise it runs live and records the result when recording. Failed live
+// lookups are never recorded so a replay fails the same way a live run would
+// on the next attempt.
+func resolverLookup(kind, key string, live func() ([]byte, error)) ([]byte, error) {
+	store := resolverFixtures
+	if store == nil {
+		return live()
+	}
+	if store.mode == resolverFixtureModeReplay {
+		return store.load(kind, key)
+	}
+
+	data, err := live()
+	if err != nil {
+		return data, err
+	}
+	if saveErr := store.save(kind, key, data); saveErr != nil {
+		return nil, saveErr
+	}
+	return data, nil
+}
+
+func (s *resolverFixtureStore) load(kind, key string) ([]byte, error) {
+	s.mu.Lock()
+	name := s.index.Entries[kind][key]
+	s.mu.Unlock()
+	if name == "" {
+		return nil, fmt.Errorf("resolver fixtures in %s have no %s entry for %q; re-record them", s.dir, kind, key)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s fixture for %q: %w", kind, key, err)
+	}
+	return data, nil
+}
+
+func (s *resolverFixtureStore) save(kind, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(kind + "\x00" + key))
+	name := filepath.ToSlash(filepath.Join(kind, hex.EncodeToString(sum[:8])))
+	path := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to record %s fixture for %q: %w", kind, key, err)
+	}
+
+	if s.index.Entries[kind] == nil {
+		s.index.Entries[kind] = map[string]string{}
+	}
+	s.index.Entries[kind][key] = name
+	return s.writeIndex()
+}
+
+func (s *resolverFixtureStore) writeIndex() error {
+	data, err := json.MarshalIndent(s.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, resolverFixtureIndexFile), append(data, '\n'), 0o644)
+}
+
+func (s *resolverFixtureStore) keys(kind string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.index.Entries[kind]))
+	for key := range s.index.Entries[kind] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func resolverFixturesReplaying() bool {
+	return resolverFixtures != nil && resolverFixtures.mode == resolverFixtureModeReplay
+}
+
+func logResolverFixtureSummary() {
+	if resolverFixtures == nil {
+		return
+	}
+	for _, kind := range []string{resolverFixtureHelm, resolverFixtureHTTP, resolverFixtureRegistry, resolverFixtureInstaller} {
+		log.Printf("[resolver] %s fixtures (%s): %d entries", kind, resolverFixtures.mode, len(resolverFixtures.keys(kind)))
+	}
+}
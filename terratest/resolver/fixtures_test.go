@@ -0,0 +1,112 @@
+package resolver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestResolveRancherPlansReplaysFixtures(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	plans, err := ResolveRancherPlans(RancherResolveOptions{
+		Versions:    []string{"v2.13.4", "2.14.1-alpha3"},
+		Distro:      "auto",
+		FixtureMode: resolverFixtureModeReplay,
+		FixtureDir:  "testdata/resolver-fixtures",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plans) != 2 {
+		t.Fatalf("expected 2 plans, got %d", len(plans))
+	}
+	if resolverFixtures != nil {
+		t.Fatal("expected fixtures to be cleared after the run")
+	}
+
+	released := plans[0]
+	if released.ChartRepoAlias != "rancher-prime" || released.ChartVersion != "2.13.4" || released.RancherImage != "registry.rancher.com/rancher/rancher" {
+		t.Fatalf("unexpected released plan: %+v", released)
+	}
+	if released.UseRancherImageFields {
+		t.Fatalf("expected legacy rancherImage values for 2.13.4: %+v", released)
+	}
+
+	alpha := plans[1]
+	if alpha.ChartRepoAlias != "optimus-rancher-alpha" || alpha.CompatibilityBaseline != "2.13.4" {
+		t.Fatalf("unexpected alpha chart resolution: %+v", alpha)
+	}
+	if alpha.RancherImageTag != "v2.14.1-alpha3" || alpha.AgentImage != "stgregistry.suse.com/rancher/rancher-agent:v2.14.1-alpha3" || !alpha.UseRancherImageFields {
+		t.Fatalf("unexpected alpha images: %+v", alpha)
+	}
+
+	for _, plan := range plans {
+		if plan.RecommendedRKE2Version != "v1.34.6+rke2r1" {
+			t.Fatalf("expected v1.34.6+rke2r1, got %s", plan.RecommendedRKE2Version)
+		}
+		if plan.InstallerSHA256 != "2fd1c3a7bb0cdbf47fd00bcc6d7f3c6c8e2b6e36ad4d4bdb1e1a1b4a8d0e9f51" {
+			t.Fatalf("unexpected installer checksum %s", plan.InstallerSHA256)
+		}
+		if len(plan.HelmCommands) != 1 || !strings.Contains(plan.HelmCommands[0], "--version "+plan.ChartVersion) {
+			t.Fatalf("unexpected Helm commands %v", plan.HelmCommands)
+		}
+		explanation := strings.Join(plan.Explanation, "\n")
+		for _, snippet := range []string{
+			"The kdm support matrix certifies RKE2 from v1.32 through v1.34",
+			"Selected v1.34.6+rke2r1 from kdm",
+			"github reports v1.34.7+rke2r1",
+		} {
+			if !strings.Contains(explanation, snippet) {
+				t.Fatalf("expected %q in explanation:\n%s", snippet, explanation)
+			}
+		}
+	}
+}
+
+func TestResolveRancherPlansReplayFailsOnMissingFixture(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	_, err := ResolveRancherPlans(RancherResolveOptions{
+		Versions:    []string{"2.12.3"},
+		FixtureMode: resolverFixtureModeReplay,
+		FixtureDir:  "testdata/resolver-fixtures",
+	})
+	if err == nil || !strings.Contains(err.Error(), "re-record") {
+		t.Fatalf("expected a missing fixture error, got %v", err)
+	}
+}
+
+func TestResolverLookupRecordsOnlySuccessfulLookups(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openResolverFixtures(resolverFixtureModeRecord, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolverFixtures = store
+	t.Cleanup(func() { resolverFixtures = nil })
+
+	if _, err := resolverLookup(resolverFixtureHTTP, "https://example.com/ok", func() ([]byte, error) { return []byte("body"), nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolverLookup(resolverFixtureHTTP, "https://example.com/down", func() ([]byte, error) { return nil, errors.New("offline") }); err == nil {
+		t.Fatal("expected live error to be returned")
+	}
+
+	resolverFixtures, err = openResolverFixtures(resolverFixtureModeReplay, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := func() ([]byte, error) { t.Fatal("replay must not run live lookups"); return nil, nil }
+	data, err := resolverLookup(resolverFixtureHTTP, "https://example.com/ok", live)
+	if err != nil || string(data) != "body" {
+		t.Fatalf("expected recorded body, got %q (%v)", data, err)
+	}
+	if _, err := resolverLookup(resolverFixtureHTTP, "https://example.com/down", live); err == nil {
+		t.Fatal("expected failed lookup to be missing from fixtures")
+	}
+}
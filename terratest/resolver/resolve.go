@@ -0,0 +1,57 @@
+// Package resolver turns requested Rancher versions into install plans: the
+// chart source, image overrides, supported RKE2 release and Helm commands. It
+// reads the same tool-config keys as the lifecycle tests but has no AWS or
+// Kubernetes dependencies, so the resolve-rancher-plan CLI can use it alone.
+package resolver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// RancherResolveOptions drives a resolve-only run of the auto-mode resolver
+// without provisioning anything or reading tool-config.yml.
+type RancherResolveOptions struct {
+	Versions          []string
+	Distro            string
+	BootstrapPassword string
+	// FixtureMode is "", "record", or "replay". Record snapshots every
+	// external lookup into FixtureDir; replay serves them back offline.
+	FixtureMode string
+	FixtureDir  string
+}
+
+func ResolveRancherPlans(options RancherResolveOptions) ([]*RancherResolvedPlan, error) {
+	var versions []string
+	for _, version := range options.Versions {
+		if version = strings.TrimSpace(version); version != "" {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("at least one Rancher version is required")
+	}
+
+	bootstrapPassword := options.BootstrapPassword
+	if bootstrapPassword == "" {
+		bootstrapPassword = "resolve-only"
+	}
+	viper.Set("rancher.versions", versions)
+	viper.Set("rancher.distro", options.Distro)
+	viper.Set("rancher.bootstrap_password", bootstrapPassword)
+
+	if options.FixtureMode != "" {
+		store, err := openResolverFixtures(options.FixtureMode, options.FixtureDir)
+		if err != nil {
+			return nil, err
+		}
+		resolverFixtures = store
+		defer func() { resolverFixtures = nil }()
+	}
+
+	plans, err := ResolveAutoPlans(len(versions))
+	logResolverFixtureSummary()
+	return plans, err
+}
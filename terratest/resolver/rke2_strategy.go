@@ -0,0 +1,234 @@
+package resolver
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	goversion "github.com/hashicorp/go-version"
+	"github.com/spf13/viper"
+)
+
+const (
+	rke2StrategyLatest = "latest"
+	rke2StrategyOldest = "oldest"
+	rke2StrategyExact  = "exact"
+)
+
+var (
+	rke2LinePattern       = regexp.MustCompile(`^~?v?1\.(\d+)$`)
+	rke2TildePatchPattern = regexp.MustCompile(`^~v?1\.(\d+)\.(\d+)$`)
+)
+
+// rke2VersionSelection is how auto mode picks RKE2 for one HA. Version is an
+// RKE2 line or constraint for latest/oldest, and a full release for exact.
+type rke2VersionSelection struct {
+	Strategy         string
+	Version          string
+	AllowUnsupported bool
+}
+
+func configuredRKE2VersionSelection(instanceNum int) (rke2VersionSelection, error) {
+	selection := rke2VersionSelection{
+		Strategy:         strings.ToLower(strings.TrimSpace(viper.GetString("rke2.strategy"))),
+		Version:          strings.TrimSpace(viper.GetString("rke2.version")),
+		AllowUnsupported: viper.GetBool("rke2.allow_unsupported"),
+	}
+
+	for haKey, value := range viper.GetStringMap("rke2.ha_strategy") {
+		haIndex, err := strconv.Atoi(haKey)
+		if err != nil || haIndex < 1 {
+			return selection, fmt.Errorf("rke2.ha_strategy keys must be HA numbers starting at 1, got %q", haKey)
+		}
+		if haIndex != instanceNum {
+			continue
+		}
+		override, ok := value.(map[string]interface{})
+		if !ok {
+			return selection, fmt.Errorf("rke2.ha_strategy.%s must be a map with strategy, version, or allow_unsupported", haKey)
+		}
+		for key, raw := range override {
+			switch key {
+			case "strategy":
+				selection.Strategy = strings.ToLower(strings.TrimSpace(fmt.Sprint(raw)))
+			case "version":
+				selection.Version = strings.TrimSpace(fmt.Sprint(raw))
+			case "allow_unsupported":
+				allow, ok := raw.(bool)
+				if !ok {
+					return selection, fmt.Errorf("rke2.ha_strategy.%s.allow_unsupported must be true or false", haKey)
+				}
+				selection.AllowUnsupported = allow
+			default:
+				return selection, fmt.Errorf("rke2.ha_strategy.%s.%s is not supported; use strategy, version, or allow_unsupported", haKey, key)
+			}
+		}
+	}
+
+	if selection.Strategy == "" {
+		selection.Strategy = rke2StrategyLatest
+	}
+	return selection, selection.validate()
+}
+
+func (s rke2VersionSelection) validate() error {
+	switch s.Strategy {
+	case rke2StrategyLatest, rke2StrategyOldest:
+		if s.Version == "" {
+			return nil
+		}
+		if _, _, err := parseRKE2VersionConstraint(s.Version); err != nil {
+			return err
+		}
+		return nil
+	case rke2StrategyExact:
+		if !rke2PatchPattern.MatchString(s.Version) {
+			return fmt.Errorf("rke2.strategy=exact needs rke2.version set to a full RKE2 release like v1.32.13+rke2r1, got %q", s.Version)
+		}
+		return nil
+	default:
+		return fmt.Errorf("rke2.strategy must be latest, oldest, or exact, got %q", s.Strategy)
+	}
+}
+
+func validateRKE2StrategyConfig(totalHAs int) error {
+	for i := 1; i <= totalHAs; i++ {
+		if _, err := configuredRKE2VersionSelection(i); err != nil {
+			return fmt.Errorf("HA %d: %w", i, err)
+		}
+	}
+	for haKey := range viper.GetStringMap("rke2.ha_strategy") {
+		if haIndex, err := strconv.Atoi(haKey); err == nil && haIndex > totalHAs {
+			return fmt.Errorf("rke2.ha_strategy.%s targets an HA that does not exist; total_has is %d", haKey, totalHAs)
+		}
+	}
+	return nil
+}
+
+// parseRKE2VersionConstraint accepts a single line such as ~1.32 or v1.32, a
+// patch floor within one line such as ~1.32.4, or a go-version constraint such
+// as ">= 1.32.5, < 1.34" matched against each published release. The returned
+// line is set only for the single-line forms.
+func parseRKE2VersionConstraint(value string) (goversion.Constraints, int, error) {
+	value = strings.TrimSpace(value)
+	if match := rke2LinePattern.FindStringSubmatch(value); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		constraints, err := goversion.NewConstraint(fmt.Sprintf("~> 1.%d.0", line))
+		return constraints, line, err
+	}
+	if match := rke2TildePatchPattern.FindStringSubmatch(value); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		constraints, err := goversion.NewConstraint(fmt.Sprintf("~> 1.%d.%s", line, match[2]))
+		return constraints, line, err
+	}
+	constraints, err := goversion.NewConstraint(value)
+	if err != nil {
+		return nil, 0, fmt.Errorf("rke2.version %q must be an RKE2 line like ~1.32, a patch floor like ~1.32.4, or a constraint like \">= 1.32.5, < 1.34\"", value)
+	}
+	return constraints, 0, nil
+}
+
+// candidateLines returns the RKE2 lines the selection may resolve to, in the
+// order they should be tried. Lines outside the supported range are refused
+// unless AllowUnsupported is set, and then only when the selection names one
+// line or release explicitly.
+func (s rke2VersionSelection) candidateLines(rancherVersion string, lowest, highest int) ([]int, error) {
+	supported := fmt.Sprintf("v1.%d through v1.%d", lowest, highest)
+
+	if s.Strategy == rke2StrategyExact {
+		minor, _ := parseRKE2MinorLine(s.Version)
+		if (minor < lowest || minor > highest) && !s.AllowUnsupported {
+			return nil, fmt.Errorf("RKE2 %s is outside the lines supported by Rancher %s (%s); set rke2.allow_unsupported: true to use it anyway", s.Version, rancherVersion, supported)
+		}
+		return []int{minor}, nil
+	}
+
+	if s.Version != "" {
+		_, line, err := parseRKE2VersionConstraint(s.Version)
+		if err != nil {
+			return nil, err
+		}
+		if line != 0 {
+			if (line < lowest || line > highest) && !s.AllowUnsupported {
+				return nil, fmt.Errorf("rke2.version %q matches none of the RKE2 lines supported by Rancher %s (%s); set rke2.allow_unsupported: true to use it anyway", s.Version, rancherVersion, supported)
+			}
+			return []int{line}, nil
+		}
+	}
+
+	lines := make([]int, 0, highest-lowest+1)
+	for minor := lowest; minor <= highest; minor++ {
+		lines = append(lines, minor)
+	}
+	if s.Strategy != rke2StrategyOldest {
+		slices.Reverse(lines)
+	}
+	return lines, nil
+}
+
+// latestMatchingPatch returns the newest release in the line that satisfies
+// the selection, or "" when none does. An exact selection only matches itself.
+func (s rke2VersionSelection) latestMatchingPatch(patches []string, minor int) string {
+	var constraints goversion.Constraints
+	if s.Strategy != rke2StrategyExact && s.Version != "" {
+		constraints, _, _ = parseRKE2VersionConstraint(s.Version)
+	}
+
+	var matching []string
+	for _, patch := range patches {
+		patch = strings.TrimSpace(patch)
+		switch {
+		case s.Strategy == rke2StrategyExact:
+			if patch != s.Version {
+				continue
+			}
+		case constraints != nil:
+			parsed, err := goversion.NewVersion(strings.TrimPrefix(patch, "v"))
+			if err != nil || !constraints.Check(parsed) {
+				continue
+			}
+		}
+		matching = append(matching, patch)
+	}
+	latest, err := latestRKE2PatchInMinor(matching, minor)
+	if err != nil {
+		return ""
+	}
+	return latest
+}
+
+func (s rke2VersionSelection) describe(rancherVersion string, minor, lowest, highest int) string {
+	supported := fmt.Sprintf("v1.%d through v1.%d", lowest, highest)
+	unsupported := minor < lowest || minor > highest
+	switch {
+	case s.Strategy == rke2StrategyExact && unsupported:
+		return fmt.Sprintf("Using unsupported RKE2 %s for Rancher %s (supported: %s) because allow_unsupported is set", s.Version, rancherVersion, supported)
+	case s.Strategy == rke2StrategyExact:
+		return fmt.Sprintf("Using exact RKE2 %s from rke2.strategy=exact; v1.%d is supported by Rancher %s", s.Version, minor, rancherVersion)
+	case unsupported:
+		return fmt.Sprintf("Using unsupported RKE2 line v1.%d for Rancher %s (supported: %s) because allow_unsupported is set", minor, rancherVersion, supported)
+	}
+	note := fmt.Sprintf("Using the %s supported RKE2 line v1.%d from rke2.strategy=%s", s.Strategy, minor, s.Strategy)
+	if s.Version != "" {
+		note += fmt.Sprintf(" and rke2.version %q", s.Version)
+	}
+	return note
+}
+
+// noReleaseError explains why none of the candidate lines had a release the
+// selection accepts.
+func (s rke2VersionSelection) noReleaseError(rancherVersion string, lowest, highest int) error {
+	supported := fmt.Sprintf("v1.%d through v1.%d", lowest, highest)
+	if s.Strategy == rke2StrategyExact {
+		return fmt.Errorf("RKE2 %s is not a published release according to any RKE2 release source; check rke2.version", s.Version)
+	}
+	if _, line, _ := parseRKE2VersionConstraint(s.Version); line != 0 {
+		return fmt.Errorf("rke2.version %q matches no published RKE2 release in the v1.%d line", s.Version, line)
+	}
+	if s.AllowUnsupported {
+		return fmt.Errorf("rke2.version %q matches no published RKE2 release supported by Rancher %s (%s); allow_unsupported needs a single line like ~1.31", s.Version, rancherVersion, supported)
+	}
+	return fmt.Errorf("rke2.version %q matches no published RKE2 release supported by Rancher %s (%s)", s.Version, rancherVersion, supported)
+}
@@ -0,0 +1,137 @@
+package resolver
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRKE2VersionSelectionCandidateLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection rke2VersionSelection
+		want      []int
+		wantErr   string
+	}{
+		{name: "latest", selection: rke2VersionSelection{Strategy: rke2StrategyLatest}, want: []int{34, 33, 32}},
+		{name: "oldest", selection: rke2VersionSelection{Strategy: rke2StrategyOldest}, want: []int{32, 33, 34}},
+		{name: "pinned line", selection: rke2VersionSelection{Strategy: rke2StrategyLatest, Version: "~1.33"}, want: []int{33}},
+		{name: "patch floor", selection: rke2VersionSelection{Strategy: rke2StrategyLatest, Version: "~1.32.4"}, want: []int{32}},
+		{name: "constraint", selection: rke2VersionSelection{Strategy: rke2StrategyOldest, Version: ">= 1.33"}, want: []int{32, 33, 34}},
+		{name: "exact", selection: rke2VersionSelection{Strategy: rke2StrategyExact, Version: "v1.32.13+rke2r1"}, want: []int{32}},
+		{name: "unsupported line", selection: rke2VersionSelection{Strategy: rke2StrategyLatest, Version: "~1.35"}, wantErr: "allow_unsupported: true"},
+		{name: "unsupported exact", selection: rke2VersionSelection{Strategy: rke2StrategyExact, Version: "v1.31.9+rke2r1"}, wantErr: "outside the lines supported"},
+		{name: "allowed line", selection: rke2VersionSelection{Strategy: rke2StrategyLatest, Version: "~1.35", AllowUnsupported: true}, want: []int{35}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := tt.selection.candidateLines("2.13.4", 32, 34)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(lines, tt.want) {
+				t.Fatalf("expected lines %v, got %v", tt.want, lines)
+			}
+		})
+	}
+}
+
+func TestResolveRKE2SupportAppliesStrategy(t *testing.T) {
+	providers := []SupportMatrixProvider{
+		fakeSupportMatrixProvider{name: "kdm", lowest: 32, highest: 34, patches: []string{"v1.32.13+rke2r1", "v1.35.1+rke2r1"}},
+	}
+
+	resolution, err := resolveRKE2Support(providers, "2.13.4", rke2VersionSelection{Strategy: rke2StrategyOldest})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resolution.Minor != 32 || resolution.Version != "v1.32.13+rke2r1" || resolution.Unsupported {
+		t.Fatalf("unexpected resolution: %+v", resolution)
+	}
+
+	resolution, err = resolveRKE2Support(providers, "2.13.4", rke2VersionSelection{Strategy: rke2StrategyExact, Version: "v1.35.1+rke2r1", AllowUnsupported: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resolution.Version != "v1.35.1+rke2r1" || !resolution.Unsupported {
+		t.Fatalf("expected unsupported exact version to be kept: %+v", resolution)
+	}
+}
+
+func TestResolveRKE2SupportMatchesConstraintsAgainstPublishedPatches(t *testing.T) {
+	providers := []SupportMatrixProvider{
+		fakeSupportMatrixProvider{name: "kdm", lowest: 32, highest: 34, patches: []string{
+			"v1.32.3+rke2r1", "v1.32.6+rke2r1", "v1.33.2+rke2r1", "v1.34.1+rke2r1",
+		}},
+	}
+
+	tests := []struct {
+		name      string
+		selection rke2VersionSelection
+		want      string
+		wantErr   string
+	}{
+		{name: "patch floor keeps its line", selection: rke2VersionSelection{Strategy: rke2StrategyLatest, Version: "~1.32.4"}, want: "v1.32.6+rke2r1"},
+		{name: "floor inside the oldest line", selection: rke2VersionSelection{Strategy: rke2StrategyOldest, Version: ">=1.32.5"}, want: "v1.32.6+rke2r1"},
+		{name: "ceiling picks an older patch", selection: rke2VersionSelection{Strategy: rke2StrategyLatest, Version: "< 1.32.5"}, want: "v1.32.3+rke2r1"},
+		{name: "exact release", selection: rke2VersionSelection{Strategy: rke2StrategyExact, Version: "v1.33.2+rke2r1"}, want: "v1.33.2+rke2r1"},
+		{name: "patch floor above every release", selection: rke2VersionSelection{Strategy: rke2StrategyLatest, Version: "~1.32.9"}, wantErr: "no published RKE2 release in the v1.32 line"},
+		{name: "constraint outside the range", selection: rke2VersionSelection{Strategy: rke2StrategyLatest, Version: ">= 1.35", AllowUnsupported: true}, wantErr: "single line"},
+		{name: "exact release that does not exist", selection: rke2VersionSelection{Strategy: rke2StrategyExact, Version: "v1.33.9+rke2r1"}, wantErr: "not a published release"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolution, err := resolveRKE2Support(providers, "2.13.4", tt.selection)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resolution.Version != tt.want || resolution.Unsupported {
+				t.Fatalf("expected %s, got %+v", tt.want, resolution)
+			}
+		})
+	}
+}
+
+func TestConfiguredRKE2VersionSelectionPerHA(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("rke2.strategy", "oldest")
+	viper.Set("rke2.version", ">= 1.32")
+	viper.Set("rke2.ha_strategy", map[string]interface{}{
+		"2": map[string]interface{}{"strategy": "exact", "version": "v1.35.1+rke2r1", "allow_unsupported": true},
+	})
+
+	first, err := configuredRKE2VersionSelection(1)
+	if err != nil || first.Strategy != rke2StrategyOldest || first.Version != ">= 1.32" || first.AllowUnsupported {
+		t.Fatalf("unexpected HA 1 selection %+v (%v)", first, err)
+	}
+	second, err := configuredRKE2VersionSelection(2)
+	if err != nil || second.Strategy != rke2StrategyExact || second.Version != "v1.35.1+rke2r1" || !second.AllowUnsupported {
+		t.Fatalf("unexpected HA 2 selection %+v (%v)", second, err)
+	}
+
+	if err := validateRKE2StrategyConfig(1); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected out-of-range HA error, got %v", err)
+	}
+
+	viper.Set("rke2.ha_strategy", map[string]interface{}{"1": map[string]interface{}{"strategy": "exact", "version": "~1.32"}})
+	if err := validateRKE2StrategyConfig(1); err == nil || !strings.Contains(err.Error(), "full RKE2 release") {
+		t.Fatalf("expected exact strategy to need a full release, got %v", err)
+	}
+}
@@ -0,0 +1,482 @@
+package resolver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
+	goversion "github.com/hashicorp/go-version"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	supportMatrixProviderKDM    = "kdm"
+	supportMatrixProviderGitHub = "github"
+	supportMatrixProviderSUSE   = "suse"
+	supportMatrixProviderPinned = "pinned"
+)
+
+var defaultSupportMatrixProviders = []string{supportMatrixProviderKDM, supportMatrixProviderGitHub, supportMatrixProviderSUSE}
+
+var rke2GitHubReleasesURL = "https://api.github.com/repos/rancher/rke2/releases?per_page=100"
+
+var rke2PatchPattern = regexp.MustCompile(`^v1\.(\d+)\.\d+\+rke2r\d+$`)
+
+// errSupportMatrixNotProvided marks a question a provider cannot answer, for
+// example GitHub releases know RKE2 patches but not which lines Rancher supports.
+var errSupportMatrixNotProvided = errors.New("not provided by this source")
+
+// SupportMatrixProvider answers which RKE2 releases a Rancher version supports.
+// The resolver asks every configured provider, trusts the first answer, and
+// records any disagreement from the others in the plan explanation.
+type SupportMatrixProvider interface {
+	Name() string
+	SupportedRKE2Minors(rancherVersion string) (lowest, highest int, err error)
+	RKE2Patches(rancherVersion string, minor int) ([]string, error)
+}
+
+type rke2SupportResolution struct {
+	LowestMinor  int
+	HighestMinor int
+	Minor        int
+	Version      string
+	Unsupported  bool
+	Explanation  []string
+}
+
+func configuredSupportMatrixProviders() ([]SupportMatrixProvider, error) {
+	names := viper.GetStringSlice("rke2.support_matrix_providers")
+	if len(names) == 0 {
+		names = defaultSupportMatrixProviders
+		if strings.TrimSpace(viper.GetString("rke2.support_matrix_file")) != "" {
+			names = append([]string{supportMatrixProviderPinned}, names...)
+		}
+	}
+
+	providers := make([]SupportMatrixProvider, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case supportMatrixProviderKDM:
+			providers = append(providers, kdmSupportMatrixProvider{})
+		case supportMatrixProviderGitHub:
+			providers = append(providers, githubRKE2ReleasesProvider{})
+		case supportMatrixProviderSUSE:
+			providers = append(providers, suseSupportMatrixProvider{})
+		case supportMatrixProviderPinned:
+			path := strings.TrimSpace(viper.GetString("rke2.support_matrix_file"))
+			if path == "" {
+				return nil, fmt.Errorf("rke2.support_matrix_providers includes pinned, so rke2.support_matrix_file must be set")
+			}
+			providers = append(providers, pinnedSupportMatrixProvider{path: path})
+		default:
+			return nil, fmt.Errorf("unsupported rke2.support_matrix_providers entry %q; use kdm, github, suse, or pinned", name)
+		}
+	}
+	return providers, nil
+}
+
+func resolveRKE2Support(providers []SupportMatrixProvider, rancherVersion string, selection rke2VersionSelection) (rke2SupportResolution, error) {
+	resolution := rke2SupportResolution{}
+
+	type minorAnswer struct {
+		provider        string
+		lowest, highest int
+	}
+	var minorAnswers []minorAnswer
+	var failures []error
+	for _, provider := range providers {
+		lowest, highest, err := provider.SupportedRKE2Minors(rancherVersion)
+		if errors.Is(err, errSupportMatrixNotProvided) {
+			continue
+		}
+		if err != nil {
+			log.Printf("[resolver] %s support matrix lookup failed for Rancher %s: %v", provider.Name(), rancherVersion, err)
+			resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("The %s support matrix could not be used for Rancher %s: %v", provider.Name(), rancherVersion, err))
+			failures = append(failures, fmt.Errorf("%s: %w", provider.Name(), err))
+			continue
+		}
+		minorAnswers = append(minorAnswers, minorAnswer{provider: provider.Name(), lowest: lowest, highest: highest})
+	}
+	if len(minorAnswers) == 0 {
+		return resolution, fmt.Errorf("no support matrix provider could determine the supported RKE2 lines for Rancher %s: %w", rancherVersion, errors.Join(failures...))
+	}
+
+	primary := minorAnswers[0]
+	resolution.LowestMinor, resolution.HighestMinor = primary.lowest, primary.highest
+	resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("The %s support matrix certifies RKE2 from v1.%d through v1.%d", primary.provider, primary.lowest, primary.highest))
+	for _, answer := range minorAnswers[1:] {
+		if answer.lowest != primary.lowest {
+			resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("Support matrix disagreement: %s reports v1.%d as the lowest RKE2 line but %s reports v1.%d; using %s", primary.provider, primary.lowest, answer.provider, answer.lowest, primary.provider))
+		}
+		if answer.highest != primary.highest {
+			resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("Support matrix disagreement: %s reports v1.%d as the highest RKE2 line but %s reports v1.%d; using %s", primary.provider, primary.highest, answer.provider, answer.highest, primary.provider))
+		}
+	}
+
+	lines, err := selection.candidateLines(rancherVersion, resolution.LowestMinor, resolution.HighestMinor)
+	if err != nil {
+		return resolution, err
+	}
+
+	// Constraints and exact pins are matched against the releases each source
+	// actually lists, so ">= 1.32.5" keeps v1.32 when v1.32.6 exists and a pin
+	// to a release nobody published fails here instead of during install.
+	type patchAnswer struct {
+		provider string
+		version  string
+	}
+	for _, minor := range lines {
+		var patchAnswers []patchAnswer
+		failures = nil
+		for _, provider := range providers {
+			patches, err := provider.RKE2Patches(rancherVersion, minor)
+			if errors.Is(err, errSupportMatrixNotProvided) {
+				continue
+			}
+			if err != nil {
+				log.Printf("[resolver] %s RKE2 patch lookup failed for v1.%d: %v", provider.Name(), minor, err)
+				resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("The %s RKE2 release source could not be used for v1.%d: %v", provider.Name(), minor, err))
+				failures = append(failures, fmt.Errorf("%s: %w", provider.Name(), err))
+				continue
+			}
+			patchAnswers = append(patchAnswers, patchAnswer{provider: provider.Name(), version: selection.latestMatchingPatch(patches, minor)})
+		}
+		if len(patchAnswers) == 0 {
+			return resolution, fmt.Errorf("no RKE2 release source could list the patch releases in the v1.%d line: %w", minor, errors.Join(failures...))
+		}
+
+		chosen := slices.IndexFunc(patchAnswers, func(answer patchAnswer) bool { return answer.version != "" })
+		if chosen < 0 {
+			resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("No published v1.%d release matches rke2.version %q", minor, selection.Version))
+			continue
+		}
+
+		selected := patchAnswers[chosen]
+		resolution.Minor = minor
+		resolution.Version = selected.version
+		resolution.Unsupported = minor < resolution.LowestMinor || minor > resolution.HighestMinor
+		resolution.Explanation = append(resolution.Explanation, selection.describe(rancherVersion, minor, resolution.LowestMinor, resolution.HighestMinor))
+		if selection.Strategy == rke2StrategyExact {
+			resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("Confirmed %s is a published RKE2 release according to %s", resolution.Version, selected.provider))
+		} else {
+			resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("Selected %s from %s as the latest available RKE2 patch in the v1.%d line", resolution.Version, selected.provider, minor))
+		}
+		for _, answer := range patchAnswers {
+			switch {
+			case answer.version == resolution.Version:
+			case answer.version == "":
+				resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("RKE2 release disagreement: %s reports %s but %s lists no matching v1.%d patch; using %s", selected.provider, resolution.Version, answer.provider, minor, selected.provider))
+			default:
+				resolution.Explanation = append(resolution.Explanation, fmt.Sprintf("RKE2 release disagreement: %s reports %s as the latest v1.%d patch but %s reports %s; using %s", selected.provider, resolution.Version, minor, answer.provider, answer.version, selected.provider))
+			}
+		}
+		return resolution, nil
+	}
+	return resolution, selection.noReleaseError(rancherVersion, resolution.LowestMinor, resolution.HighestMinor)
+}
+
+// kdmSupportMatrixProvider reads the RKE2 releases Rancher itself would offer
+// from kontainer-driver-metadata, the same source downstream K3s provisioning uses.
+type kdmSupportMatrixProvider struct{}
+
+type kdmRKE2Release struct {
+	Version                 string `json:"version"`
+	MinChannelServerVersion string `json:"minChannelServerVersion"`
+	MaxChannelServerVersion string `json:"maxChannelServerVersion"`
+}
+
+func (kdmSupportMatrixProvider) Name() string { return supportMatrixProviderKDM }
+
+func (p kdmSupportMatrixProvider) SupportedRKE2Minors(rancherVersion string) (int, int, error) {
+	versions, err := p.supportedVersions(rancherVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	return rke2MinorRange(versions)
+}
+
+func (p kdmSupportMatrixProvider) RKE2Patches(rancherVersion string, minor int) ([]string, error) {
+	versions, err := p.supportedVersions(rancherVersion)
+	if err != nil {
+		return nil, err
+	}
+	return rke2PatchesInMinor(versions, minor)
+}
+
+func (kdmSupportMatrixProvider) supportedVersions(rancherVersion string) ([]string, error) {
+	serverVersion, err := ParseRancherVersion(rancherVersion)
+	if err != nil {
+		return nil, err
+	}
+	metadataURL := KDMMetadataURLForRancherVersion(rancherVersion)
+	body, err := resolverFetchURLBody(metadataURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata struct {
+		RKE2 struct {
+			Releases []kdmRKE2Release `json:"releases"`
+		} `json:"rke2"`
+	}
+	if err := json.Unmarshal([]byte(body), &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse KDM metadata %s: %w", metadataURL, err)
+	}
+
+	var versions []string
+	for _, release := range metadata.RKE2.Releases {
+		if kdmReleaseSupportsRancherVersion(release, serverVersion) {
+			versions = append(versions, release.Version)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("KDM metadata %s lists no RKE2 releases for Rancher %s", metadataURL, rancherVersion)
+	}
+	return versions, nil
+}
+
+func kdmReleaseSupportsRancherVersion(release kdmRKE2Release, serverVersion *goversion.Version) bool {
+	minVersion, err := ParseRancherVersion(release.MinChannelServerVersion)
+	if err != nil {
+		return false
+	}
+	maxVersion, err := ParseRancherVersion(release.MaxChannelServerVersion)
+	if err != nil {
+		return false
+	}
+	// Compare on the release core so 2.13.4-alpha1 still falls inside a
+	// 2.13.0-2.13.99 window the same way a released 2.13.4 does.
+	core := serverVersion.Core()
+	return !core.LessThan(minVersion.Core()) && !maxVersion.Core().LessThan(core)
+}
+
+func KDMMetadataURLForRancherVersion(rancherVersion string) string {
+	version, err := ParseRancherVersion(rancherVersion)
+	if err != nil {
+		return ""
+	}
+	segments := version.Segments64()
+	if len(segments) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("https://releases.rancher.com/kontainer-driver-metadata/dev-v%d.%d/data.json", segments[0], segments[1])
+}
+
+func ParseRancherVersion(version string) (*goversion.Version, error) {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return nil, fmt.Errorf("version must not be empty")
+	}
+	parsed, err := goversion.NewVersion(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse version %q: %w", version, err)
+	}
+	return parsed, nil
+}
+
+// githubRKE2ReleasesProvider knows which RKE2 patches are published, but not
+// which lines a Rancher release supports.
+type githubRKE2ReleasesProvider struct{}
+
+func (githubRKE2ReleasesProvider) Name() string { return supportMatrixProviderGitHub }
+
+func (githubRKE2ReleasesProvider) SupportedRKE2Minors(string) (int, int, error) {
+	return 0, 0, errSupportMatrixNotProvided
+}
+
+func (githubRKE2ReleasesProvider) RKE2Patches(_ string, minor int) ([]string, error) {
+	body, err := resolverFetchURLBody(rke2GitHubReleasesURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []struct {
+		TagName    string `json:"tag_name"`
+		Draft      bool   `json:"draft"`
+		Prerelease bool   `json:"prerelease"`
+	}
+	if err := json.Unmarshal([]byte(body), &releases); err != nil {
+		return nil, fmt.Errorf("failed to parse RKE2 GitHub releases: %w", err)
+	}
+
+	var versions []string
+	for _, release := range releases {
+		if !release.Draft && !release.Prerelease {
+			versions = append(versions, release.TagName)
+		}
+	}
+	return rke2PatchesInMinor(versions, minor)
+}
+
+// suseSupportMatrixProvider scrapes the SUSE support matrix page and the RKE2
+// release notes. It is kept as a cross-check against the structured sources.
+type suseSupportMatrixProvider struct{}
+
+func (suseSupportMatrixProvider) Name() string { return supportMatrixProviderSUSE }
+
+func (suseSupportMatrixProvider) SupportedRKE2Minors(rancherVersion string) (int, int, error) {
+	return resolveSupportedRKE2MinorRange(buildSupportMatrixURL(rancherVersion))
+}
+
+func (suseSupportMatrixProvider) RKE2Patches(_ string, minor int) ([]string, error) {
+	return resolveRKE2ReleaseNotesPatches(minor)
+}
+
+// pinnedSupportMatrixProvider reads a local file keyed by Rancher minor line:
+//
+//	"2.13":
+//	  rke2_min: v1.32
+//	  rke2_max: v1.34
+//	  rke2_versions: [v1.34.6+rke2r1, v1.33.10+rke2r1]
+type pinnedSupportMatrixProvider struct {
+	path string
+}
+
+type pinnedSupportMatrixEntry struct {
+	RKE2Min      string   `yaml:"rke2_min"`
+	RKE2Max      string   `yaml:"rke2_max"`
+	RKE2Versions []string `yaml:"rke2_versions"`
+}
+
+func (pinnedSupportMatrixProvider) Name() string { return supportMatrixProviderPinned }
+
+func (p pinnedSupportMatrixProvider) SupportedRKE2Minors(rancherVersion string) (int, int, error) {
+	entry, err := p.entry(rancherVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	if entry.RKE2Min == "" || entry.RKE2Max == "" {
+		return 0, 0, errSupportMatrixNotProvided
+	}
+	lowest, err := parseRKE2MinorLine(entry.RKE2Min)
+	if err != nil {
+		return 0, 0, err
+	}
+	highest, err := parseRKE2MinorLine(entry.RKE2Max)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lowest, highest, nil
+}
+
+func (p pinnedSupportMatrixProvider) RKE2Patches(rancherVersion string, minor int) ([]string, error) {
+	entry, err := p.entry(rancherVersion)
+	if err != nil {
+		return nil, err
+	}
+	if len(entry.RKE2Versions) == 0 {
+		return nil, errSupportMatrixNotProvided
+	}
+	return rke2PatchesInMinor(entry.RKE2Versions, minor)
+}
+
+func (p pinnedSupportMatrixProvider) entry(rancherVersion string) (pinnedSupportMatrixEntry, error) {
+	path, err := settings.ExpandHomePath(p.path)
+	if err != nil {
+		return pinnedSupportMatrixEntry{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pinnedSupportMatrixEntry{}, fmt.Errorf("failed to read pinned support matrix %s: %w", path, err)
+	}
+	var entries map[string]pinnedSupportMatrixEntry
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return pinnedSupportMatrixEntry{}, fmt.Errorf("failed to parse pinned support matrix %s: %w", path, err)
+	}
+
+	minorLine, err := rancherMinorLineFromVersion(NormalizeVersionInput(rancherVersion))
+	if err != nil {
+		return pinnedSupportMatrixEntry{}, err
+	}
+	entry, ok := entries[minorLine]
+	if !ok {
+		return pinnedSupportMatrixEntry{}, fmt.Errorf("pinned support matrix %s has no entry for Rancher %s", path, minorLine)
+	}
+	return entry, nil
+}
+
+func parseRKE2MinorLine(value string) (int, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(value), "v")
+	major, minor, ok := strings.Cut(trimmed, ".")
+	if !ok || major != "1" {
+		return 0, fmt.Errorf("RKE2 line %q must look like v1.34", value)
+	}
+	minor, _, _ = strings.Cut(minor, ".")
+	parsed, err := strconv.Atoi(minor)
+	if err != nil {
+		return 0, fmt.Errorf("RKE2 line %q must look like v1.34", value)
+	}
+	return parsed, nil
+}
+
+func rke2MinorRange(versions []string) (int, int, error) {
+	var minors []int
+	for _, version := range versions {
+		if match := rke2PatchPattern.FindStringSubmatch(strings.TrimSpace(version)); match != nil {
+			minor, _ := strconv.Atoi(match[1])
+			minors = append(minors, minor)
+		}
+	}
+	if len(minors) == 0 {
+		return 0, 0, fmt.Errorf("no RKE2 release versions found")
+	}
+	return slices.Min(minors), slices.Max(minors), nil
+}
+
+// rke2PatchesInMinor keeps the RKE2 releases that belong to the v1.minor line.
+func rke2PatchesInMinor(versions []string, minor int) ([]string, error) {
+	var patches []string
+	for _, version := range versions {
+		version = strings.TrimSpace(version)
+		if match := rke2PatchPattern.FindStringSubmatch(version); match != nil && match[1] == strconv.Itoa(minor) {
+			patches = append(patches, version)
+		}
+	}
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no RKE2 release found in the v1.%d line", minor)
+	}
+	return patches, nil
+}
+
+func latestRKE2PatchInMinor(versions []string, minor int) (string, error) {
+	var best *goversion.Version
+	bestOriginal := ""
+	for _, version := range versions {
+		version = strings.TrimSpace(version)
+		match := rke2PatchPattern.FindStringSubmatch(version)
+		if match == nil || match[1] != strconv.Itoa(minor) {
+			continue
+		}
+		parsed, err := goversion.NewVersion(strings.TrimPrefix(version, "v"))
+		if err != nil {
+			continue
+		}
+		if best == nil || parsed.GreaterThan(best) || (parsed.Equal(best) && rke2ReleaseRevision(version) > rke2ReleaseRevision(bestOriginal)) {
+			best = parsed
+			bestOriginal = version
+		}
+	}
+	if bestOriginal == "" {
+		return "", fmt.Errorf("no RKE2 release found in the v1.%d line", minor)
+	}
+	return bestOriginal, nil
+}
+
+// rke2ReleaseRevision returns N from +rke2rN; go-version ignores build
+// metadata, so v1.34.6+rke2r2 would otherwise tie with +rke2r1.
+func rke2ReleaseRevision(version string) int {
+	_, revision, ok := strings.Cut(version, "+rke2r")
+	if !ok {
+		return 0
+	}
+	parsed, _ := strconv.Atoi(revision)
+	return parsed
+}
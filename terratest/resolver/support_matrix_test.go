@@ -0,0 +1,139 @@
+package resolver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+type fakeSupportMatrixProvider struct {
+	name            string
+	lowest, highest int
+	minorErr        error
+	patches         []string
+	patchErr        error
+}
+
+func (p fakeSupportMatrixProvider) Name() string { return p.name }
+
+func (p fakeSupportMatrixProvider) SupportedRKE2Minors(string) (int, int, error) {
+	return p.lowest, p.highest, p.minorErr
+}
+
+func (p fakeSupportMatrixProvider) RKE2Patches(_ string, minor int) ([]string, error) {
+	if p.patchErr != nil {
+		return nil, p.patchErr
+	}
+	return rke2PatchesInMinor(p.patches, minor)
+}
+
+func TestResolveRKE2SupportCrossChecksProviders(t *testing.T) {
+	providers := []SupportMatrixProvider{
+		fakeSupportMatrixProvider{name: "kdm", minorErr: errors.New("HTTP 503"), patchErr: errors.New("HTTP 503")},
+		fakeSupportMatrixProvider{name: "github", minorErr: errSupportMatrixNotProvided, patches: []string{"v1.34.7+rke2r1"}},
+		fakeSupportMatrixProvider{name: "suse", lowest: 32, highest: 34, patches: []string{"v1.34.6+rke2r1"}},
+		fakeSupportMatrixProvider{name: "pinned", lowest: 31, highest: 33, patchErr: errSupportMatrixNotProvided},
+	}
+
+	resolution, err := resolveRKE2Support(providers, "2.13.4", rke2VersionSelection{Strategy: rke2StrategyLatest})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resolution.HighestMinor != 34 || resolution.Version != "v1.34.7+rke2r1" {
+		t.Fatalf("unexpected resolution: %+v", resolution)
+	}
+
+	explanation := strings.Join(resolution.Explanation, "\n")
+	for _, snippet := range []string{
+		"The kdm support matrix could not be used for Rancher 2.13.4: HTTP 503",
+		"The suse support matrix certifies RKE2 from v1.32 through v1.34",
+		"suse reports v1.32 as the lowest RKE2 line but pinned reports v1.31; using suse",
+		"suse reports v1.34 as the highest RKE2 line but pinned reports v1.33; using suse",
+		"github reports v1.34.7+rke2r1 as the latest v1.34 patch but suse reports v1.34.6+rke2r1; using github",
+	} {
+		if !strings.Contains(explanation, snippet) {
+			t.Fatalf("expected %q in explanation:\n%s", snippet, explanation)
+		}
+	}
+}
+
+func TestResolveRKE2SupportReportsLowestLineDisagreement(t *testing.T) {
+	providers := []SupportMatrixProvider{
+		fakeSupportMatrixProvider{name: "kdm", lowest: 32, highest: 34, patches: []string{"v1.34.7+rke2r1"}},
+		fakeSupportMatrixProvider{name: "suse", lowest: 31, highest: 34, patches: []string{"v1.34.7+rke2r1"}},
+	}
+
+	resolution, err := resolveRKE2Support(providers, "2.13.4", rke2VersionSelection{Strategy: rke2StrategyLatest})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resolution.LowestMinor != 32 {
+		t.Fatalf("expected the primary provider's lowest line, got %+v", resolution)
+	}
+	explanation := strings.Join(resolution.Explanation, "\n")
+	if !strings.Contains(explanation, "kdm reports v1.32 as the lowest RKE2 line but suse reports v1.31; using kdm") {
+		t.Fatalf("expected the lowest line disagreement in explanation:\n%s", explanation)
+	}
+	if strings.Contains(explanation, "as the highest RKE2 line") {
+		t.Fatalf("did not expect a highest line disagreement:\n%s", explanation)
+	}
+}
+
+func TestResolveRKE2SupportFailsWithoutAnswers(t *testing.T) {
+	providers := []SupportMatrixProvider{
+		fakeSupportMatrixProvider{name: "github", minorErr: errSupportMatrixNotProvided, patches: []string{"v1.34.7+rke2r1"}},
+	}
+	if _, err := resolveRKE2Support(providers, "2.13.4", rke2VersionSelection{Strategy: rke2StrategyLatest}); err == nil || !strings.Contains(err.Error(), "no support matrix provider") {
+		t.Fatalf("expected missing support matrix error, got %v", err)
+	}
+}
+
+func TestPinnedSupportMatrixProvider(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	path := filepath.Join(t.TempDir(), "support-matrix.yaml")
+	if err := os.WriteFile(path, []byte(`"2.13":
+  rke2_min: v1.32
+  rke2_max: v1.34
+  rke2_versions:
+    - v1.34.6+rke2r1
+    - v1.34.6+rke2r2
+    - v1.33.10+rke2r1
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("rke2.support_matrix_file", path)
+
+	providers, err := configuredSupportMatrixProviders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if providers[0].Name() != supportMatrixProviderPinned || len(providers) != 4 {
+		t.Fatalf("expected pinned provider first, got %d providers starting with %s", len(providers), providers[0].Name())
+	}
+
+	lowest, highest, err := providers[0].SupportedRKE2Minors("v2.13.4")
+	if err != nil || lowest != 32 || highest != 34 {
+		t.Fatalf("unexpected range %d-%d (%v)", lowest, highest, err)
+	}
+	patches, err := providers[0].RKE2Patches("2.13.4", 34)
+	if err != nil || !reflect.DeepEqual(patches, []string{"v1.34.6+rke2r1", "v1.34.6+rke2r2"}) {
+		t.Fatalf("expected only the v1.34 releases, got %v (%v)", patches, err)
+	}
+	if latest, err := latestRKE2PatchInMinor(patches, 34); err != nil || latest != "v1.34.6+rke2r2" {
+		t.Fatalf("expected the highest rke2r revision, got %q (%v)", latest, err)
+	}
+	if _, _, err := providers[0].SupportedRKE2Minors("2.12.3"); err == nil || !strings.Contains(err.Error(), "no entry for Rancher 2.12") {
+		t.Fatalf("expected missing entry error, got %v", err)
+	}
+
+	viper.Set("rke2.support_matrix_providers", []string{"kdm", "rancher.com"})
+	if _, err := configuredSupportMatrixProviders(); err == nil {
+		t.Fatal("expected unknown provider to be rejected")
+	}
+}
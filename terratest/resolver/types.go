@@ -0,0 +1,39 @@
+package resolver
+
+type RancherResolvedPlan struct {
+	Mode                   string
+	RequestedVersion       string
+	RequestedDistro        string
+	BuildType              string
+	ResolvedDistro         string
+	ChartRepoAlias         string
+	ChartVersion           string
+	RancherImage           string
+	RancherImageTag        string
+	AgentImage             string
+	UseRancherImageFields  bool
+	CompatibilityBaseline  string
+	SupportMatrixURL       string
+	RecommendedRKE2Version string
+	RKE2Strategy           string
+	RKE2VersionConstraint  string
+	SupportedRKE2Lines     string
+	RKE2Unsupported        bool
+	InstallerSHA256        string
+	HelmCommands           []string
+	Explanation            []string
+}
+
+type HelmSearchResult struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"app_version"`
+	Description string `json:"description"`
+}
+
+type resolvedChartMatch struct {
+	repoAlias             string
+	chartVersion          string
+	compatibilityBaseline string
+	matchRank             int
+}
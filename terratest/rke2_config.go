@@ -0,0 +1,220 @@
+package test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+type rke2ConfigValueKind int
+
+const (
+	rke2ConfigString rke2ConfigValueKind = iota
+	rke2ConfigBool
+	rke2ConfigInt
+	rke2ConfigStringList
+)
+
+type rke2ConfigKey struct {
+	kind      rke2ConfigValueKind
+	agentSafe bool
+}
+
+// rke2KnownConfigKeys is the subset of RKE2 server/agent flags this tool lets
+// users pass through. Agents only receive keys marked agentSafe.
+var rke2KnownConfigKeys = map[string]rke2ConfigKey{
+	"profile":                            {kind: rke2ConfigString, agentSafe: true},
+	"selinux":                            {kind: rke2ConfigBool, agentSafe: true},
+	"protect-kernel-defaults":            {kind: rke2ConfigBool, agentSafe: true},
+	"node-label":                         {kind: rke2ConfigStringList, agentSafe: true},
+	"node-taint":                         {kind: rke2ConfigStringList, agentSafe: true},
+	"kubelet-arg":                        {kind: rke2ConfigStringList, agentSafe: true},
+	"kube-proxy-arg":                     {kind: rke2ConfigStringList, agentSafe: true},
+	"system-default-registry":            {kind: rke2ConfigString, agentSafe: true},
+	"snapshotter":                        {kind: rke2ConfigString, agentSafe: true},
+	"resolv-conf":                        {kind: rke2ConfigString, agentSafe: true},
+	"debug":                              {kind: rke2ConfigBool, agentSafe: true},
+	"cni":                                {kind: rke2ConfigStringList},
+	"cluster-cidr":                       {kind: rke2ConfigString},
+	"service-cidr":                       {kind: rke2ConfigString},
+	"cluster-dns":                        {kind: rke2ConfigString},
+	"cluster-domain":                     {kind: rke2ConfigString},
+	"service-node-port-range":            {kind: rke2ConfigString},
+	"secrets-encryption":                 {kind: rke2ConfigBool},
+	"secrets-encryption-provider":        {kind: rke2ConfigString},
+	"kube-apiserver-arg":                 {kind: rke2ConfigStringList},
+	"kube-controller-manager-arg":        {kind: rke2ConfigStringList},
+	"kube-scheduler-arg":                 {kind: rke2ConfigStringList},
+	"etcd-arg":                           {kind: rke2ConfigStringList},
+	"etcd-expose-metrics":                {kind: rke2ConfigBool},
+	"etcd-snapshot-schedule-cron":        {kind: rke2ConfigString},
+	"etcd-snapshot-retention":            {kind: rke2ConfigInt},
+	"etcd-disable-snapshots":             {kind: rke2ConfigBool},
+	"disable":                            {kind: rke2ConfigStringList},
+	"disable-cloud-controller":           {kind: rke2ConfigBool},
+	"disable-kube-proxy":                 {kind: rke2ConfigBool},
+	"egress-selector-mode":               {kind: rke2ConfigString},
+	"embedded-registry":                  {kind: rke2ConfigBool},
+	"ingress-controller":                 {kind: rke2ConfigString},
+	"write-kubeconfig-mode":              {kind: rke2ConfigString},
+	"pod-security-admission-config-file": {kind: rke2ConfigString},
+	"audit-policy-file":                  {kind: rke2ConfigString},
+}
+
+var rke2GeneratedConfigKeys = []string{"server", "token", "tls-san"}
+
+func configuredRKE2ConfigOverrides(instanceNum int) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+	maps.Copy(merged, viper.GetStringMap("rke2.config"))
+
+	for haKey, value := range viper.GetStringMap("rke2.ha_config") {
+		haIndex, err := strconv.Atoi(haKey)
+		if err != nil || haIndex < 1 {
+			return nil, fmt.Errorf("rke2.ha_config keys must be HA numbers starting at 1, got %q", haKey)
+		}
+		if haIndex != instanceNum {
+			continue
+		}
+		override, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("rke2.ha_config.%s must be a map of RKE2 config keys", haKey)
+		}
+		maps.Copy(merged, override)
+	}
+
+	if err := validateRKE2ConfigOverrides(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func validateRKE2ConfigOverrides(overrides map[string]interface{}) error {
+	for _, key := range slices.Sorted(maps.Keys(overrides)) {
+		if slices.Contains(rke2GeneratedConfigKeys, key) {
+			return fmt.Errorf("rke2.config key %q is generated by this tool and cannot be overridden", key)
+		}
+		known, ok := rke2KnownConfigKeys[key]
+		if !ok {
+			return fmt.Errorf("rke2.config key %q is not a supported RKE2 config key", key)
+		}
+		if err := validateRKE2ConfigValue(key, known.kind, overrides[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateRKE2ConfigValue(key string, kind rke2ConfigValueKind, value interface{}) error {
+	switch kind {
+	case rke2ConfigString:
+		if _, ok := value.(string); ok {
+			return nil
+		}
+		return fmt.Errorf("rke2.config key %q must be a string", key)
+	case rke2ConfigBool:
+		if _, ok := value.(bool); ok {
+			return nil
+		}
+		return fmt.Errorf("rke2.config key %q must be true or false", key)
+	case rke2ConfigInt:
+		if _, ok := value.(int); ok {
+			return nil
+		}
+		return fmt.Errorf("rke2.config key %q must be a whole number", key)
+	case rke2ConfigStringList:
+		if _, ok := value.(string); ok {
+			return nil
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("rke2.config key %q must be a string or a list of strings", key)
+		}
+		for _, item := range items {
+			if _, ok := item.(string); !ok {
+				return fmt.Errorf("rke2.config key %q must be a string or a list of strings", key)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("rke2.config key %q has an unknown schema type", key)
+	}
+}
+
+func validateRKE2ConfigPreflight(totalHAs int) error {
+	for i := 1; i <= totalHAs; i++ {
+		if _, err := configuredRKE2ConfigOverrides(i); err != nil {
+			return fmt.Errorf("HA %d: %w", i, err)
+		}
+	}
+	for haKey := range viper.GetStringMap("rke2.ha_config") {
+		if haIndex, err := strconv.Atoi(haKey); err == nil && haIndex > totalHAs {
+			return fmt.Errorf("rke2.ha_config.%s targets an HA that does not exist; total_has is %d", haKey, totalHAs)
+		}
+	}
+	return nil
+}
+
+func rke2GeneratedNodeConfig(nodeType string, haOutputs TerraformOutputs, token string) map[string]interface{} {
+	generated := map[string]interface{}{}
+	if token != "" {
+		generated["server"] = fmt.Sprintf("https://%s:9345", haOutputs.FirstServerIP())
+		generated["token"] = token
+	}
+	if nodeType == "server" {
+		generated["tls-san"] = rke2TLSSANs(haOutputs)
+	}
+	return generated
+}
+
+func renderRKE2NodeConfig(nodeType string, haOutputs TerraformOutputs, token string, overrides map[string]interface{}) (string, error) {
+	config := rke2GeneratedNodeConfig(nodeType, haOutputs, token)
+	for key, value := range overrides {
+		if _, exists := config[key]; exists {
+			return "", fmt.Errorf("rke2.config key %q conflicts with a generated key", key)
+		}
+		if nodeType != "server" && !rke2KnownConfigKeys[key].agentSafe {
+			continue
+		}
+		config[key] = value
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to render RKE2 config.yaml: %w", err)
+	}
+	return string(data), nil
+}
+
+func writeRemoteFileCommand(path, content, mode string) string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	return fmt.Sprintf("echo %s | base64 -d | sudo install -D -m %s /dev/stdin %s",
+		shellSingleQuote(encoded), shellSingleQuote(mode), shellSingleQuote(path))
+}
+
+func rke2TLSSANs(haOutputs TerraformOutputs) []string {
+	candidates := []string{haOutputs.RancherURL}
+	for i, ip := range haOutputs.ServerIPs {
+		candidates = append(candidates, ip)
+		if i < len(haOutputs.ServerPrivateIPs) {
+			candidates = append(candidates, haOutputs.ServerPrivateIPs[i])
+		}
+	}
+
+	var sans []string
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "" || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		sans = append(sans, candidate)
+	}
+	return sans
+}
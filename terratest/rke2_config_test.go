@@ -0,0 +1,160 @@
+package test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+func testRKE2HAOutputs() TerraformOutputs {
+	return TerraformOutputs{
+		ServerIPs:        []string{"3.0.0.1", "3.0.0.2", "3.0.0.3"},
+		ServerPrivateIPs: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		AgentIPs:         []string{"3.0.0.11"},
+		AgentPrivateIPs:  []string{"10.0.0.11"},
+		RancherURL:       "rancher.example.com",
+	}
+}
+
+func parseRenderedRKE2Config(t *testing.T, rendered string) map[string]interface{} {
+	t.Helper()
+	var config map[string]interface{}
+	if err := yaml.Unmarshal([]byte(rendered), &config); err != nil {
+		t.Fatalf("rendered config is not valid YAML: %v\n%s", err, rendered)
+	}
+	return config
+}
+
+func TestRenderRKE2NodeConfigForFirstServer(t *testing.T) {
+	rendered, err := renderRKE2NodeConfig("server", testRKE2HAOutputs(), "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := parseRenderedRKE2Config(t, rendered)
+	expectedSANs := []interface{}{"rancher.example.com", "3.0.0.1", "10.0.0.1", "3.0.0.2", "10.0.0.2", "3.0.0.3", "10.0.0.3"}
+	if !reflect.DeepEqual(config["tls-san"], expectedSANs) {
+		t.Fatalf("expected tls-san %v, got %v", expectedSANs, config["tls-san"])
+	}
+	if _, ok := config["server"]; ok {
+		t.Fatalf("expected first server config without server key, got:\n%s", rendered)
+	}
+}
+
+func TestRenderRKE2NodeConfigMergesOverridesForJoiningServer(t *testing.T) {
+	overrides := map[string]interface{}{
+		"profile":            "cis",
+		"cni":                []interface{}{"multus", "cilium"},
+		"secrets-encryption": true,
+		"cluster-cidr":       "10.42.0.0/16",
+		"kubelet-arg":        []interface{}{"max-pods=250"},
+	}
+
+	rendered, err := renderRKE2NodeConfig("server", testRKE2HAOutputs(), "K10::server:secret", overrides)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := parseRenderedRKE2Config(t, rendered)
+	if config["server"] != "https://3.0.0.1:9345" || config["token"] != "K10::server:secret" {
+		t.Fatalf("expected join settings, got:\n%s", rendered)
+	}
+	expectedSANs := []interface{}{"rancher.example.com", "3.0.0.1", "10.0.0.1", "3.0.0.2", "10.0.0.2", "3.0.0.3", "10.0.0.3"}
+	if !reflect.DeepEqual(config["tls-san"], expectedSANs) {
+		t.Fatalf("expected joining server tls-san %v without agent IPs, got %v", expectedSANs, config["tls-san"])
+	}
+	if config["profile"] != "cis" || config["secrets-encryption"] != true || config["cluster-cidr"] != "10.42.0.0/16" {
+		t.Fatalf("expected overrides to be rendered, got:\n%s", rendered)
+	}
+	if !reflect.DeepEqual(config["cni"], []interface{}{"multus", "cilium"}) {
+		t.Fatalf("expected cni list, got %v", config["cni"])
+	}
+}
+
+func TestRenderRKE2NodeConfigForAgentKeepsOnlyAgentKeys(t *testing.T) {
+	overrides := map[string]interface{}{
+		"profile":      "cis",
+		"cni":          "calico",
+		"cluster-cidr": "10.42.0.0/16",
+		"node-label":   []interface{}{"role=worker"},
+	}
+
+	rendered, err := renderRKE2NodeConfig("agent", testRKE2HAOutputs(), "K10::node:secret", overrides)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := parseRenderedRKE2Config(t, rendered)
+	for _, key := range []string{"tls-san", "cni", "cluster-cidr"} {
+		if _, ok := config[key]; ok {
+			t.Fatalf("expected agent config without %s, got:\n%s", key, rendered)
+		}
+	}
+	if config["profile"] != "cis" || !reflect.DeepEqual(config["node-label"], []interface{}{"role=worker"}) {
+		t.Fatalf("expected agent-safe overrides, got:\n%s", rendered)
+	}
+}
+
+func TestConfiguredRKE2ConfigOverridesAppliesPerHAOverride(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("rke2.config", map[string]interface{}{"cni": "canal", "profile": "cis"})
+	viper.Set("rke2.ha_config", map[string]interface{}{
+		"2": map[string]interface{}{"cni": "cilium"},
+	})
+
+	first, err := configuredRKE2ConfigOverrides(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := configuredRKE2ConfigOverrides(2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first["cni"] != "canal" || second["cni"] != "cilium" || second["profile"] != "cis" {
+		t.Fatalf("unexpected overrides: first=%v second=%v", first, second)
+	}
+}
+
+func TestConfiguredRKE2ConfigOverridesRejectsInvalidConfig(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"generated by this tool":     {"tls-san": []interface{}{"extra.example.com"}},
+		"not a supported RKE2":       {"cnii": "calico"},
+		"must be true or false":      {"secrets-encryption": "yes"},
+		"must be a string or a list": {"kube-apiserver-arg": 42},
+	}
+
+	for expected, config := range cases {
+		viper.Reset()
+		viper.Set("rke2.config", config)
+		if _, err := configuredRKE2ConfigOverrides(1); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected error containing %q for %v, got %v", expected, config, err)
+		}
+	}
+	viper.Reset()
+}
+
+func TestValidateRKE2ConfigPreflightRejectsUnknownHA(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("rke2.ha_config", map[string]interface{}{
+		"3": map[string]interface{}{"cni": "cilium"},
+	})
+
+	if err := validateRKE2ConfigPreflight(2); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected unknown HA error, got %v", err)
+	}
+}
+
+func TestWriteRemoteFileCommandAvoidsHeredoc(t *testing.T) {
+	cmd := writeRemoteFileCommand("/etc/rancher/rke2/config.yaml", "token: 'x'\nEOL\n", "0600")
+	if strings.Contains(cmd, "EOL") || strings.Contains(cmd, "token") {
+		t.Fatalf("expected file content to be encoded, got %s", cmd)
+	}
+	if !strings.Contains(cmd, "sudo install -D -m '0600' /dev/stdin '/etc/rancher/rke2/config.yaml'") {
+		t.Fatalf("unexpected command %s", cmd)
+	}
+}
@@ -0,0 +1,89 @@
+package settings
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	RancherSettingsConfigKey = "rancher.settings"
+	RancherFeaturesConfigKey = "rancher.features"
+)
+
+var rancherSettingNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ConfiguredRancherSettings returns rancher.settings as setting name to value.
+// Numbers and booleans are sent to Rancher as strings, the way the settings
+// API stores every value.
+func ConfiguredRancherSettings() (map[string]string, error) {
+	raw, err := rancherConfigMap(RancherSettingsConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch typed := value.(type) {
+		case string:
+			values[name] = strings.TrimSpace(typed)
+		case bool, int, int64, float64:
+			values[name] = fmt.Sprint(typed)
+		default:
+			return nil, fmt.Errorf("%s.%s must be a string, number or boolean; got %T", RancherSettingsConfigKey, name, value)
+		}
+	}
+	return values, nil
+}
+
+// ConfiguredRancherFeatures returns rancher.features as feature name to
+// enabled.
+func ConfiguredRancherFeatures() (map[string]bool, error) {
+	raw, err := rancherConfigMap(RancherFeaturesConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]bool, len(raw))
+	for name, value := range raw {
+		switch typed := value.(type) {
+		case bool:
+			values[name] = typed
+		case string:
+			enabled, err := strconv.ParseBool(strings.TrimSpace(typed))
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s must be true or false; got %q", RancherFeaturesConfigKey, name, typed)
+			}
+			values[name] = enabled
+		default:
+			return nil, fmt.Errorf("%s.%s must be true or false; got %T", RancherFeaturesConfigKey, name, value)
+		}
+	}
+	return values, nil
+}
+
+func ValidateRancherSettingsConfig() error {
+	if _, err := ConfiguredRancherSettings(); err != nil {
+		return err
+	}
+	_, err := ConfiguredRancherFeatures()
+	return err
+}
+
+func rancherConfigMap(key string) (map[string]interface{}, error) {
+	value := viper.Get(key)
+	if value == nil {
+		return nil, nil
+	}
+	raw, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map of names to values; got %T", key, value)
+	}
+	for name := range raw {
+		if !rancherSettingNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s has an invalid name %q; use Rancher's lowercase names, for example agent-tls-mode", key, name)
+		}
+	}
+	return raw, nil
+}
@@ -0,0 +1,14 @@
+package settings
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ConfiguredSystemDefaultRegistry returns registries.system_default_registry,
+// the registry Rancher and RKE2 pull system images from, or "" for the
+// upstream defaults.
+func ConfiguredSystemDefaultRegistry() string {
+	return strings.TrimSpace(viper.GetString("registries.system_default_registry"))
+}
@@ -0,0 +1,157 @@
+package settings
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	TLSModeExternal    = "external"
+	TLSModeRancher     = "rancher"
+	TLSModeLetsEncrypt = "letsencrypt"
+	TLSModeSecret      = "secret"
+
+	DefaultCertManagerVersion     = "v1.18.2"
+	DefaultLetsEncryptEnvironment = "production"
+)
+
+var allowedTLSModes = []string{TLSModeExternal, TLSModeRancher, TLSModeLetsEncrypt, TLSModeSecret}
+
+func ConfiguredTLSMode() (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(viper.GetString("tls.mode")))
+	if mode == "" {
+		return TLSModeExternal, nil
+	}
+	if !slices.Contains(allowedTLSModes, mode) {
+		return "", fmt.Errorf("tls.mode must be one of %s; got %q", strings.Join(allowedTLSModes, ", "), mode)
+	}
+	return mode, nil
+}
+
+// TLSPassthrough reports whether the load balancer forwards raw TCP so that
+// Rancher's ingress terminates TLS instead of an ACM certificate on the ALB.
+func TLSPassthrough(mode string) bool {
+	return mode != TLSModeExternal
+}
+
+func TLSNeedsCertManager(mode string) bool {
+	return mode == TLSModeRancher || mode == TLSModeLetsEncrypt
+}
+
+func ConfiguredCertManagerVersion() string {
+	if version := strings.TrimSpace(viper.GetString("tls.cert_manager_version")); version != "" {
+		return version
+	}
+	return DefaultCertManagerVersion
+}
+
+func ConfiguredLetsEncryptEmail() string {
+	return strings.TrimSpace(viper.GetString("tls.letsencrypt_email"))
+}
+
+func ConfiguredLetsEncryptEnvironment() string {
+	if environment := strings.ToLower(strings.TrimSpace(viper.GetString("tls.letsencrypt_environment"))); environment != "" {
+		return environment
+	}
+	return DefaultLetsEncryptEnvironment
+}
+
+type TLSSecretFiles struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+func ConfiguredTLSSecretFiles() TLSSecretFiles {
+	return TLSSecretFiles{
+		CertFile: tlsFilePath("tls.cert_file"),
+		KeyFile:  tlsFilePath("tls.key_file"),
+		CAFile:   tlsFilePath("tls.ca_file"),
+	}
+}
+
+func tlsFilePath(key string) string {
+	path := strings.TrimSpace(viper.GetString(key))
+	if expanded, err := ExpandHomePath(path); err == nil {
+		return expanded
+	}
+	return path
+}
+
+// Generated returns true when secret mode should mint its own CA and serving
+// certificate because no certificate files were configured.
+func (f TLSSecretFiles) Generated() bool {
+	return f.CertFile == "" && f.KeyFile == ""
+}
+
+func (f TLSSecretFiles) PrivateCA() bool {
+	return f.Generated() || f.CAFile != ""
+}
+
+// TLSUsesPrivateCA reports whether downstream agents need Rancher's CA
+// checksum because the serving certificate is not publicly trusted.
+func TLSUsesPrivateCA(mode string) bool {
+	switch mode {
+	case TLSModeRancher:
+		return true
+	case TLSModeSecret:
+		return ConfiguredTLSSecretFiles().PrivateCA()
+	case TLSModeLetsEncrypt:
+		return ConfiguredLetsEncryptEnvironment() == "staging"
+	default:
+		return false
+	}
+}
+
+func ValidateTLSConfig() error {
+	mode, err := ConfiguredTLSMode()
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case TLSModeLetsEncrypt:
+		email := ConfiguredLetsEncryptEmail()
+		if email == "" {
+			return fmt.Errorf("tls.letsencrypt_email is required when tls.mode is letsencrypt")
+		}
+		if _, err := mail.ParseAddress(email); err != nil {
+			return fmt.Errorf("tls.letsencrypt_email %q is not a valid email address", email)
+		}
+		if environment := ConfiguredLetsEncryptEnvironment(); environment != "production" && environment != "staging" {
+			return fmt.Errorf("tls.letsencrypt_environment must be production or staging; got %q", environment)
+		}
+	case TLSModeSecret:
+		files := ConfiguredTLSSecretFiles()
+		if (files.CertFile == "") != (files.KeyFile == "") {
+			return fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+		}
+		if files.Generated() && files.CAFile != "" {
+			return fmt.Errorf("tls.ca_file requires tls.cert_file and tls.key_file")
+		}
+		for key, path := range map[string]string{"tls.cert_file": files.CertFile, "tls.key_file": files.KeyFile, "tls.ca_file": files.CAFile} {
+			if path == "" {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("%s %q cannot be read: %w", key, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ConfiguredTLSModeOrDefault is ConfiguredTLSMode with invalid values treated
+// as external; preflight reports the invalid value separately.
+func ConfiguredTLSModeOrDefault() string {
+	mode, err := ConfiguredTLSMode()
+	if err != nil {
+		return TLSModeExternal
+	}
+	return mode
+}
@@ -0,0 +1,39 @@
+package settings
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/viper"
+)
+
+const DefaultServerCount = 3
+
+var allowedServerCounts = []int{1, 3, 5}
+
+func ConfiguredServerCount() (int, error) {
+	if !viper.IsSet("server_count") {
+		return DefaultServerCount, nil
+	}
+	count := viper.GetInt("server_count")
+	if !slices.Contains(allowedServerCounts, count) {
+		return 0, fmt.Errorf("server_count must be 1, 3, or 5 so etcd keeps quorum; got %d", count)
+	}
+	return count, nil
+}
+
+func ConfiguredAgentCount() (int, error) {
+	count := viper.GetInt("agent_count")
+	if count < 0 {
+		return 0, fmt.Errorf("agent_count must be 0 or greater; got %d", count)
+	}
+	return count, nil
+}
+
+func ValidateTopologyConfig() error {
+	if _, err := ConfiguredServerCount(); err != nil {
+		return err
+	}
+	_, err := ConfiguredAgentCount()
+	return err
+}
@@ -220,7 +220,7 @@ func checkToolConfigReadiness(configPath string) systemReadinessItem {
 	item := systemReadinessItem{Name: "tool-config.yml"}
 	path := strings.TrimSpace(configPath)
 	if path == "" {
-		path = filepath.Join("..", "tool-config.yml")
+		path = workspaceToolConfigPath(activeWorkspace())
 	}
 
 	absPath, err := filepath.Abs(path)
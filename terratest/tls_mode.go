@@ -0,0 +1,231 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
+)
+
+const (
+	rancherTLSCertFileName = "tls.crt"
+	rancherTLSKeyFileName  = "tls.key"
+	rancherTLSCAFileName   = "cacerts.pem"
+)
+
+func validateRancherHelmCommandsForTLSMode(mode string, helmCommands []string) error {
+	if mode == settings.TLSModeExternal {
+		return validateRancherHelmCommandsUseExternalTLS(helmCommands)
+	}
+
+	expectedSource := map[string]string{
+		settings.TLSModeRancher:     "rancher",
+		settings.TLSModeLetsEncrypt: "letsEncrypt",
+		settings.TLSModeSecret:      "secret",
+	}[mode]
+	privateCA := settings.TLSUsesPrivateCA(mode)
+
+	for i, helmCommand := range helmCommands {
+		if rancherHelmCommandUsesExternalTLS(helmCommand) {
+			return fmt.Errorf("rancher.helm_commands[%d] must not set tls=external because tls.mode is %s and the NLB passes TLS through to the Rancher ingress", i, mode)
+		}
+		values := rancherHelmCommandSetValues(helmCommand)
+		source, sourceSet := values["ingress.tls.source"]
+		if source != expectedSource && (sourceSet || mode != settings.TLSModeRancher) {
+			return fmt.Errorf("rancher.helm_commands[%d] must include --set ingress.tls.source=%s because tls.mode is %s", i, expectedSource, mode)
+		}
+		if mode == settings.TLSModeLetsEncrypt && values["letsEncrypt.email"] == "" {
+			return fmt.Errorf("rancher.helm_commands[%d] must include --set letsEncrypt.email=<address> because tls.mode is letsencrypt", i)
+		}
+		if mode == settings.TLSModeSecret && privateCA && values["privateCA"] != "true" {
+			return fmt.Errorf("rancher.helm_commands[%d] must include --set privateCA=true because the tls-rancher-ingress certificate is signed by a private CA", i)
+		}
+		if privateCA && values["agentTLSMode"] == "system-store" {
+			return fmt.Errorf("rancher.helm_commands[%d] sets agentTLSMode=system-store, but tls.mode %s serves a certificate agents will not find in the system trust store", i, mode)
+		}
+	}
+	return nil
+}
+
+// rancherHelmCommandSetValues collects every --set and --set-string key/value
+// pair in a Helm command. Later values win, matching Helm's own precedence.
+func rancherHelmCommandSetValues(helmCommand string) map[string]string {
+	values := map[string]string{}
+	addPairs := func(raw string) {
+		for _, pair := range strings.Split(cleanHelmCommandField(raw), ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				continue
+			}
+			values[strings.TrimSpace(key)] = cleanHelmCommandField(value)
+		}
+	}
+
+	fields := strings.Fields(helmCommand)
+	for i, field := range fields {
+		field = cleanHelmCommandField(field)
+		switch {
+		case field == "--set" || field == "--set-string":
+			if i+1 < len(fields) {
+				addPairs(fields[i+1])
+			}
+		case strings.HasPrefix(field, "--set="):
+			addPairs(strings.TrimPrefix(field, "--set="))
+		case strings.HasPrefix(field, "--set-string="):
+			addPairs(strings.TrimPrefix(field, "--set-string="))
+		}
+	}
+	return values
+}
+
+// rancherTLSSetupCommands returns the install.sh steps that must run between
+// creating cattle-system and installing Rancher for the given TLS mode.
+func rancherTLSSetupCommands(mode, haDir, rancherURL string) (string, error) {
+	var steps []string
+	if settings.TLSNeedsCertManager(mode) {
+		steps = append(steps, certManagerInstallCommands(settings.ConfiguredCertManagerVersion()))
+	}
+	if mode == settings.TLSModeSecret {
+		privateCA, err := prepareRancherTLSSecretFiles(haDir, rancherURL)
+		if err != nil {
+			return "", err
+		}
+		steps = append(steps, rancherTLSSecretCommands(privateCA))
+	}
+	return strings.Join(steps, "\n\n"), nil
+}
+
+func certManagerInstallCommands(version string) string {
+	return fmt.Sprintf(`echo "Installing cert-manager %[1]s..."
+helm repo add jetstack https://charts.jetstack.io --force-update
+helm upgrade --install cert-manager jetstack/cert-manager \
+  --namespace cert-manager \
+  --create-namespace \
+  --version %[1]s \
+  --set crds.enabled=true \
+  --wait \
+  --timeout 10m`, version)
+}
+
+func rancherTLSSecretCommands(privateCA bool) string {
+	commands := []string{
+		`echo "Creating Rancher ingress TLS secrets..."`,
+		fmt.Sprintf("kubectl -n cattle-system create secret tls tls-rancher-ingress --cert=%s --key=%s --dry-run=client -o yaml | kubectl apply -f -",
+			rancherTLSCertFileName, rancherTLSKeyFileName),
+	}
+	if privateCA {
+		commands = append(commands, fmt.Sprintf("kubectl -n cattle-system create secret generic tls-ca --from-file=cacerts.pem=%s --dry-run=client -o yaml | kubectl apply -f -",
+			rancherTLSCAFileName))
+	}
+	return strings.Join(commands, "\n")
+}
+
+// prepareRancherTLSSecretFiles places tls.crt, tls.key and, for private CAs,
+// cacerts.pem next to install.sh. Without configured files it mints a
+// throwaway CA and a serving certificate for the Rancher hostname.
+func prepareRancherTLSSecretFiles(haDir, rancherURL string) (bool, error) {
+	if err := os.MkdirAll(haDir, 0o755); err != nil {
+		return false, fmt.Errorf("failed to create %s: %w", haDir, err)
+	}
+
+	files := settings.ConfiguredTLSSecretFiles()
+	if files.Generated() {
+		log.Printf("[tls] Generating a private CA and serving certificate for %s", rancherURL)
+		return true, generateRancherTLSSecretFiles(haDir, rancherURL)
+	}
+
+	copies := map[string]string{files.CertFile: rancherTLSCertFileName, files.KeyFile: rancherTLSKeyFileName}
+	if files.CAFile != "" {
+		copies[files.CAFile] = rancherTLSCAFileName
+	}
+	for source, name := range copies {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", source, err)
+		}
+		if err := os.WriteFile(filepath.Join(haDir, name), data, 0o600); err != nil {
+			return false, fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return files.PrivateCA(), nil
+}
+
+func generateRancherTLSSecretFiles(haDir, rancherURL string) error {
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(365 * 24 * time.Hour)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ha-rancher-rke2 test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate serving key: %w", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: rancherURL},
+		DNSNames:     []string{rancherURL},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caTemplate, &leafKey.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("failed to create serving certificate: %w", err)
+	}
+	leafKeyDER, err := x509.MarshalECPrivateKey(leafKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode serving key: %w", err)
+	}
+
+	outputs := map[string][]byte{
+		rancherTLSCAFileName:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		rancherTLSCertFileName: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		rancherTLSKeyFileName:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: leafKeyDER}),
+	}
+	for name, data := range outputs {
+		if err := os.WriteFile(filepath.Join(haDir, name), data, 0o600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func certManagerAirgapImages(mode string) []string {
+	if !settings.TLSNeedsCertManager(mode) {
+		return nil
+	}
+	version := settings.ConfiguredCertManagerVersion()
+	var images []string
+	for _, component := range []string{"controller", "webhook", "cainjector", "startupapicheck"} {
+		images = append(images, fmt.Sprintf("quay.io/jetstack/cert-manager-%s:%s", component, version))
+	}
+	return images
+}
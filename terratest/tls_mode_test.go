@@ -0,0 +1,183 @@
+package test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brudnak/ha-rancher-rke2/terratest/resolver"
+	"github.com/brudnak/ha-rancher-rke2/terratest/settings"
+	"github.com/spf13/viper"
+)
+
+func TestBuildAutoHelmCommandUsesTLSModeSettings(t *testing.T) {
+	cases := map[string]struct {
+		expected []string
+		rejected []string
+	}{
+		settings.TLSModeExternal: {
+			expected: []string{"--set tls=external \\\n", "--set agentTLSMode=system-store"},
+			rejected: []string{"ingress.tls.source"},
+		},
+		settings.TLSModeRancher: {
+			expected: []string{"--set ingress.tls.source=rancher \\\n", "--set agentTLSMode=strict"},
+			rejected: []string{"tls=external"},
+		},
+		settings.TLSModeLetsEncrypt: {
+			expected: []string{
+				"--set ingress.tls.source=letsEncrypt \\\n",
+				"--set-string 'letsEncrypt.email=qa@example.com' \\\n",
+				"--set letsEncrypt.ingress.class=nginx \\\n",
+				"--set agentTLSMode=system-store",
+			},
+			rejected: []string{"tls=external"},
+		},
+		settings.TLSModeSecret: {
+			expected: []string{"--set ingress.tls.source=secret \\\n", "--set privateCA=true \\\n", "--set agentTLSMode=strict"},
+			rejected: []string{"tls=external"},
+		},
+	}
+
+	for mode, tc := range cases {
+		viper.Reset()
+		viper.Set("tls.mode", mode)
+		viper.Set("tls.letsencrypt_email", "qa@example.com")
+
+		command := resolver.BuildHelmCommand(resolver.HelmOperationInstall, "rancher-latest", "2.13.4", "admin", "", "", "", false)
+		for _, snippet := range tc.expected {
+			if !strings.Contains(command, snippet) {
+				t.Fatalf("%s: expected %q in:\n%s", mode, snippet, command)
+			}
+		}
+		for _, snippet := range tc.rejected {
+			if strings.Contains(command, snippet) {
+				t.Fatalf("%s: did not expect %q in:\n%s", mode, snippet, command)
+			}
+		}
+		if err := validateRancherHelmCommandsForTLSMode(mode, []string{command}); err != nil {
+			t.Fatalf("%s: generated command failed its own preflight: %v", mode, err)
+		}
+	}
+	viper.Reset()
+}
+
+func TestValidateRancherHelmCommandsForTLSModeRejectsMismatchedFlags(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	cases := []struct {
+		mode     string
+		command  string
+		expected string
+	}{
+		{mode: settings.TLSModeRancher, command: "helm install rancher rancher-latest/rancher --set tls=external", expected: "must not set tls=external"},
+		{mode: settings.TLSModeRancher, command: "helm install rancher rancher-latest/rancher --set ingress.tls.source=secret", expected: "ingress.tls.source=rancher"},
+		{mode: settings.TLSModeRancher, command: "helm install rancher rancher-latest/rancher --set agentTLSMode=system-store", expected: "agentTLSMode=system-store"},
+		{mode: settings.TLSModeLetsEncrypt, command: "helm install rancher rancher-latest/rancher --set ingress.tls.source=letsEncrypt", expected: "letsEncrypt.email"},
+		{mode: settings.TLSModeSecret, command: "helm install rancher rancher-latest/rancher --set ingress.tls.source=secret", expected: "privateCA=true"},
+		{mode: settings.TLSModeExternal, command: "helm install rancher rancher-latest/rancher --set ingress.tls.source=rancher", expected: "must include --set tls=external"},
+	}
+	for _, tc := range cases {
+		err := validateRancherHelmCommandsForTLSMode(tc.mode, []string{tc.command})
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("%s: expected error containing %q, got %v", tc.mode, tc.expected, err)
+		}
+	}
+
+	if err := validateRancherHelmCommandsForTLSMode(settings.TLSModeRancher, []string{"helm install rancher rancher-latest/rancher \\\n  --set hostname=placeholder"}); err != nil {
+		t.Fatalf("expected rancher mode to accept the chart default source, got %v", err)
+	}
+}
+
+func TestRancherTLSSetupCommandsForSecretModeGeneratesCertificate(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Chdir(t.TempDir())
+	haDir := "high-availability-1"
+
+	setup, err := rancherTLSSetupCommands(settings.TLSModeSecret, haDir, "rancher.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, snippet := range []string{"create secret tls tls-rancher-ingress", "create secret generic tls-ca --from-file=cacerts.pem=cacerts.pem"} {
+		if !strings.Contains(setup, snippet) {
+			t.Fatalf("expected %q in setup:\n%s", snippet, setup)
+		}
+	}
+	if strings.Contains(setup, "cert-manager") {
+		t.Fatalf("secret mode should not install cert-manager:\n%s", setup)
+	}
+
+	caPEM, err := os.ReadFile(filepath.Join(haDir, rancherTLSCAFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := os.ReadFile(filepath.Join(haDir, rancherTLSCertFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatal("expected a PEM CA certificate")
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "rancher.example.com", Roots: roots}); err != nil {
+		t.Fatalf("expected serving certificate to verify against the generated CA: %v", err)
+	}
+
+	CreateInstallScript("helm install rancher rancher-latest/rancher", haDir, setup)
+	script, err := os.ReadFile(filepath.Join(haDir, "install.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Index(string(script), "tls-rancher-ingress") > strings.Index(string(script), "helm install rancher") {
+		t.Fatalf("expected TLS secrets before the Rancher install:\n%s", script)
+	}
+}
+
+func TestRancherTLSSetupCommandsInstallsCertManager(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("tls.cert_manager_version", "v1.17.0")
+
+	setup, err := rancherTLSSetupCommands(settings.TLSModeLetsEncrypt, t.TempDir(), "rancher.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, snippet := range []string{"helm upgrade --install cert-manager jetstack/cert-manager", "--version v1.17.0", "--set crds.enabled=true"} {
+		if !strings.Contains(setup, snippet) {
+			t.Fatalf("expected %q in setup:\n%s", snippet, setup)
+		}
+	}
+
+	if setup, err := rancherTLSSetupCommands(settings.TLSModeExternal, t.TempDir(), "rancher.example.com"); err != nil || setup != "" {
+		t.Fatalf("expected no setup for external mode, got %q (%v)", setup, err)
+	}
+}
+
+func TestValidateTLSConfigRejectsIncompleteModes(t *testing.T) {
+	cases := []struct {
+		config   map[string]interface{}
+		expected string
+	}{
+		{config: map[string]interface{}{"mode": "passthrough"}, expected: "tls.mode must be one of"},
+		{config: map[string]interface{}{"mode": "letsencrypt"}, expected: "tls.letsencrypt_email is required"},
+		{config: map[string]interface{}{"mode": "letsencrypt", "letsencrypt_email": "qa@example.com", "letsencrypt_environment": "test"}, expected: "production or staging"},
+		{config: map[string]interface{}{"mode": "secret", "cert_file": "/tmp/tls.crt"}, expected: "must be set together"},
+	}
+	for _, tc := range cases {
+		viper.Reset()
+		viper.Set("tls", tc.config)
+		if err := settings.ValidateTLSConfig(); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("expected error containing %q, got %v", tc.expected, err)
+		}
+	}
+	viper.Reset()
+}
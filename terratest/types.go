@@ -6,41 +6,19 @@ import (
 )
 
 type TerraformOutputs struct {
-	Server1IP        string
-	Server2IP        string
-	Server3IP        string
-	Server1PrivateIP string
-	Server2PrivateIP string
-	Server3PrivateIP string
+	ServerIPs        []string
+	ServerPrivateIPs []string
+	AgentIPs         []string
+	AgentPrivateIPs  []string
 	LoadBalancerDNS  string
 	RancherURL       string
 }
 
-type RancherResolvedPlan struct {
-	Mode                   string
-	RequestedVersion       string
-	RequestedDistro        string
-	BuildType              string
-	ResolvedDistro         string
-	ChartRepoAlias         string
-	ChartVersion           string
-	RancherImage           string
-	RancherImageTag        string
-	AgentImage             string
-	UseRancherImageFields  bool
-	CompatibilityBaseline  string
-	SupportMatrixURL       string
-	RecommendedRKE2Version string
-	InstallerSHA256        string
-	HelmCommands           []string
-	Explanation            []string
-}
-
-type helmSearchResult struct {
-	Name        string `json:"name"`
-	Version     string `json:"version"`
-	AppVersion  string `json:"app_version"`
-	Description string `json:"description"`
+func (o TerraformOutputs) FirstServerIP() string {
+	if len(o.ServerIPs) == 0 {
+		return ""
+	}
+	return o.ServerIPs[0]
 }
 
 type cleanupCostEstimate struct {
@@ -57,13 +35,6 @@ type cleanupCostEstimate struct {
 	EstimatedEBSCostUSD float64
 }
 
-type resolvedChartMatch struct {
-	repoAlias             string
-	chartVersion          string
-	compatibilityBaseline string
-	matchRank             int
-}
-
 var (
 	ssmClient *ssm.Client
 	ec2Client *ec2.Client
@@ -24,6 +24,12 @@ const cleanupConfirmEl = document.getElementById('cleanupConfirm')
 const cleanupBtnEl = document.getElementById('cleanupBtn')
 const openCleanupLogsBtnEl = document.getElementById('openCleanupLogsBtn')
 const cleanupCostEl = document.getElementById('cleanupCost')
+const jobsStatusEl = document.getElementById('jobsStatus')
+const jobActionsEl = document.getElementById('jobActions')
+const jobHistoryEl = document.getElementById('jobHistory')
+const nodesEl = document.getElementById('nodes')
+const nodesStatusEl = document.getElementById('nodesStatus')
+const workspaceSelectEl = document.getElementById('workspaceSelect')
 const themeToggleEl = document.getElementById('themeToggle')
 const themeSunIconEl = document.getElementById('themeSunIcon')
 const themeMoonIconEl = document.getElementById('themeMoonIcon')
@@ -45,6 +51,9 @@ let visibleLogText = ''
 let activeLogContext = null
 let activeLogLevel = 'all'
 let liveLogState = 'idle'
+let jobActions = []
+let panelNodes = []
+let nodeCommands = []
 
 const currentTheme = () => document.documentElement.classList.contains('dark') ? 'dark' : 'light'
 
@@ -179,6 +188,48 @@ const setLiveLogState = state => {
       container: 'border-rose-200 bg-rose-50 text-rose-700 dark:border-rose-500/30 dark:bg-rose-500/15 dark:text-rose-300',
       icon: 'bg-rose-500',
       button: 'Live disabled'
+    },
+    jobRunning: {
+      label: 'Job running',
+      container: 'border-sky-200 bg-sky-50 text-sky-700 dark:border-sky-500/30 dark:bg-sky-500/15 dark:text-sky-300',
+      icon: 'bg-sky-500 animate-pulse',
+      button: 'Live disabled'
+    },
+    jobSucceeded: {
+      label: 'Job completed',
+      container: 'border-emerald-200 bg-emerald-50 text-emerald-700 dark:border-emerald-500/30 dark:bg-emerald-500/15 dark:text-emerald-300',
+      icon: 'bg-emerald-500',
+      button: 'Live disabled'
+    },
+    jobFailed: {
+      label: 'Job failed',
+      container: 'border-rose-200 bg-rose-50 text-rose-700 dark:border-rose-500/30 dark:bg-rose-500/15 dark:text-rose-300',
+      icon: 'bg-rose-500',
+      button: 'Live disabled'
+    },
+    jobCanceled: {
+      label: 'Job canceled',
+      container: 'border-amber-200 bg-amber-50 text-amber-700 dark:border-amber-500/30 dark:bg-amber-500/15 dark:text-amber-300',
+      icon: 'bg-amber-500',
+      button: 'Live disabled'
+    },
+    commandRunning: {
+      label: 'Command running',
+      container: 'border-sky-200 bg-sky-50 text-sky-700 dark:border-sky-500/30 dark:bg-sky-500/15 dark:text-sky-300',
+      icon: 'bg-sky-500 animate-pulse',
+      button: 'Live disabled'
+    },
+    commandDone: {
+      label: 'Command completed',
+      container: 'border-emerald-200 bg-emerald-50 text-emerald-700 dark:border-emerald-500/30 dark:bg-emerald-500/15 dark:text-emerald-300',
+      icon: 'bg-emerald-500',
+      button: 'Live disabled'
+    },
+    commandFailed: {
+      label: 'Command failed',
+      container: 'border-rose-200 bg-rose-50 text-rose-700 dark:border-rose-500/30 dark:bg-rose-500/15 dark:text-rose-300',
+      icon: 'bg-rose-500',
+      button: 'Live disabled'
     }
   }
   const selected = states[state] || states.idle
@@ -187,7 +238,7 @@ const setLiveLogState = state => {
   liveLogStateIconEl.className = `h-2.5 w-2.5 rounded-full ${selected.icon}`
   liveLogStateLabelEl.textContent = selected.label
   stopStreamBtnEl.textContent = selected.button
-  stopStreamBtnEl.classList.toggle('hidden', state.startsWith('cleanup'))
+  stopStreamBtnEl.classList.toggle('hidden', state.startsWith('cleanup') || state.startsWith('job') || state.startsWith('command'))
 }
 
 const logFilename = () => {
@@ -196,6 +247,11 @@ const logFilename = () => {
     return `cleanup${filter}.log`
   }
 
+  if (['job', 'command', 'journal'].includes(activeLogContext?.mode)) {
+    const filter = logSearchEl.value.trim() ? '-filtered' : ''
+    return `${activeLogContext.podName}${filter}.log`
+  }
+
   const pod = activeLogContext?.podName || 'pod'
   const mode = activeLogContext?.mode || 'logs'
   const filter = logSearchEl.value.trim() ? '-filtered' : ''
@@ -244,8 +300,10 @@ const renderLogViewer = () => {
     : `${entries.length} lines`
 
   if (!filteredEntries.length || (filteredEntries.length === 1 && filteredEntries[0].line === '')) {
-    const waitingForLive = activeLogContext?.mode === 'live' && (liveLogState === 'connecting' || liveLogState === 'live')
-    const waitingForCleanup = activeLogContext?.mode === 'cleanup' && liveLogState === 'cleanupRunning'
+    const waitingForLive = ['live', 'journal'].includes(activeLogContext?.mode) && (liveLogState === 'connecting' || liveLogState === 'live')
+    const waitingForCleanup = (activeLogContext?.mode === 'cleanup' && liveLogState === 'cleanupRunning') ||
+      (activeLogContext?.mode === 'job' && liveLogState === 'jobRunning') ||
+      (activeLogContext?.mode === 'command' && liveLogState === 'commandRunning')
     const waiting = waitingForLive || waitingForCleanup
     logBoxEl.innerHTML = `
       <div class="flex h-full min-h-64 items-center justify-center rounded-xl border border-dashed border-zinc-300 bg-white text-sm text-zinc-500 dark:border-white/10 dark:bg-white/[0.03] dark:text-zinc-400">
@@ -267,7 +325,7 @@ const renderLogViewer = () => {
 }
 
 const appendLogLine = line => {
-  if (activeLogContext?.mode === 'live' && liveLogState !== 'live') {
+  if (['live', 'journal'].includes(activeLogContext?.mode) && liveLogState !== 'live') {
     setLiveLogState('live')
   }
   rawLogText = rawLogText ? `${rawLogText}\n${line}` : line
@@ -308,6 +366,56 @@ const statusFor = cluster => {
   }
 }
 
+const healthStyles = {
+  green: { label: 'Healthy', className: 'bg-emerald-100 text-emerald-700 dark:bg-emerald-500/15 dark:text-emerald-300' },
+  yellow: { label: 'Degraded', className: 'bg-amber-100 text-amber-700 dark:bg-amber-500/15 dark:text-amber-300' },
+  red: { label: 'Unhealthy', className: 'bg-rose-100 text-rose-700 dark:bg-rose-500/15 dark:text-rose-300' }
+}
+
+const healthBadge = cluster => {
+  const style = healthStyles[cluster.health?.score]
+  if (!style) {
+    return ''
+  }
+  return `<span title="${escapeHtml((cluster.health.reasons || []).join('\n'))}" class="inline-flex items-center rounded-full px-3 py-1.5 text-xs font-semibold ${style.className}">${style.label}</span>`
+}
+
+const healthChip = (label, ok) => `<span class="inline-flex items-center rounded-md px-2 py-1 text-xs font-semibold ${ok ? 'bg-emerald-100 text-emerald-700 dark:bg-emerald-500/15 dark:text-emerald-300' : 'bg-rose-100 text-rose-700 dark:bg-rose-500/15 dark:text-rose-300'}">${escapeHtml(label)}</span>`
+
+const renderHealth = cluster => {
+  const health = cluster.health
+  if (!health || cluster.provisioning) {
+    return ''
+  }
+
+  const reasons = (health.reasons || []).length
+    ? `<ul class="mt-2 list-disc space-y-1 pl-5 text-sm text-zinc-700 dark:text-zinc-300">${health.reasons.map(reason => `<li>${escapeHtml(reason)}</li>`).join('')}</ul>`
+    : '<div class="mt-2 text-sm text-zinc-500 dark:text-zinc-400">All checks passed.</div>'
+  const nodes = (health.nodes || []).map(node => {
+    const details = [node.roles, node.version, node.server ? `kube-apiserver ${node.server}` : '', ...(node.problems || []), node.unschedulable ? 'cordoned' : ''].filter(Boolean).join(' • ')
+    return `<div class="flex min-w-0 flex-wrap items-center gap-2">${healthChip(node.ready ? 'Ready' : 'NotReady', node.ready)}<span class="font-medium text-zinc-950 dark:text-zinc-100">${escapeHtml(node.name)}</span><span class="text-zinc-500 dark:text-zinc-400">${escapeHtml(details)}</span></div>`
+  }).join('')
+  const etcd = (health.etcd || []).map(member => healthChip(`${member.node || member.name}${member.restarts ? ` (${member.restarts} restarts)` : ''}`, member.ready)).join(' ')
+  const releases = (health.releases || []).map(release => healthChip(`${release.name} ${release.status}${release.revision ? ` r${release.revision}` : ''}`, release.status === 'deployed')).join(' ')
+  const conditions = (health.conditions || []).map(condition => `<span title="${escapeHtml(condition.message || condition.reason || '')}">${healthChip(`${condition.type}=${condition.status}`, condition.status === 'True')}</span>`).join(' ')
+
+  return `
+    <div class="mt-4 rounded-xl border border-zinc-200 p-4 dark:border-white/10">
+      <div class="flex items-center justify-between gap-3">
+        <div class="text-sm font-semibold text-zinc-950 dark:text-zinc-100">Health</div>
+        ${healthBadge(cluster)}
+      </div>
+      ${reasons}
+      <div class="mt-3 grid gap-3 text-sm">
+        ${nodes ? `<div><div class="text-xs font-semibold uppercase tracking-wide text-zinc-500 dark:text-zinc-400">Nodes</div><div class="mt-2 grid gap-2">${nodes}</div></div>` : ''}
+        ${etcd ? `<div><div class="text-xs font-semibold uppercase tracking-wide text-zinc-500 dark:text-zinc-400">etcd members</div><div class="mt-2 flex flex-wrap gap-2">${etcd}</div></div>` : ''}
+        ${releases ? `<div><div class="text-xs font-semibold uppercase tracking-wide text-zinc-500 dark:text-zinc-400">Helm releases</div><div class="mt-2 flex flex-wrap gap-2">${releases}</div></div>` : ''}
+        ${conditions ? `<div><div class="text-xs font-semibold uppercase tracking-wide text-zinc-500 dark:text-zinc-400">Cluster conditions</div><div class="mt-2 flex flex-wrap gap-2">${conditions}</div></div>` : ''}
+      </div>
+    </div>
+  `
+}
+
 const initializeCollapseState = cluster => {
   if (initializedCollapseState.has(cluster.id)) {
     return
@@ -499,6 +607,7 @@ const renderCluster = cluster => {
           ${renderKubeconfigActions(cluster)}
           <button type="button" data-action="toggle-cluster" data-cluster="${escapeHtml(cluster.id)}" class="rounded-lg border border-zinc-200 bg-white px-3 py-2 text-sm font-semibold text-zinc-700 hover:bg-zinc-50 dark:border-white/10 dark:bg-white/[0.06] dark:text-zinc-200 dark:hover:bg-white/[0.1]">${toggleText}</button>
           <span class="inline-flex items-center rounded-full px-3 py-1.5 text-xs font-semibold ${status.className}">${cluster.provisioning ? '<span class="spinner mr-2"></span>' : ''}${status.label}</span>
+          ${healthBadge(cluster)}
         </div>
       </div>
       ${clusterCollapsed ? '' : `
@@ -510,6 +619,7 @@ const renderCluster = cluster => {
           ${clusterID}
         </div>
         ${leaderSummary}
+        ${renderHealth(cluster)}
         ${renderPodsTable(cluster, pods, changedLeader)}
       `}
     </article>
@@ -666,6 +776,388 @@ const renderCleanup = cleanup => {
   }
 }
 
+const jobStatusBadge = status => {
+  const classes = {
+    running: 'bg-sky-100 text-sky-700 dark:bg-sky-500/15 dark:text-sky-300',
+    succeeded: 'bg-emerald-100 text-emerald-700 dark:bg-emerald-500/15 dark:text-emerald-300',
+    failed: 'bg-rose-100 text-rose-700 dark:bg-rose-500/15 dark:text-rose-300',
+    canceled: 'bg-amber-100 text-amber-700 dark:bg-amber-500/15 dark:text-amber-300'
+  }
+  return `<span class="inline-flex items-center rounded-full px-2.5 py-1 text-xs font-semibold ${classes[status] || classes.canceled}">${status === 'running' ? '<span class="spinner mr-2"></span>' : ''}${escapeHtml(status)}</span>`
+}
+
+const jobLogState = status => ({
+  running: 'jobRunning',
+  succeeded: 'jobSucceeded',
+  failed: 'jobFailed',
+  canceled: 'jobCanceled'
+})[status] || 'idle'
+
+const renderJobActions = () => {
+  const actions = jobActions.filter(action => action.name !== 'cleanup')
+  if (!actions.length) {
+    jobActionsEl.innerHTML = '<div class="text-sm text-zinc-500 dark:text-zinc-400">No lifecycle actions are available.</div>'
+    return
+  }
+
+  jobActionsEl.innerHTML = actions.map(action => {
+    const inputs = (action.inputs || []).map(input => {
+      const listId = `job-${action.name}-${input.name}-options`
+      const options = Array.isArray(input.options) && input.options.length
+        ? `<datalist id="${listId}">${input.options.map(option => `<option value="${escapeHtml(option)}"></option>`).join('')}</datalist>`
+        : ''
+      return `
+        <label class="grid gap-1 text-xs font-semibold text-zinc-600 dark:text-zinc-400">
+          ${escapeHtml(input.label)}
+          <input data-job-input="${escapeHtml(input.name)}" type="text" autocomplete="off" ${options ? `list="${listId}"` : ''} class="w-full rounded-lg border border-zinc-200 bg-white px-3 py-2 text-sm font-medium text-zinc-950 outline-none focus:border-emerald-400 dark:border-white/10 dark:bg-zinc-950/50 dark:text-zinc-100" />
+          ${options}
+        </label>
+      `
+    }).join('')
+    const confirm = action.confirm
+      ? `<input data-job-confirm type="text" autocomplete="off" placeholder='Type "${escapeHtml(action.confirm)}" to enable' class="w-full rounded-lg border border-zinc-200 bg-white px-3 py-2 text-sm font-medium text-zinc-950 outline-none focus:border-emerald-400 dark:border-white/10 dark:bg-zinc-950/50 dark:text-zinc-100" />`
+      : ''
+    return `
+      <form data-job-action="${escapeHtml(action.name)}" class="grid content-start gap-3 rounded-xl border border-zinc-200 bg-zinc-50 p-4 dark:border-white/10 dark:bg-white/[0.04]">
+        <div>
+          <div class="text-sm font-semibold text-zinc-950 dark:text-zinc-50">${escapeHtml(action.label)}</div>
+          <div class="mt-1 break-all font-mono text-[11px] text-zinc-500 dark:text-zinc-400">${escapeHtml(action.testName)} • ${escapeHtml(action.timeout)}</div>
+        </div>
+        ${inputs}
+        ${confirm}
+        <button type="submit" class="rounded-lg bg-emerald-500 px-4 py-2 text-sm font-semibold text-white shadow-sm shadow-emerald-500/20 hover:bg-emerald-400 disabled:bg-zinc-200 disabled:text-zinc-500 disabled:shadow-none dark:disabled:bg-white/[0.06] dark:disabled:text-zinc-400">Run</button>
+      </form>
+    `
+  }).join('')
+}
+
+const renderJobs = jobs => {
+  const items = Array.isArray(jobs) ? jobs : []
+  const running = items.find(job => job.status === 'running')
+
+  jobsStatusEl.innerHTML = running
+    ? `<span class="spinner mr-2"></span>${escapeHtml(running.label)} running since ${new Date(running.startedAt).toLocaleTimeString()}`
+    : 'Idle'
+  jobActionsEl.querySelectorAll('button[type="submit"]').forEach(button => {
+    button.disabled = Boolean(running)
+  })
+
+  if (!items.length) {
+    jobHistoryEl.innerHTML = '<div class="text-sm text-zinc-500 dark:text-zinc-400">No jobs have run in this panel session.</div>'
+    return
+  }
+
+  jobHistoryEl.innerHTML = items.map(job => {
+    const inputs = Object.entries(job.inputs || {}).map(([name, value]) => `${escapeHtml(name)}=${escapeHtml(value)}`).join(' ')
+    const workspace = job.workspace && job.workspace !== 'default' ? ` • workspace ${escapeHtml(job.workspace)}` : ''
+    const finished = job.finishedAt ? ` • finished ${new Date(job.finishedAt).toLocaleTimeString()}` : ''
+    return `
+      <div class="flex flex-col gap-2 rounded-lg border border-zinc-200 px-3 py-2 text-sm dark:border-white/10 sm:flex-row sm:items-center sm:justify-between">
+        <div class="min-w-0">
+          <div class="font-semibold text-zinc-950 dark:text-zinc-50">#${job.id} ${escapeHtml(job.label)} ${inputs ? `<span class="font-mono text-xs font-normal text-zinc-500 dark:text-zinc-400">${inputs}</span>` : ''}</div>
+          <div class="text-xs text-zinc-500 dark:text-zinc-400">started ${new Date(job.startedAt).toLocaleTimeString()}${workspace}${finished}${job.error ? ` • ${escapeHtml(job.error)}` : ''}</div>
+        </div>
+        <div class="flex shrink-0 items-center gap-2">
+          ${jobStatusBadge(job.status)}
+          <button type="button" data-job-logs="${job.id}" class="rounded-lg border border-zinc-200 bg-white px-3 py-1.5 text-xs font-semibold text-zinc-700 hover:bg-zinc-50 dark:border-white/10 dark:bg-white/[0.06] dark:text-zinc-200 dark:hover:bg-white/[0.1]">Output</button>
+          ${job.status === 'running' ? `<button type="button" data-job-cancel="${job.id}" class="rounded-lg border border-rose-200 bg-white px-3 py-1.5 text-xs font-semibold text-rose-700 hover:bg-rose-50 dark:border-rose-500/30 dark:bg-white/[0.06] dark:text-rose-300">Cancel</button>` : ''}
+        </div>
+      </div>
+    `
+  }).join('')
+}
+
+const loadJobActions = async () => {
+  const response = await fetch('/api/jobs', {
+    cache: 'no-store',
+    headers: {
+      'Accept': 'application/json',
+      'X-Control-Panel-Token': token
+    }
+  })
+  if (!response.ok) {
+    jobActionsEl.innerHTML = `<div class="text-sm text-rose-600 dark:text-rose-300">${escapeHtml(await response.text())}</div>`
+    return
+  }
+  const payload = await response.json()
+  jobActions = Array.isArray(payload.actions) ? payload.actions : []
+  renderJobActions()
+  renderJobs(payload.jobs)
+}
+
+const openJobLogs = (jobId, label) => {
+  stopStream({ internal: true })
+  activeLogContext = { mode: 'job', clusterId: 'local', namespace: 'terratest', podName: `job-${jobId}` }
+  logModalKindEl.textContent = 'Job output'
+  logModalTitleEl.textContent = label || `Job ${jobId}`
+  logModalSubtitleEl.textContent = `Job #${jobId}`
+  openLogViewerBtnEl.classList.remove('hidden')
+  setLiveLogState('jobRunning')
+  rawLogText = ''
+  renderLogViewer()
+  openLogModal()
+
+  const params = new URLSearchParams({ token, id: String(jobId) })
+  stream = new EventSource(`/api/jobs/stream?${params.toString()}`)
+  stream.addEventListener('line', event => {
+    appendLogLine(event.data)
+  })
+  stream.addEventListener('status', event => {
+    try {
+      const job = JSON.parse(event.data)
+      logModalSubtitleEl.textContent = `Job #${job.id} • ${job.command}`
+      setLiveLogState(jobLogState(job.status))
+    } catch (_) {
+      setLiveLogState('jobFailed')
+    }
+  })
+  stream.addEventListener('end', () => {
+    if (stream) {
+      stream.close()
+      stream = null
+    }
+    refresh()
+  })
+  stream.addEventListener('error', () => {
+    if (stream && stream.readyState === EventSource.CLOSED) {
+      stream = null
+    }
+  })
+}
+
+const runJob = async form => {
+  const action = form.dataset.jobAction
+  const inputs = {}
+  form.querySelectorAll('input[data-job-input]').forEach(input => {
+    inputs[input.dataset.jobInput] = input.value.trim()
+  })
+  const confirmEl = form.querySelector('input[data-job-confirm]')
+
+  const response = await fetch('/api/jobs', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      'X-Control-Panel-Token': token
+    },
+    body: JSON.stringify({ action, confirm: confirmEl ? confirmEl.value.trim() : '', inputs })
+  })
+  if (!response.ok) {
+    jobsStatusEl.textContent = await response.text()
+    return
+  }
+
+  const job = await response.json()
+  if (confirmEl) {
+    confirmEl.value = ''
+  }
+  openJobLogs(job.id, job.label)
+  refresh()
+}
+
+const cancelJob = async jobId => {
+  const response = await fetch(`/api/jobs/cancel?id=${encodeURIComponent(jobId)}`, {
+    method: 'POST',
+    headers: {
+      'X-Control-Panel-Token': token
+    }
+  })
+  jobsStatusEl.textContent = response.ok ? `Canceling job #${jobId}...` : await response.text()
+  refresh()
+}
+
+const renderNodes = () => {
+  if (!panelNodes.length) {
+    nodesEl.innerHTML = '<div class="text-sm text-zinc-500 dark:text-zinc-400">No nodes were found in the Terraform outputs.</div>'
+    return
+  }
+
+  const commandButtons = node => nodeCommands.map(command => `
+    <button type="button" data-node-command="${escapeHtml(command.name)}" data-node="${escapeHtml(node.id)}" title="${escapeHtml(command.description)}" class="rounded-lg border border-zinc-200 bg-white px-3 py-1.5 text-xs font-semibold text-zinc-700 hover:bg-zinc-50 dark:border-white/10 dark:bg-white/[0.06] dark:text-zinc-200 dark:hover:bg-white/[0.1]">${escapeHtml(command.label)}</button>
+  `).join('')
+
+  nodesEl.innerHTML = panelNodes.map(node => `
+    <div class="flex flex-col gap-2 rounded-lg border border-zinc-200 px-3 py-2 text-sm dark:border-white/10 lg:flex-row lg:items-center lg:justify-between">
+      <div class="min-w-0">
+        <div class="font-semibold text-zinc-950 dark:text-zinc-50">HA ${node.haIndex} ${escapeHtml(node.name)} <span class="ml-1 rounded-full bg-zinc-100 px-2 py-0.5 text-[11px] font-semibold text-zinc-600 dark:bg-white/[0.06] dark:text-zinc-300">${escapeHtml(node.role)}</span></div>
+        <div class="font-mono text-xs text-zinc-500 dark:text-zinc-400">${escapeHtml(node.publicIp)}${node.privateIp ? ` • ${escapeHtml(node.privateIp)}` : ''} • ${escapeHtml(node.unit)}</div>
+      </div>
+      <div class="flex flex-wrap items-center gap-2">
+        <button type="button" data-node-journal="${escapeHtml(node.id)}" class="rounded-lg bg-emerald-500 px-3 py-1.5 text-xs font-semibold text-white shadow-sm shadow-emerald-500/20 hover:bg-emerald-400">Journal</button>
+        ${commandButtons(node)}
+      </div>
+    </div>
+  `).join('')
+}
+
+const loadNodes = async () => {
+  const response = await fetch('/api/nodes', {
+    cache: 'no-store',
+    headers: {
+      'Accept': 'application/json',
+      'X-Control-Panel-Token': token
+    }
+  })
+  if (!response.ok) {
+    nodesEl.innerHTML = `<div class="text-sm text-rose-600 dark:text-rose-300">${escapeHtml(await response.text())}</div>`
+    return
+  }
+  const payload = await response.json()
+  panelNodes = Array.isArray(payload.nodes) ? payload.nodes : []
+  nodeCommands = Array.isArray(payload.commands) ? payload.commands : []
+  nodesStatusEl.textContent = panelNodes.length ? `${panelNodes.length} nodes` : ''
+  renderNodes()
+}
+
+const setNodeLogContext = (mode, node, title) => {
+  activeLogContext = { mode, clusterId: `ha-${node.haIndex}`, namespace: node.publicIp, podName: `${node.id}-${title}`, nodeId: node.id }
+  logModalKindEl.textContent = mode === 'journal' ? 'Node journal' : 'Node diagnostics'
+  logModalTitleEl.textContent = `${node.name} • ${title}`
+  logModalSubtitleEl.textContent = `${node.publicIp} • ha-${node.haIndex} • ${mode === 'journal' ? `following ${node.unit}` : 'read-only command'}`
+  openLogViewerBtnEl.classList.remove('hidden')
+}
+
+const runNodeCommand = async (nodeId, commandName) => {
+  const node = panelNodes.find(item => item.id === nodeId)
+  const command = nodeCommands.find(item => item.name === commandName)
+  if (!node || !command) {
+    return
+  }
+
+  stopStream({ internal: true })
+  setNodeLogContext('command', node, command.name)
+  setLiveLogState('commandRunning')
+  rawLogText = ''
+  renderLogViewer()
+  openLogModal()
+  logStatusEl.textContent = `Running ${command.label} on ${node.name}...`
+
+  const response = await fetch('/api/nodes/run', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      'X-Control-Panel-Token': token
+    },
+    body: JSON.stringify({ node: nodeId, command: commandName })
+  })
+  if (activeLogContext?.nodeId !== nodeId || activeLogContext?.mode !== 'command') {
+    return
+  }
+  if (!response.ok) {
+    rawLogText = await response.text()
+    setLiveLogState('commandFailed')
+    renderLogViewer()
+    logStatusEl.textContent = `${command.label} failed on ${node.name}`
+    return
+  }
+
+  const result = await response.json()
+  rawLogText = (result.output || '').replace(/\n+$/, '')
+  setLiveLogState('commandDone')
+  renderLogViewer()
+  logStatusEl.textContent = `${command.label} on ${node.name}`
+}
+
+// SSM and SSH do not keep a session open, so the server polls journalctl for
+// entries after the last cursor and pushes them over the event stream.
+const streamJournal = nodeId => {
+  const node = panelNodes.find(item => item.id === nodeId)
+  if (!node) {
+    return
+  }
+
+  stopStream({ internal: true })
+  setNodeLogContext('journal', node, 'journal')
+  setLiveLogState('connecting')
+  rawLogText = ''
+  renderLogViewer()
+  openLogModal()
+  logStatusEl.textContent = `Following ${node.unit} on ${node.name}...`
+
+  const params = new URLSearchParams({ token, node: nodeId })
+  stream = new EventSource(`/api/nodes/journal/stream?${params.toString()}`)
+  stream.addEventListener('line', event => {
+    appendLogLine(event.data)
+  })
+  stream.addEventListener('error', event => {
+    setLiveLogState('error')
+    if (event.data) {
+      appendLogLine(`[error] ${event.data}`)
+    }
+  })
+  stream.addEventListener('end', () => {
+    logStatusEl.textContent = `Journal follow finished for ${node.name}`
+    if (stream) {
+      stream.close()
+      stream = null
+    }
+    setLiveLogState('stopped')
+  })
+}
+
+const renderWorkspaces = payload => {
+  const items = Array.isArray(payload.items) ? payload.items : []
+  workspaceSelectEl.innerHTML = items.map(item => `
+    <option value="${escapeHtml(item.name)}" ${item.configured ? '' : 'disabled'} title="${escapeHtml(item.configPath)}">${escapeHtml(item.name)}${item.configured ? '' : ' (no tool-config.yml)'}</option>
+  `).join('')
+  workspaceSelectEl.value = payload.active || 'default'
+}
+
+const loadWorkspaces = async () => {
+  const response = await fetch('/api/workspaces', {
+    cache: 'no-store',
+    headers: {
+      'Accept': 'application/json',
+      'X-Control-Panel-Token': token
+    }
+  })
+  if (response.ok) {
+    renderWorkspaces(await response.json())
+  }
+}
+
+// Switching reloads the workspace's tool-config.yml on the server, so the
+// clusters, nodes and actions all change with it.
+const switchWorkspace = async name => {
+  refreshStatusEl.textContent = `Switching to workspace ${name}...`
+  const response = await fetch('/api/workspaces', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      'X-Control-Panel-Token': token
+    },
+    body: JSON.stringify({ name })
+  })
+  if (!response.ok) {
+    refreshStatusEl.textContent = await response.text()
+    workspaceSelectEl.value = lastState?.workspace || 'default'
+    return
+  }
+
+  renderWorkspaces(await response.json())
+  collapsedClusters = new Map()
+  collapsedPods = new Map()
+  initializedCollapseState = new Set()
+  previousLeaders = new Map()
+  pendingLeaderHighlights = new Map()
+  refresh()
+  loadNodes()
+  loadJobActions()
+}
+
+const applyState = (state, label) => {
+  lastState = state
+  if (state.workspace && workspaceSelectEl.value !== state.workspace) {
+    loadWorkspaces()
+  }
+  updateLeaderTracking(state)
+  renderClusters(state)
+  renderCleanup(state.cleanup)
+  renderJobs(state.jobs)
+  refreshStatusEl.textContent = lastLeaderChangeMessage
+    ? `${lastLeaderChangeMessage} • ${new Date().toLocaleTimeString()}`
+    : `${label} at ${new Date().toLocaleTimeString()}`
+}
+
 const refresh = async () => {
   if (refreshInFlight) {
     return
@@ -675,14 +1167,7 @@ const refresh = async () => {
   refreshStatusEl.textContent = 'Refreshing...'
 
   try {
-    const state = await fetchState()
-    lastState = state
-    updateLeaderTracking(state)
-    renderClusters(state)
-    renderCleanup(state.cleanup)
-    refreshStatusEl.textContent = lastLeaderChangeMessage
-      ? `${lastLeaderChangeMessage} • ${new Date().toLocaleTimeString()}`
-      : `Last refreshed at ${new Date().toLocaleTimeString()}`
+    applyState(await fetchState(), 'Last refreshed')
   } catch (error) {
     refreshStatusEl.textContent = error instanceof Error ? error.message : 'Refresh failed'
   } finally {
@@ -690,12 +1175,56 @@ const refresh = async () => {
   }
 }
 
+let stateStream = null
+let pollTimer = null
+
+const startPolling = () => {
+  if (!pollTimer) {
+    pollTimer = window.setInterval(refresh, 5000)
+  }
+}
+
+const stopPolling = () => {
+  if (pollTimer) {
+    window.clearInterval(pollTimer)
+    pollTimer = null
+  }
+}
+
+// The server pushes state when pods, leases, clusters, or jobs change. Polling
+// only covers the gap while the browser reconnects the stream.
+const watchState = () => {
+  if (!window.EventSource) {
+    refresh()
+    startPolling()
+    return
+  }
+
+  const params = new URLSearchParams({ token })
+  stateStream = new EventSource(`/api/state/stream?${params.toString()}`)
+  stateStream.addEventListener('state', event => {
+    stopPolling()
+    try {
+      applyState(JSON.parse(event.data), 'Live update')
+    } catch (_) {
+      refreshStatusEl.textContent = 'Received an unreadable state update'
+    }
+  })
+  stateStream.addEventListener('error', () => {
+    startPolling()
+  })
+}
+
 const stopStream = (options = {}) => {
-  if (!options.internal && activeLogContext?.mode === 'live' && (liveLogState === 'stopped' || liveLogState === 'error')) {
+  if (!options.internal && ['live', 'journal'].includes(activeLogContext?.mode) && (liveLogState === 'stopped' || liveLogState === 'error')) {
     if (stream) {
       stream.close()
       stream = null
     }
+    if (activeLogContext.mode === 'journal') {
+      streamJournal(activeLogContext.nodeId)
+      return
+    }
     streamLogs(activeLogContext.clusterId, activeLogContext.namespace, activeLogContext.podName, { preserveLogs: true })
     return
   }
@@ -711,6 +1240,9 @@ const stopStream = (options = {}) => {
   logModalSubtitleEl.textContent = activeLogContext
     ? `${activeLogContext.namespace} • ${activeLogContext.clusterId} • live stream stopped`
     : 'Live log stream stopped.'
+  if (activeLogContext?.mode === 'journal') {
+    logModalSubtitleEl.textContent = `${activeLogContext.namespace} • ${activeLogContext.clusterId} • journal follow stopped`
+  }
 }
 
 const loadLogs = async (clusterId, namespace, podName) => {
@@ -905,13 +1437,13 @@ const runCleanup = async () => {
     return
   }
 
-  const response = await fetch('/api/cleanup', {
+  const response = await fetch('/api/jobs', {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
       'X-Control-Panel-Token': token
     },
-    body: JSON.stringify({ confirm: confirmValue })
+    body: JSON.stringify({ action: 'cleanup', confirm: confirmValue })
   })
 
   if (!response.ok) {
@@ -990,11 +1522,53 @@ clustersEl.addEventListener('click', event => {
   }
 })
 
+jobActionsEl.addEventListener('submit', event => {
+  event.preventDefault()
+  const form = event.target.closest('form[data-job-action]')
+  if (form) {
+    runJob(form)
+  }
+})
+
+nodesEl.addEventListener('click', event => {
+  const journalButton = event.target.closest('button[data-node-journal]')
+  if (journalButton) {
+    streamJournal(journalButton.dataset.nodeJournal)
+    return
+  }
+
+  const commandButton = event.target.closest('button[data-node-command]')
+  if (commandButton) {
+    runNodeCommand(commandButton.dataset.node, commandButton.dataset.nodeCommand)
+  }
+})
+
+jobHistoryEl.addEventListener('click', event => {
+  const logsButton = event.target.closest('button[data-job-logs]')
+  if (logsButton) {
+    const job = (lastState?.jobs || []).find(item => String(item.id) === logsButton.dataset.jobLogs)
+    openJobLogs(logsButton.dataset.jobLogs, job?.label)
+    return
+  }
+
+  const cancelButton = event.target.closest('button[data-job-cancel]')
+  if (cancelButton) {
+    cancelJob(cancelButton.dataset.jobCancel)
+  }
+})
+
+workspaceSelectEl.addEventListener('change', () => {
+  switchWorkspace(workspaceSelectEl.value)
+})
+
 themeToggleEl.addEventListener('click', () => {
   setTheme(currentTheme() === 'dark' ? 'light' : 'dark')
 })
 
-document.getElementById('refreshBtn').addEventListener('click', refresh)
+document.getElementById('refreshBtn').addEventListener('click', () => {
+  refresh()
+  loadNodes()
+})
 document.getElementById('cleanupBtn').addEventListener('click', runCleanup)
 openCleanupLogsBtnEl.addEventListener('click', openCleanupLogs)
 document.getElementById('stopStreamBtn').addEventListener('click', stopStream)
@@ -1036,5 +1610,7 @@ document.body.addEventListener('htmx:afterRequest', event => {
 
 setLiveLogState('idle')
 setTheme(currentTheme())
-refresh()
-window.setInterval(refresh, 5000)
+loadWorkspaces()
+loadJobActions()
+loadNodes()
+watchState()
@@ -182,7 +182,7 @@ func TestHAWaitWebhookChartVersion(t *testing.T) {
 	errCh := make(chan error, totalHAs+len(records))
 	for i := 1; i <= totalHAs; i++ {
 		instanceNum := i
-		kubeconfigPath := filepath.Join(fmt.Sprintf("high-availability-%d", instanceNum), "kube_config.yaml")
+		kubeconfigPath := filepath.Join(haInstanceDir(instanceNum), "kube_config.yaml")
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -220,7 +220,7 @@ func TestHAWaitWebhookChartVersion(t *testing.T) {
 }
 
 func overrideLocalWebhook(instanceNum int, webhookImage string) error {
-	haDir := fmt.Sprintf("high-availability-%d", instanceNum)
+	haDir := haInstanceDir(instanceNum)
 	currentDir, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
@@ -364,11 +364,7 @@ func ensureDownstreamKubeconfig(record downstreamOutputRecord) (string, error) {
 	if record.ManagementClusterID == "" {
 		return "", fmt.Errorf("downstream kubeconfig missing for HA %d and management_cluster_id is empty; rerun TestHAProvisionLinodeDownstream", record.HAIndex)
 	}
-	adminToken, err := createRancherAdminToken(record.RancherHost, viper.GetString("rancher.bootstrap_password"))
-	if err != nil {
-		return "", err
-	}
-	kubeconfig, err := generateRancherKubeconfig(record.RancherHost, adminToken, record.ManagementClusterID)
+	kubeconfig, err := generateKubeconfigWithTemporaryToken(record.HAIndex, record.RancherHost, viper.GetString("rancher.bootstrap_password"), record.ManagementClusterID)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,145 @@
+package test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Named workspaces let several stacks live side by side, for example a repro on
+// one Rancher line and a sign-off on another. The default workspace keeps the
+// original layout: tool-config.yml at the repo root, high-availability-N in
+// terratest/ and the default Terraform state. A named workspace keeps all of
+// that under environments/<name>/ and uses the Terraform workspace <name>.
+const (
+	defaultWorkspace  = "default"
+	workspaceEnvVar   = "HA_ENV"
+	workspacesDirName = "environments"
+)
+
+var (
+	workspaceFlag        = flag.String("env", "", "named workspace under environments/ (same as "+workspaceEnvVar+")")
+	workspaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,39}$`)
+
+	workspaceMu   sync.RWMutex
+	workspaceName string
+)
+
+type workspaceView struct {
+	Name       string `json:"name"`
+	ConfigPath string `json:"configPath"`
+	Configured bool   `json:"configured"`
+	Active     bool   `json:"active"`
+}
+
+// resolveWorkspaceName reads --env, then HA_ENV. The default workspace is
+// returned as an empty name.
+func resolveWorkspaceName() (string, error) {
+	name := strings.TrimSpace(*workspaceFlag)
+	if name == "" {
+		name = strings.TrimSpace(os.Getenv(workspaceEnvVar))
+	}
+	return normalizeWorkspaceName(name)
+}
+
+func normalizeWorkspaceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == defaultWorkspace {
+		return "", nil
+	}
+	if !workspaceNamePattern.MatchString(name) {
+		return "", fmt.Errorf("workspace name %q must start with a letter or digit and contain only letters, digits, '.', '_' or '-' (at most 40 characters)", name)
+	}
+	return name, nil
+}
+
+func useWorkspace(name string) {
+	workspaceMu.Lock()
+	defer workspaceMu.Unlock()
+	workspaceName = name
+}
+
+func activeWorkspace() string {
+	workspaceMu.RLock()
+	defer workspaceMu.RUnlock()
+	return workspaceName
+}
+
+func workspaceLabel(name string) string {
+	if name == "" {
+		return defaultWorkspace
+	}
+	return name
+}
+
+// workspaceRoot is the directory holding a workspace's tool-config.yml,
+// relative to terratest/ where the lifecycle tests run.
+func workspaceRoot(name string) string {
+	if name == "" {
+		return ".."
+	}
+	return filepath.Join("..", workspacesDirName, name)
+}
+
+func workspaceToolConfigPath(name string) string {
+	return filepath.Join(workspaceRoot(name), "tool-config.yml")
+}
+
+func haInstanceDir(instanceNum int) string {
+	return haInstanceDirFor(activeWorkspace(), instanceNum)
+}
+
+func haInstanceDirFor(name string, instanceNum int) string {
+	dir := fmt.Sprintf("high-availability-%d", instanceNum)
+	if name == "" {
+		return dir
+	}
+	return filepath.Join(workspaceRoot(name), dir)
+}
+
+// terraformDataDir is the TF_DATA_DIR for a named workspace. Giving each
+// workspace its own data dir keeps the backend settings and the selected
+// Terraform workspace apart, so two stacks can run from one checkout.
+func terraformDataDir(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	return filepath.Abs(filepath.Join(workspaceRoot(name), ".terraform"))
+}
+
+// listWorkspaces returns the default workspace followed by every directory
+// under environments/ in repoRoot.
+func listWorkspaces(repoRoot string) []workspaceView {
+	active := activeWorkspace()
+	rootConfig := filepath.Join(repoRoot, "tool-config.yml")
+	views := []workspaceView{{
+		Name:       defaultWorkspace,
+		ConfigPath: rootConfig,
+		Configured: fileExists(rootConfig),
+		Active:     active == "",
+	}}
+
+	entries, _ := os.ReadDir(filepath.Join(repoRoot, workspacesDirName))
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && workspaceNamePattern.MatchString(entry.Name()) && entry.Name() != defaultWorkspace {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		configPath := filepath.Join(repoRoot, workspacesDirName, name, "tool-config.yml")
+		views = append(views, workspaceView{
+			Name:       name,
+			ConfigPath: configPath,
+			Configured: fileExists(configPath),
+			Active:     active == name,
+		})
+	}
+	return views
+}
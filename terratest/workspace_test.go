@@ -0,0 +1,334 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// newWorkspaceRepo lays out a repo with a default tool-config.yml, a "repro"
+// workspace and an unconfigured "scratch" workspace, and runs the test from
+// its terratest directory like go test does.
+func newWorkspaceRepo(t *testing.T) string {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Cleanup(func() { useWorkspace("") })
+
+	repoRoot := t.TempDir()
+	files := map[string]string{
+		"go.mod":                                  "module example\n",
+		"tool-config.yml":                         "total_has: 1\n",
+		"environments/repro/tool-config.yml":      "total_has: 2\nrancher:\n  version: 2.12.3\n",
+		"environments/scratch/notes.txt":          "no config yet\n",
+		"environments/bad/tool-config.yml":        "total_has: 0\n",
+		"terratest/high-availability-1/keep.yaml": "default\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repoRoot, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(filepath.Join(repoRoot, "terratest"))
+	t.Setenv("GITHUB_WORKSPACE", "")
+	return repoRoot
+}
+
+func TestResolveWorkspaceName(t *testing.T) {
+	original := *workspaceFlag
+	t.Cleanup(func() { *workspaceFlag = original })
+
+	*workspaceFlag = ""
+	t.Setenv(workspaceEnvVar, "")
+	if name, err := resolveWorkspaceName(); err != nil || name != "" {
+		t.Fatalf("expected the default workspace, got %q %v", name, err)
+	}
+
+	t.Setenv(workspaceEnvVar, "2.12-repro")
+	if name, err := resolveWorkspaceName(); err != nil || name != "2.12-repro" {
+		t.Fatalf("expected HA_ENV to select the workspace, got %q %v", name, err)
+	}
+
+	*workspaceFlag = "signoff"
+	if name, err := resolveWorkspaceName(); err != nil || name != "signoff" {
+		t.Fatalf("expected --env to win over HA_ENV, got %q %v", name, err)
+	}
+
+	*workspaceFlag = "default"
+	if name, err := resolveWorkspaceName(); err != nil || name != "" {
+		t.Fatalf("expected default to select the default workspace, got %q %v", name, err)
+	}
+
+	for _, bad := range []string{"../escape", ".hidden", "a/b", strings.Repeat("x", 41)} {
+		*workspaceFlag = bad
+		if _, err := resolveWorkspaceName(); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestWorkspacePaths(t *testing.T) {
+	t.Cleanup(func() { useWorkspace("") })
+	t.Setenv("GITHUB_WORKSPACE", "")
+
+	if got := haInstanceDir(2); got != "high-availability-2" {
+		t.Fatalf("expected the default layout, got %q", got)
+	}
+	if dir, err := terraformDataDir(""); err != nil || dir != "" {
+		t.Fatalf("expected the default workspace to keep modules/aws/.terraform, got %q %v", dir, err)
+	}
+
+	useWorkspace("repro")
+	want := filepath.Join("..", "environments", "repro")
+	if got := haInstanceDir(2); got != filepath.Join(want, "high-availability-2") {
+		t.Fatalf("unexpected HA dir %q", got)
+	}
+	if got := automationOutputDir(); got != filepath.Join(want, "automation-output") {
+		t.Fatalf("unexpected automation output dir %q", got)
+	}
+	if got := haSetupCheckpointPath(1); got != filepath.Join(want, "high-availability-1", haSetupCheckpointFile) {
+		t.Fatalf("unexpected checkpoint path %q", got)
+	}
+	if got := workspaceToolConfigPath("repro"); got != filepath.Join(want, "tool-config.yml") {
+		t.Fatalf("unexpected tool-config path %q", got)
+	}
+	dataDir, err := terraformDataDir("repro")
+	if err != nil || !filepath.IsAbs(dataDir) || !strings.HasSuffix(dataDir, filepath.Join("environments", "repro", ".terraform")) {
+		t.Fatalf("expected an absolute TF_DATA_DIR, got %q %v", dataDir, err)
+	}
+}
+
+func TestLoadWorkspaceConfig(t *testing.T) {
+	newWorkspaceRepo(t)
+
+	if err := loadWorkspaceConfig("repro"); err != nil {
+		t.Fatal(err)
+	}
+	if activeWorkspace() != "repro" || viper.GetInt("total_has") != 2 || viper.GetString("rancher.version") != "2.12.3" {
+		t.Fatalf("expected the repro config, got workspace %q total_has %d", activeWorkspace(), viper.GetInt("total_has"))
+	}
+
+	err := loadWorkspaceConfig("scratch")
+	if err == nil || !strings.Contains(err.Error(), filepath.Join("environments", "scratch", "tool-config.yml")) {
+		t.Fatalf("expected a missing config to name its path, got %v", err)
+	}
+	if activeWorkspace() != "repro" {
+		t.Fatalf("expected a failed load to keep the previous workspace, got %q", activeWorkspace())
+	}
+
+	if err := loadWorkspaceConfig(""); err != nil || viper.GetInt("total_has") != 1 {
+		t.Fatalf("expected the default config, got %d %v", viper.GetInt("total_has"), err)
+	}
+}
+
+func TestCleanupIsScopedToTheActiveWorkspace(t *testing.T) {
+	repoRoot := newWorkspaceRepo(t)
+	paths := []string{
+		"modules/aws/terraform.tfstate",
+		"modules/aws/.terraform/terraform.tfstate",
+		"modules/aws/terraform.tfstate.d/repro/terraform.tfstate",
+		"environments/repro/.terraform/environment",
+		"environments/repro/high-availability-1/kube_config.yaml",
+		"environments/repro/automation-output/control-panel/node-audit.jsonl",
+		"terratest/automation-output/control-panel/node-audit.jsonl",
+	}
+	for _, name := range paths {
+		path := filepath.Join(repoRoot, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	useWorkspace("repro")
+	cleanupHAInstance(1)
+	cleanupTerraformFiles()
+	cleanupAutomationOutput()
+
+	for _, removed := range []string{
+		"modules/aws/terraform.tfstate.d/repro",
+		"environments/repro/.terraform",
+		"environments/repro/high-availability-1",
+		"environments/repro/automation-output",
+	} {
+		if _, err := os.Stat(filepath.Join(repoRoot, removed)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, stat err=%v", removed, err)
+		}
+	}
+	for _, kept := range []string{
+		"environments/repro/tool-config.yml",
+		"modules/aws/terraform.tfstate",
+		"modules/aws/.terraform/terraform.tfstate",
+		"terratest/high-availability-1/keep.yaml",
+		"terratest/automation-output/control-panel/node-audit.jsonl",
+	} {
+		if _, err := os.Stat(filepath.Join(repoRoot, kept)); err != nil {
+			t.Fatalf("expected %s to be left alone: %v", kept, err)
+		}
+	}
+}
+
+func TestControlPanelWorkspaceSwitch(t *testing.T) {
+	repoRoot := newWorkspaceRepo(t)
+	if err := loadWorkspaceConfig(""); err != nil {
+		t.Fatal(err)
+	}
+
+	panel := &localControlPanel{
+		token:                     "panel-token",
+		config:                    panelConfig{TotalHAs: 1},
+		repoRoot:                  repoRoot,
+		testDir:                   filepath.Join(repoRoot, "terratest"),
+		jobs:                      newHelperPanelJobRunner("sleep"),
+		kube:                      newKubeWatchers(),
+		terraformOutputs:          map[string]string{"ha_1_rancher_url": "https://default.example.com"},
+		terraformOutputsAt:        time.Now(),
+		rancherTokens:             map[int]panelRancherToken{1: {token: "token"}},
+		downstreamKubeconfigCache: map[string]string{"old": "old.yaml"},
+	}
+	t.Cleanup(panel.kube.stopAll)
+
+	send := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		panel.handleWorkspaces(rec, httptest.NewRequest(method, "/api/workspaces?token=panel-token", strings.NewReader(body)))
+		return rec
+	}
+
+	var state panelWorkspacesState
+	if err := json.Unmarshal(send(http.MethodGet, "").Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range state.Items {
+		names = append(names, item.Name)
+	}
+	if state.Active != "default" || strings.Join(names, ",") != "default,bad,repro,scratch" || state.Items[3].Configured {
+		t.Fatalf("unexpected workspaces %+v", state)
+	}
+
+	if rec := send(http.MethodPost, `{"name":"scratch"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected a workspace without tool-config.yml to 404, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, `{"name":"bad"}`); rec.Code != http.StatusBadRequest || activeWorkspace() != "" || viper.GetInt("total_has") != 1 {
+		t.Fatalf("expected an invalid workspace to be rejected and the default restored, got %d %s", rec.Code, rec.Body.String())
+	}
+	if err := os.WriteFile(filepath.Join(repoRoot, "environments", "bad", "tool-config.yml"), []byte("total_has: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if rec := send(http.MethodPost, `{"name":"bad"}`); rec.Code != http.StatusBadRequest || activeWorkspace() != "" || viper.GetInt("total_has") != 1 {
+		t.Fatalf("expected a workspace that does not parse to leave the default loaded, got %d %s total_has=%d", rec.Code, rec.Body.String(), viper.GetInt("total_has"))
+	}
+
+	rec := send(http.MethodPost, `{"name":"repro"}`)
+	if rec.Code != http.StatusOK || activeWorkspace() != "repro" {
+		t.Fatalf("expected the switch to succeed, got %d %s", rec.Code, rec.Body.String())
+	}
+	if panel.haCount() != 2 || panel.terraformOutputs != nil || len(panel.rancherTokens) != 0 || len(panel.downstreamKubeconfigCache) != 0 {
+		t.Fatalf("expected the panel caches to be reset for the new workspace, got %+v", panel)
+	}
+	clusters := panel.discoverClusters()
+	if len(clusters) != 2 || clusters[0].KubeconfigPath != filepath.Join(repoRoot, "environments", "repro", "high-availability-1", "kube_config.yaml") {
+		t.Fatalf("expected the repro workspace clusters, got %+v", clusters)
+	}
+	if got := panel.buildState().Workspace; got != "repro" {
+		t.Fatalf("expected the state to report the workspace, got %q", got)
+	}
+
+	job, err := panel.jobs.start("wait-ready", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Workspace != "repro" {
+		t.Fatalf("expected the job to record its workspace, got %+v", job)
+	}
+	if rec := send(http.MethodPost, `{"name":"default"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("expected switching during a job to conflict, got %d", rec.Code)
+	}
+	if _, err := panel.jobs.cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitForPanelJob(t, panel.jobs, job.ID)
+}
+
+func TestControlPanelWorkspaceSwitchWhileBuildingState(t *testing.T) {
+	repoRoot := newWorkspaceRepo(t)
+	if err := loadWorkspaceConfig(""); err != nil {
+		t.Fatal(err)
+	}
+
+	panel := &localControlPanel{
+		token:                     "panel-token",
+		config:                    panelConfigFrom(viper.GetViper()),
+		repoRoot:                  repoRoot,
+		testDir:                   filepath.Join(repoRoot, "terratest"),
+		jobs:                      newPanelJobRunner(""),
+		kube:                      newKubeWatchers(),
+		rancherTokens:             map[int]panelRancherToken{},
+		kubeconfigTokens:          map[int][]string{},
+		downstreamKubeconfigCache: map[string]string{},
+	}
+	t.Cleanup(panel.kube.stopAll)
+
+	done := make(chan struct{})
+	errs := make(chan string, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// The repro workspace has two HAs on 2.12.3 and the default one
+				// HA without a version, so a mix of the two is a torn read.
+				clusters := panel.buildState().Clusters.Items
+				switch {
+				case len(clusters) == 2 && clusters[0].Version != "" && clusters[1].Version != "":
+				case len(clusters) == 1 && clusters[0].Version == "":
+				default:
+					errs <- fmt.Sprintf("inconsistent clusters %+v", clusters)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		name := "repro"
+		if i%2 == 1 {
+			name = ""
+		}
+		if err := panel.switchWorkspace(name); err != nil {
+			close(done)
+			wg.Wait()
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if panel.haCount() != 1 || activeWorkspace() != "" {
+		t.Fatalf("expected to end on the default workspace, got %d HAs in %q", panel.haCount(), activeWorkspace())
+	}
+}